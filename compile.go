@@ -5,36 +5,63 @@
 package main
 
 import (
-	"github.com/albrow/scribble/compilers"
+	"context"
 	"github.com/albrow/scribble/config"
-	"github.com/albrow/scribble/log"
+	"github.com/albrow/scribble/site"
 	"github.com/albrow/scribble/util"
 	"os"
 )
 
-// compile compiles all the contents of config.SourceDir and puts the compiled
-// result in config.DestDir.
-func compile(watch bool) {
-	config.Parse()
-	log.Default.Println("Compiling...")
-	if err := createDestDir(); err != nil {
+// compile compiles the site described by config.toml and returns it. If
+// watch is true, it also begins watching for changes in the background.
+func compile(watch bool) *site.Site {
+	s, err := site.NewFromFile("config.toml")
+	if err != nil {
 		panic(err)
 	}
-	if err := compilers.CompileAll(); err != nil {
+	applyFlags(&s.Config)
+	s.InMemory = *serveMemory
+	s.OnError = func(err error) {
+		util.ChimeError(err)
+	}
+	if err := s.Build(context.Background()); err != nil {
 		util.ChimeError(err)
 	}
 	if watch {
-		watchAll()
+		go func() {
+			if err := s.Watch(context.Background()); err != nil {
+				util.ChimeError(err)
+				os.Exit(1)
+			}
+		}()
 	}
+	return s
 }
 
-func createDestDir() error {
-	if err := os.MkdirAll(config.DestDir, os.ModePerm); err != nil {
-		if !os.IsExist(err) {
-			// If the directory already existed, that's fine,
-			// otherwise return the error
-			return err
-		}
-	}
-	return nil
+// applyFlags overrides any config variables in c which were set via command
+// line flags.
+func applyFlags(c *config.Config) {
+	if *compileDrafts || *serveDrafts {
+		c.Drafts = true
+	}
+	if *compileFuture || *serveFuture {
+		c.Future = true
+	}
+	if *compileJobs > 0 {
+		c.Jobs = *compileJobs
+	} else if *serveJobs > 0 {
+		c.Jobs = *serveJobs
+	}
+	if *serveHost != "" {
+		c.Serve.Host = *serveHost
+	}
+	if *serveBasePath != "" {
+		c.Serve.BasePath = config.CleanBasePath(*serveBasePath)
+	}
+	if *serveGzip {
+		c.Serve.Gzip = true
+	}
+	if *serveLog {
+		c.Serve.Log = true
+	}
 }
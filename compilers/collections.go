@@ -0,0 +1,119 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// postsCollection is the name of the built-in collection of posts in
+// config.PostsDir. Every other collection is declared in config.Collections.
+const postsCollection = "posts"
+
+// Collection returns up to limit published items in the collection identified
+// by name, sorted by date. If limit is 0, it returns all published items. If
+// there is no collection with the given name, it returns an empty slice.
+// Collection("posts") is equivalent to Posts.
+func (s *State) Collection(name string, limit ...int) []*Post {
+	// Sort the published items by date
+	sortedPosts := []*Post{}
+	s.postsMutex.RLock()
+	for _, post := range s.posts {
+		if post.collection() == name && s.published(post) {
+			sortedPosts = append(sortedPosts, post)
+		}
+	}
+	s.postsMutex.RUnlock()
+	sort.Sort(PostsByDate(sortedPosts))
+
+	// Return up to limit items
+	if len(limit) == 0 || limit[0] == 0 || limit[0] > len(sortedPosts) {
+		return sortedPosts
+	} else {
+		return sortedPosts[:limit[0]]
+	}
+}
+
+// Collections returns a map of the name of each collection (including posts)
+// to its published items, sorted by date. It is used for template engines
+// which do not support functions, e.g. jade.
+func (s *State) Collections() map[string][]*Post {
+	all := map[string][]*Post{
+		postsCollection: s.Posts(),
+	}
+	for name := range s.config.Collections {
+		all[name] = s.Collection(name)
+	}
+	return all
+}
+
+// collection returns the name of the collection that p belongs to. Posts
+// with an empty Collection belong to the posts collection.
+func (p *Post) collection() string {
+	if p.Collection == "" {
+		return postsCollection
+	}
+	return p.Collection
+}
+
+// collectionForPath returns the name of the collection that the markdown
+// file at srcPath belongs to.
+func (s *State) collectionForPath(srcPath string) string {
+	dir := filepath.Dir(srcPath)
+	for name, c := range s.config.Collections {
+		if dir == filepath.Clean(c.Dir) {
+			return name
+		}
+	}
+	return postsCollection
+}
+
+// collectionsMatchFunc returns a MatchFunc which will return true for any
+// markdown file in one of the directories in config.Collections. It does
+// not match posts.
+func (s *State) collectionsMatchFunc() MatchFunc {
+	match := unionMatchFuncs()
+	for _, c := range s.config.Collections {
+		match = unionMatchFuncs(match, pathMatchFunc(filepath.Join(c.Dir, "*.md"), true, false))
+	}
+	return match
+}
+
+// collectionLayoutsMatchFunc returns a MatchFunc which will return true for
+// any file in one of the layout directories in config.Collections.
+func (s *State) collectionLayoutsMatchFunc() MatchFunc {
+	match := unionMatchFuncs()
+	for _, c := range s.config.Collections {
+		if c.LayoutDir != "" {
+			match = unionMatchFuncs(match, pathMatchFunc(filepath.Join(c.LayoutDir, "*"), true, false))
+		}
+	}
+	return match
+}
+
+// collectionLayoutsDir returns the directory where the layouts for the
+// collection identified by name live.
+func (s *State) collectionLayoutsDir(name string) string {
+	if c, found := s.config.Collections[name]; found && c.LayoutDir != "" {
+		return c.LayoutDir
+	}
+	return s.config.PostLayoutsDir
+}
+
+// collectionPermalink returns the permalink pattern for the collection
+// identified by name.
+func (s *State) collectionPermalink(name string) string {
+	if name == postsCollection {
+		if s.config.Permalink == "" {
+			return defaultPermalink
+		}
+		return s.config.Permalink
+	}
+	if c := s.config.Collections[name]; c.Permalink != "" {
+		return c.Permalink
+	}
+	return "/" + name + "/:slug"
+}
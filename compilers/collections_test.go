@@ -0,0 +1,96 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/test_util"
+	"github.com/albrow/scribble/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollections(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_collections")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "collections")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	c.DestDir = filepath.Join(root, "public")
+	c.Collections = map[string]config.Collection{
+		"projects": {
+			Dir:       filepath.Join(c.SourceDir, "_projects"),
+			LayoutDir: filepath.Join(c.SourceDir, "_project_layouts"),
+			Permalink: "/projects/:slug/",
+		},
+	}
+	s := NewState(c)
+	postsCompiler := s.compiler("posts").(*PostsCompilerType)
+	postsCompiler.Init()
+
+	// Attempt to compile the posts, the projects, and the index page
+	srcPaths, err := s.FindPaths(postsCompiler.CompileMatchFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srcPaths) != 3 {
+		t.Fatalf("Expected 3 paths for PostsCompiler but got %d", len(srcPaths))
+	}
+	if err := postsCompiler.CompileAll(srcPaths); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.compiler("html").Compile(filepath.Join(c.SourceDir, "index.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the helper functions return the correct results
+	if got := len(s.Posts()); got != 1 {
+		t.Errorf("Expected 1 post but got %d", got)
+	}
+	if got := len(s.Collection("posts")); got != 1 {
+		t.Errorf("Expected 1 item in posts collection but got %d", got)
+	}
+	projects := s.Collection("projects")
+	if len(projects) != 2 {
+		t.Fatalf("Expected 2 projects but got %d", len(projects))
+	}
+	if projects[0].Title != "Scribble" || projects[0].Url != "/projects/scribble/" {
+		t.Errorf("First project was incorrect. Got %+v", projects[0])
+	}
+	if got := len(s.Collection("missing")); got != 0 {
+		t.Errorf("Expected 0 items in missing collection but got %d", got)
+	}
+
+	// Make sure the compiled results are correct
+	expectedDir := filepath.Join(testFilesDir, "public")
+	expectedFiles := []string{
+		"index.html",
+		filepath.Join("one", "index.html"),
+		filepath.Join("projects", "scribble", "index.html"),
+		filepath.Join("projects", "humble", "index.html"),
+	}
+	for _, file := range expectedFiles {
+		test_util.CheckFilesMatch(t, filepath.Join(expectedDir, file), filepath.Join(c.DestDir, file))
+	}
+}
@@ -5,34 +5,13 @@
 package compilers
 
 import (
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/log"
-	"github.com/albrow/scribble/util"
 	"github.com/howeyc/fsnotify"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// Compilers is a slice of all known Compilers.
-// NOTE: it is important that PostsCompiler is the first
-// item in the slice, because some other compilers rely on
-// the existence of a list of parsed Post objects. For example,
-// AceCompiler relies on the Posts function returning the correct
-// results inside of ace templates.
-var Compilers = []Compiler{
-	&PostsCompiler,
-	&SassCompiler,
-	&HtmlTemplatesCompiler,
-	&JadeCompiler,
-}
-
-// CompilerPaths is a map of Compiler to the matched paths for that Compiler
-var CompilerPaths = map[Compiler][]string{}
-
-// UnmatchedPaths is a slice of paths which do not match any compiler
-var UnmatchedPaths = []string{}
-
 // noHiddenNoIgnore is a MatchFunc which returns true for any path that is
 // does not begin with a "." or "_" and is not inside any directory which begins
 // with a "." or "_".
@@ -54,6 +33,16 @@ type Initer interface {
 	Init()
 }
 
+// AlwaysCompiler is an interface which may be satisfied by any Compiler that
+// creates files which don't come from a single source file, e.g. the feeds,
+// which are made out of every post. Normally CompileAll is only called for a
+// Compiler if at least one path matches its CompileMatchFunc.
+type AlwaysCompiler interface {
+	// AlwaysCompile returns true iff CompileAll should be called whenever
+	// everything is compiled, even if no paths match.
+	AlwaysCompile() bool
+}
+
 // Compiler is capable of compiling a certain type of file. It
 // also is responsible for watching for changes to certain types
 // of files.
@@ -90,12 +79,12 @@ type Compiler interface {
 	FileChanged(srcPath string, ev *fsnotify.FileEvent) error
 }
 
-// FindPaths iterates recursively through config.SourceDir and
-// returns all the matched paths using mf as a MatchFunc.
-func FindPaths(mf MatchFunc) ([]string, error) {
+// FindPaths iterates recursively through the source directory for the site
+// and returns all the matched paths using mf as a MatchFunc.
+func (s *State) FindPaths(mf MatchFunc) ([]string, error) {
 	paths := []string{}
 	walkFunc := matchWalkFunc(&paths, mf)
-	if err := filepath.Walk(config.SourceDir, walkFunc); err != nil {
+	if err := filepath.Walk(s.config.SourceDir, walkFunc); err != nil {
 		return nil, err
 	}
 	return paths, nil
@@ -103,82 +92,105 @@ func FindPaths(mf MatchFunc) ([]string, error) {
 
 // CompileAll compiles all files in config.SourceDir by delegating each path to
 // it's corresponding Compiler. If a path in config.SourceDir does not match any Compiler,
-// it will be copied to config.DestDir directly.
-func CompileAll() error {
-	initCompilers()
-	if err := RemoveAllOld(); err != nil {
+// it will be copied to config.DestDir directly. Everything is written to a staging
+// directory next to config.DestDir first, which replaces config.DestDir only if every
+// Compiler succeeded. If compilation fails, the previous contents of config.DestDir
+// are left intact. The config itself is never changed, and s.DestDir returns the
+// staging directory while the build is written there.
+func (s *State) CompileAll() error {
+	destDir := s.config.DestDir
+	stagingDir, err := s.createStagingDir(destDir)
+	if err != nil {
+		return err
+	}
+	s.destDir = stagingDir
+	err = s.compileAllInDestDir()
+	s.destDir = destDir
+	if err != nil {
+		if removeErr := s.removeStagingDir(stagingDir); removeErr != nil {
+			log.Error.Printf("Could not remove staging directory %s: %s", stagingDir, removeErr.Error())
+		}
 		return err
 	}
-	if err := delegateCompilePaths(); err != nil {
+	if err := s.swapStagingDir(stagingDir, destDir); err != nil {
 		return err
 	}
-	for _, c := range Compilers {
-		if err := compileAllForCompiler(c); err != nil {
+	s.moveDestPaths(stagingDir, destDir)
+	return nil
+}
+
+// compileAllInDestDir compiles all files in config.SourceDir directly into
+// s.destDir.
+func (s *State) compileAllInDestDir() error {
+	s.initCompilers()
+	if err := s.RemoveAllOld(); err != nil {
+		return err
+	}
+	if err := s.delegateCompilePaths(); err != nil {
+		return err
+	}
+	for _, c := range s.compilers {
+		if err := s.compileAllForCompiler(c); err != nil {
 			return err
 		}
 	}
 	log.Default.Print("Copying other files...")
-	if err := copyUnmatchedPaths(UnmatchedPaths); err != nil {
+	if err := s.copyUnmatchedPaths(s.unmatchedPaths); err != nil {
+		return err
+	}
+	if err := s.compileSitemap(); err != nil {
 		return err
 	}
 	return nil
 }
 
 // RemoveAllOld removes all the files from config.DestDir
-func RemoveAllOld() error {
+func (s *State) RemoveAllOld() error {
 	log.Default.Println("Removing old files...")
-	UnmatchedPaths = []string{}
-	// walk through the dest dir
-	if err := filepath.Walk(config.DestDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	s.unmatchedPaths = []string{}
+	s.sitemapEntries = map[string]sitemapEntry{}
+	s.depGraph = newDependencyGraph()
+	// remove everything inside the dest dir, but not the dest dir itself
+	infos, err := s.files.ReadDir(s.destDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := s.files.RemoveAllIfExists(filepath.Join(s.destDir, info.Name())); err != nil {
 			return err
 		}
-		if info.Name() == config.DestDir {
-			// ignore the destDir itself
-			return nil
-		} else if info.IsDir() {
-			// remove the dir and everything in it
-			if err := util.RemoveAllIfExists(path); err != nil {
-				return err
-			}
-			return filepath.SkipDir
-		} else {
-			// remove the file
-			if err := os.Remove(path); err != nil {
-				return err
-			}
-		}
-		return nil
-	}); err != nil {
-		return err
 	}
 	return nil
 }
 
 // compileAllForCompiler recompiles all paths that are matched according to the given compiler's
-// MatchFunc
-func compileAllForCompiler(c Compiler) error {
-	paths, found := CompilerPaths[c]
-	if found && len(paths) > 0 {
-		if err := c.CompileAll(paths); err != nil {
-			return err
+// MatchFunc. If there are no such paths, the compiler is skipped unless it satisfies
+// AlwaysCompiler and asks to be compiled anyway.
+func (s *State) compileAllForCompiler(c Compiler) error {
+	paths := s.compilerPaths[c]
+	if len(paths) == 0 {
+		if always, ok := c.(AlwaysCompiler); !ok || !always.AlwaysCompile() {
+			return nil
 		}
 	}
+	if err := c.CompileAll(paths); err != nil {
+		return s.withCompilerName(c, err)
+	}
 	return nil
 }
 
 // FileChanged delegates file changes to the appropriate compiler. If srcPath does not match any
 // Compiler, it will be copied to config.DestDir directly.
-func FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
+func (s *State) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
 	hasMatch := false
-	for _, c := range Compilers {
+	for _, c := range s.compilers {
 		if match, err := c.WatchMatchFunc()(srcPath); err != nil {
 			return err
 		} else if match {
 			hasMatch = true
 			log.Info.Printf("CHANGED: %s", ev.Name)
 			if err := c.FileChanged(srcPath, ev); err != nil {
-				return err
+				return s.withCompilerName(c, err)
 			}
 		}
 	}
@@ -188,13 +200,9 @@ func FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
 			return err
 		} else if match {
 			log.Info.Printf("CHANGED: %s", ev.Name)
-			// Okay, so.. this case can get a little complicated. We have to take into
-			// account whether the thing being changed is a file or folder, and whether
-			// it is being deleted, created, or modified. We also have to make sure we
-			// don't accidentally change any of the files and folders that other compilers
-			// care about.For now, we're just going to recompile the entire blog.
-			// TODO: optimize this by only recompiling the files that need to be recompiled.
-			if err := CompileAll(); err != nil {
+			// The path is simply copied to config.DestDir, so we only need to
+			// copy it again (or remove it if it was removed).
+			if err := s.unmatchedPathChanged(srcPath); err != nil {
 				return err
 			}
 		}
@@ -203,13 +211,15 @@ func FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
 }
 
 // initCompilers calls the Init method for each compiler that has it
-func initCompilers() {
-	for _, c := range Compilers {
+func (s *State) initCompilers() {
+	// context.FuncMap may have changed since the last time
+	s.funcMap = s.newFuncMap()
+	for _, c := range s.compilers {
 		if initer, ok := c.(Initer); ok {
 			// If the Compiler has an Init function, run it
 			initer.Init()
 		}
-		CompilerPaths[c] = []string{}
+		s.compilerPaths[c] = []string{}
 	}
 }
 
@@ -218,13 +228,13 @@ func initCompilers() {
 // (in case something changed since the last time we found the paths). Next, it compiles
 // all of the matching files with a call to CompileAll. Finally, it removes any empty
 // directories that may still be in config.DestDir.
-func recompileAllForCompiler(c Compiler) error {
+func (s *State) recompileAllForCompiler(c Compiler) error {
 	// Have the compiler remove any files it may have created
 	if err := c.RemoveOld(); err != nil {
 		return err
 	}
 	// Find all the paths again for the given compiler (in case something changed)
-	paths, err := FindPaths(c.CompileMatchFunc())
+	paths, err := s.FindPaths(c.CompileMatchFunc())
 	if err != nil {
 		return err
 	}
@@ -232,28 +242,33 @@ func recompileAllForCompiler(c Compiler) error {
 	if err := c.CompileAll(paths); err != nil {
 		return err
 	}
+	// The compiler may have created or removed pages, so the sitemap needs
+	// to be updated
+	if err := s.compileSitemap(); err != nil {
+		return err
+	}
 	// Cleanup by removing any empty dirs from config.DestDir
-	if err := util.RemoveEmptyDirs(config.DestDir); err != nil {
+	if err := s.files.RemoveEmptyDirs(s.destDir); err != nil {
 		return err
 	}
 	return nil
 }
 
 // delegateCompilePaths walks through the source directory, checks if a path matches according
-// to the MatchFunc for each compiler, and adds the path to CompilerPaths if it does
+// to the MatchFunc for each compiler, and adds the path to compilerPaths if it does
 // match.
-func delegateCompilePaths() error {
-	return filepath.Walk(config.SourceDir, func(path string, info os.FileInfo, err error) error {
+func (s *State) delegateCompilePaths() error {
+	return filepath.Walk(s.config.SourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		matched := false
-		for _, c := range Compilers {
+		for _, c := range s.compilers {
 			if match, err := c.CompileMatchFunc()(path); err != nil {
 				return err
 			} else if match {
 				matched = true
-				CompilerPaths[c] = append(CompilerPaths[c], path)
+				s.compilerPaths[c] = append(s.compilerPaths[c], path)
 			}
 		}
 		if !matched && !info.IsDir() {
@@ -264,7 +279,7 @@ func delegateCompilePaths() error {
 			if match, err := noHiddenNoIgnore(path); err != nil {
 				return err
 			} else if match {
-				UnmatchedPaths = append(UnmatchedPaths, path)
+				s.unmatchedPaths = append(s.unmatchedPaths, path)
 			}
 		}
 		return nil
@@ -273,13 +288,21 @@ func delegateCompilePaths() error {
 
 // copyUnmatchedPaths copies paths from config.SourceDir to config.DestDir without changing them. It perserves
 // directory structures, so e.g., source/archive/index.html becomes public/archive/index.html.
-func copyUnmatchedPaths(paths []string) error {
+func (s *State) copyUnmatchedPaths(paths []string) error {
 	for _, path := range paths {
-		destPath := strings.Replace(path, config.SourceDir, config.DestDir, 1)
+		destPath := strings.Replace(path, s.config.SourceDir, s.destDir, 1)
 		log.Success.Printf("CREATE: %s -> %s", path, destPath)
-		if err := util.CopyFile(path, destPath); err != nil {
+		if err := s.files.CopyFile(path, destPath); err != nil {
 			return err
 		}
+		if filepath.Ext(path) == ".html" {
+			// Html files may be pages, so they should be in the sitemap
+			lastMod, err := modTime(path)
+			if err != nil {
+				return err
+			}
+			s.addSitemapEntry(destPath, lastMod, false)
+		}
 	}
 	return nil
 }
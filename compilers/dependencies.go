@@ -0,0 +1,314 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/jade"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// postsNode is a virtual node in the dependency graph which represents
+	// every post in every collection. Pages which list posts, e.g. the index
+	// page, depend on it.
+	postsNode = "<posts>"
+	// taxonomiesNode is a virtual source in the dependency graph which is
+	// used to keep track of the files created for taxonomy pages.
+	taxonomiesNode = "<taxonomies>"
+)
+
+// postsIdentifiers matches any of the names which are used to access posts
+// from a template. If a template or any of its layouts or includes contains
+// one of them, it is assumed to depend on every post.
+var postsIdentifiers = regexp.MustCompile(`\b(Posts|Collection|Collections|Tags|Categories|PostsWithTag|PostsInCategory|Paginator)\b`)
+
+// dependencyGraph keeps track of which source files each compiled file depends
+// on and which files were created from it in config.DestDir. It allows us to
+// recompile only the files that are affected when some file changes. It is
+// safe for concurrent use.
+type dependencyGraph struct {
+	sync.Mutex
+	// dependencies is a map of the path of each compiled file to the set of
+	// paths (or virtual nodes) that it depends on
+	dependencies map[string]map[string]bool
+	// outputs is a map of the path of each compiled file to the files that
+	// were created from it in config.DestDir
+	outputs map[string][]string
+}
+
+func newDependencyGraph() *dependencyGraph {
+	return &dependencyGraph{
+		dependencies: map[string]map[string]bool{},
+		outputs:      map[string][]string{},
+	}
+}
+
+// setDependencies records that srcPath depends on deps, replacing any
+// dependencies which were previously recorded for srcPath.
+func (g *dependencyGraph) setDependencies(srcPath string, deps []string) {
+	g.Lock()
+	defer g.Unlock()
+	set := map[string]bool{}
+	for _, dep := range deps {
+		set[dep] = true
+	}
+	g.dependencies[srcPath] = set
+}
+
+// addOutput records that the file at destPath was created from srcPath.
+func (g *dependencyGraph) addOutput(srcPath string, destPath string) {
+	g.Lock()
+	defer g.Unlock()
+	g.outputs[srcPath] = append(g.outputs[srcPath], destPath)
+}
+
+// dependents returns the paths of the compiled files which depend on path,
+// sorted alphabetically.
+func (g *dependencyGraph) dependents(path string) []string {
+	g.Lock()
+	defer g.Unlock()
+	results := []string{}
+	for srcPath, deps := range g.dependencies {
+		if deps[path] {
+			results = append(results, srcPath)
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+// takeOutputs forgets about all the files that were created from srcPath and
+// returns them.
+func (g *dependencyGraph) takeOutputs(srcPath string) []string {
+	g.Lock()
+	defer g.Unlock()
+	outputs := g.outputs[srcPath]
+	delete(g.outputs, srcPath)
+	return outputs
+}
+
+// forget forgets about srcPath entirely, except for the files that were
+// created from it.
+func (g *dependencyGraph) forget(srcPath string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.dependencies, srcPath)
+}
+
+// removeOutputs removes all the files that were created from srcPath and
+// forgets about them.
+func (s *State) removeOutputs(srcPath string) error {
+	for _, destPath := range s.depGraph.takeOutputs(srcPath) {
+		if err := s.files.RemoveIfExists(destPath); err != nil {
+			return err
+		}
+		s.removeSitemapEntry(destPath)
+	}
+	return nil
+}
+
+// removeSource removes all the files that were created from srcPath and
+// forgets about srcPath entirely. It should be called when srcPath is deleted.
+func (s *State) removeSource(srcPath string) error {
+	if err := s.removeOutputs(srcPath); err != nil {
+		return err
+	}
+	s.depGraph.forget(srcPath)
+	return nil
+}
+
+// dependsOn returns true iff srcPath depends on path.
+func (g *dependencyGraph) dependsOn(srcPath string, path string) bool {
+	g.Lock()
+	defer g.Unlock()
+	return g.dependencies[srcPath][path]
+}
+
+// templateDependencies returns files, plus postsNode if any of the files
+// refer to posts.
+func templateDependencies(files []string) ([]string, error) {
+	deps := append([]string{}, files...)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if postsIdentifiers.Match(content) {
+			deps = append(deps, postsNode)
+			break
+		}
+	}
+	return deps, nil
+}
+
+// htmlTemplateDependencies returns the paths that the html template at
+// srcPath depends on, i.e. the template itself and all the layouts and
+// includes.
+func (s *State) htmlTemplateDependencies(srcPath string) ([]string, error) {
+	files := []string{srcPath}
+	for _, dir := range []string{s.config.LayoutsDir, s.config.IncludesDir} {
+		if dir == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return templateDependencies(files)
+}
+
+// jadeDependencies returns the paths that the jade file at srcPath depends
+// on, i.e. the file itself and any files it includes or extends (recursively).
+func jadeDependencies(srcPath string) ([]string, error) {
+	tmpl, err := jade.ParseFile(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	return templateDependencies(tmpl.Files())
+}
+
+// layoutDependencies returns the paths that a post or page rendered with the
+// layout at layoutPath depends on.
+func (s *State) layoutDependencies(layoutPath string) ([]string, error) {
+	switch filepath.Ext(layoutPath) {
+	case ".tmpl":
+		return s.htmlTemplateDependencies(layoutPath)
+	case ".jade":
+		return jadeDependencies(layoutPath)
+	}
+	return []string{layoutPath}, nil
+}
+
+// sourceRemoved returns true iff srcPath no longer exists, e.g. because it
+// was deleted or renamed. We check the file system instead of relying on the
+// type of the event, because text editors which use atomic saves may trigger
+// rename events for files which still exist.
+func sourceRemoved(srcPath string) bool {
+	_, err := os.Stat(srcPath)
+	return os.IsNotExist(err)
+}
+
+// affectedPaths returns the paths compiled by c which are affected by a change
+// to srcPath, i.e. srcPath itself if c compiles it and any paths compiled by
+// c which depend on srcPath. If srcPath was removed, it is not included in
+// the results and the files created from it are removed.
+func (s *State) affectedPaths(c Compiler, srcPath string) ([]string, error) {
+	paths := []string{}
+	if match, err := c.CompileMatchFunc()(srcPath); err != nil {
+		return nil, err
+	} else if match {
+		if sourceRemoved(srcPath) {
+			if err := s.removeSource(srcPath); err != nil {
+				return nil, err
+			}
+		} else {
+			paths = append(paths, srcPath)
+		}
+	}
+	dependents, err := s.dependentsForCompiler(c, srcPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(paths, dependents...), nil
+}
+
+// dependentsForCompiler returns the paths compiled by c which depend on path
+// according to the dependency graph.
+func (s *State) dependentsForCompiler(c Compiler, path string) ([]string, error) {
+	paths := []string{}
+	for _, dependent := range s.depGraph.dependents(path) {
+		if dependent == path {
+			continue
+		}
+		if match, err := c.CompileMatchFunc()(dependent); err != nil {
+			return nil, err
+		} else if match {
+			paths = append(paths, dependent)
+		}
+	}
+	return paths, nil
+}
+
+// recompilePaths removes the files that were previously created from each
+// path and then compiles it again with c.
+func (s *State) recompilePaths(c Compiler, paths []string) error {
+	for _, path := range paths {
+		if err := s.removeOutputs(path); err != nil {
+			return err
+		}
+		if err := c.Compile(path); err != nil {
+			return s.withCompilerName(c, s.newFileError(path, err))
+		}
+	}
+	return nil
+}
+
+// recompilePostsDependents recompiles every file which depends on posts, e.g.
+// the index page, using whichever compiler is responsible for each one.
+func (s *State) recompilePostsDependents() error {
+	for _, c := range s.compilers {
+		paths, err := s.dependentsForCompiler(c, postsNode)
+		if err != nil {
+			return err
+		}
+		if err := s.recompilePaths(c, paths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileChangedForCompiler reacts to a change to srcPath by recompiling only
+// the files compiled by c which are affected by it. Then it updates the sitemap
+// and cleans up any empty directories in config.DestDir.
+func (s *State) fileChangedForCompiler(c Compiler, srcPath string) error {
+	paths, err := s.affectedPaths(c, srcPath)
+	if err != nil {
+		return err
+	}
+	if err := s.recompilePaths(c, paths); err != nil {
+		return err
+	}
+	return s.finishRecompile()
+}
+
+// finishRecompile updates the sitemap and removes any empty directories from
+// config.DestDir after some files were recompiled.
+func (s *State) finishRecompile() error {
+	if err := s.compileSitemap(); err != nil {
+		return err
+	}
+	return s.files.RemoveEmptyDirs(s.destDir)
+}
+
+// unmatchedPathChanged reacts to a change to srcPath, which does not match
+// any Compiler, by copying it to config.DestDir again or removing it from
+// config.DestDir if it was removed.
+func (s *State) unmatchedPathChanged(srcPath string) error {
+	destPath := strings.Replace(srcPath, s.config.SourceDir, s.destDir, 1)
+	if sourceRemoved(srcPath) {
+		if err := s.files.RemoveAllIfExists(destPath); err != nil {
+			return err
+		}
+		s.removeSitemapEntry(destPath)
+	} else if info, err := os.Stat(srcPath); err != nil {
+		return err
+	} else if info.IsDir() {
+		// A new directory may contain any number of files which belong to
+		// different compilers, so just recompile everything.
+		return s.CompileAll()
+	} else if err := s.copyUnmatchedPaths([]string{srcPath}); err != nil {
+		return err
+	}
+	return s.finishRecompile()
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/util"
+	"github.com/howeyc/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncrementalRebuilds(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_incremental_rebuilds")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compile everything once
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.DestDir = filepath.Join(root, "public")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	if err := os.MkdirAll(c.DestDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	s := NewState(c)
+	if err := s.CompileAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile := func(path string, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readFile := func(path string) string {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+	fileChanged := func(path string) {
+		if err := s.FileChanged(path, &fsnotify.FileEvent{Name: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Replace some of the compiled files with a sentinel value so we can
+	// tell whether or not they were recompiled
+	const sentinel = "not recompiled"
+	aboutPath := filepath.Join(c.DestDir, "about.html")
+	twoPath := filepath.Join(c.DestDir, "two", "index.html")
+	indexPath := filepath.Join(c.DestDir, "index.html")
+	writeFile(aboutPath, sentinel)
+	writeFile(twoPath, sentinel)
+
+	// Change the title of a post. The post itself and the index page which lists
+	// the posts should be recompiled, but nothing else.
+	onePath := filepath.Join(c.PostsDir, "one.md")
+	writeFile(onePath, strings.Replace(readFile(onePath), `title = "One"`, `title = "Changed"`, 1))
+	fileChanged(onePath)
+	if got := readFile(filepath.Join(c.DestDir, "one", "index.html")); !strings.Contains(got, "Changed") {
+		t.Errorf("Expected the changed post to be recompiled but got: %s", got)
+	}
+	if got := readFile(indexPath); !strings.Contains(got, "Changed") {
+		t.Errorf("Expected the index page to be recompiled but got: %s", got)
+	}
+	for _, path := range []string{aboutPath, twoPath} {
+		if got := readFile(path); got != sentinel {
+			t.Errorf("Expected %s to not be recompiled but got: %s", path, got)
+		}
+	}
+
+	// Remove a post. The compiled post should be removed too, and the index
+	// page should no longer list it.
+	if err := os.Remove(filepath.Join(c.PostsDir, "two.md")); err != nil {
+		t.Fatal(err)
+	}
+	fileChanged(filepath.Join(c.PostsDir, "two.md"))
+	if _, err := os.Stat(twoPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", twoPath)
+	}
+	if got := readFile(indexPath); strings.Contains(got, "Two") {
+		t.Errorf("Expected the index page to not include the removed post but got: %s", got)
+	}
+
+	// Change a file which does not match any compiler. It should be copied
+	// without recompiling anything else.
+	jsPath := filepath.Join(c.SourceDir, "js", "main.js")
+	writeFile(jsPath, "changed")
+	fileChanged(jsPath)
+	if got := readFile(filepath.Join(c.DestDir, "js", "main.js")); got != "changed" {
+		t.Errorf("Expected main.js to be copied but got: %s", got)
+	}
+	if got := readFile(aboutPath); got != sentinel {
+		t.Errorf("Expected %s to not be recompiled but got: %s", aboutPath, got)
+	}
+
+	// Change a layout. Every page which depends on it should be recompiled.
+	layoutPath := filepath.Join(c.LayoutsDir, "base.tmpl")
+	writeFile(layoutPath, `<html><body class="changed">{{ template "content" .}}</body></html>`)
+	fileChanged(layoutPath)
+	for _, path := range []string{aboutPath, indexPath, filepath.Join(c.DestDir, "one", "index.html")} {
+		if got := readFile(path); !strings.Contains(got, `class="changed"`) {
+			t.Errorf("Expected %s to be recompiled with the changed layout but got: %s", path, got)
+		}
+	}
+}
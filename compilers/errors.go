@@ -0,0 +1,142 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"errors"
+	"github.com/albrow/scribble/sass"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// FileError is an error which occurred while compiling a single source file.
+// Its message is the message of the underlying error, so it reads the same as
+// it would have without the extra information.
+type FileError struct {
+	// Compiler is the name the Compiler which failed was registered with,
+	// e.g. "sass" or "html". It is empty if it is not known.
+	Compiler string
+	// Path is the path of the file where the error occurred. It is usually
+	// the source file which could not be compiled, but it may also be a
+	// partial, layout, or include which that file uses.
+	Path string
+	// Line is the line in the file at Path where the error occurred, or 0 if
+	// it is not known
+	Line int
+	// Err is the underlying error
+	Err error
+}
+
+func (e *FileError) Error() string {
+	return e.Err.Error()
+}
+
+// lineRegexps match the file and line number in error messages from sass or
+// sassc (e.g. "on line 3 of main.scss"), and from go templates and jade
+// (e.g. "template: index.tmpl:3: ..." or "index.jade:3: ..."). The messages
+// may have a prefix, e.g. "while compiling jade: ".
+var lineRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`on line (\d+)(?::\d+)? of ([^\s,]+)`),
+	regexp.MustCompile(`(?:^|\s)([^:\s]+):(\d+):`),
+}
+
+// newFileError returns err as a *FileError for the source file at path, with
+// the file and line number where the error occurred taken from err if
+// possible. That file may be a partial, layout, or include instead of the file
+// at path. If err is already a *FileError or CompileErrors it is returned as
+// is.
+func (s *State) newFileError(path string, err error) error {
+	switch err.(type) {
+	case *FileError, CompileErrors:
+		return err
+	}
+	fileErr := &FileError{Path: path, Err: err}
+	var sassErr *sass.Error
+	if errors.As(err, &sassErr) {
+		fileErr.Path = sassErr.Path
+		fileErr.Line = sassErr.Line
+		return fileErr
+	}
+	for i, re := range lineRegexps {
+		match := re.FindStringSubmatch(err.Error())
+		if match == nil {
+			continue
+		}
+		lineMatch, name := match[1], match[2]
+		if i == 1 {
+			name, lineMatch = match[1], match[2]
+		}
+		if failed := s.failedPath(path, name); failed != "" {
+			fileErr.Path = failed
+			fileErr.Line, _ = strconv.Atoi(lineMatch)
+		}
+		break
+	}
+	return fileErr
+}
+
+// failedPath returns the path of the file called name in an error message
+// which occurred while compiling the file at path, or an empty string if it
+// could not be found. Sass and jade use the path of the file, but go templates
+// only use its name, so layouts and includes are looked for by name.
+func (s *State) failedPath(path string, name string) string {
+	if name == path || name == filepath.Base(path) {
+		return path
+	}
+	if exists(name) {
+		return name
+	}
+	if filepath.Base(name) != name {
+		return ""
+	}
+	dirs := []string{s.config.LayoutsDir, s.config.IncludesDir, s.config.PostLayoutsDir}
+	for _, collection := range s.config.Collections {
+		dirs = append(dirs, collection.LayoutDir)
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if candidate := filepath.Join(dir, name); exists(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// exists returns true iff there is a file at path
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// withCompilerName sets the name of c as the Compiler for any *FileError in
+// err which doesn't already have one, and returns err.
+func (s *State) withCompilerName(c Compiler, err error) error {
+	switch err := err.(type) {
+	case *FileError:
+		if err.Compiler == "" {
+			err.Compiler = s.compilerName(c)
+		}
+	case CompileErrors:
+		for _, e := range err {
+			s.withCompilerName(c, e)
+		}
+	}
+	return err
+}
+
+// compilerName returns the name c was registered with, or an empty string if
+// it was not registered
+func (s *State) compilerName(c Compiler) string {
+	for i, compiler := range s.compilers {
+		if compiler == c {
+			return s.names[i]
+		}
+	}
+	return ""
+}
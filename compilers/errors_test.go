@@ -0,0 +1,117 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/util"
+	"github.com/howeyc/fsnotify"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileErrors(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_file_errors")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.DestDir = filepath.Join(root, "public")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	if err := os.MkdirAll(c.DestDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	s := NewState(c)
+
+	writeFile := func(path string, content string) {
+		file, err := util.CreateFileWithPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A sass file which imports a partial with an undefined variable on the
+	// second line
+	sassPath := filepath.Join(srcDir, "styles", "main.scss")
+	partialPath := filepath.Join(srcDir, "styles", "_colors.scss")
+	writeFile(sassPath, "@import \"colors\";\n")
+	writeFile(partialPath, "body {\n  color: $undefined;\n}\n")
+
+	checkFileError := func(err error, compiler string, path string, line int) {
+		fileErr, ok := err.(*FileError)
+		if !ok {
+			t.Fatalf("Expected a *FileError but got %T: %v", err, err)
+		}
+		got := fmt.Sprintf("%s %s %d", fileErr.Compiler, fileErr.Path, fileErr.Line)
+		expected := fmt.Sprintf("%s %s %d", compiler, path, line)
+		if got != expected {
+			t.Errorf("FileError was incorrect.\nExpected: %s\nBut got:  %s", expected, got)
+		}
+		if fileErr.Error() != fileErr.Err.Error() {
+			t.Errorf("Expected the message to be the same as the underlying error but got: %s", fileErr.Error())
+		}
+	}
+
+	// The error should say which compiler and file failed, both when
+	// compiling everything and when recompiling after a change
+	checkFileError(s.CompileAll(), "sass", partialPath, 2)
+	checkFileError(s.FileChanged(sassPath, &fsnotify.FileEvent{Name: sassPath}), "sass", partialPath, 2)
+	if err := util.RemoveAllIfExists(filepath.Join(srcDir, "styles")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from jade and go templates should have the file and line of the
+	// include or layout which caused them
+	jadePath := filepath.Join(srcDir, "broken.jade")
+	jadeIncludePath := filepath.Join(srcDir, "_broken.jade")
+	writeFile(jadePath, "div\n\tinclude _broken\n")
+	writeFile(jadeIncludePath, "p\n\tp= Missing()\n")
+	checkFileError(s.newFileError(jadePath, s.compiler("jade").Compile(jadePath)), "", jadeIncludePath, 2)
+
+	layoutPath := filepath.Join(c.LayoutsDir, "broken_layout.tmpl")
+	writeFile(layoutPath, "{{ define \"broken\" }}\n{{ if }}{{ end }}{{ end }}")
+	indexPath := filepath.Join(srcDir, "index.tmpl")
+	checkFileError(s.newFileError(indexPath, s.compiler("html").Compile(indexPath)), "", layoutPath, 2)
+	if err := util.RemoveIfExists(layoutPath); err != nil {
+		t.Fatal(err)
+	}
+
+	postLayoutPath := filepath.Join(c.PostLayoutsDir, "broken_post.tmpl")
+	writeFile(postLayoutPath, "{{ define \"content\" }}\n{{ .Post.Missing }}{{ end }}{{ template \"base.tmpl\" . }}")
+	postPath := filepath.Join(c.PostsDir, "one.md")
+	post := &Post{LayoutName: "broken_post.tmpl"}
+	err := s.compiler("html").(*HtmlTemplatesCompilerType).RenderPost(post, filepath.Join(c.DestDir, "broken", "index.html"))
+	checkFileError(s.newFileError(postPath, err), "", postLayoutPath, 2)
+
+	// Numbers in other messages should not be mistaken for line numbers
+	for _, msg := range []string{
+		"something went wrong: 3",
+		"Get http://localhost:4000: connection refused",
+	} {
+		checkFileError(s.newFileError(postPath, fmt.Errorf("%s", msg)), "", postPath, 0)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/log"
+	"github.com/howeyc/fsnotify"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExternalCompilersType represents a type capable of compiling files by
+// running the external commands declared in config.ExternalCompilers.
+type ExternalCompilersType struct {
+	state *State
+	// createdFiles is a slice of file paths which were created by this
+	// compiler. It is important for implementing the RemoveOld method.
+	createdFiles []string
+}
+
+// CompileMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. In this case, the pattern
+// is the match pattern for any of config.ExternalCompilers, excluding
+// hidden and ignored files and directories, and excluding files which
+// another Compiler compiles (e.g. if the pattern is "*.md").
+func (e *ExternalCompilersType) CompileMatchFunc() MatchFunc {
+	matchFuncs := []MatchFunc{}
+	for _, ec := range e.state.config.ExternalCompilers {
+		matchFuncs = append(matchFuncs, filenameMatchFunc(ec.Match, true, true))
+	}
+	return excludeMatchFuncs(unionMatchFuncs(matchFuncs...), e.otherCompileMatchFuncs()...)
+}
+
+// WatchMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. In this case, the pattern
+// is the match pattern for any of config.ExternalCompilers, excluding
+// hidden files and directories, but including those that start with an
+// underscore, since they may be imported in other files. Files which
+// another Compiler compiles are excluded, just like in CompileMatchFunc.
+func (e *ExternalCompilersType) WatchMatchFunc() MatchFunc {
+	matchFuncs := []MatchFunc{}
+	for _, ec := range e.state.config.ExternalCompilers {
+		matchFuncs = append(matchFuncs, filenameMatchFunc(ec.Match, true, false))
+	}
+	return excludeMatchFuncs(unionMatchFuncs(matchFuncs...), e.otherCompileMatchFuncs()...)
+}
+
+// otherCompileMatchFuncs returns the CompileMatchFunc of every Compiler for
+// the site besides e. A pattern in config.ExternalCompilers may overlap with
+// the files that another Compiler is responsible for, and those files should
+// not be compiled twice.
+func (e *ExternalCompilersType) otherCompileMatchFuncs() []MatchFunc {
+	matchFuncs := []MatchFunc{}
+	for _, c := range e.state.compilers {
+		if c != Compiler(e) {
+			matchFuncs = append(matchFuncs, c.CompileMatchFunc())
+		}
+	}
+	return matchFuncs
+}
+
+// Compile compiles the file at srcPath by running the command for the first
+// of config.ExternalCompilers which matches it. The caller will only call
+// this function for files which belong to ExternalCompilers according to
+// the MatchFunc. Compile will output the compiled result to the appropriate
+// location in config.DestDir. If the command fails, the error includes
+// anything it wrote to stderr.
+func (e *ExternalCompilersType) Compile(srcPath string) error {
+	s := e.state
+	ec, err := externalCompilerForPath(s.config.ExternalCompilers, srcPath)
+	if err != nil {
+		return err
+	}
+
+	// parse path and figure out destPath
+	destPath := strings.Replace(srcPath, s.config.SourceDir, s.destDir, 1)
+	if ec.OutExt != "" {
+		destPath = strings.TrimSuffix(destPath, filepath.Ext(destPath)) + ec.OutExt
+	}
+	log.Success.Printf("CREATE: %s -> %s", srcPath, destPath)
+
+	// set up and execute the command, capturing stderr in case there was an error
+	if err := s.withDiskPath(destPath, nil, func(diskPath string) error {
+		args := make([]string, len(ec.Command))
+		for i, arg := range ec.Command {
+			arg = strings.Replace(arg, "{src}", srcPath, -1)
+			args[i] = strings.Replace(arg, "{dest}", diskPath, -1)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		stderr := bytes.Buffer{}
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = err.Error()
+			}
+			return fmt.Errorf("while compiling %s with %s: %s", srcPath, args[0], msg)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Add destPath to the list of created files, and to the sitemap if
+	// it is an html page
+	if filepath.Ext(destPath) == ".html" {
+		lastMod, err := modTime(srcPath)
+		if err != nil {
+			return err
+		}
+		s.addSitemapEntry(destPath, lastMod, false)
+	}
+	s.appendPath(&e.createdFiles, destPath)
+	s.depGraph.addOutput(srcPath, destPath)
+
+	return nil
+}
+
+// CompileAll compiles zero or more files identified by srcPaths.
+// It works simply by calling Compile for each path, using up to
+// config.Jobs workers at once. The caller is
+// responsible for only passing in files that belong to ExternalCompilers
+// according to the MatchFunc. Behavior for any other file is undefined.
+func (e *ExternalCompilersType) CompileAll(srcPaths []string) error {
+	log.Default.Println("Compiling with external commands...")
+	return e.state.forEachPath(srcPaths, e.Compile)
+}
+
+func (e *ExternalCompilersType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
+	if match, err := e.CompileMatchFunc()(srcPath); err != nil {
+		return err
+	} else if match {
+		// Only recompile the file at srcPath (or remove it if it was removed)
+		return e.state.fileChangedForCompiler(e, srcPath)
+	}
+	// We don't know which files import the file at srcPath (e.g. files that
+	// start with an underscore), so just recompile everything.
+	return e.state.recompileAllForCompiler(e)
+}
+
+func (e *ExternalCompilersType) RemoveOld() error {
+	// Simply iterate through createdFiles and remove each of them
+	for _, path := range e.createdFiles {
+		if err := e.state.files.RemoveIfExists(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DestDirMoved satisfies DestDirMover
+func (e *ExternalCompilersType) DestDirMoved(from string, to string) {
+	movedPaths(e.createdFiles, from, to)
+}
+
+// externalCompilerForPath returns the first of externalCompilers whose match
+// pattern matches the name of the file at srcPath.
+func externalCompilerForPath(externalCompilers []config.ExternalCompiler, srcPath string) (config.ExternalCompiler, error) {
+	for _, ec := range externalCompilers {
+		if match, err := filepath.Match(ec.Match, filepath.Base(srcPath)); err != nil {
+			return ec, err
+		} else if match {
+			return ec, nil
+		}
+	}
+	return config.ExternalCompiler{}, fmt.Errorf("No external compiler matches %s", srcPath)
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/test_util"
+	"github.com/albrow/scribble/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExternalCompilersCompile(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_external_compilers")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "external")
+	srcDir := filepath.Join(root, "source")
+	destDir := filepath.Join(root, "public")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Use cp as a stand-in for a real compiler
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.DestDir = destDir
+	c.ExternalCompilers = []config.ExternalCompiler{
+		{
+			Match:   "*.ts",
+			Command: []string{"cp", "{src}", "{dest}"},
+			OutExt:  ".js",
+		},
+	}
+	s := NewState(c)
+	externalCompilers := s.compiler("external")
+
+	// Only files which don't start with an underscore should be compiled
+	gotPaths, err := s.FindPaths(externalCompilers.CompileMatchFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	test_util.CheckStringsMatch(t, []string{filepath.Join(srcDir, "scripts", "main.ts")}, gotPaths)
+	if err := externalCompilers.CompileAll(gotPaths); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the compiled result is correct
+	expectedFile := filepath.Join(testFilesDir, "public", "scripts", "main.js")
+	gotFile := filepath.Join(destDir, "scripts", "main.js")
+	test_util.CheckFilesMatch(t, expectedFile, gotFile)
+
+	// If the output is kept in memory, the command should write to a
+	// temporary directory and the result should be copied into memory
+	fs := util.NewMemoryFileSystem()
+	s.Files().Mount(destDir, fs)
+	if err := externalCompilers.Compile(gotPaths[0]); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memFile, err := fs.Open("scripts/main.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(memFile); err != nil {
+		t.Fatal(err)
+	} else if string(got) != string(expected) {
+		t.Errorf("Expected the file in memory to be %q but got %q", expected, got)
+	}
+	if infos, err := ioutil.ReadDir(root); err != nil {
+		t.Fatal(err)
+	} else if len(infos) != 2 {
+		t.Errorf("Expected nothing to be written next to the dest dir. Got %d entries in %s", len(infos), root)
+	}
+	diskPath := ""
+	if err := s.withDiskPath(gotFile, nil, func(path string) error {
+		diskPath = path
+		return ioutil.WriteFile(path, expected, os.ModePerm)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(diskPath, os.TempDir()) {
+		t.Errorf("Expected the command to write to the temporary directory %s but it wrote to %s", os.TempDir(), diskPath)
+	}
+	if _, err := os.Stat(diskPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary directory to be removed but %s still exists", diskPath)
+	}
+	s.Files().Unmount(destDir)
+
+	// If the command fails, anything it wrote to stderr should be in the error
+	c.ExternalCompilers[0].Command = []string{"sh", "-c", "echo 'syntax error in {src}' >&2; exit 1"}
+	err = externalCompilers.Compile(gotPaths[0])
+	if err == nil {
+		t.Fatal("Expected an error when the command fails but got none")
+	}
+	if expected := "syntax error in " + gotPaths[0]; !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected the error to contain %q but got: %s", expected, err.Error())
+	}
+
+	// Files which another compiler is responsible for should not be matched,
+	// even if they match the pattern
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "index.md"), []byte("# Hello"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	c.ExternalCompilers = []config.ExternalCompiler{
+		{
+			Match:   "*.ts",
+			Command: []string{"cp", "{src}", "{dest}"},
+		},
+		{
+			Match:   "*.md",
+			Command: []string{"cp", "{src}", "{dest}"},
+		},
+	}
+	s = NewState(c)
+	s.initCompilers()
+	expectedPaths := []string{
+		filepath.Join(srcDir, "scripts", "main.ts"),
+	}
+	if gotPaths, err := s.FindPaths(s.compiler("external").CompileMatchFunc()); err != nil {
+		t.Fatal(err)
+	} else {
+		test_util.CheckStringsMatch(t, expectedPaths, gotPaths)
+	}
+	expectedPaths = append(expectedPaths, filepath.Join(srcDir, "scripts", "_partial.ts"))
+	if gotPaths, err := s.FindPaths(s.compiler("external").WatchMatchFunc()); err != nil {
+		t.Fatal(err)
+	} else {
+		test_util.CheckStringsMatch(t, expectedPaths, gotPaths)
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/albrow/scribble/log"
+	"github.com/howeyc/fsnotify"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FeedsCompilerType represents a type capable of generating rss and atom
+// feeds for posts.
+type FeedsCompilerType struct {
+	state     *State
+	pathMatch string
+	// createdFiles is a slice of file paths which were created by this
+	// compiler. It is important for implementing the RemoveOld method.
+	createdFiles []string
+}
+
+const (
+	// the names of the generated feed files, relative to config.DestDir
+	rssFeedName  = "feed.xml"
+	atomFeedName = "atom.xml"
+)
+
+// Init should be called before any other methods. In this case, Init
+// sets up the pathMatch variable based on config.PostsDir.
+func (f *FeedsCompilerType) Init() {
+	f.pathMatch = filepath.Join(f.state.config.PostsDir, "*.md")
+}
+
+// CompileMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. Feeds are made out of posts,
+// so the pattern is the same as it is for PostsCompiler. If feeds are
+// disabled in config, the MatchFunc never returns true.
+func (f *FeedsCompilerType) CompileMatchFunc() MatchFunc {
+	if !f.state.config.Feeds {
+		return unionMatchFuncs()
+	}
+	return pathMatchFunc(f.pathMatch, true, false)
+}
+
+// AlwaysCompile satisfies AlwaysCompiler. The feeds are generated
+// whenever they are enabled in config, even if there are no posts yet.
+func (f *FeedsCompilerType) AlwaysCompile() bool {
+	return f.state.config.Feeds
+}
+
+// WatchMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. In this case, the pattern
+// is the same as it is for CompileMatchFunc.
+func (f *FeedsCompilerType) WatchMatchFunc() MatchFunc {
+	return f.CompileMatchFunc()
+}
+
+// Compile regenerates the feeds. Every post may appear in the
+// feeds, so there is no way to compile the feeds for a single
+// post at srcPath.
+func (f *FeedsCompilerType) Compile(srcPath string) error {
+	s := f.state
+	if s.config.BaseURL == "" {
+		return fmt.Errorf("Missing required config variable: baseURL. Please add it to config.toml or set feeds to false.")
+	}
+	feedPosts := f.feedPosts()
+	rssPath := filepath.Join(s.destDir, rssFeedName)
+	log.Success.Printf("CREATE: %s -> %s", s.config.PostsDir, rssPath)
+	if err := s.writeXML(rssPath, f.newRSSFeed(feedPosts)); err != nil {
+		return err
+	}
+	f.createdFiles = append(f.createdFiles, rssPath)
+	atomPath := filepath.Join(s.destDir, atomFeedName)
+	log.Success.Printf("CREATE: %s -> %s", s.config.PostsDir, atomPath)
+	atom, err := f.newAtomFeed(feedPosts)
+	if err != nil {
+		return err
+	}
+	if err := s.writeXML(atomPath, atom); err != nil {
+		return err
+	}
+	f.createdFiles = append(f.createdFiles, atomPath)
+	return nil
+}
+
+// CompileAll regenerates the feeds once for all the posts. The
+// posts must have already been parsed by PostsCompiler.
+func (f *FeedsCompilerType) CompileAll(srcPaths []string) error {
+	log.Default.Println("Compiling feeds...")
+	return f.Compile("")
+}
+
+func (f *FeedsCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
+	// PostsCompiler is notified of the change first, so by now
+	// the posts have been parsed again and we can simply regenerate
+	// the feeds.
+	if err := f.state.recompileAllForCompiler(f); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FeedsCompilerType) RemoveOld() error {
+	// Simply iterate through createdFiles and remove each of them
+	for _, path := range f.createdFiles {
+		if err := f.state.files.RemoveIfExists(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DestDirMoved satisfies DestDirMover
+func (f *FeedsCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(f.createdFiles, from, to)
+}
+
+// feedPosts returns the posts which should be included in the
+// feeds, with the most recent first and up to config.FeedLimit
+// posts.
+func (f *FeedsCompilerType) feedPosts() []*Post {
+	sortedPosts := f.state.Posts()
+	result := []*Post{}
+	for i := len(sortedPosts) - 1; i >= 0; i-- {
+		if f.state.config.FeedLimit > 0 && len(result) == f.state.config.FeedLimit {
+			break
+		}
+		result = append(result, sortedPosts[i])
+	}
+	return result
+}
+
+// feedContent returns the content of post as it should appear in
+// the feeds, according to config.FeedContent.
+func (f *FeedsCompilerType) feedContent(post *Post) string {
+	if f.state.config.FeedContent == "summary" {
+		return string(post.Summary)
+	}
+	return string(post.Content)
+}
+
+// feedUpdated returns the date of the most recent post, or the current
+// time if there are no posts. Using the date of the most recent post
+// instead of the current time means the feeds only change when the
+// posts do.
+func feedUpdated(feedPosts []*Post) time.Time {
+	if len(feedPosts) == 0 {
+		return time.Now()
+	}
+	return feedPosts[0].Date
+}
+
+// contextString returns the value identified by key in the context for
+// the site as a string, or an empty string if there is no such value.
+func (s *State) contextString(key string) string {
+	if value, found := s.config.Context[key]; found {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// absoluteUrl converts a url path (e.g. the Url field of a post) into an
+// absolute url by prepending config.BaseURL.
+func (s *State) absoluteUrl(path string) string {
+	return strings.TrimSuffix(s.config.BaseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// writeXML encodes v as xml and writes it to a file at path,
+// including the standard xml header.
+func (s *State) writeXML(path string, v interface{}) error {
+	destFile, err := s.files.CreateFileWithPath(path)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+	if _, err := destFile.WriteString(xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(destFile)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	_, err = destFile.WriteString("\n")
+	return err
+}
+
+// rssFeed is the root element of an rss 2.0 feed.
+// See http://cyber.law.harvard.edu/rss/rss.html
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	AtomLink      rssLink   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// newRSSFeed creates an rss feed for feedPosts.
+func (f *FeedsCompilerType) newRSSFeed(feedPosts []*Post) *rssFeed {
+	s := f.state
+	feed := &rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       s.contextString("title"),
+			Link:        s.absoluteUrl("/"),
+			Description: s.contextString("description"),
+			AtomLink: rssLink{
+				Href: s.absoluteUrl(rssFeedName),
+				Rel:  "self",
+				Type: "application/rss+xml",
+			},
+		},
+	}
+	if updated := feedUpdated(feedPosts); !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+	for _, post := range feedPosts {
+		link := s.absoluteUrl(string(post.Url))
+		item := rssItem{
+			Title:       post.Title,
+			Link:        link,
+			Guid:        rssGuid{IsPermaLink: true, Value: link},
+			Creator:     post.Author,
+			Description: f.feedContent(post),
+		}
+		if !post.Date.IsZero() {
+			item.PubDate = post.Date.Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+// atomFeed is the root element of an atom feed.
+// See https://tools.ietf.org/html/rfc4287
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Id       string      `xml:"id"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Author   *atomAuthor `xml:"author,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary *atomText   `xml:"summary,omitempty"`
+	Content *atomText   `xml:"content,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// newAtomFeed creates an atom feed for feedPosts.
+func (f *FeedsCompilerType) newAtomFeed(feedPosts []*Post) (*atomFeed, error) {
+	s := f.state
+	feed := &atomFeed{
+		Title:    s.contextString("title"),
+		Subtitle: s.contextString("description"),
+		Id:       s.absoluteUrl("/"),
+		Links: []atomLink{
+			{Href: s.absoluteUrl("/")},
+			{Href: s.absoluteUrl(atomFeedName), Rel: "self"},
+		},
+		Updated: feedUpdated(feedPosts).Format(time.RFC3339),
+	}
+	if author := s.contextString("author"); author != "" {
+		feed.Author = &atomAuthor{Name: author}
+	}
+	for _, post := range feedPosts {
+		// Every atom entry needs an updated time, so posts without a date
+		// use the time their file was last modified, like in the sitemap
+		updated, err := postLastMod(post)
+		if err != nil {
+			return nil, err
+		}
+		link := s.absoluteUrl(string(post.Url))
+		entry := atomEntry{
+			Title:   post.Title,
+			Id:      link,
+			Link:    atomLink{Href: link},
+			Updated: updated.Format(time.RFC3339),
+		}
+		if post.Author != "" {
+			entry.Author = &atomAuthor{Name: post.Author}
+		}
+		if s.config.FeedContent == "summary" {
+			entry.Summary = &atomText{Type: "html", Value: f.feedContent(post)}
+		} else {
+			entry.Content = &atomText{Type: "html", Value: f.feedContent(post)}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/test_util"
+	"github.com/albrow/scribble/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeedsCompile(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_feeds_compiler")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Attempt to compile the feeds
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "feeds")
+	destDir := filepath.Join(root, "public")
+	c := config.Default()
+	c.DestDir = destDir
+	c.BaseURL = "http://example.com/"
+	c.FeedLimit = 2
+	c.Context["title"] = "Test Blog"
+	c.Context["description"] = "A blog for testing"
+	c.Context["author"] = "Alex Browne"
+	s := NewState(c)
+
+	// Feeds are made out of posts which have already been parsed, so
+	// we can create the posts directly
+	s.posts = []*Post{
+		{
+			Title:   "One",
+			Date:    time.Date(2014, time.November, 16, 13, 50, 53, 0, time.UTC),
+			Url:     "/one",
+			Content: "<p>The first post</p>\n",
+		},
+		{
+			Title:   "Two",
+			Date:    time.Date(2014, time.November, 17, 13, 50, 53, 0, time.UTC),
+			Url:     "/two",
+			Content: "<p>The second post</p>\n",
+		},
+		{
+			Title:   "Three",
+			Author:  "Alex Browne",
+			Date:    time.Date(2014, time.November, 18, 13, 50, 53, 0, time.UTC),
+			Url:     "/three",
+			Content: "<p>The third post &amp; some <em>html</em></p>\n",
+		},
+	}
+	if err := s.compiler("feeds").CompileAll(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the compiled results are correct
+	expectedDir := filepath.Join(testFilesDir, "public")
+	for _, file := range []string{rssFeedName, atomFeedName} {
+		test_util.CheckFilesMatch(t, filepath.Join(expectedDir, file), filepath.Join(destDir, file))
+	}
+}
+
+func TestFeedsCompileWithoutBaseURL(t *testing.T) {
+	c := config.Default()
+	c.BaseURL = ""
+	if err := NewState(c).compiler("feeds").Compile(""); err == nil {
+		t.Error("Expected an error when compiling feeds without baseURL but got none")
+	}
+}
+
+func TestFeedsCompileWithoutPosts(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_feeds_compiler_without_posts")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// The feeds should be generated whenever they are enabled, even
+	// though there are no posts for them to be made out of
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	c.Feeds = true
+	c.BaseURL = "http://example.com/"
+	if err := os.MkdirAll(c.SourceDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewState(c).CompileAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// With no posts, the atom feed should fall back to the build time
+	// instead of the zero time
+	data, err := ioutil.ReadFile(filepath.Join(c.DestDir, atomFeedName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "0001-01-01") {
+		t.Errorf("Expected the atom feed to have a real updated time but got:\n%s", string(data))
+	}
+}
+
+func TestAtomFeedWithoutPostDate(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_atom_feed_without_post_date")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// A post without a date should use the time its file was last modified
+	srcPath := filepath.Join(root, "source", "_posts", "undated.md")
+	file, err := util.CreateFileWithPath(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	modified := time.Date(2015, time.March, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(srcPath, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	c := config.Default()
+	c.BaseURL = "http://example.com/"
+	f := NewState(c).compiler("feeds").(*FeedsCompilerType)
+	feed, err := f.newAtomFeed([]*Post{{Title: "Undated", Url: "/undated", src: srcPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := feed.Entries[0].Updated, modified.Local().Format(time.RFC3339); got != expected {
+		t.Errorf("Expected the entry to be updated at %s but got %s", expected, got)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/BurntSushi/toml"
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/context"
 	"github.com/albrow/scribble/log"
 	"github.com/albrow/scribble/util"
@@ -21,15 +20,13 @@ import (
 
 // HtmlTemplatesCompilerType represents a type capable of compiling go html template files.
 type HtmlTemplatesCompilerType struct {
+	state       *State
 	layoutFiles []string
 	// createdFiles is a slice of file paths which were created by this
 	// compiler. It is important for implementing the RemoveOld method.
 	createdFiles []string
 }
 
-// HtmlTemplatesCompiler is an instatiation of HtmlTemplatesCompilerType
-var HtmlTemplatesCompiler = HtmlTemplatesCompilerType{}
-
 // CompileMatchFunc returns a MatchFunc which will return true for
 // any files which match a given pattern. In this case, the pattern
 // is any file that ends in ".tmpl", excluding hidden and ignored
@@ -49,7 +46,7 @@ func (c *HtmlTemplatesCompilerType) WatchMatchFunc() MatchFunc {
 	// they only affect posts, so we don't need to recompile any other
 	// html template files.
 	htmlTemplatesMatch := filenameMatchFunc("*.tmpl", true, false)
-	postLayoutsMatch := pathMatchFunc(filepath.Join(config.PostLayoutsDir, "*.tmpl"), true, false)
+	postLayoutsMatch := pathMatchFunc(filepath.Join(c.state.config.PostLayoutsDir, "*.tmpl"), true, false)
 	// excludeMatchFuncs lets us express these conditions easily. It
 	// returns a MatchFunc which will return true iff the path represents
 	// and html template *and* is *not* in the post layouts dir. I.e., if
@@ -61,7 +58,7 @@ func (c *HtmlTemplatesCompilerType) WatchMatchFunc() MatchFunc {
 // Init should be called before any other methods. In this case, Init
 // finds and loads the layout templates in config.LayoutsDir
 func (c *HtmlTemplatesCompilerType) Init() {
-	pattern := filepath.Join(config.LayoutsDir, "*.tmpl")
+	pattern := filepath.Join(c.state.config.LayoutsDir, "*.tmpl")
 	files, err := filepath.Glob(pattern)
 	if err != nil {
 		panic(err)
@@ -75,9 +72,10 @@ func (c *HtmlTemplatesCompilerType) Init() {
 // undefined. Compile will output the compiled result to the appropriate
 // location in config.DestDir.
 func (c *HtmlTemplatesCompilerType) Compile(srcPath string) error {
+	s := c.state
 	// parse path and figure out destPath
 	destPath := strings.Replace(srcPath, ".tmpl", ".html", 1)
-	destPath = strings.Replace(destPath, config.SourceDir, config.DestDir, 1)
+	destPath = strings.Replace(destPath, s.config.SourceDir, s.destDir, 1)
 	log.Success.Printf("CREATE: %s -> %s", srcPath, destPath)
 
 	// Open the source file
@@ -89,119 +87,162 @@ func (c *HtmlTemplatesCompilerType) Compile(srcPath string) error {
 
 	// Split source file into front matter and content
 	frontMatter, content, err := util.SplitFrontMatter(reader)
-	pageContext := context.CopyContext()
+	srcFile.Close()
+	if err != nil {
+		return err
+	}
+	pageContext := s.config.Context.Copy()
 	if frontMatter != "" {
 		if _, err := toml.Decode(frontMatter, pageContext); err != nil {
 			return err
 		}
 	}
 
-	// Create the template by parsing the raw content. Then parse all the layout files, include files, and add context.FuncMap
+	// Create the template by parsing the raw content. Then parse all the layout files, include files, and add the FuncMap
 	tmpl := template.New(filepath.Base(srcPath))
-	tmpl.Funcs(context.FuncMap)
+	tmpl.Funcs(s.funcMap)
 	if _, err := tmpl.Parse(content); err != nil {
 		return err
 	}
-	if config.LayoutsDir != "" {
-		if _, err := tmpl.ParseGlob(filepath.Join(config.LayoutsDir, "*.tmpl")); err != nil {
+	if s.config.LayoutsDir != "" {
+		if _, err := tmpl.ParseGlob(filepath.Join(s.config.LayoutsDir, "*.tmpl")); err != nil {
 			return err
 		}
 	} else {
 		// config.LayoutsDir is more or less required. Every page must have a layout
 		return fmt.Errorf("Missing required config variable: layoutsDir. Please add it to config.toml.")
 	}
-	if config.IncludesDir != "" {
+	if s.config.IncludesDir != "" {
 		// config.IncludesDir, on the other hand, is optional. You don't have to use includes.
-		if _, err := tmpl.ParseGlob(filepath.Join(config.IncludesDir, "*.tmpl")); err != nil {
+		if _, err := tmpl.ParseGlob(filepath.Join(s.config.IncludesDir, "*.tmpl")); err != nil {
 			return err
 		}
 	}
 
-	// Create and write to the destination file
-	destFile, err := util.CreateFileWithPath(destPath)
+	// If the page is paginated, it will be rendered once for each page of
+	// posts. Otherwise it is only rendered once, without a Paginator.
+	pages := []*Paginator{nil}
+	if s.isPaginated(srcPath) {
+		pages = s.paginators()
+	}
+	lastMod, err := modTime(srcPath)
 	if err != nil {
 		return err
 	}
-	if err := tmpl.Execute(destFile, pageContext); err != nil {
-		return err
+	for _, page := range pages {
+		pageDestPath := destPath
+		if page != nil {
+			pageContext["Paginator"] = page
+			pageDestPath = s.paginatorDestPath(destPath, page.PageNumber)
+			if page.PageNumber > 1 {
+				log.Success.Printf("CREATE: %s -> %s", srcPath, pageDestPath)
+			}
+		}
+
+		// Create and write to the destination file
+		destFile, err := s.files.CreateFileWithPath(pageDestPath)
+		if err != nil {
+			return err
+		}
+		if err := tmpl.Execute(destFile, pageContext); err != nil {
+			destFile.Close()
+			return err
+		}
+		if err := destFile.Close(); err != nil {
+			return err
+		}
+		s.addSitemapEntry(pageDestPath, lastMod, excludedFromSitemap(pageContext))
+
+		// Add the created file to the list of created files
+		s.appendPath(&c.createdFiles, pageDestPath)
+		s.depGraph.addOutput(srcPath, pageDestPath)
 	}
 
-	// Add the created file to the list of created files
-	c.createdFiles = append(c.createdFiles, destPath)
+	// Keep track of the files the page depends on, so it can be recompiled
+	// whenever one of them changes
+	deps, err := s.htmlTemplateDependencies(srcPath)
+	if err != nil {
+		return err
+	}
+	s.depGraph.setDependencies(srcPath, deps)
 
 	return nil
 }
 
 // CompileAll compiles zero or more files identified by srcPaths.
-// It works simply by calling Compile for each path. The caller is
+// It works simply by calling Compile for each path, using up to
+// config.Jobs workers at once. The caller is
 // responsible for only passing in files that belong to HtmlTemplatesCompiler
 // according to the MatchFunc. Behavior for any other file is undefined.
 func (c *HtmlTemplatesCompilerType) CompileAll(srcPaths []string) error {
 	log.Default.Println("Compiling go html templates...")
-	for _, srcPath := range srcPaths {
-		if err := c.Compile(srcPath); err != nil {
-			return err
-		}
-	}
-	return nil
+	return c.state.forEachPath(srcPaths, c.Compile)
 }
 
 func (c *HtmlTemplatesCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
-	// TODO: Analyze template files and be more intelligent here?
-	// If a single file was changed, only recompile that file. If a
-	// layout file was changed, recompile all the files that use that
-	// layout. For now, just recompile all html templates.
-	if err := recompileAllForCompiler(c); err != nil {
-		return err
-	}
-	return nil
+	// If a single file was changed, only recompile that file. If a layout
+	// or include was changed, recompile all the files that depend on it.
+	return c.state.fileChangedForCompiler(c, srcPath)
 }
 
 func (c *HtmlTemplatesCompilerType) RemoveOld() error {
 	// Simply iterate through createdFiles and remove each of them
 	for _, path := range c.createdFiles {
-		if err := util.RemoveIfExists(path); err != nil {
+		if err := c.state.files.RemoveIfExists(path); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// DestDirMoved satisfies DestDirMover
+func (c *HtmlTemplatesCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(c.createdFiles, from, to)
+}
+
 func (c *HtmlTemplatesCompilerType) PostLayoutMatchFunc() MatchFunc {
 	return filenameMatchFunc("*.tmpl", true, false)
 }
 
 func (c *HtmlTemplatesCompilerType) RenderPost(post *Post, destPath string) error {
+	// Render the post with the proper context
+	postContext := c.state.config.Context.Copy()
+	postContext["Post"] = post
+	if err := c.RenderPostLayout(c.state.postLayoutPath(post), postContext, destPath); err != nil {
+		return fmt.Errorf("ERROR compiling html template for posts: %s", err.Error())
+	}
+	return nil
+}
+
+func (c *HtmlTemplatesCompilerType) RenderPostLayout(layoutPath string, layoutContext context.Context, destPath string) error {
+	s := c.state
 	// Create the template object by parsing all the files we might need
-	postLayoutFile := filepath.Join(config.PostLayoutsDir, post.LayoutName)
-	otherLayoutFiles, err := filepath.Glob(filepath.Join(config.LayoutsDir, "*.tmpl"))
+	postLayoutFile := layoutPath
+	otherLayoutFiles, err := filepath.Glob(filepath.Join(s.config.LayoutsDir, "*.tmpl"))
 	if err != nil {
 		return err
 	}
 	allFiles := append([]string{postLayoutFile}, otherLayoutFiles...)
-	if config.IncludesDir != "" {
-		includeFiles, err := filepath.Glob(filepath.Join(config.IncludesDir, "*tmpl"))
+	if s.config.IncludesDir != "" {
+		includeFiles, err := filepath.Glob(filepath.Join(s.config.IncludesDir, "*tmpl"))
 		if err != nil {
 			return err
 		}
 		allFiles = append(allFiles, includeFiles...)
 	}
-	tmpl, err := template.ParseFiles(allFiles...)
-	if err != nil {
+	tmpl := template.New(filepath.Base(postLayoutFile))
+	tmpl.Funcs(s.funcMap)
+	if _, err := tmpl.ParseFiles(allFiles...); err != nil {
 		return err
 	}
 
 	// Create the index file
-	destFile, err := util.CreateFileWithPath(destPath)
+	destFile, err := s.files.CreateFileWithPath(destPath)
 	if err != nil {
 		return err
 	}
+	defer destFile.Close()
 
-	// Render the post with the proper context and write the results to the destFile
-	postContext := context.CopyContext()
-	postContext["Post"] = post
-	if err := tmpl.Execute(destFile, postContext); err != nil {
-		return fmt.Errorf("ERROR compiling html template for posts: %s", err.Error())
-	}
-	return nil
+	// Render the layout with the given context and write the results to the destFile
+	return tmpl.Execute(destFile, layoutContext)
 }
@@ -48,8 +48,10 @@ func TestHtmlTemplatesPathMatch(t *testing.T) {
 	}
 
 	// Use the MatchFunc to find all the paths
-	config.SourceDir = root
-	gotPaths, err := FindPaths(HtmlTemplatesCompiler.CompileMatchFunc())
+	c := config.Default()
+	c.SourceDir = root
+	s := NewState(c)
+	gotPaths, err := s.FindPaths(s.compiler("html").CompileMatchFunc())
 	if err != nil {
 		t.Error(err)
 	}
@@ -78,11 +80,12 @@ func TestHtmlTemplatesCompile(t *testing.T) {
 	}
 
 	// Attempt to compile the html template files
-	config.SourceDir = filepath.Join(root, "source")
-	config.DestDir = filepath.Join(root, "public")
-	config.LayoutsDir = filepath.Join(config.SourceDir, "_layouts")
-	config.IncludesDir = filepath.Join(config.SourceDir, "_includes")
-	if err := HtmlTemplatesCompiler.Compile(filepath.Join(srcDir, "index.tmpl")); err != nil {
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.IncludesDir = filepath.Join(c.SourceDir, "_includes")
+	if err := NewState(c).compiler("html").Compile(filepath.Join(srcDir, "index.tmpl")); err != nil {
 		t.Fatal(err)
 	}
 
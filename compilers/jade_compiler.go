@@ -5,29 +5,24 @@
 package compilers
 
 import (
-	"encoding/json"
+	"bufio"
 	"fmt"
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/context"
+	"github.com/albrow/scribble/jade"
 	"github.com/albrow/scribble/log"
-	"github.com/albrow/scribble/util"
 	"github.com/howeyc/fsnotify"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
 )
 
 // JadeCompilerType represents a type capable of compiling jade files.
 type JadeCompilerType struct {
+	state *State
 	// createdFiles is a slice of file paths which were created by this
 	// compiler. It is important for implementing the RemoveOld method.
 	createdFiles []string
 }
 
-// JadeCompiler is an instatiation of JadeCompilerType
-var JadeCompiler = JadeCompilerType{}
-
 // CompileMatchFunc returns a MatchFunc which will return true for
 // any files which match a given pattern. In this case, the pattern
 // is any file that ends in ".jade", excluding hidden and ignored
@@ -51,106 +46,167 @@ func (*JadeCompilerType) WatchMatchFunc() MatchFunc {
 // undefined. Compile will output the compiled result to the appropriate
 // location in config.DestDir.
 func (j *JadeCompilerType) Compile(srcPath string) error {
+	s := j.state
 	// parse path and figure out destPath
 	destPath := strings.Replace(srcPath, ".jade", ".html", 1)
-	destPath = strings.Replace(destPath, config.SourceDir, config.DestDir, 1)
+	destPath = strings.Replace(destPath, s.config.SourceDir, s.destDir, 1)
 	log.Success.Printf("CREATE: %s -> %s", srcPath, destPath)
 
-	// create the dest directory if needed
-	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-		// if the dir already exists, that's fine
-		// if there was some other error, return it
-		if !os.IsExist(err) {
-			return err
-		}
+	tmpl, err := j.parseJade(srcPath)
+	if err != nil {
+		return err
 	}
 
-	// convert context to json to pass to jade
-	// TODO: read frontmatter and add it to the context?
-	pageContext := context.CopyContext()
-	pageContext["Posts"] = Posts()
-	jsonContext, err := json.Marshal(pageContext)
+	pageContext := s.config.Context.Copy()
+	pageContext["Posts"] = s.Posts()
+	pageContext["Collections"] = s.Collections()
+	pageContext["Tags"] = s.Tags()
+	pageContext["Categories"] = s.Categories()
+
+	// If the page is paginated, it will be rendered once for each page of
+	// posts. Otherwise it is only rendered once, without a Paginator.
+	pages := []*Paginator{nil}
+	if s.isPaginated(srcPath) {
+		pages = s.paginators()
+	}
+	lastMod, err := modTime(srcPath)
 	if err != nil {
+		return err
+	}
+	exclude, err := jadeExcludedFromSitemap(srcPath)
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		pageDestPath := destPath
+		if page != nil {
+			pageContext["Paginator"] = page
+			pageDestPath = s.paginatorDestPath(destPath, page.PageNumber)
+			if page.PageNumber > 1 {
+				log.Success.Printf("CREATE: %s -> %s", srcPath, pageDestPath)
+			}
+		}
+		if err := j.executeJade(tmpl, pageContext, pageDestPath); err != nil {
+			return err
+		}
+		s.addSitemapEntry(pageDestPath, lastMod, exclude)
 
+		// Add pageDestPath to the list of created files
+		s.appendPath(&j.createdFiles, pageDestPath)
+		s.depGraph.addOutput(srcPath, pageDestPath)
 	}
 
-	// set up and execute the command, capturing the output only if there was an error
-	destDir := filepath.Dir(destPath)
-	cmd := exec.Command("jade", srcPath, "--out", destDir, "--obj", string(jsonContext))
-	response, err := cmd.CombinedOutput()
+	// Keep track of the files the page depends on, so it can be recompiled
+	// whenever one of them changes
+	deps, err := templateDependencies(tmpl.Files())
 	if err != nil {
-		return fmt.Errorf("while compiling jade: %s", string(response))
+		return err
 	}
-
-	// Add destPath to the list of created files
-	j.createdFiles = append(j.createdFiles, destPath)
+	s.depGraph.setDependencies(srcPath, deps)
 
 	return nil
 }
 
 // CompileAll compiles zero or more files identified by srcPaths.
-// It works simply by calling Compile for each path. The caller is
+// It works simply by calling Compile for each path, using up to
+// config.Jobs workers at once. The caller is
 // responsible for only passing in files that belong to JadeCompiler
 // according to the MatchFunc. Behavior for any other file is undefined.
 func (j *JadeCompilerType) CompileAll(srcPaths []string) error {
 	log.Default.Println("Compiling jade...")
-	for _, srcPath := range srcPaths {
-		if err := j.Compile(srcPath); err != nil {
-			return err
-		}
-	}
-	return nil
+	return j.state.forEachPath(srcPaths, j.Compile)
 }
 
 func (j *JadeCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
-	// TODO: Analyze jade files and be more intelligent here?
-	// Only recompile the file at srcPath and any files that import it?
-	// For now, just recompile all jade.
-	if err := recompileAllForCompiler(j); err != nil {
-		return err
-	}
-	return nil
+	// Only recompile the file at srcPath (if it was not removed) and any
+	// files that include or extend it.
+	return j.state.fileChangedForCompiler(j, srcPath)
 }
 
 func (j *JadeCompilerType) RemoveOld() error {
 	// Simply iterate through createdFiles and remove each of them
 	for _, path := range j.createdFiles {
-		if err := util.RemoveIfExists(path); err != nil {
+		if err := j.state.files.RemoveIfExists(path); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *JadeCompilerType) PostLayoutMatchFunc() MatchFunc {
+// DestDirMoved satisfies DestDirMover
+func (j *JadeCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(j.createdFiles, from, to)
+}
+
+func (j *JadeCompilerType) PostLayoutMatchFunc() MatchFunc {
 	return filenameMatchFunc("*.jade", true, false)
 }
 
-func (c *JadeCompilerType) RenderPost(post *Post, destPath string) error {
-	// Create the context for the post (in this case json data)
-	postContext := context.CopyContext()
+func (j *JadeCompilerType) RenderPost(post *Post, destPath string) error {
+	// Create the context for the post
+	postContext := j.state.config.Context.Copy()
 	postContext["Post"] = post
-	jsonContext, err := json.Marshal(postContext)
+	return j.RenderPostLayout(j.state.postLayoutPath(post), postContext, destPath)
+}
+
+func (j *JadeCompilerType) RenderPostLayout(layoutPath string, layoutContext context.Context, destPath string) error {
+	tmpl, err := j.parseJade(layoutPath)
+	if err != nil {
+		return err
+	}
+	return j.executeJade(tmpl, layoutContext, destPath)
+}
+
+// jadeExcludedFromSitemap returns true iff the jade file at path has the
+// comment "//- sitemap: false" before any of its content, i.e. among the
+// blank lines and comments at the start of the file. Jade files don't have
+// frontmatter, so the comment takes the place of sitemap = false.
+func jadeExcludedFromSitemap(path string) (bool, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("ERROR converting post context to json for jade post layout:\n%s", err.Error())
+		return false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			// The content of the page has started
+			break
+		}
+		if fields := strings.SplitN(strings.TrimPrefix(line, "//-"), ":", 2); len(fields) == 2 {
+			if strings.TrimSpace(fields[0]) == "sitemap" && strings.TrimSpace(fields[1]) == "false" {
+				return true, nil
+			}
+		}
 	}
+	return false, scanner.Err()
+}
 
-	// set up and execute the command, capturing the output only if there was an error
-	postLayoutFile := filepath.Join(config.PostLayoutsDir, post.LayoutName)
-	destDir := filepath.Dir(destPath)
-	cmd := exec.Command("jade", postLayoutFile, "--out", destDir, "--obj", string(jsonContext))
-	response, err := cmd.CombinedOutput()
+// parseJade parses the jade file at path, along with any files that it
+// extends or includes, and makes the functions in the FuncMap for the site
+// available to it.
+func (j *JadeCompilerType) parseJade(path string) (*jade.Template, error) {
+	tmpl, err := jade.ParseFile(path)
 	if err != nil {
-		return fmt.Errorf("while compiling jade: %s", string(response))
+		return nil, fmt.Errorf("while compiling jade: %s", err)
 	}
+	return tmpl.Funcs(j.state.funcMap), nil
+}
 
-	// jade does not allow us to specify the filename, so we'll manually do a rename
-	// TODO: on unixy systems use a pipe or redirect to a file
-	layoutNameExt := filepath.Ext(post.LayoutName)
-	layoutNameNoExt := strings.Replace(post.LayoutName, layoutNameExt, "", 1)
-	oldName := strings.Replace(destPath, "index", layoutNameNoExt, 1)
-	if err := os.Rename(oldName, destPath); err != nil {
+// executeJade renders tmpl with the given context and writes the result to
+// destPath.
+func (j *JadeCompilerType) executeJade(tmpl *jade.Template, jadeContext context.Context, destPath string) error {
+	destFile, err := j.state.files.CreateFileWithPath(destPath)
+	if err != nil {
 		return err
 	}
+	defer destFile.Close()
+	if err := tmpl.Execute(destFile, jadeContext); err != nil {
+		return fmt.Errorf("while compiling jade: %s", err)
+	}
 	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/albrow/scribble/util"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -45,8 +46,10 @@ func TestJadePathMatch(t *testing.T) {
 	}
 
 	// Use the MatchFunc to find all the paths
-	config.SourceDir = root
-	gotPaths, err := FindPaths(JadeCompiler.CompileMatchFunc())
+	c := config.Default()
+	c.SourceDir = root
+	s := NewState(c)
+	gotPaths, err := s.FindPaths(s.compiler("jade").CompileMatchFunc())
 	if err != nil {
 		t.Error(err)
 	}
@@ -75,9 +78,10 @@ func TestJadeCompile(t *testing.T) {
 	}
 
 	// Attempt to compile the html template files
-	config.SourceDir = filepath.Join(root, "source")
-	config.DestDir = filepath.Join(root, "public")
-	if err := JadeCompiler.Compile(filepath.Join(srcDir, "index.jade")); err != nil {
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	if err := NewState(c).compiler("jade").Compile(filepath.Join(srcDir, "index.jade")); err != nil {
 		t.Fatal(err)
 	}
 
@@ -85,3 +89,50 @@ func TestJadeCompile(t *testing.T) {
 	expectedDir := filepath.Join(testFilesDir, "public")
 	test_util.CheckFilesMatch(t, filepath.Join(expectedDir, "index.html"), filepath.Join(destDir, "index.html"))
 }
+
+func TestJadeSitemapOptOut(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_jade_sitemap_opt_out")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Only a "//- sitemap: false" comment before the content of the page
+	// should keep it out of the sitemap
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	s := NewState(c)
+	testCases := []struct {
+		name     string
+		content  string
+		expected bool
+	}{
+		{"secret.jade", "//- sitemap: false\nh1 Secret\n", true},
+		{"draft.jade", "//- A page which is not ready yet\n\n//- sitemap : false\nh1 Draft\n", true},
+		{"late.jade", "h1 Late\n//- sitemap: false\n", false},
+		{"public.jade", "h1 Public\n", false},
+	}
+	for _, tc := range testCases {
+		srcPath := filepath.Join(c.SourceDir, tc.name)
+		file, err := util.CreateFileWithPath(srcPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString(tc.content); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if err := s.compiler("jade").Compile(srcPath); err != nil {
+			t.Fatal(err)
+		}
+		destPath := filepath.Join(c.DestDir, strings.TrimSuffix(tc.name, ".jade")+".html")
+		if got := s.sitemapEntries[destPath].exclude; got != tc.expected {
+			t.Errorf("Expected exclude to be %v for %s but got %v", tc.expected, tc.name, got)
+		}
+	}
+}
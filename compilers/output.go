@@ -0,0 +1,57 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// withDiskPath calls run with a path on disk where an external command can
+// write the file for destPath. Usually that is destPath itself. If destPath is
+// kept in memory instead, run gets a path in a new directory in the system's
+// temporary directory (see os.TempDir), at the same depth as destPath so that
+// any relative paths the command writes (e.g. in source maps) are still
+// correct. The directory next to config.DestDir may not even exist, e.g. when
+// a site is served from memory. Afterwards the file
+// is copied to destPath, along with the files with each of the given suffixes
+// if the command wrote them, e.g. ".map".
+func (s *State) withDiskPath(destPath string, suffixes []string, run func(diskPath string) error) error {
+	if s.files.OnDisk(destPath) {
+		if err := s.files.MkdirAll(filepath.Dir(destPath)); err != nil {
+			return err
+		}
+		return run(destPath)
+	}
+	rel, err := filepath.Rel(s.destDir, destPath)
+	if err != nil {
+		return err
+	}
+	tempDir, err := ioutil.TempDir("", "scribble-command-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	diskPath := filepath.Join(tempDir, rel)
+	if err := os.MkdirAll(filepath.Dir(diskPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := run(diskPath); err != nil {
+		return err
+	}
+	if err := s.files.CopyFile(diskPath, destPath); err != nil {
+		return err
+	}
+	for _, suffix := range suffixes {
+		if _, err := os.Stat(diskPath + suffix); os.IsNotExist(err) {
+			continue
+		}
+		if err := s.files.CopyFile(diskPath+suffix, destPath+suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
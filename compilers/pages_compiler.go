@@ -0,0 +1,218 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/albrow/scribble/log"
+	"github.com/albrow/scribble/util"
+	"github.com/howeyc/fsnotify"
+	"github.com/russross/blackfriday"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PagesCompilerType represents a type capable of compiling markdown pages,
+// i.e. markdown files anywhere in config.SourceDir other than config.PostsDir.
+type PagesCompilerType struct {
+	state *State
+	// createdFiles is a slice of file paths which were created by this
+	// compiler. It is important for implementing the RemoveOld method.
+	createdFiles []string
+}
+
+// Page is an in-memory representation of a markdown page. Unlike posts,
+// pages are not part of any collection, so they do not appear in Posts
+// or Collection.
+// Much of this data comes from the toml frontmatter.
+type Page struct {
+	Title       string `toml:"title"`
+	Description string `toml:"description"`
+	// the url for the page, not including protocol or domain name
+	Url template.URL `toml:"-"`
+	// the html content for the page (parsed from markdown source)
+	Content template.HTML `toml:"-"`
+	// the full source path
+	src string `toml:"-"`
+	// the layout file in config.PostLayoutsDir to be used for the page
+	LayoutName string `toml:"layout"`
+	// the layout template compiler (e.g. go html template or jade) that the page will be rendered into
+	LayoutCompiler PostLayoutCompiler
+	// any custom keys in the toml frontmatter which do not correspond to
+	// one of the fields above
+	Params map[string]interface{} `toml:"-"`
+}
+
+// CompileMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. In this case, the pattern
+// is any file that ends in ".md", excluding hidden and ignored files
+// and directories, and excluding posts and other collections.
+func (p *PagesCompilerType) CompileMatchFunc() MatchFunc {
+	pagesMatch := filenameMatchFunc("*.md", true, true)
+	// postsDir and the dirs for collections typically start with an
+	// underscore, but they don't have to, so we exclude them explicitly.
+	postsMatch := pathMatchFunc(filepath.Join(p.state.config.PostsDir, "*.md"), true, false)
+	return excludeMatchFuncs(pagesMatch, postsMatch, p.state.collectionsMatchFunc())
+}
+
+// WatchMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. In this case, the pattern
+// is the same as it is for CompileMatchFunc, plus any files which may
+// affect the way pages are rendered, i.e. layouts and includes.
+func (p *PagesCompilerType) WatchMatchFunc() MatchFunc {
+	allMatch := unionMatchFuncs(
+		p.CompileMatchFunc(),
+		pathMatchFunc(filepath.Join(p.state.config.PostLayoutsDir, "*"), true, false),
+	)
+	for _, plc := range p.state.postLayoutCompilers {
+		c := plc.(Compiler)
+		allMatch = unionMatchFuncs(allMatch, c.WatchMatchFunc())
+	}
+	if p.state.config.IncludesDir != "" {
+		includesMatch := pathMatchFunc(filepath.Join(p.state.config.IncludesDir, "*.tmpl"), true, false)
+		allMatch = unionMatchFuncs(allMatch, includesMatch)
+	}
+	return allMatch
+}
+
+// Compile compiles the file at srcPath. The caller will only
+// call this function for files which belong to PagesCompiler
+// according to the MatchFunc. Behavior for any other file is
+// undefined. Compile will output the compiled result to the appropriate
+// location in config.DestDir.
+func (p *PagesCompilerType) Compile(srcPath string) error {
+	s := p.state
+	page := &Page{src: srcPath}
+	if err := s.parsePage(page); err != nil {
+		return err
+	}
+	if page.LayoutName == "" {
+		// Plain markdown files without a layout, e.g. a README.md, are not
+		// pages, so they are left alone
+		log.Default.Printf("SKIP: %s has no layout in its frontmatter", srcPath)
+		return nil
+	}
+	destPath := s.destPathForPage(srcPath)
+	log.Success.Printf("CREATE: %s -> %s", srcPath, destPath)
+
+	// Render the page using its layout compiler
+	pageContext := s.config.Context.Copy()
+	pageContext["Page"] = page
+	layoutPath := filepath.Join(s.config.PostLayoutsDir, page.LayoutName)
+	if err := page.LayoutCompiler.RenderPostLayout(layoutPath, pageContext, destPath); err != nil {
+		return fmt.Errorf("ERROR compiling layout for page %s: %s", srcPath, err.Error())
+	}
+	lastMod, err := modTime(srcPath)
+	if err != nil {
+		return err
+	}
+	s.addSitemapEntry(destPath, lastMod, excludedFromSitemap(page.Params))
+
+	// Add the created file to the list of created files and keep track of
+	// the files the page depends on
+	s.appendPath(&p.createdFiles, destPath)
+	s.depGraph.addOutput(srcPath, destPath)
+	deps, err := s.layoutDependencies(layoutPath)
+	if err != nil {
+		return err
+	}
+	s.depGraph.setDependencies(srcPath, append(deps, srcPath))
+	return nil
+}
+
+// CompileAll compiles zero or more files identified by srcPaths.
+// It works simply by calling Compile for each path, using up to
+// config.Jobs workers at once. The caller is
+// responsible for only passing in files that belong to PagesCompiler
+// according to the MatchFunc. Behavior for any other file is undefined.
+func (p *PagesCompilerType) CompileAll(srcPaths []string) error {
+	log.Default.Println("Compiling markdown pages...")
+	return p.state.forEachPath(srcPaths, p.Compile)
+}
+
+func (p *PagesCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
+	// If a single page was changed, only recompile that page. If a layout
+	// or include was changed, recompile all the pages that depend on it.
+	return p.state.fileChangedForCompiler(p, srcPath)
+}
+
+func (p *PagesCompilerType) RemoveOld() error {
+	// Simply iterate through createdFiles and remove each of them
+	for _, path := range p.createdFiles {
+		if err := p.state.files.RemoveIfExists(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DestDirMoved satisfies DestDirMover
+func (p *PagesCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(p.createdFiles, from, to)
+}
+
+// destPathForPage returns the path in config.DestDir where the page at srcPath
+// should be written. Like posts, pages are written to an index.html file in a
+// folder with the same name as the markdown file (for prettier urls), so
+// source/about.md becomes public/about/index.html. The exception is index.md,
+// which becomes index.html in the same directory.
+func (s *State) destPathForPage(srcPath string) string {
+	relPath := strings.TrimPrefix(srcPath, s.config.SourceDir)
+	name := strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath))
+	destDir := filepath.Join(s.destDir, filepath.Dir(relPath))
+	if name == "index" {
+		return filepath.Join(destDir, "index.html")
+	}
+	return filepath.Join(destDir, name, "index.html")
+}
+
+// parsePage reads from the source file and sets the content and metadata
+// fields for p, as well as the layout compiler based on the layout field of
+// the frontmatter. If the page has no frontmatter or no layout, the layout
+// compiler is left nil.
+func (s *State) parsePage(p *Page) error {
+	// Open the source file
+	file, err := os.Open(p.src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	// Split the file into frontmatter and markdown content
+	frontMatter, content, err := util.SplitFrontMatter(r)
+	if err != nil {
+		return err
+	}
+
+	// Decode the frontmatter and keep any custom keys in p.Params
+	md, err := toml.Decode(frontMatter, p)
+	if err != nil {
+		return err
+	}
+	if p.Params, err = frontMatterParams(frontMatter, md.Undecoded()); err != nil {
+		return err
+	}
+
+	p.Url = template.URL(s.urlPathForDestPath(s.destPathForPage(p.src)))
+	p.Content = template.HTML(blackfriday.MarkdownCommon([]byte(content)))
+
+	// Select the proper compiler for the page layout
+	if p.LayoutName == "" {
+		return nil
+	}
+	if c, err := s.findPostLayoutCompiler(p.LayoutName); err != nil {
+		return err
+	} else if c == nil {
+		return fmt.Errorf("Could not find post layout compiler for layout named %s page: %s", p.LayoutName, p.src)
+	} else {
+		p.LayoutCompiler = c
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/test_util"
+	"github.com/albrow/scribble/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPagesCompiler(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_pages_compiler")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "pages")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	c.DestDir = filepath.Join(root, "public")
+	s := NewState(c)
+	postsCompiler := s.compiler("posts").(*PostsCompilerType)
+	pagesCompiler := s.compiler("pages")
+	postsCompiler.Init()
+
+	// Make sure pages and posts are matched by the right compilers
+	pagePaths, err := s.FindPaths(pagesCompiler.CompileMatchFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := []string{
+		filepath.Join(c.SourceDir, "README.md"),
+		filepath.Join(c.SourceDir, "about.md"),
+		filepath.Join(c.SourceDir, "docs", "index.md"),
+	}
+	if len(pagePaths) != len(expectedPaths) {
+		t.Fatalf("Expected pages %v but got %v", expectedPaths, pagePaths)
+	}
+	for i, path := range pagePaths {
+		if path != expectedPaths[i] {
+			t.Errorf("Expected page %s but got %s", expectedPaths[i], path)
+		}
+	}
+
+	// Attempt to compile the posts and the pages
+	postPaths, err := s.FindPaths(postsCompiler.CompileMatchFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := postsCompiler.CompileAll(postPaths); err != nil {
+		t.Fatal(err)
+	}
+	if err := pagesCompiler.CompileAll(pagePaths); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages should not be included in the posts
+	if got := len(s.Posts()); got != 1 {
+		t.Errorf("Expected 1 post but got %d", got)
+	}
+
+	// Make sure the compiled results are correct
+	expectedDir := filepath.Join(testFilesDir, "public")
+	expectedFiles := []string{
+		filepath.Join("about", "index.html"),
+		filepath.Join("docs", "index.html"),
+	}
+	for _, file := range expectedFiles {
+		test_util.CheckFilesMatch(t, filepath.Join(expectedDir, file), filepath.Join(c.DestDir, file))
+	}
+
+	// Markdown files without a layout should be skipped instead of failing
+	if _, err := os.Stat(filepath.Join(c.DestDir, "README")); !os.IsNotExist(err) {
+		t.Errorf("Expected README.md to be skipped but got: %v", err)
+	}
+}
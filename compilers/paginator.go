@@ -0,0 +1,109 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"html/template"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Paginator represents a single page of posts for a paginated page. It is
+// available to paginated pages via the Paginator key in the context.
+type Paginator struct {
+	// the number of the current page, starting at 1
+	PageNumber int
+	// the total number of pages
+	TotalPages int
+	// the posts on the current page, with the most recent first
+	Posts []*Post
+	// the url for the current page
+	Url template.URL
+	// the urls for the next and previous pages, which are empty if
+	// there is no next or previous page
+	Next, Prev template.URL
+	// whether or not there is a next or previous page
+	HasNext, HasPrev bool
+}
+
+// isPaginated returns true iff the page at srcPath should be paginated. Only
+// the index page in config.SourceDir is paginated, and only if config.Paginate
+// is greater than 0.
+func (s *State) isPaginated(srcPath string) bool {
+	if s.config.Paginate <= 0 || filepath.Dir(srcPath) != filepath.Clean(s.config.SourceDir) {
+		return false
+	}
+	name := filepath.Base(srcPath)
+	return strings.TrimSuffix(name, filepath.Ext(name)) == "index"
+}
+
+// paginators splits all the published posts into pages of config.Paginate
+// posts each, with the most recent posts first, and returns a Paginator for
+// each page. There is always at least one page, even if there are no posts.
+func (s *State) paginators() []*Paginator {
+	allPosts := s.Posts()
+	newestFirst := make([]*Post, len(allPosts))
+	for i, post := range allPosts {
+		newestFirst[len(allPosts)-1-i] = post
+	}
+	totalPages := (len(newestFirst) + s.config.Paginate - 1) / s.config.Paginate
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	pages := make([]*Paginator, totalPages)
+	for i := range pages {
+		start := i * s.config.Paginate
+		end := start + s.config.Paginate
+		if end > len(newestFirst) {
+			end = len(newestFirst)
+		}
+		pageNumber := i + 1
+		page := &Paginator{
+			PageNumber: pageNumber,
+			TotalPages: totalPages,
+			Posts:      newestFirst[start:end],
+			Url:        s.paginatorUrl(pageNumber),
+		}
+		if pageNumber < totalPages {
+			page.HasNext = true
+			page.Next = s.paginatorUrl(pageNumber + 1)
+		}
+		if pageNumber > 1 {
+			page.HasPrev = true
+			page.Prev = s.paginatorUrl(pageNumber - 1)
+		}
+		pages[i] = page
+	}
+	return pages
+}
+
+// paginatePath returns the name of the directory in config.DestDir where
+// every page but the first is created.
+func (s *State) paginatePath() string {
+	if s.config.PaginatePath == "" {
+		return "page"
+	}
+	return strings.Trim(s.config.PaginatePath, "/")
+}
+
+// paginatorUrl returns the url for the page identified by pageNumber.
+// The first page is the index page itself.
+func (s *State) paginatorUrl(pageNumber int) template.URL {
+	if pageNumber == 1 {
+		return "/"
+	}
+	return template.URL("/" + s.paginatePath() + "/" + strconv.Itoa(pageNumber) + "/")
+}
+
+// paginatorDestPath returns the path in config.DestDir where the page
+// identified by pageNumber should be written, given the destPath for the
+// first page.
+func (s *State) paginatorDestPath(destPath string, pageNumber int) string {
+	if pageNumber == 1 {
+		return destPath
+	}
+	return filepath.Join(s.destDir, filepath.FromSlash(s.paginatePath()), strconv.Itoa(pageNumber), filepath.Base(destPath))
+}
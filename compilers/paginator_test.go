@@ -0,0 +1,133 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/util"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPaginators(t *testing.T) {
+	// Create 5 posts directly, each one day apart
+	c := config.Default()
+	c.Paginate = 2
+	s := NewState(c)
+	for i := 0; i < 5; i++ {
+		s.posts = append(s.posts, &Post{
+			Title: string(rune('A' + i)),
+			Date:  time.Date(2014, time.November, 16+i, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	pages := s.paginators()
+	if len(pages) != 3 {
+		t.Fatalf("Expected 3 pages but got %d", len(pages))
+	}
+	expected := []struct {
+		titles     []string
+		url        template.URL
+		prev, next template.URL
+	}{
+		{[]string{"E", "D"}, "/", "", "/page/2/"},
+		{[]string{"C", "B"}, "/page/2/", "/", "/page/3/"},
+		{[]string{"A"}, "/page/3/", "/page/2/", ""},
+	}
+	for i, page := range pages {
+		e := expected[i]
+		if page.PageNumber != i+1 || page.TotalPages != 3 {
+			t.Errorf("Page %d had the wrong numbers: %+v", i+1, page)
+		}
+		if page.Url != e.url || page.Prev != e.prev || page.Next != e.next {
+			t.Errorf("Page %d had the wrong urls: %+v", i+1, page)
+		}
+		if page.HasPrev != (e.prev != "") || page.HasNext != (e.next != "") {
+			t.Errorf("Page %d had the wrong HasPrev or HasNext: %+v", i+1, page)
+		}
+		if len(page.Posts) != len(e.titles) {
+			t.Errorf("Page %d expected %d posts but got %d", i+1, len(e.titles), len(page.Posts))
+			continue
+		}
+		for j, post := range page.Posts {
+			if post.Title != e.titles[j] {
+				t.Errorf("Page %d expected post %d to be %s but got %s", i+1, j, e.titles[j], post.Title)
+			}
+		}
+	}
+}
+
+func TestHtmlTemplatesPagination(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_html_templates_pagination")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Create a layout and a paginated index page
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.IncludesDir = ""
+	files := map[string]string{
+		filepath.Join(c.LayoutsDir, "base.tmpl"):          `<html><body>{{ template "content" . }}</body></html>`,
+		filepath.Join(c.SourceDir, "index.tmpl"):          `{{ define "content" }}{{ range .Paginator.Posts }}{{ .Title }}{{ end }}|{{ .Paginator.Prev }}|{{ .Paginator.Next }}{{ end }}{{ template "base.tmpl" . }}`,
+		filepath.Join(c.SourceDir, "about", "index.tmpl"): `{{ define "content" }}about{{ end }}{{ template "base.tmpl" . }}`,
+	}
+	for path, content := range files {
+		if err := util.CreateEmptyFiles([]string{path}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Create 3 posts directly and paginate them 2 at a time
+	c.Paginate = 2
+	s := NewState(c)
+	for i := 0; i < 3; i++ {
+		s.posts = append(s.posts, &Post{
+			Title: string(rune('A' + i)),
+			Date:  time.Date(2014, time.November, 16+i, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	// Attempt to compile the pages
+	for _, path := range []string{"index.tmpl", filepath.Join("about", "index.tmpl")} {
+		if err := s.compiler("html").Compile(filepath.Join(c.SourceDir, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Make sure the compiled results are correct
+	expectedFiles := map[string]string{
+		filepath.Join(c.DestDir, "index.html"):              "<html><body>CB||/page/2/</body></html>",
+		filepath.Join(c.DestDir, "page", "2", "index.html"): "<html><body>A|/|</body></html>",
+		filepath.Join(c.DestDir, "about", "index.html"):     "<html><body>about</body></html>",
+	}
+	for path, expected := range expectedFiles {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != expected {
+			t.Errorf("Contents of file at %s were incorrect.\nExpected: %s\nGot: %s\n", path, expected, string(got))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(c.DestDir, "about", "page")); !os.IsNotExist(err) {
+		t.Errorf("Expected pages other than the index page to not be paginated")
+	}
+}
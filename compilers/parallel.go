@@ -0,0 +1,76 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"strings"
+	"sync"
+)
+
+// CompileErrors is returned when more than one file could not be compiled.
+// It holds the error for each file in the order the files were given.
+type CompileErrors []error
+
+func (errs CompileErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// forEachPath calls f for each path, using up to config.Jobs workers at once.
+// It waits for every call to finish, even if some of them fail, and then returns
+// the errors from all of them, each as a *FileError for its path. If only one
+// call failed, its error is returned by itself. Otherwise the errors are returned
+// as CompileErrors.
+func (s *State) forEachPath(paths []string, f func(path string) error) error {
+	jobs := s.config.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, len(paths))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < jobs && i < len(paths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := f(paths[i]); err != nil {
+					errs[i] = s.newFileError(paths[i], err)
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// Collect the errors in the same order as paths
+	compileErrs := CompileErrors{}
+	for _, err := range errs {
+		if err != nil {
+			compileErrs = append(compileErrs, err)
+		}
+	}
+	switch len(compileErrs) {
+	case 0:
+		return nil
+	case 1:
+		return compileErrs[0]
+	}
+	return compileErrs
+}
+
+// appendPath appends path to paths while holding createdMutex, so that workers
+// compiling files in parallel can safely keep track of the files they create.
+func (s *State) appendPath(paths *[]string, path string) {
+	s.createdMutex.Lock()
+	defer s.createdMutex.Unlock()
+	*paths = append(*paths, path)
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEachPath(t *testing.T) {
+	c := config.Default()
+	c.Jobs = 3
+	s := NewState(c)
+
+	// Keep track of which paths were visited and the maximum number of
+	// workers running at once. Some of the paths will fail.
+	paths := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	failures := map[string]bool{"b": true, "g": true}
+	visited := map[string]bool{}
+	running, maxRunning := 0, 0
+	mutex := sync.Mutex{}
+	err := s.forEachPath(paths, func(path string) error {
+		mutex.Lock()
+		visited[path] = true
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		if failures[path] {
+			return fmt.Errorf("could not compile %s", path)
+		}
+		return nil
+	})
+
+	if len(visited) != len(paths) {
+		t.Errorf("Expected every path to be visited, even after an error. Got %v", visited)
+	}
+	if maxRunning > c.Jobs {
+		t.Errorf("Expected at most %d workers at once but got %d", c.Jobs, maxRunning)
+	}
+	compileErrs, ok := err.(CompileErrors)
+	if !ok {
+		t.Fatalf("Expected CompileErrors but got %T: %v", err, err)
+	}
+	expected := "could not compile b\ncould not compile g"
+	if compileErrs.Error() != expected {
+		t.Errorf("Errors were incorrect.\nExpected: %s\nGot: %s", expected, compileErrs.Error())
+	}
+
+	// A single error should be returned as is
+	err = s.forEachPath(paths, func(path string) error {
+		if path == "c" {
+			return fmt.Errorf("could not compile %s", path)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "could not compile c" {
+		t.Errorf("Expected a single error but got %v", err)
+	}
+}
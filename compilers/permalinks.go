@@ -0,0 +1,82 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/util"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultPermalink is the permalink pattern used when config.Permalink is
+// not set. It matches the urls used by older versions of scribble.
+const defaultPermalink = "/:slug"
+
+// expandPermalink returns the url path for post by replacing each placeholder
+// in pattern with the corresponding value for post. The placeholders are:
+//
+//	:year   the four digit year of the post's date
+//	:month  the two digit month of the post's date
+//	:day    the two digit day of the post's date
+//	:slug   the post's slug
+//	:title  the post's title converted to a slug
+//
+// The result always starts with a "/" and is cleaned, e.g. "//" becomes "/".
+func expandPermalink(pattern string, post *Post) string {
+	replacer := strings.NewReplacer(
+		":year", post.Date.Format("2006"),
+		":month", post.Date.Format("01"),
+		":day", post.Date.Format("02"),
+		":slug", post.Slug,
+		":title", util.Slugify(post.Title),
+	)
+	urlPath := replacer.Replace(pattern)
+	cleaned := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// hasParentSegment returns true iff urlPath refers to a parent directory
+// with "..", which would make it possible to write outside of config.DestDir.
+func hasParentSegment(urlPath string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// postUrl returns the url path for post. If the post has a custom url in its
+// frontmatter, it is used as is. Otherwise the url is determined by the
+// permalink for the post's collection, which is config.Permalink for posts.
+// It returns an error if the slug or url contains "..", since the post would
+// be written outside of config.DestDir.
+func (s *State) postUrl(post *Post) (string, error) {
+	pattern := post.CustomUrl
+	if pattern == "" {
+		pattern = s.collectionPermalink(post.collection())
+	}
+	if hasParentSegment(pattern) || hasParentSegment(post.Slug) {
+		return "", fmt.Errorf("The url for post %s can not contain .. but the slug was %q and the url was %q", post.src, post.Slug, pattern)
+	}
+	return expandPermalink(pattern, post), nil
+}
+
+// destPathForUrl returns the path in config.DestDir where the page for urlPath
+// should be written. Typically this is an index.html file inside a directory
+// (for prettier urls), but if urlPath ends in ".html" it is used as the filename
+// directly.
+func (s *State) destPathForUrl(urlPath string) string {
+	destPath := filepath.Join(s.destDir, filepath.FromSlash(urlPath))
+	if filepath.Ext(destPath) == ".html" {
+		return destPath
+	}
+	return filepath.Join(destPath, "index.html")
+}
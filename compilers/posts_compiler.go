@@ -8,7 +8,6 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/BurntSushi/toml"
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/context"
 	"github.com/albrow/scribble/log"
 	"github.com/albrow/scribble/util"
@@ -17,32 +16,21 @@ import (
 	"html/template"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 )
 
-func init() {
-	// Detect whether a compiler is capable of compiling post layouts,
-	// and if so, add it to the list of post layout compilers.
-	for _, c := range Compilers {
-		if plc, ok := c.(PostLayoutCompiler); ok {
-			PostLayoutCompilers = append(PostLayoutCompilers, plc)
-		}
-	}
-}
-
 // PostsCompilerType represents a type capable of compiling post files.
 type PostsCompilerType struct {
+	state     *State
 	pathMatch string
 	// createdDirs keeps track of the directories that were created in config.DestDir.
 	// It is used in the RemoveOld method.
 	createdDirs []string
-}
-
-// PostCompiler is an instatiation of PostCompilerType
-var PostsCompiler = PostsCompilerType{
-	pathMatch: "",
+	// createdFiles keeps track of any files that were created in config.DestDir
+	// outside of createdDirs, e.g. taxonomy overview pages. It is also used in
+	// the RemoveOld method.
+	createdFiles []string
 }
 
 // Post is an in-memory representation of the metadata for a given post.
@@ -52,48 +40,73 @@ type Post struct {
 	Author      string    `toml:"author"`
 	Description string    `toml:"description"`
 	Date        time.Time `toml:"date"`
-	// the url for the post, not including protocol or domain name (useful for creating links)
+	// whether or not the post is a draft. Drafts are not published
+	// unless config.Drafts is true.
+	Draft bool `toml:"draft"`
+	// the tags and categories that the post belongs to
+	Tags       []string `toml:"tags"`
+	Categories []string `toml:"categories"`
+	// the url for the post, not including protocol or domain name (useful for creating links).
+	// It is determined by CustomUrl if set, or else by config.Permalink.
 	Url template.URL `toml:"-"`
+	// the slug used in the post's url. Defaults to the name of the source file
+	// without the extension.
+	Slug string `toml:"slug"`
+	// a custom url for the post which takes precedence over config.Permalink
+	CustomUrl string `toml:"url"`
 	// the html content for the post (parsed from markdown source)
 	Content template.HTML `toml:"-"`
+	// a short html summary of the post, e.g. for teasers on the index page.
+	// It comes from the summary key in the frontmatter if set, otherwise from
+	// the content before a <!--more--> marker, or otherwise from the first
+	// config.SummaryLength words of the content.
+	Summary template.HTML `toml:"summary"`
+	// the number of words in the content and the estimated number of minutes
+	// it takes to read
+	WordCount   int `toml:"-"`
+	ReadingTime int `toml:"-"`
+	// the name of the collection the post belongs to, which is "posts" for
+	// posts in config.PostsDir
+	Collection string `toml:"-"`
 	// the full source path
 	src string `toml:"-"`
-	// the layout tmpl file to be used for the post
+	// the layout file to be used for the post, relative to the layouts dir
+	// for its collection
 	LayoutName string `toml:"layout"`
 	// the layout template compiler (e.g. go html template or jade) that the post will be rendered into
 	LayoutCompiler PostLayoutCompiler
+	// any custom keys in the toml frontmatter which do not correspond to
+	// one of the fields above, e.g. a cover image or a canonical url
+	Params map[string]interface{} `toml:"-"`
 }
 
-var PostLayoutCompilers []PostLayoutCompiler
-
+// PostLayoutCompiler is an interface which should be satisfied by any Compiler
+// which is capable of rendering post layouts. Posts use the first one whose
+// PostLayoutMatchFunc matches their layout, in the order that compilers run.
 type PostLayoutCompiler interface {
 	RenderPost(post *Post, destPath string) error
+	// RenderPostLayout renders the layout file at layoutPath with the given
+	// context and writes the result to destPath. It is used to render pages
+	// which are not individual posts but are still made out of markdown or
+	// posts, e.g. markdown pages and taxonomy pages.
+	RenderPostLayout(layoutPath string, layoutContext context.Context, destPath string) error
 	PostLayoutMatchFunc() MatchFunc
 }
 
-var (
-	// a slice of all posts
-	posts = []*Post{}
-	// a map of source path to post
-	postsMap = map[string]*Post{}
-)
-
 // Init should be called before any other methods. In this case, Init
-// sets up the pathMatch variable based on config.SourceDir and config.PostsDir
-// and adds the Posts helper function to FuncMap.
+// sets up the pathMatch variable based on config.PostsDir.
 func (p *PostsCompilerType) Init() {
-	p.pathMatch = filepath.Join(config.PostsDir, "*.md")
-	// Add the posts function to FuncMap
-	context.FuncMap["Posts"] = Posts
+	p.pathMatch = filepath.Join(p.state.config.PostsDir, "*.md")
 }
 
 // CompileMatchFunc returns a MatchFunc which will return true for
 // any files which match a given pattern. In this case, the pattern
-// is any file that is inside config.PostsDir and ends in ".md", excluding
-// hidden files and directories (which start with a ".") but not those
-// which start with an underscore.
+// is any file that is inside config.PostsDir or the dir for one of
+// config.Collections and ends in ".md", excluding hidden files and
+// directories (which start with a ".") but not those which start with an
+// underscore.
 func (p *PostsCompilerType) CompileMatchFunc() MatchFunc {
-	return pathMatchFunc(p.pathMatch, true, false)
+	return unionMatchFuncs(pathMatchFunc(p.pathMatch, true, false), p.state.collectionsMatchFunc())
 }
 
 // WatchMatchFunc returns a MatchFunc which will return true for
@@ -104,9 +117,9 @@ func (p *PostsCompilerType) WatchMatchFunc() MatchFunc {
 	// but also needs to watch all the files that post layouts compiler
 	// watches. Because if those change, it may affect the way posts are
 	// rendered.
-	postsMatch := pathMatchFunc(p.pathMatch, true, false)
-	layoutsMatch := unionMatchFuncs()
-	for _, plc := range PostLayoutCompilers {
+	postsMatch := p.CompileMatchFunc()
+	layoutsMatch := p.state.collectionLayoutsMatchFunc()
+	for _, plc := range p.state.postLayoutCompilers {
 		c := plc.(Compiler)
 		layoutsMatch = unionMatchFuncs(layoutsMatch, c.WatchMatchFunc())
 	}
@@ -115,9 +128,9 @@ func (p *PostsCompilerType) WatchMatchFunc() MatchFunc {
 	// which will return true if either matches. This allows us to watch
 	// for changes in both the posts dir and the posts layouts dir.
 	allMatch := unionMatchFuncs(postsMatch, layoutsMatch)
-	if config.IncludesDir != "" {
+	if p.state.config.IncludesDir != "" {
 		// We also want to watch includes if there are any
-		includesMatch := pathMatchFunc(filepath.Join(config.IncludesDir, "*.tmpl"), true, false)
+		includesMatch := pathMatchFunc(filepath.Join(p.state.config.IncludesDir, "*.tmpl"), true, false)
 		allMatch = unionMatchFuncs(allMatch, includesMatch)
 	}
 	return allMatch
@@ -129,40 +142,118 @@ func (p *PostsCompilerType) WatchMatchFunc() MatchFunc {
 // undefined. Compile will output the compiled result to the appropriate
 // location in config.DestDir.
 func (p *PostsCompilerType) Compile(srcPath string) error {
-	// Get the parsed post object and determine dest path
-	post := getOrCreatePostFromPath(srcPath)
-	srcFilename := filepath.Base(srcPath)
-	destPath := fmt.Sprintf("%s/%s", config.DestDir, strings.TrimSuffix(srcFilename, ".md"))
-	destIndexFilePath := filepath.Join(destPath, "index.html")
-	log.Success.Printf("CREATE: %s -> %s", srcPath, destIndexFilePath)
+	// Parse the content and frontmatter and set the appropriate layout based
+	// on the layout key in the frontmatter, then replace the old post (if any)
+	post, err := p.state.parsePost(srcPath)
+	if err != nil {
+		return err
+	}
+	p.state.setPost(post)
+	return p.render(post)
+}
+
+// render renders post, which must already be parsed, to the appropriate
+// location in config.DestDir.
+func (p *PostsCompilerType) render(post *Post) error {
+	s := p.state
+	srcPath := post.src
+
+	// Don't render drafts or posts scheduled for the future unless config says so
+	if !s.published(post) {
+		log.Default.Printf("SKIP: %s is a draft or has a future date", srcPath)
+		return nil
+	}
 
-	// Parse content and frontmatter, then set the appropriate layout based on
-	// the layout key in the frontmatter
-	if err := post.parse(); err != nil {
+	// Keep track of the files the post depends on, so it can be recompiled
+	// whenever one of them changes
+	deps, err := s.layoutDependencies(s.postLayoutPath(post))
+	if err != nil {
 		return err
 	}
+	s.depGraph.setDependencies(srcPath, append(deps, srcPath))
+
+	// Determine the dest path from the url, so that the two always agree
+	destIndexFilePath := s.destPathForUrl(string(post.Url))
+	log.Success.Printf("CREATE: %s -> %s", srcPath, destIndexFilePath)
 
 	// Render the post using its layout compiler
 	if err := post.LayoutCompiler.RenderPost(post, destIndexFilePath); err != nil {
 		return err
 	}
-
-	// Add the created dir to the list of created dirs
-	p.createdDirs = append(p.createdDirs, destPath)
+	lastMod, err := postLastMod(post)
+	if err != nil {
+		return err
+	}
+	s.addSitemapEntry(destIndexFilePath, lastMod, excludedFromSitemap(post.Params))
+	s.depGraph.addOutput(srcPath, destIndexFilePath)
+
+	// Add the created dir to the list of created dirs, or if the url did not
+	// end in a directory, add the created file to the list of created files.
+	// config.DestDir itself is never a created dir, even for a post with the
+	// url "/", since removing it would remove everything else too.
+	if filepath.Base(destIndexFilePath) == "index.html" && filepath.Dir(destIndexFilePath) != filepath.Clean(s.destDir) {
+		s.appendPath(&p.createdDirs, filepath.Dir(destIndexFilePath))
+	} else {
+		s.appendPath(&p.createdFiles, destIndexFilePath)
+	}
 
 	return nil
 }
 
 // CompileAll compiles zero or more files identified by srcPaths.
-// It works simply by calling Compile for each path. The caller is
-// responsible for only passing in files that belong to AceCompiler
-// according to the MatchFunc. Behavior for any other file is undefined.
+// It works by parsing every post and then rendering every post (both
+// in parallel), and then compiling the taxonomy pages, which depend on
+// every post. Every post is parsed before any are rendered, because post
+// layouts may refer to other posts. The caller is responsible for only
+// passing in files that belong to PostsCompiler according to the
+// MatchFunc. Behavior for any other file is undefined.
 func (p *PostsCompilerType) CompileAll(srcPaths []string) error {
 	log.Default.Println("Compiling posts...")
-	for _, srcPath := range srcPaths {
-		if err := p.Compile(srcPath); err != nil {
+	s := p.state
+	// Start with a fresh set of posts, so that any posts which were deleted
+	// or renamed since the last time we compiled are forgotten
+	s.postsMutex.Lock()
+	s.posts = []*Post{}
+	s.postsMap = map[string]*Post{}
+	s.postsMutex.Unlock()
+	if err := s.forEachPath(srcPaths, func(srcPath string) error {
+		post, err := s.parsePost(srcPath)
+		if err != nil {
 			return err
 		}
+		s.setPost(post)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := s.forEachPath(srcPaths, func(srcPath string) error {
+		return p.render(s.getPostByPath(srcPath))
+	}); err != nil {
+		return err
+	}
+	if err := s.checkUniqueUrls(); err != nil {
+		return err
+	}
+	if err := p.compileTaxonomies(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkUniqueUrls returns an error if any two published posts (in any
+// collection) have the same url, since one would overwrite the other.
+func (s *State) checkUniqueUrls() error {
+	s.postsMutex.RLock()
+	defer s.postsMutex.RUnlock()
+	srcPathsByUrl := map[template.URL]string{}
+	for _, post := range s.posts {
+		if !s.published(post) {
+			continue
+		}
+		if other, found := srcPathsByUrl[post.Url]; found {
+			return fmt.Errorf("Posts %s and %s have the same url: %s", other, post.src, post.Url)
+		}
+		srcPathsByUrl[post.Url] = post.src
 	}
 	return nil
 }
@@ -170,23 +261,45 @@ func (p *PostsCompilerType) CompileAll(srcPaths []string) error {
 func (p *PostsCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
 	// Because of the way we set up the watcher, there are two possible
 	// cases here.
-	// 1) A template in the post layouts dir was changed. In this case,
-	// we would ideally recompile all the posts that used that layout.
+	// 1) A layout or include was changed. In this case, we recompile all
+	// the posts that depend on it according to the dependency graph.
 	// 2) A markdown file corresponding to a single post was changed. In this
-	// case, ideally we only recompile the post that was changed. We need to
-	// take into account any rename, delete, or create events and how they
-	// affect the output files in destDir.
-
-	// TODO: Be more intelligent here? If a single post file was changed,
-	// we can simply recompile that post. If a post layout file was changed,
-	// we should recompile all posts that use that layout. We would also need
-	// to take into account the subtle differences between rename, create, and
-	// delete events. For now, recompile all posts.
-	if err := recompileAllForCompiler(p); err != nil {
+	// case, we only recompile the post that was changed, or forget about it
+	// if it was removed.
+	s := p.state
+	isPost, err := p.CompileMatchFunc()(srcPath)
+	if err != nil {
+		return err
+	}
+	if isPost && sourceRemoved(srcPath) {
+		s.removePost(srcPath)
+	}
+	paths, err := s.affectedPaths(p, srcPath)
+	if err != nil {
+		return err
+	}
+	if err := s.recompilePaths(p, paths); err != nil {
+		return err
+	}
+	if !isPost && len(paths) == 0 && !s.depGraph.dependsOn(taxonomiesNode, srcPath) {
+		// Nothing that depends on posts could have changed
+		return s.finishRecompile()
+	}
+	if err := s.checkUniqueUrls(); err != nil {
 		return err
 	}
-	return nil
 
+	// Any page which lists posts may have changed, including the taxonomy pages
+	if err := s.removeOutputs(taxonomiesNode); err != nil {
+		return err
+	}
+	if err := p.compileTaxonomies(); err != nil {
+		return err
+	}
+	if err := s.recompilePostsDependents(); err != nil {
+		return err
+	}
+	return s.finishRecompile()
 }
 
 func (p *PostsCompilerType) RemoveOld() error {
@@ -196,96 +309,193 @@ func (p *PostsCompilerType) RemoveOld() error {
 	// (for prettier urls). So instead of removing files, we're removing
 	// directories.
 	for _, dir := range p.createdDirs {
-		if err := util.RemoveAllIfExists(dir); err != nil {
+		if err := p.state.files.RemoveAllIfExists(dir); err != nil {
+			return err
+		}
+	}
+	for _, path := range p.createdFiles {
+		if err := p.state.files.RemoveIfExists(path); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// Posts returns up to limit posts, sorted by date. If limit is 0,
-// it returns all posts. If limit is greater than len(posts), it returns
-// all posts.
-func Posts(limit ...int) []*Post {
-	// Sort the posts by date
-	sortedPosts := make([]*Post, len(posts))
-	copy(sortedPosts, posts)
-	sort.Sort(PostsByDate(sortedPosts))
-
-	// Return up to limit posts
-	if len(limit) == 0 || limit[0] == 0 || limit[0] > len(sortedPosts) {
-		return sortedPosts
-	} else {
-		return sortedPosts[:limit[0]]
-	}
+// DestDirMoved satisfies DestDirMover
+func (p *PostsCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(p.createdDirs, from, to)
+	movedPaths(p.createdFiles, from, to)
 }
 
-func createPostFromPath(path string) *Post {
-	// create post object
-	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-	p := &Post{
-		Url: template.URL("/" + name),
-		src: path,
-	}
-	posts = append(posts, p)
-	postsMap[path] = p
-	return p
+// Posts returns up to limit published posts, sorted by date. If limit is 0,
+// it returns all published posts. If limit is greater than the number of
+// published posts, it returns all published posts. Drafts and posts with
+// a date in the future are only included if config.Drafts or config.Future
+// are true, respectively. The posts are never changed afterwards, since
+// compiling a post again replaces it with a new one.
+func (s *State) Posts(limit ...int) []*Post {
+	return s.Collection(postsCollection, limit...)
 }
 
-func getPostByPath(path string) *Post {
-	return postsMap[path]
+// published returns true iff the post should be included in the output. Drafts
+// are only published if config.Drafts is true, and posts with a date in the future
+// are only published if config.Future is true.
+func (s *State) published(p *Post) bool {
+	if p.Draft && !s.config.Drafts {
+		return false
+	}
+	if p.Date.After(time.Now()) && !s.config.Future {
+		return false
+	}
+	return true
 }
 
-func getOrCreatePostFromPath(path string) *Post {
-	if p, found := postsMap[path]; found {
-		return p
+// setPost adds post to posts and postsMap, replacing the post with the same
+// source path if there is one. The old post is left as is, so anything which
+// is still using it doesn't see it change.
+func (s *State) setPost(post *Post) {
+	s.postsMutex.Lock()
+	defer s.postsMutex.Unlock()
+	if old, found := s.postsMap[post.src]; found {
+		for i, p := range s.posts {
+			if p == old {
+				s.posts[i] = post
+				break
+			}
+		}
 	} else {
-		return createPostFromPath(path)
+		s.posts = append(s.posts, post)
 	}
+	s.postsMap[post.src] = post
 }
 
-// parse reads from the source file and sets the content and metadata fields
-// for the post. It also creates a new template for the post using the layout
-// field of the frontmatter.
-func (p *Post) parse() error {
+// removePost forgets about the post at path, e.g. because its source file
+// was removed.
+func (s *State) removePost(path string) {
+	s.postsMutex.Lock()
+	defer s.postsMutex.Unlock()
+	delete(s.postsMap, path)
+	for i, post := range s.posts {
+		if post.src == path {
+			s.posts = append(s.posts[:i], s.posts[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *State) getPostByPath(path string) *Post {
+	s.postsMutex.RLock()
+	defer s.postsMutex.RUnlock()
+	return s.postsMap[path]
+}
+
+// parsePost reads from the source file at path and returns a new post with
+// the content and metadata fields set. It also finds the compiler for the
+// layout field of the frontmatter.
+func (s *State) parsePost(path string) (*Post, error) {
 	// Open the source file
-	file, err := os.Open(p.src)
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer file.Close()
 	r := bufio.NewReader(file)
 
 	// Split the file into frontmatter and markdown content
 	frontMatter, content, err := util.SplitFrontMatter(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Decode the frontmatter
-	if _, err := toml.Decode(frontMatter, p); err != nil {
-		return err
+	p := &Post{
+		src:        path,
+		Collection: s.collectionForPath(path),
 	}
+	md, err := toml.Decode(frontMatter, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Any keys which were not decoded into one of the fields of p are custom
+	// metadata. Decode the frontmatter again into a generic map and keep those
+	// keys in p.Params so they are accessible from post layouts.
+	if p.Params, err = frontMatterParams(frontMatter, md.Undecoded()); err != nil {
+		return nil, err
+	}
+
+	// Set the slug and url, which may depend on the frontmatter
+	if p.Slug == "" {
+		p.Slug = strings.TrimSuffix(filepath.Base(p.src), filepath.Ext(p.src))
+	}
+	url, err := s.postUrl(p)
+	if err != nil {
+		return nil, err
+	}
+	p.Url = template.URL(url)
 
 	// Parse the markdown content and set p.Content
 	p.Content = template.HTML(blackfriday.MarkdownCommon([]byte(content)))
 
+	// Set the summary, word count, and reading time based on the content
+	p.setSummary(content, s.config.SummaryLength)
+
 	// Select the proper compiler for the post layout
 	if p.LayoutName == "" {
-		return fmt.Errorf("Could not find layout definition in toml frontmatter for post: %s", p.src)
+		return nil, fmt.Errorf("Could not find layout definition in toml frontmatter for post: %s", p.src)
 	}
-	for _, c := range PostLayoutCompilers {
-		if match, err := c.PostLayoutMatchFunc()(p.LayoutName); err != nil {
-			return err
+	if c, err := s.findPostLayoutCompiler(p.LayoutName); err != nil {
+		return nil, err
+	} else if c == nil {
+		return nil, fmt.Errorf("Could not find post layout compiler for layout named %s post: %s", p.LayoutName, p.src)
+	} else {
+		p.LayoutCompiler = c
+	}
+
+	return p, nil
+}
+
+// postLayoutPath returns the path to the layout file for post.
+func (s *State) postLayoutPath(post *Post) string {
+	return filepath.Join(s.collectionLayoutsDir(post.collection()), post.LayoutName)
+}
+
+// findPostLayoutCompiler returns the first PostLayoutCompiler which is capable
+// of rendering the post layout identified by layoutName, or nil if there is none.
+func (s *State) findPostLayoutCompiler(layoutName string) (PostLayoutCompiler, error) {
+	for _, c := range s.postLayoutCompilers {
+		if match, err := c.PostLayoutMatchFunc()(layoutName); err != nil {
+			return nil, err
 		} else if match {
-			p.LayoutCompiler = c
-			break
+			return c, nil
 		}
 	}
-	if p.LayoutCompiler == nil {
-		return fmt.Errorf("Could not find post layout compiler for layout named %s post: %s", p.LayoutName, p.src)
-	}
+	return nil, nil
+}
 
-	return nil
+// frontMatterParams returns the top-level keys in frontMatter which are
+// present in undecoded, i.e. the custom keys which did not correspond to
+// any field of the struct the frontMatter was decoded into.
+func frontMatterParams(frontMatter string, undecoded []toml.Key) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if len(undecoded) == 0 {
+		return params, nil
+	}
+	allKeys := map[string]interface{}{}
+	if _, err := toml.Decode(frontMatter, &allKeys); err != nil {
+		return nil, err
+	}
+	for _, key := range undecoded {
+		// Nested keys (e.g. inside a table) will also be included in undecoded,
+		// but they are reachable through their top-level key.
+		if len(key) == 0 {
+			continue
+		}
+		if val, found := allKeys[key[0]]; found {
+			params[key[0]] = val
+		}
+	}
+	return params, nil
 }
 
 // The PostsByDate type is used only for sorting
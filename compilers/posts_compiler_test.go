@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestPostsPathMatch(t *testing.T) {
@@ -37,16 +38,18 @@ func TestPostsPathMatch(t *testing.T) {
 
 	// Only some paths are expected to be matched by the PostsCompiler,
 	// the other files should be ignored.
-	config.SourceDir = root
-	config.PostsDir = filepath.Join(config.SourceDir, "_posts")
-	PostsCompiler.Init()
+	c := config.Default()
+	c.SourceDir = root
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	s := NewState(c)
+	postsCompiler := s.compiler("posts").(*PostsCompilerType)
+	postsCompiler.Init()
 	expectedPaths := []string{
 		filepath.Join(root, "_posts", "post.md"),
 	}
 
 	// Use the MatchFunc to find all the paths
-	config.SourceDir = root
-	gotPaths, err := FindPaths(PostsCompiler.CompileMatchFunc())
+	gotPaths, err := s.FindPaths(postsCompiler.CompileMatchFunc())
 	if err != nil {
 		t.Error(err)
 	}
@@ -75,12 +78,13 @@ func TestPostsCompilerWithHtmlTemplateLayout(t *testing.T) {
 	}
 
 	// Attempt to compile the posts
-	config.SourceDir = filepath.Join(root, "source")
-	config.PostsDir = filepath.Join(config.SourceDir, "_posts")
-	config.LayoutsDir = filepath.Join(config.SourceDir, "_layouts")
-	config.PostLayoutsDir = filepath.Join(config.SourceDir, "_post_layouts")
-	config.DestDir = filepath.Join(root, "public")
-	if err := PostsCompiler.Compile(filepath.Join(config.PostsDir, "post.md")); err != nil {
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.DestDir = filepath.Join(root, "public")
+	if err := NewState(c).compiler("posts").Compile(filepath.Join(c.PostsDir, "post.md")); err != nil {
 		t.Fatal(err)
 	}
 
@@ -111,12 +115,13 @@ func TestPostsCompilerWithJadeLayout(t *testing.T) {
 	}
 
 	// Attempt to compile the posts
-	config.SourceDir = filepath.Join(root, "source")
-	config.PostsDir = filepath.Join(config.SourceDir, "_posts")
-	config.LayoutsDir = filepath.Join(config.SourceDir, "_layouts")
-	config.PostLayoutsDir = filepath.Join(config.SourceDir, "_post_layouts")
-	config.DestDir = filepath.Join(root, "public")
-	if err := PostsCompiler.Compile(filepath.Join(config.PostsDir, "post.md")); err != nil {
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.DestDir = filepath.Join(root, "public")
+	if err := NewState(c).compiler("posts").Compile(filepath.Join(c.PostsDir, "post.md")); err != nil {
 		t.Fatal(err)
 	}
 
@@ -126,3 +131,187 @@ func TestPostsCompilerWithJadeLayout(t *testing.T) {
 	gotFile := filepath.Join(destDir, "post", "index.html")
 	test_util.CheckFilesMatch(t, expectedFile, gotFile)
 }
+
+func TestPostParams(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_posts_compiler", "params")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Create a post with some custom keys in the frontmatter
+	srcPath := filepath.Join(root, "_posts", "params.md")
+	f, err := util.CreateFileWithPath(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "+++\ntitle = \"Params\"\nlayout = \"post.tmpl\"\ncover = \"/images/cover.png\"\nseries = 3\n[hero]\ncolor = \"red\"\n+++\n\nSome content\n"
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parse the post and make sure the custom keys were added to Params
+	// and the standard keys were not
+	post, err := NewState(config.Default()).parsePost(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "Params" {
+		t.Errorf("Expected post.Title to be %s but got %s", "Params", post.Title)
+	}
+	if got, expected := post.Params["cover"], "/images/cover.png"; got != expected {
+		t.Errorf("Expected post.Params[\"cover\"] to be %v but got %v", expected, got)
+	}
+	if got, expected := post.Params["series"], int64(3); got != expected {
+		t.Errorf("Expected post.Params[\"series\"] to be %v but got %v", expected, got)
+	}
+	if hero, ok := post.Params["hero"].(map[string]interface{}); !ok {
+		t.Errorf("Expected post.Params[\"hero\"] to be a map but got %T", post.Params["hero"])
+	} else if got, expected := hero["color"], "red"; got != expected {
+		t.Errorf("Expected post.Params[\"hero\"][\"color\"] to be %v but got %v", expected, got)
+	}
+	for _, key := range []string{"title", "layout"} {
+		if _, found := post.Params[key]; found {
+			t.Errorf("Expected post.Params to not contain standard key %s", key)
+		}
+	}
+}
+
+func TestPostsDraftsAndFuture(t *testing.T) {
+	// Create some posts directly, including a draft and a post
+	// with a date in the future
+	published := &Post{Title: "Published", Date: time.Now().Add(-time.Hour)}
+	draft := &Post{Title: "Draft", Date: time.Now().Add(-time.Hour), Draft: true}
+	future := &Post{Title: "Future", Date: time.Now().Add(time.Hour)}
+	c := config.Default()
+	s := NewState(c)
+	s.posts = []*Post{published, draft, future}
+
+	testCases := []struct {
+		drafts, future bool
+		expected       []*Post
+	}{
+		{false, false, []*Post{published}},
+		{true, false, []*Post{published, draft}},
+		{false, true, []*Post{published, future}},
+		{true, true, []*Post{published, draft, future}},
+	}
+	for _, tc := range testCases {
+		c.Drafts, c.Future = tc.drafts, tc.future
+		s.SetConfig(c)
+		got := s.Posts()
+		if len(got) != len(tc.expected) {
+			t.Errorf("With drafts = %v and future = %v, expected %d posts but got %d", tc.drafts, tc.future, len(tc.expected), len(got))
+			continue
+		}
+		for i, post := range tc.expected {
+			if got[i] != post {
+				t.Errorf("With drafts = %v and future = %v, expected post %d to be %s but got %s", tc.drafts, tc.future, i, post.Title, got[i].Title)
+			}
+		}
+	}
+}
+
+func TestPostsPermalinks(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_posts_compiler", "permalinks")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "posts", "html_template_layout")
+	srcDir := filepath.Join(root, "source")
+	destDir := filepath.Join(root, "public")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.DestDir = destDir
+
+	testCases := []struct {
+		permalink   string
+		expectedUrl string
+		destFile    string
+	}{
+		{"", "/post", filepath.Join("post", "index.html")},
+		{"/:year/:month/:day/:slug/", "/2014/11/16/post/", filepath.Join("2014", "11", "16", "post", "index.html")},
+		{"/blog/:title.html", "/blog/post.html", filepath.Join("blog", "post.html")},
+	}
+	for _, tc := range testCases {
+		// Attempt to compile the post with the given permalink pattern
+		c.Permalink = tc.permalink
+		s := NewState(c)
+		srcPath := filepath.Join(c.PostsDir, "post.md")
+		if err := s.compiler("posts").Compile(srcPath); err != nil {
+			t.Fatal(err)
+		}
+
+		// Make sure the url and the compiled result are correct
+		if got := string(s.getPostByPath(srcPath).Url); got != tc.expectedUrl {
+			t.Errorf("With permalink %q, expected url to be %s but got %s", tc.permalink, tc.expectedUrl, got)
+		}
+		expectedFile := filepath.Join(testFilesDir, "public", "post", "index.html")
+		test_util.CheckFilesMatch(t, expectedFile, filepath.Join(destDir, tc.destFile))
+	}
+
+	// A post at the root of the site should never cause the whole
+	// destination directory to be removed
+	c.Permalink = "/"
+	postsCompiler := NewState(c).compiler("posts")
+	if err := postsCompiler.Compile(filepath.Join(c.PostsDir, "post.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := postsCompiler.RemoveOld(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(destDir); err != nil {
+		t.Errorf("Expected %s to still exist after removing old posts but got: %s", destDir, err)
+	}
+}
+
+func TestPostsCustomUrlAndSlug(t *testing.T) {
+	c := config.Default()
+	c.Permalink = "/:year/:slug/"
+	s := NewState(c)
+	post := &Post{Title: "Hello World", Slug: "hello", Date: time.Date(2014, time.November, 16, 0, 0, 0, 0, time.UTC)}
+	for customUrl, expected := range map[string]string{
+		"":                        "/2014/hello/",
+		"/archive/old-hello.html": "/archive/old-hello.html",
+		"archive//./hello/":       "/archive/hello/",
+		"/":                       "/",
+	} {
+		post.CustomUrl = customUrl
+		if got, err := s.postUrl(post); err != nil {
+			t.Error(err)
+		} else if got != expected {
+			t.Errorf("Expected url to be %s but got %s", expected, got)
+		}
+	}
+
+	// Urls which would escape c.DestDir should be rejected
+	post.CustomUrl = "/../../etc/hello.html"
+	if _, err := s.postUrl(post); err == nil {
+		t.Errorf("Expected an error for url %q but got none", post.CustomUrl)
+	}
+	post.CustomUrl = ""
+	post.Slug = "../hello"
+	if _, err := s.postUrl(post); err == nil {
+		t.Errorf("Expected an error for slug %q but got none", post.Slug)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/albrow/scribble/log"
+	"github.com/howeyc/fsnotify"
+	"html"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// redirectsName is the name of the file in config.SourceDir which holds the
+// redirect rules
+const redirectsName = "_redirects"
+
+// Redirect is a rule which sends requests for one url path to another url,
+// e.g. because a post was moved.
+type Redirect struct {
+	// From is the url path which is redirected, e.g. /old-post/
+	From string
+	// To is the url path or absolute url that requests are redirected to
+	To string
+	// Status is the http status code for the redirect, e.g. 301
+	Status int
+}
+
+// Matches returns true iff urlPath is the path that r redirects from. The
+// trailing slash and index.html are ignored, so /old-post, /old-post/, and
+// /old-post/index.html all match each other.
+func (r Redirect) Matches(urlPath string) bool {
+	return redirectKey(r.From) == redirectKey(urlPath)
+}
+
+func redirectKey(urlPath string) string {
+	urlPath = strings.TrimSuffix(urlPath, "index.html")
+	return "/" + strings.Trim(urlPath, "/")
+}
+
+// RedirectsCompilerType represents a type capable of reading the redirect
+// rules in the _redirects file and generating an html page for each of them
+// which redirects browsers with a meta refresh tag. This makes the rules
+// work on static hosts which don't support _redirects files.
+type RedirectsCompilerType struct {
+	state     *State
+	pathMatch string
+	// createdFiles is a slice of file paths which were created by this
+	// compiler. It is important for implementing the RemoveOld method.
+	createdFiles []string
+	// redirects holds the rules from the last time the _redirects file was
+	// compiled
+	redirects []Redirect
+}
+
+// Init should be called before any other methods. In this case, Init
+// sets up the pathMatch variable based on config.SourceDir and forgets any
+// rules from a previous build.
+func (r *RedirectsCompilerType) Init() {
+	r.pathMatch = filepath.Join(r.state.config.SourceDir, redirectsName)
+	r.redirects = nil
+}
+
+// CompileMatchFunc returns a MatchFunc which will return true for
+// the _redirects file at the root of config.SourceDir.
+func (r *RedirectsCompilerType) CompileMatchFunc() MatchFunc {
+	return pathMatchFunc(r.pathMatch, true, false)
+}
+
+// WatchMatchFunc returns a MatchFunc which will return true for
+// any files which match a given pattern. In this case, the pattern
+// is the same as it is for CompileMatchFunc.
+func (r *RedirectsCompilerType) WatchMatchFunc() MatchFunc {
+	return r.CompileMatchFunc()
+}
+
+// Compile reads the redirect rules in the file at srcPath and creates a
+// page in config.DestDir for each rule that redirects from an html page.
+// Rules for other kinds of files, e.g. /feed.xml, only work in the dev
+// server and on hosts which support _redirects files.
+func (r *RedirectsCompilerType) Compile(srcPath string) error {
+	redirects, err := parseRedirects(srcPath)
+	if err != nil {
+		return err
+	}
+	r.redirects = redirects
+	for _, redirect := range redirects {
+		destPath := r.redirectDestPath(redirect.From)
+		if destPath == "" {
+			continue
+		}
+		if _, err := r.state.files.Stat(destPath); err == nil {
+			return fmt.Errorf("%s: Can not redirect from %s because there is already a page there.", srcPath, redirect.From)
+		}
+		log.Success.Printf("CREATE: %s -> %s", srcPath, destPath)
+		if err := r.writeRedirectPage(destPath, redirect.To); err != nil {
+			return err
+		}
+		r.createdFiles = append(r.createdFiles, destPath)
+	}
+	return nil
+}
+
+// CompileAll compiles the _redirects file, if there is one.
+func (r *RedirectsCompilerType) CompileAll(srcPaths []string) error {
+	log.Default.Println("Compiling redirects...")
+	for _, srcPath := range srcPaths {
+		if err := r.Compile(srcPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RedirectsCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
+	// The rules may have been changed or removed, so forget the old ones and
+	// start over
+	r.redirects = nil
+	return r.state.recompileAllForCompiler(r)
+}
+
+func (r *RedirectsCompilerType) RemoveOld() error {
+	// Simply iterate through createdFiles and remove each of them
+	for _, path := range r.createdFiles {
+		if err := r.state.files.RemoveIfExists(path); err != nil {
+			return err
+		}
+	}
+	r.createdFiles = nil
+	return nil
+}
+
+// DestDirMoved satisfies DestDirMover
+func (r *RedirectsCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(r.createdFiles, from, to)
+}
+
+// Redirects returns the redirect rules from the _redirects file for the site,
+// in the order they appear in it.
+func (s *State) Redirects() []Redirect {
+	for _, c := range s.compilers {
+		if r, ok := c.(*RedirectsCompilerType); ok {
+			return append([]Redirect{}, r.redirects...)
+		}
+	}
+	return []Redirect{}
+}
+
+// parseRedirects parses the redirect rules in the file at path. Each line
+// has the url path to redirect from, the url to redirect to, and optionally
+// the status code, separated by whitespace, e.g.
+//
+//	/old-post/    /posts/new-post/    301
+//
+// The status code is 301 if it is omitted. Blank lines and lines which start
+// with # are ignored.
+func parseRedirects(path string) ([]Redirect, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	redirects := []Redirect{}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: Expected a path to redirect from, a url to redirect to, and optionally a status code but got: %s", path, lineNum, line)
+		}
+		redirect := Redirect{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently}
+		if !strings.HasPrefix(redirect.From, "/") {
+			return nil, fmt.Errorf("%s:%d: The path to redirect from should start with a / but got: %s", path, lineNum, redirect.From)
+		}
+		if hasParentSegment(redirect.From) {
+			// The page for the rule would be created outside of config.DestDir
+			return nil, fmt.Errorf("%s:%d: The path to redirect from can not contain .. but got: %s", path, lineNum, redirect.From)
+		}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil || !validRedirectStatus(status) {
+				return nil, fmt.Errorf("%s:%d: The status code should be one of 301, 302, 303, 307, or 308 but got: %s", path, lineNum, fields[2])
+			}
+			redirect.Status = status
+		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects, scanner.Err()
+}
+
+func validRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectDestPath returns the path in config.DestDir where the page which
+// redirects from the url path from should be created, e.g. /old-post/ becomes
+// public/old-post/index.html. It returns an empty string if from is not an
+// html page.
+func (r *RedirectsCompilerType) redirectDestPath(from string) string {
+	switch path.Ext(from) {
+	case "":
+		from = path.Join(from, "index.html")
+	case ".html", ".htm":
+	default:
+		return ""
+	}
+	return filepath.Join(r.state.destDir, filepath.FromSlash(strings.TrimPrefix(from, "/")))
+}
+
+// writeRedirectPage writes an html page to destPath which redirects
+// browsers to the url to.
+func (r *RedirectsCompilerType) writeRedirectPage(destPath string, to string) error {
+	destFile, err := r.state.files.CreateFileWithPath(destPath)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+	to = html.EscapeString(to)
+	_, err = fmt.Fprintf(destFile, `<!doctype html><html><head><meta charset="utf-8"><title>Redirecting</title><link rel="canonical" href="%s"><meta http-equiv="refresh" content="0; url=%s"></head><body><p>This page has moved to <a href="%s">%s</a>.</p></body></html>`+"\n", to, to, to, to)
+	return err
+}
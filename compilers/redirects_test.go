@@ -0,0 +1,127 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/util"
+	"github.com/howeyc/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedirectsCompiler(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_redirects_compiler")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+	redirectsPath := filepath.Join(srcDir, "_redirects")
+	writeRedirects := func(content string) {
+		if err := ioutil.WriteFile(redirectsPath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRedirects(`# moved posts
+/old-one/      /one/
+/legacy.html   https://example.com/new?a=1&b=2   302
+
+/old-feed.xml  /feed.xml   307
+`)
+
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.DestDir = filepath.Join(root, "public")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	s := NewState(c)
+	if err := s.CompileAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the rules
+	expected := []Redirect{
+		{From: "/old-one/", To: "/one/", Status: 301},
+		{From: "/legacy.html", To: "https://example.com/new?a=1&b=2", Status: 302},
+		{From: "/old-feed.xml", To: "/feed.xml", Status: 307},
+	}
+	if got := s.Redirects(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Redirects were incorrect.\nExpected: %v\nBut got:  %v", expected, got)
+	}
+	for path, expected := range map[string]bool{
+		"/old-one":            true,
+		"/old-one/":           true,
+		"/old-one/index.html": true,
+		"/old-one/other":      false,
+		"/one/":               false,
+	} {
+		if got := s.Redirects()[0].Matches(path); got != expected {
+			t.Errorf("Expected Matches(%q) to be %v but got %v", path, expected, got)
+		}
+	}
+
+	// There should be a page which redirects with a meta refresh tag for
+	// each html page, but not for other kinds of files
+	for path, to := range map[string]string{
+		filepath.Join("old-one", "index.html"): "/one/",
+		"legacy.html":                          "https://example.com/new?a=1&amp;b=2",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(c.DestDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := `<meta http-equiv="refresh" content="0; url=` + to + `">`; !strings.Contains(string(content), expected) {
+			t.Errorf("Expected %s to contain %s but got: %s", path, expected, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(c.DestDir, "old-feed.xml")); !os.IsNotExist(err) {
+		t.Errorf("Expected no page for old-feed.xml but got %v", err)
+	}
+
+	// Removing a rule should remove its page
+	writeRedirects("/old-one/ /one/\n")
+	if err := s.FileChanged(redirectsPath, &fsnotify.FileEvent{Name: redirectsPath}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(c.DestDir, "legacy.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy.html to be removed but got %v", err)
+	}
+	if got := len(s.Redirects()); got != 1 {
+		t.Errorf("Expected 1 redirect but got %d", got)
+	}
+
+	// Invalid rules and rules which would replace a page are errors
+	for content, expected := range map[string]string{
+		"/a":                  "_redirects:1: Expected a path to redirect from",
+		"\n/a /b 200":         "_redirects:2: The status code should be one of",
+		"a /b":                "_redirects:1: The path to redirect from should start with a /",
+		"/../x /b":            "_redirects:1: The path to redirect from can not contain ..",
+		"/a/../../x.html /b":  "_redirects:1: The path to redirect from can not contain ..",
+		"/about.html /one/\n": "Can not redirect from /about.html because there is already a page there.",
+	} {
+		writeRedirects(content)
+		err := s.CompileAll()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q but got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RegisterOptions declares where a Compiler belongs in the order that
+// compilers run, relative to other compilers identified by the name they were
+// registered with. Names which do not belong to any registered Compiler are
+// ignored, so a Compiler may declare an order relative to compilers which are
+// not always linked into the program.
+type RegisterOptions struct {
+	// After is the names of compilers which must run before this one. E.g.,
+	// any Compiler which renders posts or lists of posts should run after
+	// "posts", because that is the Compiler which parses them.
+	After []string
+	// Before is the names of compilers which must run after this one.
+	Before []string
+	// Last means that this Compiler must run after every Compiler which was
+	// not registered with Last, including ones which are registered later.
+	// E.g., redirect pages must not replace any real page.
+	Last bool
+}
+
+// registration is the function which creates a Compiler, along with the
+// name and options it was registered with.
+type registration struct {
+	name        string
+	newCompiler func(s *State) Compiler
+	opts        RegisterOptions
+}
+
+// registrations holds every registered Compiler in the order they were
+// registered, and registered holds them in the order that they run.
+var registrations, registered = []registration{}, []registration{}
+
+func init() {
+	// Register the built-in compilers. All of them but sass and external
+	// commands may render posts or lists of posts, so they must come after the posts compiler.
+	afterPosts := RegisterOptions{After: []string{"posts"}}
+	Register("posts", func(s *State) Compiler { return &PostsCompilerType{state: s} }, RegisterOptions{})
+	Register("feeds", func(s *State) Compiler { return &FeedsCompilerType{state: s} }, afterPosts)
+	Register("pages", func(s *State) Compiler { return &PagesCompilerType{state: s} }, afterPosts)
+	Register("sass", func(s *State) Compiler { return &SassCompilerType{state: s} }, RegisterOptions{})
+	Register("html", func(s *State) Compiler { return &HtmlTemplatesCompilerType{state: s} }, afterPosts)
+	Register("jade", func(s *State) Compiler { return &JadeCompilerType{state: s} }, afterPosts)
+	Register("external", func(s *State) Compiler { return &ExternalCompilersType{state: s} }, RegisterOptions{})
+	// Redirect pages must not replace real pages, so they come last
+	Register("redirects", func(s *State) Compiler { return &RedirectsCompilerType{state: s} }, RegisterOptions{Last: true})
+}
+
+// Register makes a Compiler available under the given name, e.g. so that a
+// Compiler in a separate package can be linked into a custom scribble binary.
+// newCompiler is called once for each site to create the instance of the
+// Compiler for that site, which should keep everything it knows about the
+// site (e.g. the files it created) to itself. opts declares which other
+// compilers it must run before or after. Compilers with no declared order run
+// in the order they were registered. Register should be called from an init
+// function, since sites which were created before it is called don't use the
+// Compiler. It panics if name is already registered, if newCompiler is nil,
+// or if the declared order can not be satisfied.
+func Register(name string, newCompiler func(s *State) Compiler, opts RegisterOptions) {
+	if newCompiler == nil {
+		panic("compilers: Register newCompiler is nil")
+	}
+	for _, r := range registrations {
+		if r.name == name {
+			panic(fmt.Sprintf("compilers: Register called twice for compiler %s", name))
+		}
+	}
+	registrations = append(registrations, registration{name: name, newCompiler: newCompiler, opts: opts})
+	sorted, err := sortRegistrations(registrations)
+	if err != nil {
+		panic(err)
+	}
+	registered = sorted
+}
+
+// sortRegistrations returns regs sorted so that every Compiler comes after
+// the compilers it must run after and before the compilers it must run before,
+// and compilers registered with Last come after all of the others. Otherwise, compilers keep the order they were registered in. It returns an
+// error if there is no order which satisfies every declaration.
+func sortRegistrations(regs []registration) ([]registration, error) {
+	indexes := map[string]int{}
+	for i, r := range regs {
+		indexes[r.name] = i
+	}
+	// after[i] is the set of indexes of registrations which must come
+	// before regs[i]
+	after := make([]map[int]bool, len(regs))
+	for i := range regs {
+		after[i] = map[int]bool{}
+	}
+	for i, r := range regs {
+		for _, name := range r.opts.After {
+			if j, found := indexes[name]; found {
+				after[i][j] = true
+			}
+		}
+		for _, name := range r.opts.Before {
+			if j, found := indexes[name]; found {
+				after[j][i] = true
+			}
+		}
+		if r.opts.Last {
+			for j, other := range regs {
+				if !other.opts.Last {
+					after[i][j] = true
+				}
+			}
+		}
+	}
+
+	// Repeatedly take the first registration which does not have to come
+	// after any of the remaining ones
+	sorted := []registration{}
+	done := make([]bool, len(regs))
+	for len(sorted) < len(regs) {
+		next := -1
+		for i := range regs {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for j := range after[i] {
+				if !done[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			remaining := []string{}
+			for i, r := range regs {
+				if !done[i] {
+					remaining = append(remaining, r.name)
+				}
+			}
+			return nil, fmt.Errorf("compilers: the order of compilers %s can not be satisfied because they must run before or after each other", strings.Join(remaining, ", "))
+		}
+		done[next] = true
+		sorted = append(sorted, regs[next])
+	}
+	return sorted, nil
+}
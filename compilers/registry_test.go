@@ -0,0 +1,160 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/config"
+	"github.com/howeyc/fsnotify"
+	"reflect"
+	"testing"
+)
+
+func TestBuiltInCompilersOrder(t *testing.T) {
+	s := NewState(config.Default())
+	expected := []string{"posts", "feeds", "pages", "sass", "html", "jade", "external", "redirects"}
+	if !reflect.DeepEqual(s.names, expected) {
+		t.Errorf("Built-in compilers were in the wrong order.\nExpected: %v\nGot: %v", expected, s.names)
+	}
+	expectedTypes := []string{
+		"*compilers.PostsCompilerType",
+		"*compilers.FeedsCompilerType",
+		"*compilers.PagesCompilerType",
+		"*compilers.SassCompilerType",
+		"*compilers.HtmlTemplatesCompilerType",
+		"*compilers.JadeCompilerType",
+		"*compilers.ExternalCompilersType",
+		"*compilers.RedirectsCompilerType",
+	}
+	gotTypes := []string{}
+	for _, c := range s.compilers {
+		gotTypes = append(gotTypes, fmt.Sprintf("%T", c))
+	}
+	if !reflect.DeepEqual(gotTypes, expectedTypes) {
+		t.Errorf("Built-in compilers had the wrong types.\nExpected: %v\nGot: %v", expectedTypes, gotTypes)
+	}
+	expectedLayouts := []PostLayoutCompiler{s.compiler("html").(PostLayoutCompiler), s.compiler("jade").(PostLayoutCompiler)}
+	if !reflect.DeepEqual(s.postLayoutCompilers, expectedLayouts) {
+		t.Errorf("Post layout compilers were incorrect.\nExpected: %v\nGot: %v", expectedLayouts, s.postLayoutCompilers)
+	}
+
+	// Each State should have its own instance of each compiler
+	if other := NewState(config.Default()); other.compilers[0] == s.compilers[0] {
+		t.Error("Expected each State to have its own instance of each compiler")
+	}
+}
+
+func TestSortRegistrations(t *testing.T) {
+	testCases := []struct {
+		regs     []registration
+		expected []string
+	}{
+		{
+			// With no declared order, registration order is kept
+			regs: []registration{
+				{name: "a"},
+				{name: "b"},
+				{name: "c"},
+			},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			regs: []registration{
+				{name: "a", opts: RegisterOptions{After: []string{"c"}}},
+				{name: "b"},
+				{name: "c"},
+			},
+			expected: []string{"b", "c", "a"},
+		},
+		{
+			regs: []registration{
+				{name: "a"},
+				{name: "b"},
+				{name: "c", opts: RegisterOptions{Before: []string{"a"}}},
+			},
+			expected: []string{"b", "c", "a"},
+		},
+		{
+			// Compilers registered with Last come after all of the others,
+			// even ones registered after them
+			regs: []registration{
+				{name: "a", opts: RegisterOptions{Last: true}},
+				{name: "b"},
+				{name: "c", opts: RegisterOptions{Last: true}},
+				{name: "d", opts: RegisterOptions{After: []string{"b"}}},
+			},
+			expected: []string{"b", "d", "a", "c"},
+		},
+		{
+			// Unknown names are ignored
+			regs: []registration{
+				{name: "a", opts: RegisterOptions{After: []string{"unknown"}}},
+				{name: "b", opts: RegisterOptions{Before: []string{"unknown"}}},
+			},
+			expected: []string{"a", "b"},
+		},
+	}
+	for i, tc := range testCases {
+		sorted, err := sortRegistrations(tc.regs)
+		if err != nil {
+			t.Errorf("Unexpected error in test case %d: %s", i, err)
+			continue
+		}
+		got := []string{}
+		for _, r := range sorted {
+			got = append(got, r.name)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Order was incorrect for test case %d.\nExpected: %v\nGot: %v", i, tc.expected, got)
+		}
+	}
+
+	// An order which can not be satisfied should return an error
+	if _, err := sortRegistrations([]registration{
+		{name: "a", opts: RegisterOptions{After: []string{"b"}}},
+		{name: "b", opts: RegisterOptions{After: []string{"a"}}},
+	}); err == nil {
+		t.Error("Expected an error for compilers which must run after each other but got none")
+	}
+}
+
+// pluginCompiler is a Compiler which is registered by a test the same way a
+// Compiler from another package would be.
+type pluginCompiler struct {
+	state *State
+}
+
+func (p *pluginCompiler) CompileMatchFunc() MatchFunc {
+	return filenameMatchFunc("*.plugin", false, false)
+}
+func (p *pluginCompiler) Compile(srcPath string) error                             { return nil }
+func (p *pluginCompiler) CompileAll(srcPaths []string) error                       { return nil }
+func (p *pluginCompiler) RemoveOld() error                                         { return nil }
+func (p *pluginCompiler) WatchMatchFunc() MatchFunc                                { return p.CompileMatchFunc() }
+func (p *pluginCompiler) FileChanged(srcPath string, ev *fsnotify.FileEvent) error { return nil }
+
+func TestRegisterPluginCompiler(t *testing.T) {
+	// Restore the registered compilers afterwards so other tests don't see
+	// the plugin
+	oldRegistrations, oldRegistered := registrations, registered
+	defer func() {
+		registrations, registered = oldRegistrations, oldRegistered
+	}()
+	registrations = append([]registration{}, registrations...)
+
+	// A plugin registered after the built-in compilers with no declared
+	// order should still run before redirects
+	Register("plugin", func(s *State) Compiler { return &pluginCompiler{state: s} }, RegisterOptions{})
+	s := NewState(config.Default())
+	expected := []string{"posts", "feeds", "pages", "sass", "html", "jade", "external", "plugin", "redirects"}
+	if !reflect.DeepEqual(s.names, expected) {
+		t.Errorf("Compilers were in the wrong order.\nExpected: %v\nGot: %v", expected, s.names)
+	}
+	if plugin, ok := s.compiler("plugin").(*pluginCompiler); !ok {
+		t.Errorf("Expected compiler named plugin to be a *pluginCompiler but got %T", s.compiler("plugin"))
+	} else if plugin.state != s {
+		t.Error("Expected plugin compiler to be created with the State it belongs to")
+	}
+}
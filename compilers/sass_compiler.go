@@ -6,31 +6,27 @@ package compilers
 
 import (
 	"fmt"
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/log"
-	"github.com/albrow/scribble/util"
+	"github.com/albrow/scribble/sass"
 	"github.com/howeyc/fsnotify"
-	"os"
 	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
 )
 
 // SassCompilerType represents a type capable of compiling sass files.
 type SassCompilerType struct {
+	state *State
 	// createdFiles is a slice of file paths which were created by this
 	// compiler. It is important for implementing the RemoveOld method.
 	createdFiles []string
 }
 
-// SassCompiler is an instatiation of SassCompilerType
-var SassCompiler = SassCompilerType{}
-
 // CompileMatchFunc returns a MatchFunc which will return true for
 // any files which match a given pattern. In this case, the pattern
 // is any file that ends in ".scss", excluding hidden and ignored
 // files and directories.
-func (s *SassCompilerType) CompileMatchFunc() MatchFunc {
+func (c *SassCompilerType) CompileMatchFunc() MatchFunc {
 	return filenameMatchFunc("*.scss", true, true)
 }
 
@@ -39,7 +35,7 @@ func (s *SassCompilerType) CompileMatchFunc() MatchFunc {
 // is any file that ends in ".scss", excluding hidden files and directories,
 // but including those that start with an underscore, since they may
 // be imported in other files.
-func (s *SassCompilerType) WatchMatchFunc() MatchFunc {
+func (c *SassCompilerType) WatchMatchFunc() MatchFunc {
 	return filenameMatchFunc("*.scss", true, false)
 }
 
@@ -48,64 +44,132 @@ func (s *SassCompilerType) WatchMatchFunc() MatchFunc {
 // according to the MatchFunc. Behavior for any other file is
 // undefined. Compile will output the compiled result to the appropriate
 // location in config.DestDir.
-func (s *SassCompilerType) Compile(srcPath string) error {
+func (c *SassCompilerType) Compile(srcPath string) error {
+	s := c.state
 	// parse path and figure out destPath
 	destPath := strings.Replace(srcPath, ".scss", ".css", 1)
-	destPath = strings.Replace(destPath, config.SourceDir, config.DestDir, 1)
+	destPath = strings.Replace(destPath, s.config.SourceDir, s.destDir, 1)
 	log.Success.Printf("CREATE: %s -> %s", srcPath, destPath)
 
-	// create the dest directory if needed
-	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-		// if the dir already exists, that's fine
-		// if there was some other error, return it
-		if !os.IsExist(err) {
-			return err
-		}
+	// Keep track of the partials the file imports, so it can be recompiled
+	// whenever one of them changes. This happens first so that fixing an
+	// error in a partial recompiles the file.
+	deps, err := sass.Dependencies(srcPath, s.config.Sass.LoadPaths)
+	if err != nil {
+		return err
 	}
+	s.depGraph.setDependencies(srcPath, deps)
 
-	// set up and execute the command, capturing the output only if there was an error
-	cmd := exec.Command("sassc", srcPath, destPath)
-	response, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("while compiling sass: %s", string(response))
+	compile := c.compileSassNative
+	if s.config.Sass.Compiler == "sassc" {
+		compile = c.compileSassc
+	}
+	if err := compile(srcPath, destPath); err != nil {
+		return err
 	}
 
-	// Add destPath to the list of created files
-	s.createdFiles = append(s.createdFiles, destPath)
+	// Add destPath (and the source map, if any) to the list of created files
+	if s.config.Sass.SourceMap {
+		s.appendPath(&c.createdFiles, destPath+".map")
+		s.depGraph.addOutput(srcPath, destPath+".map")
+	}
+	s.appendPath(&c.createdFiles, destPath)
+	s.depGraph.addOutput(srcPath, destPath)
 
 	return nil
 }
 
-// CompileAll compiles zero or more files identified by srcPaths.
-// It works simply by calling Compile for each path. The caller is
-// responsible for only passing in files that belong to SassCompiler
-// according to the MatchFunc. Behavior for any other file is undefined.
-func (s *SassCompilerType) CompileAll(srcPaths []string) error {
-	log.Default.Println("Compiling sass...")
-	for _, srcPath := range srcPaths {
-		if err := s.Compile(srcPath); err != nil {
-			return err
+// compileSassc compiles the file at srcPath with the sassc command, using
+// the options in s.config.Sass, and writes the result to destPath.
+func (c *SassCompilerType) compileSassc(srcPath string, destPath string) error {
+	s := c.state
+	// sassc writes the source map (if any) to destPath + ".map"
+	return s.withDiskPath(destPath, []string{".map"}, func(diskPath string) error {
+		args := []string{
+			"--style", s.config.Sass.OutputStyle,
+			"--precision", strconv.Itoa(s.config.Sass.Precision),
+		}
+		for _, loadPath := range s.config.Sass.LoadPaths {
+			args = append(args, "--load-path", loadPath)
 		}
+		if s.config.Sass.SourceMap {
+			args = append(args, "--sourcemap")
+		}
+		args = append(args, srcPath, diskPath)
+
+		// set up and execute the command, capturing the output only if there was an error
+		cmd := exec.Command("sassc", args...)
+		response, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("while compiling sass: %s", string(response))
+		}
+		return nil
+	})
+}
+
+// compileSassNative compiles the file at srcPath with the built-in sass
+// compiler, using the options in s.config.Sass, and writes the result to
+// destPath.
+func (c *SassCompilerType) compileSassNative(srcPath string, destPath string) error {
+	s := c.state
+	opts := sass.Options{
+		Style:     s.config.Sass.OutputStyle,
+		LoadPaths: s.config.Sass.LoadPaths,
+		Precision: s.config.Sass.Precision,
+	}
+	if s.config.Sass.SourceMap {
+		opts.SourceMap = destPath + ".map"
+	}
+	result, err := sass.Compile(srcPath, opts)
+	if err != nil {
+		return fmt.Errorf("while compiling sass: %w", err)
+	}
+	if err := c.writeSassFile(destPath, result.CSS); err != nil {
+		return err
+	}
+	if result.SourceMap != nil {
+		return c.writeSassFile(opts.SourceMap, result.SourceMap)
 	}
 	return nil
 }
 
-func (s *SassCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
-	// TODO: Analyze sass files and be more intelligent here?
-	// Only recompile the file at srcPath and any files that import it?
-	// For now, just recompile all sass.
-	if err := recompileAllForCompiler(s); err != nil {
+func (c *SassCompilerType) writeSassFile(path string, content []byte) error {
+	destFile, err := c.state.files.CreateFileWithPath(path)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer destFile.Close()
+	_, err = destFile.Write(content)
+	return err
 }
 
-func (s *SassCompilerType) RemoveOld() error {
+// CompileAll compiles zero or more files identified by srcPaths.
+// It works simply by calling Compile for each path, using up to
+// config.Jobs workers at once. The caller is
+// responsible for only passing in files that belong to SassCompiler
+// according to the MatchFunc. Behavior for any other file is undefined.
+func (c *SassCompilerType) CompileAll(srcPaths []string) error {
+	log.Default.Println("Compiling sass...")
+	return c.state.forEachPath(srcPaths, c.Compile)
+}
+
+func (c *SassCompilerType) FileChanged(srcPath string, ev *fsnotify.FileEvent) error {
+	// Only recompile the file at srcPath (if it was not removed) and any
+	// files that import it, directly or through other partials.
+	return c.state.fileChangedForCompiler(c, srcPath)
+}
+
+func (c *SassCompilerType) RemoveOld() error {
 	// Simply iterate through createdFiles and remove each of them
-	for _, path := range s.createdFiles {
-		if err := util.RemoveIfExists(path); err != nil {
+	for _, path := range c.createdFiles {
+		if err := c.state.files.RemoveIfExists(path); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// DestDirMoved satisfies DestDirMover
+func (c *SassCompilerType) DestDirMoved(from string, to string) {
+	movedPaths(c.createdFiles, from, to)
+}
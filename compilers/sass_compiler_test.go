@@ -8,8 +8,11 @@ import (
 	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/test_util"
 	"github.com/albrow/scribble/util"
+	"github.com/howeyc/fsnotify"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -47,8 +50,10 @@ func TestSassPathMatch(t *testing.T) {
 	}
 
 	// Use the MatchFunc to find all the paths
-	config.SourceDir = root
-	gotPaths, err := FindPaths(SassCompiler.CompileMatchFunc())
+	c := config.Default()
+	c.SourceDir = root
+	s := NewState(c)
+	gotPaths, err := s.FindPaths(s.compiler("sass").CompileMatchFunc())
 	if err != nil {
 		t.Error(err)
 	}
@@ -77,9 +82,10 @@ func TestSassCompile(t *testing.T) {
 	}
 
 	// Attempt to compile the sass files
-	config.SourceDir = filepath.Join(root, "source")
-	config.DestDir = filepath.Join(root, "public")
-	if err := SassCompiler.Compile(srcDir + "/styles/main.scss"); err != nil {
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	if err := NewState(c).compiler("sass").Compile(srcDir + "/styles/main.scss"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -89,3 +95,172 @@ func TestSassCompile(t *testing.T) {
 	gotFile := filepath.Join(destDir, "styles", "main.css")
 	test_util.CheckFilesMatch(t, expectedFile, gotFile)
 }
+
+func TestSassCompileOptions(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_compiler_options")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Create a sass file which imports a partial from a load path
+	srcDir := filepath.Join(root, "source")
+	destDir := filepath.Join(root, "public")
+	files := map[string]string{
+		filepath.Join(srcDir, "styles", "main.scss"):    "@import \"reset\";\n\nbody {\n\tcolor: $red;\n\twidth: (1/3) * 100%;\n}\n",
+		filepath.Join(srcDir, "_vendor", "_reset.scss"): "$red: #ff0000;\nhtml, body > p { margin: 0; }\n",
+	}
+	for path, content := range files {
+		file, err := util.CreateFileWithPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.DestDir = destDir
+	c.Sass = config.SassConfig{
+		Compiler:    "native",
+		OutputStyle: "compressed",
+		LoadPaths:   []string{filepath.Join(srcDir, "_vendor")},
+		Precision:   2,
+		SourceMap:   true,
+	}
+	sassCompiler := NewState(c).compiler("sass").(*SassCompilerType)
+	if err := sassCompiler.Compile(filepath.Join(srcDir, "styles", "main.scss")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the css is compressed, uses the precision, and links to the
+	// source map
+	gotCSS, err := ioutil.ReadFile(filepath.Join(destDir, "styles", "main.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCSS := "html,body>p{margin:0}body{color:#f00;width:33.33%}\n\n/*# sourceMappingURL=main.css.map */\n"
+	if string(gotCSS) != expectedCSS {
+		t.Errorf("Compiled css was incorrect.\nExpected: %q\nBut got:  %q", expectedCSS, string(gotCSS))
+	}
+
+	// Make sure the source map was written next to the css and refers to
+	// the sources relative to itself
+	gotMap, err := ioutil.ReadFile(filepath.Join(destDir, "styles", "main.css.map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSources := `"sources":["../../source/_vendor/_reset.scss","../../source/styles/main.scss"]`
+	if !strings.Contains(string(gotMap), expectedSources) {
+		t.Errorf("Expected source map to contain %s but got: %s", expectedSources, string(gotMap))
+	}
+	test_util.CheckStringsMatch(t, []string{
+		filepath.Join(destDir, "styles", "main.css"),
+		filepath.Join(destDir, "styles", "main.css.map"),
+	}, sassCompiler.createdFiles)
+}
+
+func TestSassIncrementalRebuilds(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_incremental_rebuilds")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	writeFile := func(path string, content string) {
+		file, err := util.CreateFileWithPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readFile := func(path string) string {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// a.scss imports _colors.scss through _base.scss, c.scss imports it
+	// directly, and b.scss doesn't import it at all
+	srcDir := filepath.Join(root, "source")
+	destDir := filepath.Join(root, "public")
+	colorsPath := filepath.Join(srcDir, "styles", "_colors.scss")
+	writeFile(colorsPath, "$red: #ff0000;")
+	writeFile(filepath.Join(srcDir, "styles", "_base.scss"), "@import \"colors\";")
+	writeFile(filepath.Join(srcDir, "styles", "a.scss"), "@import \"base\";\na { color: $red; }")
+	writeFile(filepath.Join(srcDir, "styles", "b.scss"), "b { color: blue; }")
+	writeFile(filepath.Join(srcDir, "styles", "c.scss"), "@import \"colors\";\nc { color: $red; }")
+
+	// Compile everything once
+	c := config.Default()
+	c.SourceDir = srcDir
+	c.DestDir = destDir
+	s := NewState(c)
+	sassCompiler := s.compiler("sass")
+	paths, err := s.FindPaths(sassCompiler.CompileMatchFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sassCompiler.CompileAll(paths); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the compiled files with a sentinel value so we can tell
+	// whether or not they were recompiled
+	const sentinel = "not recompiled"
+	aPath := filepath.Join(destDir, "styles", "a.css")
+	bPath := filepath.Join(destDir, "styles", "b.css")
+	cPath := filepath.Join(destDir, "styles", "c.css")
+	for _, path := range []string{aPath, bPath, cPath} {
+		writeFile(path, sentinel)
+	}
+
+	// Change the partial. Only the files which import it should be
+	// recompiled.
+	writeFile(colorsPath, "$red: #ee0000;")
+	if err := sassCompiler.FileChanged(colorsPath, &fsnotify.FileEvent{Name: colorsPath}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{aPath, cPath} {
+		if got := readFile(path); !strings.Contains(got, "#ee0000") {
+			t.Errorf("Expected %s to be recompiled but got: %s", path, got)
+		}
+	}
+	if got := readFile(bPath); got != sentinel {
+		t.Errorf("Expected %s to not be recompiled but got: %s", bPath, got)
+	}
+
+	// Remove an entry file. Only its output should be removed.
+	writeFile(aPath, sentinel)
+	cSrcPath := filepath.Join(srcDir, "styles", "c.scss")
+	if err := os.Remove(cSrcPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := sassCompiler.FileChanged(cSrcPath, &fsnotify.FileEvent{Name: cSrcPath}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", cPath)
+	}
+	for _, path := range []string{aPath, bPath} {
+		if got := readFile(path); got != sentinel {
+			t.Errorf("Expected %s to not be recompiled but got: %s", path, got)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/albrow/scribble/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// the names of the generated files, relative to config.DestDir
+	sitemapName = "sitemap.xml"
+	robotsName  = "robots.txt"
+	// the url path of the page that static hosts respond with when a file
+	// can't be found. It is never included in the sitemap.
+	notFoundUrlPath = "/404.html"
+)
+
+// sitemapEntry is a record of an html page which was created in config.DestDir
+// and may be included in the sitemap.
+type sitemapEntry struct {
+	// the time the page was last modified, e.g. the date of a post
+	lastMod time.Time
+	// exclude is true iff the page opted out of the sitemap with
+	// sitemap = false in its frontmatter, or for jade pages, with a
+	// "//- sitemap: false" comment
+	exclude bool
+}
+
+// addSitemapEntry records that an html page was created at destPath, so that
+// it can be included in the sitemap. Compilers should call it whenever they
+// create an html page.
+func (s *State) addSitemapEntry(destPath string, lastMod time.Time, exclude bool) {
+	s.sitemapMutex.Lock()
+	defer s.sitemapMutex.Unlock()
+	s.sitemapEntries[destPath] = sitemapEntry{
+		lastMod: lastMod,
+		exclude: exclude,
+	}
+}
+
+// removeSitemapEntry forgets about the html page at destPath, e.g. because
+// it was removed.
+func (s *State) removeSitemapEntry(destPath string) {
+	s.sitemapMutex.Lock()
+	defer s.sitemapMutex.Unlock()
+	delete(s.sitemapEntries, destPath)
+}
+
+// excludedFromSitemap returns true iff frontMatter (a map of decoded toml
+// frontmatter) has a sitemap key which is set to false.
+func excludedFromSitemap(frontMatter map[string]interface{}) bool {
+	if include, ok := frontMatter["sitemap"].(bool); ok {
+		return !include
+	}
+	return false
+}
+
+// modTime returns the time that the file at path was last modified.
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// postLastMod returns the date of post, or the time that its file was last
+// modified if it doesn't have a date.
+func postLastMod(post *Post) (time.Time, error) {
+	if !post.Date.IsZero() {
+		return post.Date, nil
+	}
+	return modTime(post.src)
+}
+
+// compileSitemap writes sitemap.xml and robots.txt to config.DestDir, if they
+// are enabled in config. The sitemap includes every html page that was created
+// by the compilers (and still exists) except for those which opted out.
+func (s *State) compileSitemap() error {
+	if !s.config.Sitemap && !s.config.Robots {
+		return nil
+	}
+	if s.config.BaseURL == "" {
+		return fmt.Errorf("Missing required config variable: baseURL. Please add it to config.toml or set sitemap and robots to false.")
+	}
+	if s.config.Sitemap {
+		sitemapPath := filepath.Join(s.destDir, sitemapName)
+		log.Success.Printf("CREATE: %s", sitemapPath)
+		if err := s.writeXML(sitemapPath, s.newSitemap()); err != nil {
+			return err
+		}
+	}
+	if s.config.Robots {
+		if err := s.writeRobots(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sitemapUrlset is the root element of a sitemap.
+// See http://www.sitemaps.org/protocol.html
+type sitemapUrlset struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	Urls    []sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// newSitemap creates a sitemap out of sitemapEntries, sorted by url. The 404
+// page is left out, since it is not a real page of the site.
+func (s *State) newSitemap() *sitemapUrlset {
+	sitemap := &sitemapUrlset{}
+	s.sitemapMutex.Lock()
+	defer s.sitemapMutex.Unlock()
+	for destPath, entry := range s.sitemapEntries {
+		if entry.exclude {
+			continue
+		}
+		urlPath := s.urlPathForDestPath(destPath)
+		if urlPath == notFoundUrlPath {
+			continue
+		}
+		if _, err := s.files.Stat(destPath); err != nil {
+			// The page was removed since it was created
+			continue
+		}
+		url := sitemapUrl{
+			Loc: s.absoluteUrl(urlPath),
+		}
+		if !entry.lastMod.IsZero() {
+			url.LastMod = entry.lastMod.Format(time.RFC3339)
+		}
+		sitemap.Urls = append(sitemap.Urls, url)
+	}
+	sort.Sort(sitemapUrlsByLoc(sitemap.Urls))
+	return sitemap
+}
+
+// urlPathForDestPath returns the url path that would be used to access the
+// file at destPath, e.g. public/about/index.html becomes /about/.
+func (s *State) urlPathForDestPath(destPath string) string {
+	relPath := filepath.ToSlash(strings.TrimPrefix(destPath, s.destDir))
+	if filepath.Base(relPath) == "index.html" {
+		relPath = strings.TrimSuffix(relPath, "index.html")
+	}
+	return "/" + strings.TrimPrefix(relPath, "/")
+}
+
+// writeRobots writes a robots.txt file which allows everything and points to
+// the sitemap (if there is one). If there is already a robots.txt file in
+// config.SourceDir, it will be copied as is and writeRobots does nothing.
+func (s *State) writeRobots() error {
+	if _, err := os.Stat(filepath.Join(s.config.SourceDir, robotsName)); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	robotsPath := filepath.Join(s.destDir, robotsName)
+	log.Success.Printf("CREATE: %s", robotsPath)
+	robotsFile, err := s.files.CreateFileWithPath(robotsPath)
+	if err != nil {
+		return err
+	}
+	defer robotsFile.Close()
+	content := "User-agent: *\nDisallow:\n"
+	if s.config.Sitemap {
+		content += fmt.Sprintf("\nSitemap: %s\n", s.absoluteUrl(sitemapName))
+	}
+	_, err = robotsFile.WriteString(content)
+	return err
+}
+
+// The sitemapUrlsByLoc type is used only for sorting
+type sitemapUrlsByLoc []sitemapUrl
+
+func (s sitemapUrlsByLoc) Len() int {
+	return len(s)
+}
+
+func (s sitemapUrlsByLoc) Less(i, j int) bool {
+	return s[i].Loc < s[j].Loc
+}
+
+func (s sitemapUrlsByLoc) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
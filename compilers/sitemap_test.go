@@ -0,0 +1,69 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/test_util"
+	"github.com/albrow/scribble/util"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompileSitemap(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sitemap")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Create a few pages, as if they had been created by the compilers
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	c.BaseURL = "http://example.com"
+	c.Sitemap = true
+	c.Robots = true
+	s := NewState(c)
+	date := time.Date(2014, time.November, 16, 13, 50, 53, 0, time.UTC)
+	pages := map[string]bool{
+		filepath.Join(c.DestDir, "index.html"):               false,
+		filepath.Join(c.DestDir, "first", "index.html"):      false,
+		filepath.Join(c.DestDir, "about.html"):               false,
+		filepath.Join(c.DestDir, "secret", "index.html"):     true,
+		filepath.Join(c.DestDir, "tags", "go", "index.html"): false,
+	}
+	for destPath, exclude := range pages {
+		if err := util.CreateEmptyFiles([]string{destPath}); err != nil {
+			t.Fatal(err)
+		}
+		s.addSitemapEntry(destPath, date, exclude)
+	}
+	// The 404 page should never be included
+	notFoundPath := filepath.Join(c.DestDir, "404.html")
+	if err := util.CreateEmptyFiles([]string{notFoundPath}); err != nil {
+		t.Fatal(err)
+	}
+	s.addSitemapEntry(notFoundPath, date, false)
+	// Pages which were removed since they were created should not be included
+	s.addSitemapEntry(filepath.Join(c.DestDir, "removed", "index.html"), date, false)
+
+	if err := s.compileSitemap(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the compiled results are correct
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "sitemap")
+	expectedDir := filepath.Join(testFilesDir, "public")
+	for _, file := range []string{sitemapName, robotsName} {
+		test_util.CheckFilesMatch(t, filepath.Join(expectedDir, file), filepath.Join(c.DestDir, file))
+	}
+}
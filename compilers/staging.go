@@ -0,0 +1,217 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// DestDirMover is an interface which should be satisfied by any Compiler which
+// keeps track of the files it created in config.DestDir. CompileAll writes
+// everything to a staging directory first, and DestDirMoved is used to update
+// those paths after the staging directory is swapped in for config.DestDir.
+type DestDirMover interface {
+	// DestDirMoved is called after everything in the directory from was
+	// moved to the directory to.
+	DestDirMoved(from string, to string)
+}
+
+// stagingCount is used to name staging directories which are kept in memory
+var stagingCount int64
+
+// buildDirPrefix returns the prefix of the name of each directory which holds
+// a build for destDir. They are hidden and placed next to destDir, e.g.
+// .public-build-123 for public.
+func buildDirPrefix(destDir string) string {
+	return "." + filepath.Base(destDir) + "-build-"
+}
+
+// createStagingDir creates and returns a new, empty directory next to destDir
+// where a build can be written before it is swapped in. The staging directory
+// has the same permissions as destDir if it already exists. If destDir is
+// kept in memory, i.e. a util.FileSystem is mounted there, the staging
+// directory is kept in memory too.
+func (s *State) createStagingDir(destDir string) (string, error) {
+	parent := filepath.Dir(filepath.Clean(destDir))
+	if s.files.MountedAt(destDir) != nil {
+		name := fmt.Sprintf(".%s-staging-%d", filepath.Base(destDir), atomic.AddInt64(&stagingCount, 1))
+		stagingDir := filepath.Join(parent, name)
+		s.files.Mount(stagingDir, util.NewMemoryFileSystem())
+		return stagingDir, nil
+	}
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return "", err
+	}
+	stagingDir, err := ioutil.TempDir(parent, buildDirPrefix(destDir))
+	if err != nil {
+		return "", err
+	}
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(destDir); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(stagingDir, mode); err != nil {
+		return "", err
+	}
+	return stagingDir, nil
+}
+
+// swapStagingDir replaces destDir with stagingDir. On disk, destDir is a
+// symbolic link to the directory which holds the current build, so that the
+// swap is atomic: a new link to stagingDir is created next to destDir and then
+// renamed over it, which replaces the old link in a single step. Afterwards
+// the previous build is removed. Anything which reads destDir sees either the
+// previous build or the new one, never a missing or partial directory.
+//
+// There are two cases where the swap falls back to renaming directories,
+// which is not atomic. If destDir is an ordinary directory, e.g. from an
+// older version of scribble, it is renamed out of the way before the link
+// takes its place, so destDir is missing for the instant between the two
+// renames. This only happens once, since destDir is a link afterwards. On
+// platforms or file systems where links can't be created (e.g. Windows
+// without the required privilege), stagingDir itself is renamed to destDir
+// in the same way on every build. If scribble is killed between the two
+// renames, the previous build is left in a hidden directory next to destDir
+// with "-old-" in its name.
+//
+// If stagingDir is kept in memory, its FileSystem is simply mounted at
+// destDir instead, which is atomic.
+func (s *State) swapStagingDir(stagingDir string, destDir string) error {
+	if fs := s.files.MountedAt(stagingDir); fs != nil {
+		s.files.Mount(destDir, fs)
+		s.files.Unmount(stagingDir)
+		return nil
+	}
+	info, err := os.Lstat(destDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// The link is relative, so that it still works if the parent directory
+	// is moved
+	link := strings.Replace(stagingDir, "-build-", "-link-", 1)
+	oldDir := strings.Replace(stagingDir, "-build-", "-old-", 1)
+	if err := os.Symlink(filepath.Base(stagingDir), link); err != nil {
+		// Links are not supported here, so fall back to renaming stagingDir
+		if info == nil {
+			return os.Rename(stagingDir, destDir)
+		}
+		return replaceDir(stagingDir, destDir, oldDir)
+	}
+	switch {
+	case info == nil:
+		return os.Rename(link, destDir)
+	case info.Mode()&os.ModeSymlink != 0:
+		previousBuild := linkedBuildDir(destDir)
+		if err := os.Rename(link, destDir); err != nil {
+			os.Remove(link)
+			return err
+		}
+		if previousBuild == "" {
+			return nil
+		}
+		return util.RemoveAllIfExists(previousBuild)
+	default:
+		if err := replaceDir(link, destDir, oldDir); err != nil {
+			os.Remove(link)
+			return err
+		}
+		return nil
+	}
+}
+
+// linkedBuildDir returns the directory which holds the build that the link
+// at destDir points to, or an empty string if it does not point to one of
+// the directories created by createStagingDir. E.g. if a user made destDir a
+// link to some other directory, that directory is never removed.
+func linkedBuildDir(destDir string) string {
+	target, err := os.Readlink(destDir)
+	if err != nil {
+		return ""
+	}
+	parent := filepath.Dir(filepath.Clean(destDir))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(parent, target)
+	}
+	if filepath.Dir(target) != parent || !strings.HasPrefix(filepath.Base(target), buildDirPrefix(destDir)) {
+		return ""
+	}
+	return target
+}
+
+// replaceDir replaces the directory at destDir with path by renaming destDir
+// to oldDir, renaming path to destDir, and then removing oldDir. destDir is
+// missing for the instant between the two renames.
+func replaceDir(path string, destDir string, oldDir string) error {
+	if err := os.Rename(destDir, oldDir); err != nil {
+		return err
+	}
+	if err := os.Rename(path, destDir); err != nil {
+		// Try to put the previous output back where it was
+		if restoreErr := os.Rename(oldDir, destDir); restoreErr != nil {
+			return fmt.Errorf("%s\nWhile restoring the previous build: %s", err.Error(), restoreErr.Error())
+		}
+		return err
+	}
+	return util.RemoveAllIfExists(oldDir)
+}
+
+// removeStagingDir removes stagingDir after a build failed
+func (s *State) removeStagingDir(stagingDir string) error {
+	if s.files.MountedAt(stagingDir) != nil {
+		s.files.Unmount(stagingDir)
+		return nil
+	}
+	return util.RemoveAllIfExists(stagingDir)
+}
+
+// moveDestPaths updates all the paths in config.DestDir that the compilers
+// and the sitemap keep track of after everything in from was moved to to.
+func (s *State) moveDestPaths(from string, to string) {
+	for _, c := range s.compilers {
+		if mover, ok := c.(DestDirMover); ok {
+			mover.DestDirMoved(from, to)
+		}
+	}
+	s.sitemapMutex.Lock()
+	movedEntries := map[string]sitemapEntry{}
+	for path, entry := range s.sitemapEntries {
+		movedEntries[movedPath(path, from, to)] = entry
+	}
+	s.sitemapEntries = movedEntries
+	s.sitemapMutex.Unlock()
+	s.depGraph.destDirMoved(from, to)
+}
+
+// movedPaths replaces each path in paths which is inside from with the
+// corresponding path inside to.
+func movedPaths(paths []string, from string, to string) {
+	for i, path := range paths {
+		paths[i] = movedPath(path, from, to)
+	}
+}
+
+// movedPath returns the path inside to which corresponds to path, if path is
+// inside from. Otherwise it returns path unchanged.
+func movedPath(path string, from string, to string) string {
+	rel, err := filepath.Rel(from, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.Join(to, rel)
+}
+
+func (g *dependencyGraph) destDirMoved(from string, to string) {
+	g.Lock()
+	defer g.Unlock()
+	for _, outputs := range g.outputs {
+		movedPaths(outputs, from, to)
+	}
+}
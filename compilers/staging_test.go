@@ -0,0 +1,118 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileAllIsAtomic(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_compile_all_is_atomic")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Create a layout, a page, and an old file in the dest dir which should
+	// be removed by a successful build
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.DestDir = filepath.Join(root, "public")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.IncludesDir = ""
+	pagePath := filepath.Join(c.SourceDir, "index.tmpl")
+	files := map[string]string{
+		filepath.Join(c.LayoutsDir, "base.tmpl"): `<html><body>{{ template "content" . }}</body></html>`,
+		pagePath:                                 `{{ define "content" }}first{{ end }}{{ template "base.tmpl" . }}`,
+		filepath.Join(c.DestDir, "old.html"):     "old",
+	}
+	for path, content := range files {
+		if err := util.CreateEmptyFiles([]string{path}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewState(c)
+
+	// A successful build should replace everything in the dest dir
+	if err := s.CompileAll(); err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(c.DestDir, "index.html")
+	expected := "<html><body>first</body></html>"
+	if got, err := ioutil.ReadFile(indexPath); err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("Contents of %s were incorrect.\nExpected: %s\nGot: %s", indexPath, expected, string(got))
+	}
+	if _, err := os.Stat(filepath.Join(c.DestDir, "old.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected old.html to be removed by a successful build")
+	}
+	if s.DestDir() != c.DestDir || s.Config().DestDir != c.DestDir {
+		t.Errorf("Expected the dest dir to be %s after the build but got %s and %s", c.DestDir, s.DestDir(), s.Config().DestDir)
+	}
+
+	// A failed build should leave the previous build intact
+	if err := ioutil.WriteFile(pagePath, []byte(`{{ define "content" }}{{ .Missing.Field }}{{ end }}{{ template "base.tmpl" . }`), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CompileAll(); err == nil {
+		t.Fatal("Expected an error for an invalid template but got none")
+	}
+	if got, err := ioutil.ReadFile(indexPath); err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("Expected the previous build to be left intact after a failed build.\nExpected: %s\nGot: %s", expected, string(got))
+	}
+
+	// The dest dir was an ordinary directory before the first build, and now
+	// it should be a link to the directory which holds the current build.
+	// Another successful build should replace the link and remove the
+	// previous build.
+	firstBuild := linkedBuildDir(c.DestDir)
+	if firstBuild == "" {
+		t.Fatalf("Expected %s to be a link to the current build", c.DestDir)
+	}
+	if err := ioutil.WriteFile(pagePath, []byte(`{{ define "content" }}second{{ end }}{{ template "base.tmpl" . }}`), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CompileAll(); err != nil {
+		t.Fatal(err)
+	}
+	expected = "<html><body>second</body></html>"
+	if got, err := ioutil.ReadFile(indexPath); err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("Contents of %s were incorrect.\nExpected: %s\nGot: %s", indexPath, expected, string(got))
+	}
+	secondBuild := linkedBuildDir(c.DestDir)
+	if secondBuild == "" || secondBuild == firstBuild {
+		t.Errorf("Expected %s to be a link to a new build but got %q", c.DestDir, secondBuild)
+	}
+
+	// There should be no staging directories or previous builds left over
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "source" && entry.Name() != "public" && entry.Name() != filepath.Base(secondBuild) {
+			t.Errorf("Expected no other directories in %s but found %s", root, entry.Name())
+		}
+	}
+}
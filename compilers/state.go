@@ -0,0 +1,152 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/context"
+	"github.com/albrow/scribble/util"
+	"html/template"
+	"sync"
+)
+
+// State holds everything the compilers know about a single site: its config,
+// an instance of each registered Compiler, the parsed posts, the files that
+// were created, and the dependency graph. Each site has its own State, so
+// several sites can be compiled by the same program at once without sharing
+// anything. A State should only be used by one goroutine at a time, except
+// for the methods which return posts, which may be called from templates
+// while the posts are being compiled.
+type State struct {
+	config config.Config
+	// destDir is the directory where compiled files are written. It is
+	// config.DestDir, except while CompileAll writes to a staging directory.
+	destDir string
+	// files is used to create and remove every compiled file, so that the
+	// site can be kept in memory by mounting a util.FileSystem
+	files *util.Mounts
+	// funcMap holds the functions which are available to templates, i.e.
+	// context.FuncMap plus the functions which return posts for the site
+	funcMap template.FuncMap
+	// compilers holds an instance of each registered Compiler in the order
+	// that they run, and names holds the name each one was registered with
+	compilers           []Compiler
+	names               []string
+	postLayoutCompilers []PostLayoutCompiler
+	// compilerPaths holds the matched paths for each Compiler, and
+	// unmatchedPaths holds the paths which do not match any Compiler
+	compilerPaths  map[Compiler][]string
+	unmatchedPaths []string
+	// posts holds every post and postsMap holds each post by its source
+	// path. postsMutex guards both, since posts may be parsed by several
+	// workers at once.
+	posts      []*Post
+	postsMap   map[string]*Post
+	postsMutex sync.RWMutex
+	// sitemapEntries holds the sitemapEntry for each html page which was
+	// created, guarded by sitemapMutex
+	sitemapEntries map[string]sitemapEntry
+	sitemapMutex   sync.Mutex
+	// createdMutex guards the createdFiles and createdDirs of every
+	// compiler, which may be appended to by several workers at once
+	createdMutex sync.Mutex
+	// depGraph is the dependency graph for the most recent compilation. It
+	// is reset whenever everything is recompiled.
+	depGraph *dependencyGraph
+}
+
+// NewState returns the State for a site with the given config which has not
+// been compiled yet. It has its own instance of every Compiler which was
+// registered before it was created.
+func NewState(c config.Config) *State {
+	s := &State{
+		config:         c,
+		destDir:        c.DestDir,
+		files:          util.NewMounts(),
+		compilerPaths:  map[Compiler][]string{},
+		unmatchedPaths: []string{},
+		posts:          []*Post{},
+		postsMap:       map[string]*Post{},
+		sitemapEntries: map[string]sitemapEntry{},
+		depGraph:       newDependencyGraph(),
+	}
+	s.funcMap = s.newFuncMap()
+	for _, r := range registered {
+		compiler := r.newCompiler(s)
+		s.compilers = append(s.compilers, compiler)
+		s.names = append(s.names, r.name)
+		// Detect whether a compiler is capable of compiling post layouts,
+		// and if so, add it to the list of post layout compilers.
+		if plc, ok := compiler.(PostLayoutCompiler); ok {
+			s.postLayoutCompilers = append(s.postLayoutCompilers, plc)
+		}
+	}
+	return s
+}
+
+// Config returns the config for the site.
+func (s *State) Config() config.Config {
+	return s.config
+}
+
+// SetConfig replaces the config for the site. It takes effect the next time
+// everything is compiled.
+func (s *State) SetConfig(c config.Config) {
+	s.config = c
+	s.destDir = c.DestDir
+}
+
+// DestDir returns the directory where compiled files should be written.
+// While everything is being compiled, this is a staging directory next to
+// config.DestDir, so compilers should use it instead of config.DestDir.
+func (s *State) DestDir() string {
+	return s.destDir
+}
+
+// Files returns the util.Mounts which is used to create and remove every
+// compiled file. Compilers should use its methods instead of the functions
+// in the util package, so that they work when the site is kept in memory.
+func (s *State) Files() *util.Mounts {
+	return s.files
+}
+
+// FuncMap returns the functions which are available to templates, i.e.
+// context.FuncMap plus the functions which return the posts for the site.
+func (s *State) FuncMap() template.FuncMap {
+	return s.funcMap
+}
+
+// Compilers returns the instance of each registered Compiler for the site,
+// in the order that they run.
+func (s *State) Compilers() []Compiler {
+	return append([]Compiler{}, s.compilers...)
+}
+
+// newFuncMap returns a copy of context.FuncMap along with the Posts,
+// Collection, and taxonomy functions for the site.
+func (s *State) newFuncMap() template.FuncMap {
+	funcMap := template.FuncMap{}
+	for name, f := range context.FuncMap {
+		funcMap[name] = f
+	}
+	funcMap["Posts"] = s.Posts
+	funcMap["Collection"] = s.Collection
+	funcMap["Tags"] = s.Tags
+	funcMap["Categories"] = s.Categories
+	funcMap["PostsWithTag"] = s.PostsWithTag
+	funcMap["PostsInCategory"] = s.PostsInCategory
+	return funcMap
+}
+
+// compiler returns the Compiler for the site which was registered with the
+// given name, or nil if there is none.
+func (s *State) compiler(name string) Compiler {
+	for i, c := range s.compilers {
+		if s.names[i] == name {
+			return c
+		}
+	}
+	return nil
+}
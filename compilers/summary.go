@@ -0,0 +1,56 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/russross/blackfriday"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+const (
+	// moreSeparator can be placed in the markdown content of a post to mark
+	// the end of the summary
+	moreSeparator = "<!--more-->"
+	// wordsPerMinute is the reading speed used to estimate ReadingTime
+	wordsPerMinute = 200
+)
+
+// htmlTagRegexp matches any html tag
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// setSummary sets the Summary, WordCount, and ReadingTime fields for the post.
+// It should be called after the frontmatter has been decoded and p.Content
+// has been set. markdown is the raw markdown content of the post, and
+// summaryLength is the number of words in a summary which is taken from the
+// content (i.e. config.SummaryLength).
+func (p *Post) setSummary(markdown string, summaryLength int) {
+	words := strings.Fields(plainText(string(p.Content)))
+	p.WordCount = len(words)
+	p.ReadingTime = (p.WordCount + wordsPerMinute - 1) / wordsPerMinute
+
+	if p.Summary != "" {
+		// The summary was set in the frontmatter and may contain markdown
+		p.Summary = template.HTML(blackfriday.MarkdownCommon([]byte(p.Summary)))
+	} else if i := strings.Index(markdown, moreSeparator); i != -1 {
+		// Everything before the separator is the summary
+		p.Summary = template.HTML(blackfriday.MarkdownCommon([]byte(markdown[:i])))
+	} else if len(words) > 0 {
+		// Use the first few words of the content
+		text := strings.Join(words, " ")
+		if len(words) > summaryLength {
+			text = strings.Join(words[:summaryLength], " ") + "…"
+		}
+		p.Summary = template.HTML("<p>" + html.EscapeString(text) + "</p>\n")
+	}
+}
+
+// plainText converts some html into plain text by removing any tags and
+// unescaping any entities.
+func plainText(htmlContent string) string {
+	return html.UnescapeString(htmlTagRegexp.ReplaceAllString(htmlContent, ""))
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/russross/blackfriday"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestPostSummary(t *testing.T) {
+	c := config.Default()
+	c.SummaryLength = 3
+	testCases := []struct {
+		frontMatterSummary template.HTML
+		markdown           string
+		expectedSummary    template.HTML
+		expectedWordCount  int
+	}{
+		{
+			markdown:          "One two *three* four & five.\n",
+			expectedSummary:   "<p>One two three…</p>\n",
+			expectedWordCount: 6,
+		},
+		{
+			markdown:          "One & two.\n",
+			expectedSummary:   "<p>One &amp; two.</p>\n",
+			expectedWordCount: 3,
+		},
+		{
+			markdown:          "The *intro*.\n\n<!--more-->\n\nThe rest of the post.\n",
+			expectedSummary:   "<p>The <em>intro</em>.</p>\n",
+			expectedWordCount: 7,
+		},
+		{
+			frontMatterSummary: "A **custom** summary",
+			markdown:           "The intro.\n\n<!--more-->\n\nThe rest.\n",
+			expectedSummary:    "<p>A <strong>custom</strong> summary</p>\n",
+			expectedWordCount:  4,
+		},
+		{
+			markdown:          "",
+			expectedSummary:   "",
+			expectedWordCount: 0,
+		},
+	}
+	for _, tc := range testCases {
+		post := &Post{
+			Summary: tc.frontMatterSummary,
+			Content: template.HTML(blackfriday.MarkdownCommon([]byte(tc.markdown))),
+		}
+		post.setSummary(tc.markdown, c.SummaryLength)
+		if post.Summary != tc.expectedSummary {
+			t.Errorf("Summary for %q was incorrect.\nExpected: %q\nGot: %q", tc.markdown, tc.expectedSummary, post.Summary)
+		}
+		if post.WordCount != tc.expectedWordCount {
+			t.Errorf("WordCount for %q was incorrect. Expected %d but got %d", tc.markdown, tc.expectedWordCount, post.WordCount)
+		}
+	}
+}
+
+func TestPostReadingTime(t *testing.T) {
+	testCases := map[int]int{
+		0:   0,
+		1:   1,
+		200: 1,
+		201: 2,
+		950: 5,
+	}
+	for words, expected := range testCases {
+		markdown := strings.Repeat("word ", words)
+		post := &Post{Content: template.HTML(blackfriday.MarkdownCommon([]byte(markdown)))}
+		post.setSummary(markdown, config.Default().SummaryLength)
+		if post.ReadingTime != expected {
+			t.Errorf("ReadingTime for %d words was incorrect. Expected %d but got %d", words, expected, post.ReadingTime)
+		}
+	}
+}
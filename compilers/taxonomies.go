@@ -0,0 +1,227 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/context"
+	"github.com/albrow/scribble/log"
+	"github.com/albrow/scribble/util"
+	"html/template"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Term is a single tag or category, along with all the posts which
+// belong to it.
+type Term struct {
+	// the name of the term as it was first written in the frontmatter of a post
+	Name string
+	// the name of the term converted to a form suitable for urls
+	Slug string
+	// the url for the term's page, not including protocol or domain name
+	Url template.URL
+	// the posts which belong to the term, sorted by date
+	Posts []*Post
+}
+
+// taxonomy describes a way of grouping posts, e.g. by tags or by categories.
+type taxonomy struct {
+	// the name of the directory in config.DestDir where pages will be created,
+	// which is also used in the url for each term
+	dirName string
+	// the key used to identify a single term and the list of all terms in the
+	// context passed to layouts
+	termKey, termsKey string
+	// the layout used to render the page for a single term, and the layout
+	// used to render the overview page for all terms
+	termLayout, termsLayout string
+	// terms returns the names of the terms that post belongs to
+	terms func(post *Post) []string
+}
+
+// taxonomies returns all the known taxonomies, configured according to
+// the config for the site.
+func (s *State) taxonomies() []taxonomy {
+	return []taxonomy{
+		{
+			dirName:     "tags",
+			termKey:     "Tag",
+			termsKey:    "Tags",
+			termLayout:  s.config.TagLayout,
+			termsLayout: s.config.TagsLayout,
+			terms:       func(post *Post) []string { return post.Tags },
+		},
+		{
+			dirName:     "categories",
+			termKey:     "Category",
+			termsKey:    "Categories",
+			termLayout:  s.config.CategoryLayout,
+			termsLayout: s.config.CategoriesLayout,
+			terms:       func(post *Post) []string { return post.Categories },
+		},
+	}
+}
+
+// Tags returns all the tags used by any post, sorted by name.
+func (s *State) Tags() []*Term {
+	return s.taxonomies()[0].allTerms(s.Posts())
+}
+
+// Categories returns all the categories used by any post, sorted by name.
+func (s *State) Categories() []*Term {
+	return s.taxonomies()[1].allTerms(s.Posts())
+}
+
+// PostsWithTag returns all the posts which have the given tag, sorted by date.
+// Tags are compared by their slugs, so e.g. "Go" and "go" are the same tag.
+func (s *State) PostsWithTag(tag string) []*Post {
+	return s.taxonomies()[0].postsForTerm(s.Posts(), tag)
+}
+
+// PostsInCategory returns all the posts which are in the given category, sorted
+// by date. Categories are compared by their slugs, so e.g. "Web Development" and
+// "web-development" are the same category.
+func (s *State) PostsInCategory(category string) []*Post {
+	return s.taxonomies()[1].postsForTerm(s.Posts(), category)
+}
+
+// allTerms returns all the terms for the taxonomy that posts belong to,
+// sorted by name.
+func (t taxonomy) allTerms(posts []*Post) []*Term {
+	terms := []*Term{}
+	termsBySlug := map[string]*Term{}
+	for _, post := range posts {
+		for _, name := range t.terms(post) {
+			slug := util.Slugify(name)
+			if slug == "" {
+				continue
+			}
+			term, found := termsBySlug[slug]
+			if !found {
+				term = &Term{
+					Name: name,
+					Slug: slug,
+					Url:  template.URL("/" + t.dirName + "/" + slug),
+				}
+				termsBySlug[slug] = term
+				terms = append(terms, term)
+			}
+			if len(term.Posts) == 0 || term.Posts[len(term.Posts)-1] != post {
+				// Only add the post once, even if it lists the same term twice
+				term.Posts = append(term.Posts, post)
+			}
+		}
+	}
+	sort.Sort(TermsByName(terms))
+	return terms
+}
+
+// postsForTerm returns the posts out of posts which belong to the term
+// identified by name.
+func (t taxonomy) postsForTerm(posts []*Post, name string) []*Post {
+	slug := util.Slugify(name)
+	for _, term := range t.allTerms(posts) {
+		if term.Slug == slug {
+			return term.Posts
+		}
+	}
+	return []*Post{}
+}
+
+// compileTaxonomies renders a page for every term in each taxonomy, as well
+// as an overview page for each taxonomy, using the layouts set in config.
+// Pages are not rendered for any taxonomy which does not have a layout.
+// The created pages are recorded in the dependency graph under taxonomiesNode.
+func (p *PostsCompilerType) compileTaxonomies() error {
+	s := p.state
+	deps := []string{}
+	posts := s.Posts()
+	for _, t := range s.taxonomies() {
+		if t.termLayout == "" && t.termsLayout == "" {
+			continue
+		}
+		for _, layoutName := range []string{t.termLayout, t.termsLayout} {
+			if layoutName == "" {
+				continue
+			}
+			layoutDeps, err := s.layoutDependencies(filepath.Join(s.config.PostLayoutsDir, layoutName))
+			if err != nil {
+				return err
+			}
+			deps = append(deps, layoutDeps...)
+		}
+		terms := t.allTerms(posts)
+		if t.termLayout != "" {
+			for _, term := range terms {
+				destPath := filepath.Join(s.destDir, t.dirName, term.Slug)
+				termContext := s.config.Context.Copy()
+				termContext[t.termKey] = term
+				termContext["Posts"] = term.Posts
+				if err := s.renderPostLayout(t.termLayout, termContext, filepath.Join(destPath, "index.html")); err != nil {
+					return err
+				}
+				s.addSitemapEntry(filepath.Join(destPath, "index.html"), latestDate(term.Posts), false)
+				p.createdDirs = append(p.createdDirs, destPath)
+				s.depGraph.addOutput(taxonomiesNode, filepath.Join(destPath, "index.html"))
+			}
+		}
+		if t.termsLayout != "" {
+			destPath := filepath.Join(s.destDir, t.dirName, "index.html")
+			termsContext := s.config.Context.Copy()
+			termsContext[t.termsKey] = terms
+			if err := s.renderPostLayout(t.termsLayout, termsContext, destPath); err != nil {
+				return err
+			}
+			s.addSitemapEntry(destPath, latestDate(posts), false)
+			p.createdFiles = append(p.createdFiles, destPath)
+			s.depGraph.addOutput(taxonomiesNode, destPath)
+		}
+	}
+	s.depGraph.setDependencies(taxonomiesNode, deps)
+	return nil
+}
+
+// latestDate returns the date of the most recent post in posts, or the zero
+// time if there are no posts.
+func latestDate(posts []*Post) time.Time {
+	latest := time.Time{}
+	for _, post := range posts {
+		if post.Date.After(latest) {
+			latest = post.Date
+		}
+	}
+	return latest
+}
+
+// renderPostLayout renders the post layout identified by layoutName with the
+// given context, using the appropriate PostLayoutCompiler.
+func (s *State) renderPostLayout(layoutName string, layoutContext context.Context, destPath string) error {
+	c, err := s.findPostLayoutCompiler(layoutName)
+	if err != nil {
+		return err
+	} else if c == nil {
+		return fmt.Errorf("Could not find post layout compiler for layout named %s", layoutName)
+	}
+	layoutPath := filepath.Join(s.config.PostLayoutsDir, layoutName)
+	log.Success.Printf("CREATE: %s -> %s", layoutPath, destPath)
+	return c.RenderPostLayout(layoutPath, layoutContext, destPath)
+}
+
+// The TermsByName type is used only for sorting
+type TermsByName []*Term
+
+func (t TermsByName) Len() int {
+	return len(t)
+}
+
+func (t TermsByName) Less(i, j int) bool {
+	return t[i].Slug < t[j].Slug
+}
+
+func (t TermsByName) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+}
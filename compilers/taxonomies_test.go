@@ -0,0 +1,84 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package compilers
+
+import (
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/test_util"
+	"github.com/albrow/scribble/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTaxonomies(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_taxonomies")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Copy some files from test_files to source directory in the temp root
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "taxonomies")
+	srcDir := filepath.Join(root, "source")
+	destDir := filepath.Join(root, "public")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attempt to compile the posts and taxonomy pages
+	c := config.Default()
+	c.SourceDir = filepath.Join(root, "source")
+	c.PostsDir = filepath.Join(c.SourceDir, "_posts")
+	c.LayoutsDir = filepath.Join(c.SourceDir, "_layouts")
+	c.PostLayoutsDir = filepath.Join(c.SourceDir, "_post_layouts")
+	c.DestDir = filepath.Join(root, "public")
+	c.TagLayout = "tag.tmpl"
+	c.TagsLayout = "tags.tmpl"
+	c.CategoryLayout = "category.tmpl"
+	s := NewState(c)
+	postsCompiler := s.compiler("posts").(*PostsCompilerType)
+	postsCompiler.Init()
+	srcPaths, err := s.FindPaths(postsCompiler.CompileMatchFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := postsCompiler.CompileAll(srcPaths); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the helper functions return the correct results
+	tags := s.Tags()
+	if len(tags) != 2 {
+		t.Fatalf("Expected 2 tags but got %d", len(tags))
+	}
+	if tags[0].Name != "Go" || tags[0].Slug != "go" || len(tags[0].Posts) != 2 {
+		t.Errorf("First tag was incorrect. Got %+v", tags[0])
+	}
+	if got := len(s.PostsWithTag("GO")); got != 2 {
+		t.Errorf("Expected 2 posts with tag GO but got %d", got)
+	}
+	if got := len(s.PostsWithTag("missing")); got != 0 {
+		t.Errorf("Expected 0 posts with tag missing but got %d", got)
+	}
+
+	// Make sure the compiled results are correct
+	expectedDir := filepath.Join(testFilesDir, "public")
+	expectedFiles := []string{
+		filepath.Join("tags", "index.html"),
+		filepath.Join("tags", "go", "index.html"),
+		filepath.Join("categories", "web-development", "index.html"),
+	}
+	for _, file := range expectedFiles {
+		test_util.CheckFilesMatch(t, filepath.Join(expectedDir, file), filepath.Join(destDir, file))
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "categories", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected categories overview to not be created without a categoriesLayout")
+	}
+}
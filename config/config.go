@@ -9,31 +9,314 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/albrow/scribble/context"
 	"github.com/albrow/scribble/log"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 )
 
-// a list of config vars
-var (
+// Collection is the configuration for a named collection of markdown files
+// which are compiled the same way as posts, e.g. projects or talks. Posts
+// themselves are the built-in collection named "posts".
+type Collection struct {
+	// Dir is the directory where the markdown files for the collection live
+	Dir string
+	// LayoutDir is the directory where the layouts for the collection live.
+	// If it is empty, PostLayoutsDir is used instead.
+	LayoutDir string
+	// Permalink is the pattern used to determine the url for each item
+	// in the collection. If it is empty, "/<name>/:slug" is used.
+	Permalink string
+}
+
+// ExternalCompiler is the configuration for an external command which is used
+// to compile all the files that match some pattern, e.g. the typescript
+// compiler for .ts files.
+type ExternalCompiler struct {
+	// Match is a pattern which is matched against the name of each file, e.g.
+	// "*.ts". See path/filepath.Match for the syntax.
+	Match string
+	// Command is the name of the command followed by its arguments. The
+	// placeholders {src} and {dest} in any argument are replaced with the
+	// path of the file to compile and the path where the result should go.
+	Command []string
+	// OutExt is the extension of the compiled files, e.g. ".js". If it is
+	// empty, compiled files have the same extension as the source files.
+	OutExt string
+}
+
+// SassConfig is the configuration for compiling sass files
+type SassConfig struct {
+	// Compiler is the backend which is used to compile sass files. It may be
+	// "native" (the default) to use the built-in compiler or "sassc" to run
+	// the sassc command, which must be installed.
+	Compiler string
+	// OutputStyle is the style of the resulting css. It may be "nested" (the
+	// default), "expanded", "compact", or "compressed".
+	OutputStyle string
+	// LoadPaths are additional directories where imports are looked for, e.g.
+	// a directory of vendored sass libraries. They are relative to SourceDir.
+	LoadPaths []string
+	// Precision is the number of digits after the decimal point for numbers
+	// in the resulting css. It defaults to 5.
+	Precision int
+	// SourceMap determines whether or not a source map is generated for each
+	// css file. The source map is written next to the css file with an
+	// added .map extension, and the css file links to it.
+	SourceMap bool
+}
+
+// ServeConfig is the configuration for serving the site, which makes it
+// possible to serve it the same way it is served in production
+type ServeConfig struct {
+	// Host is the address of the interface the server listens on, e.g.
+	// "127.0.0.1". If it is empty, the server listens on all interfaces.
+	Host string
+	// BasePath is the url path the site is served under, e.g. "/blog" for a
+	// site which is deployed to a subdirectory. It always starts with a / and
+	// never ends with one. If it is empty, the site is served at the root.
+	BasePath string
+	// Gzip determines whether or not responses are compressed for browsers
+	// which support it.
+	Gzip bool
+	// Log determines whether or not each request is logged.
+	Log bool
+	// Headers are the rules for adding headers to responses, ordered from
+	// the least specific pattern to the most specific one.
+	Headers []HeaderRule
+}
+
+// HeaderRule is a set of headers which are added to the response for every
+// url path that matches a pattern, e.g. a Cache-Control header for
+// everything in /images/.
+type HeaderRule struct {
+	// Match is the pattern which is matched against the url path. A * matches
+	// any number of characters, including slashes, e.g. "/images/*".
+	Match string
+	// Headers maps the name of each header to its value
+	Headers map[string]string
+}
+
+// Matches returns true iff urlPath matches the pattern for h.
+func (h HeaderRule) Matches(urlPath string) bool {
+	return globMatch(h.Match, urlPath)
+}
+
+// globMatch returns true iff s matches pattern, where a * in pattern matches
+// any number of characters and everything else matches itself.
+func globMatch(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i == -1 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// Config holds a value for each of the config variables, along with the
+// full contents of config.toml. Each site has its own Config, which makes it
+// possible to keep several sites around at once, e.g. when embedding scribble
+// in another program.
+type Config struct {
 	SourceDir, DestDir, PostsDir, LayoutsDir, PostLayoutsDir, IncludesDir string
-)
 
-// Parse reads and parses config.toml, setting the values
-// of the config variables here and in the context. It panics
-// if there was a problem reading the file.
-func Parse() {
-	log.Default.Println("Parsing config.toml...")
-	if _, err := toml.DecodeFile("config.toml", context.GetContext()); err != nil {
-		msg := fmt.Sprintf("Problem reading config.toml file:\n%s", err)
-		panic(msg)
+	// layouts in PostLayoutsDir used to render taxonomy pages. The singular
+	// layouts render the page for a single tag or category, and the plural
+	// layouts render an overview of all tags or categories. Pages are only
+	// generated for the layouts which are set.
+	TagLayout, TagsLayout, CategoryLayout, CategoriesLayout string
+
+	// BaseURL is the protocol and domain name where the site is hosted,
+	// e.g. "http://example.com". It is used wherever absolute urls are
+	// required, e.g. in feeds.
+	BaseURL string
+	// Feeds determines whether or not rss and atom feeds are generated
+	// for posts. FeedContent may be "full" to include the full content of
+	// each post in the feeds or "summary" to include only a summary, and
+	// FeedLimit is the maximum number of posts in the feeds (0 means no limit).
+	Feeds       bool
+	FeedContent string
+	FeedLimit   int
+
+	// Sitemap and Robots determine whether or not sitemap.xml and robots.txt
+	// are generated. Both require BaseURL.
+	Sitemap, Robots bool
+	// Drafts and Future determine whether or not draft posts and posts
+	// with a date in the future are published. They are typically set
+	// with command line flags.
+	Drafts, Future bool
+
+	// Permalink is the pattern used to determine the url for each post,
+	// e.g. "/:year/:month/:slug/". See compilers/permalinks.go for the
+	// supported placeholders.
+	Permalink string
+	// Paginate is the number of posts on each page of the index page. If
+	// it is greater than 0, the index page is rendered once for each page
+	// of posts, and every page but the first is written to a directory
+	// inside of PaginatePath (which defaults to "page"), e.g. /page/2/.
+	// PaginatePath must be a relative path inside of DestDir.
+	Paginate     int
+	PaginatePath string
+	// SummaryLength is the number of words in the summary of a post, for
+	// posts which don't have a summary or a <!--more--> marker.
+	SummaryLength int
+	// Collections holds the configuration for each collection, keyed by
+	// the name of the collection. Collections are declared in config.toml
+	// with a [collections.<name>] table.
+	Collections map[string]Collection
+	// Jobs is the maximum number of files which are compiled at once. It
+	// defaults to the number of CPUs and is typically set with the --jobs
+	// command line flag.
+	Jobs int
+
+	// ExternalCompilers holds the configuration for each external command
+	// which is used to compile certain files. External compilers are declared
+	// in config.toml with a [[compilers.external]] table.
+	ExternalCompilers []ExternalCompiler
+	// Sass holds the configuration for compiling sass files, which is set in
+	// config.toml with a [sass] table.
+	Sass SassConfig
+	// Serve holds the configuration for serving the site with scribble serve,
+	// which is set in config.toml with a [serve] table.
+	Serve ServeConfig
+
+	// Context holds everything in config.toml, which is passed through to
+	// templates when rendering.
+	Context context.Context
+}
+
+// Default returns a Config with the default value for each variable.
+func Default() Config {
+	return Config{
+		FeedContent:   "full",
+		SummaryLength: 70,
+		Collections:   map[string]Collection{},
+		Jobs:          runtime.NumCPU(),
+		Context:       context.Context{},
+		Sass: SassConfig{
+			Compiler:    "native",
+			OutputStyle: "nested",
+			LoadPaths:   []string{},
+			Precision:   5,
+		},
+		Serve: ServeConfig{
+			Headers: []HeaderRule{},
+		},
+	}
+}
+
+// Load reads and parses the config file at path and returns the result.
+// Any variables which are not in the file keep their default values.
+// Relative directories in the file are relative to the directory that
+// contains it.
+func Load(path string) (Config, error) {
+	log.Default.Printf("Parsing %s...", filepath.Base(path))
+	c := Default()
+	if _, err := toml.DecodeFile(path, &c.Context); err != nil {
+		return c, fmt.Errorf("Problem reading %s file:\n%s", filepath.Base(path), err)
 	}
 	vars := map[string]*string{
-		"sourceDir":      &SourceDir,
-		"destDir":        &DestDir,
-		"layoutsDir":     &LayoutsDir,
-		"postsDir":       &PostsDir,
-		"postLayoutsDir": &PostLayoutsDir,
-		"includesDir":    &IncludesDir,
+		"sourceDir":      &c.SourceDir,
+		"destDir":        &c.DestDir,
+		"layoutsDir":     &c.LayoutsDir,
+		"postsDir":       &c.PostsDir,
+		"postLayoutsDir": &c.PostLayoutsDir,
+		"includesDir":    &c.IncludesDir,
+		// taxonomy layouts
+		"tagLayout":        &c.TagLayout,
+		"tagsLayout":       &c.TagsLayout,
+		"categoryLayout":   &c.CategoryLayout,
+		"categoriesLayout": &c.CategoriesLayout,
+		// feeds
+		"baseURL":     &c.BaseURL,
+		"feedContent": &c.FeedContent,
+		// permalinks
+		"permalink": &c.Permalink,
+		// pagination
+		"paginatePath": &c.PaginatePath,
+	}
+	setConfig(vars, c.Context)
+	if c.FeedContent != "full" && c.FeedContent != "summary" {
+		return c, fmt.Errorf("Problem reading config.toml file:\nfeedContent should be \"full\" or \"summary\" but was %q", c.FeedContent)
+	}
+	if !validPaginatePath(c.PaginatePath) {
+		return c, fmt.Errorf("Problem reading config.toml file:\npaginatePath should be a relative path without \"..\" but was %q", c.PaginatePath)
+	}
+	root := filepath.Dir(path)
+	for _, dir := range []*string{&c.SourceDir, &c.DestDir, &c.PostsDir, &c.LayoutsDir, &c.PostLayoutsDir, &c.IncludesDir} {
+		if *dir != "" && !filepath.IsAbs(*dir) {
+			*dir = filepath.Join(root, *dir)
+		}
+	}
+	boolVars := map[string]*bool{
+		"feeds":   &c.Feeds,
+		"sitemap": &c.Sitemap,
+		"robots":  &c.Robots,
+		"drafts":  &c.Drafts,
+		"future":  &c.Future,
+	}
+	if err := setBoolConfig(boolVars, c.Context); err != nil {
+		return c, err
+	}
+	intVars := map[string]*int{
+		"feedLimit":     &c.FeedLimit,
+		"paginate":      &c.Paginate,
+		"summaryLength": &c.SummaryLength,
+		"jobs":          &c.Jobs,
+	}
+	if err := setIntConfig(intVars, c.Context); err != nil {
+		return c, err
+	}
+	if c.SummaryLength < 1 {
+		return c, fmt.Errorf("Problem reading config.toml file:\nsummaryLength should be at least 1 but was %d", c.SummaryLength)
+	}
+	collections, err := collectionsConfig(c.Context, c.SourceDir)
+	if err != nil {
+		return c, err
+	}
+	c.Collections = collections
+	externalCompilers, err := externalCompilersConfig(c.Context)
+	if err != nil {
+		return c, err
+	}
+	c.ExternalCompilers = externalCompilers
+	sass, err := sassConfig(c.Context, c.Sass, c.SourceDir)
+	if err != nil {
+		return c, err
 	}
-	setConfig(vars, context.GetContext())
+	c.Sass = sass
+	serve, err := serveConfig(c.Context, c.Serve)
+	if err != nil {
+		return c, err
+	}
+	c.Serve = serve
+	return c, nil
+}
+
+// validPaginatePath returns true iff paginatePath is a relative path which
+// does not refer to a parent directory with "..", so that the pages in it
+// can only be written inside of destDir.
+func validPaginatePath(paginatePath string) bool {
+	if path.IsAbs(paginatePath) || filepath.IsAbs(paginatePath) {
+		return false
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(paginatePath), "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
 }
 
 // setConfig sets the values of vars based on the contents of data
@@ -44,3 +327,248 @@ func setConfig(vars map[string]*string, data map[string]interface{}) {
 		}
 	}
 }
+
+// setBoolConfig sets the values of vars based on the contents of data. It
+// returns an error if any value in data is not a bool.
+func setBoolConfig(vars map[string]*bool, data map[string]interface{}) error {
+	for name, holder := range vars {
+		if value, found := data[name]; found {
+			if b, ok := value.(bool); !ok {
+				return fmt.Errorf("Problem reading config.toml file:\n%s should be true or false but was %v", name, value)
+			} else {
+				(*holder) = b
+			}
+		}
+	}
+	return nil
+}
+
+// setIntConfig sets the values of vars based on the contents of data. It
+// returns an error if any value in data is not an integer.
+func setIntConfig(vars map[string]*int, data map[string]interface{}) error {
+	for name, holder := range vars {
+		if value, found := data[name]; found {
+			if i, ok := value.(int64); !ok {
+				return fmt.Errorf("Problem reading config.toml file:\n%s should be an integer but was %v", name, value)
+			} else {
+				(*holder) = int(i)
+			}
+		}
+	}
+	return nil
+}
+
+// collectionsConfig returns the collections in the collections table in
+// data. The dir and layoutDir of each collection are relative to sourceDir.
+// It returns an error if the table is not formatted correctly.
+func collectionsConfig(data map[string]interface{}, sourceDir string) (map[string]Collection, error) {
+	collections := map[string]Collection{}
+	value, found := data["collections"]
+	if !found {
+		return collections, nil
+	}
+	tables, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Problem reading config.toml file:\ncollections should be a table but was %v", value)
+	}
+	for name, value := range tables {
+		if name == "posts" {
+			return nil, fmt.Errorf("Problem reading config.toml file:\nposts is a built-in collection and cannot be declared in collections. Use postsDir instead.")
+		}
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Problem reading config.toml file:\ncollections.%s should be a table but was %v", name, value)
+		}
+		var dir, layoutDir, permalink string
+		setConfig(map[string]*string{
+			"dir":       &dir,
+			"layoutDir": &layoutDir,
+			"permalink": &permalink,
+		}, table)
+		if dir == "" {
+			return nil, fmt.Errorf("Problem reading config.toml file:\ncollections.%s is missing required key: dir", name)
+		}
+		collection := Collection{
+			Dir:       filepath.Join(sourceDir, dir),
+			Permalink: permalink,
+		}
+		if layoutDir != "" {
+			collection.LayoutDir = filepath.Join(sourceDir, layoutDir)
+		}
+		collections[name] = collection
+	}
+	return collections, nil
+}
+
+// externalCompilersConfig returns the external compilers in the
+// compilers.external array of tables in data. It returns an error if the
+// array is not formatted correctly.
+func externalCompilersConfig(data map[string]interface{}) ([]ExternalCompiler, error) {
+	externalCompilers := []ExternalCompiler{}
+	value, found := data["compilers"]
+	if !found {
+		return externalCompilers, nil
+	}
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Problem reading config.toml file:\ncompilers should be a table but was %v", value)
+	}
+	value, found = table["external"]
+	if !found {
+		return externalCompilers, nil
+	}
+	tables, ok := value.([]map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Problem reading config.toml file:\ncompilers.external should be an array of tables but was %v", value)
+	}
+	for i, table := range tables {
+		var externalCompiler ExternalCompiler
+		setConfig(map[string]*string{
+			"match":  &externalCompiler.Match,
+			"outExt": &externalCompiler.OutExt,
+		}, table)
+		if externalCompiler.Match == "" {
+			return nil, fmt.Errorf("Problem reading config.toml file:\ncompilers.external[%d] is missing required key: match", i)
+		}
+		if _, err := filepath.Match(externalCompiler.Match, ""); err != nil {
+			return nil, fmt.Errorf("Problem reading config.toml file:\ncompilers.external[%d] has an invalid match pattern %q: %s", i, externalCompiler.Match, err)
+		}
+		command, ok := table["command"].([]interface{})
+		if !ok || len(command) == 0 {
+			return nil, fmt.Errorf("Problem reading config.toml file:\ncompilers.external[%d] should have a command which is a non-empty array of strings", i)
+		}
+		for _, arg := range command {
+			if s, ok := arg.(string); !ok {
+				return nil, fmt.Errorf("Problem reading config.toml file:\ncompilers.external[%d] should have a command which is a non-empty array of strings but had %v", i, arg)
+			} else {
+				externalCompiler.Command = append(externalCompiler.Command, s)
+			}
+		}
+		externalCompilers = append(externalCompilers, externalCompiler)
+	}
+	return externalCompilers, nil
+}
+
+// sassConfig returns the sass configuration in the sass table in data,
+// starting from the defaults in sass. Load paths are relative to sourceDir.
+// It returns an error if the table is not formatted correctly.
+func sassConfig(data map[string]interface{}, sass SassConfig, sourceDir string) (SassConfig, error) {
+	value, found := data["sass"]
+	if !found {
+		return sass, nil
+	}
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return sass, fmt.Errorf("Problem reading config.toml file:\nsass should be a table but was %v", value)
+	}
+	setConfig(map[string]*string{
+		"compiler":    &sass.Compiler,
+		"outputStyle": &sass.OutputStyle,
+	}, table)
+	if sass.Compiler != "native" && sass.Compiler != "sassc" {
+		return sass, fmt.Errorf("Problem reading config.toml file:\nsass.compiler should be \"native\" or \"sassc\" but was %q", sass.Compiler)
+	}
+	switch sass.OutputStyle {
+	case "nested", "expanded", "compact", "compressed":
+	default:
+		return sass, fmt.Errorf("Problem reading config.toml file:\nsass.outputStyle should be \"nested\", \"expanded\", \"compact\", or \"compressed\" but was %q", sass.OutputStyle)
+	}
+	if err := setIntConfig(map[string]*int{"precision": &sass.Precision}, table); err != nil {
+		return sass, err
+	}
+	if sass.Precision < 1 {
+		return sass, fmt.Errorf("Problem reading config.toml file:\nsass.precision should be at least 1 but was %d", sass.Precision)
+	}
+	if err := setBoolConfig(map[string]*bool{"sourceMap": &sass.SourceMap}, table); err != nil {
+		return sass, err
+	}
+	if value, found := table["loadPaths"]; found {
+		loadPaths, ok := value.([]interface{})
+		if !ok {
+			return sass, fmt.Errorf("Problem reading config.toml file:\nsass.loadPaths should be an array of strings but was %v", value)
+		}
+		sass.LoadPaths = []string{}
+		for _, loadPath := range loadPaths {
+			dir, ok := loadPath.(string)
+			if !ok {
+				return sass, fmt.Errorf("Problem reading config.toml file:\nsass.loadPaths should be an array of strings but had %v", loadPath)
+			}
+			sass.LoadPaths = append(sass.LoadPaths, filepath.Join(sourceDir, dir))
+		}
+	}
+	return sass, nil
+}
+
+// serveConfig returns the config for serving the site from the serve table
+// in data. Any keys which are not in the table keep their values in serve. It
+// returns an error if the table is not formatted correctly.
+func serveConfig(data map[string]interface{}, serve ServeConfig) (ServeConfig, error) {
+	value, found := data["serve"]
+	if !found {
+		return serve, nil
+	}
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return serve, fmt.Errorf("Problem reading config.toml file:\nserve should be a table but was %v", value)
+	}
+	setConfig(map[string]*string{
+		"host":     &serve.Host,
+		"basePath": &serve.BasePath,
+	}, table)
+	serve.BasePath = CleanBasePath(serve.BasePath)
+	if err := setBoolConfig(map[string]*bool{
+		"gzip": &serve.Gzip,
+		"log":  &serve.Log,
+	}, table); err != nil {
+		return serve, err
+	}
+	value, found = table["headers"]
+	if !found {
+		return serve, nil
+	}
+	rules, ok := value.(map[string]interface{})
+	if !ok {
+		return serve, fmt.Errorf("Problem reading config.toml file:\nserve.headers should be a table but was %v", value)
+	}
+	serve.Headers = []HeaderRule{}
+	for match, value := range rules {
+		if !strings.HasPrefix(match, "/") {
+			return serve, fmt.Errorf("Problem reading config.toml file:\nThe patterns in serve.headers should start with a / but got %q", match)
+		}
+		headers, ok := value.(map[string]interface{})
+		if !ok {
+			return serve, fmt.Errorf("Problem reading config.toml file:\nserve.headers.%q should be a table but was %v", match, value)
+		}
+		rule := HeaderRule{Match: match, Headers: map[string]string{}}
+		for name, value := range headers {
+			headerValue, ok := value.(string)
+			if !ok {
+				return serve, fmt.Errorf("Problem reading config.toml file:\nThe value of %s in serve.headers.%q should be a string but was %v", name, match, value)
+			}
+			rule.Headers[name] = headerValue
+		}
+		serve.Headers = append(serve.Headers, rule)
+	}
+	// Apply the most specific rules last, so that they take precedence. The
+	// order of the keys in the table is lost, so the length of the pattern
+	// stands in for how specific it is.
+	sort.Slice(serve.Headers, func(i, j int) bool {
+		a, b := serve.Headers[i].Match, serve.Headers[j].Match
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return serve, nil
+}
+
+// CleanBasePath returns basePath with a leading / and without a trailing
+// one, e.g. "/blog" for "blog/". It returns an empty string if basePath is
+// the root.
+func CleanBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
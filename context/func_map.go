@@ -11,6 +11,11 @@ import (
 // FuncMap represents a set of functions, identified by some key, which
 // will be availalbe to templates when rendering. Similarly to the context,
 // the FuncMap will be passed through any time an ace template is rendered.
-// See http://golang.org/pkg/text/template/#FuncMap. There is one func provided
-// by default, called Posts, which is defined in compilers/posts_compiler.
+// See http://golang.org/pkg/text/template/#FuncMap. Programs which embed
+// scribble may add their own functions to it before building any sites. It
+// does not include the funcs provided by default, which depend on the posts
+// of each site and are added separately for each one. They are Posts, which
+// is defined in compilers/posts_compiler, Collection, which is defined in
+// compilers/collections, and Tags, Categories, PostsWithTag, and
+// PostsInCategory, which are defined in compilers/taxonomies.
 var FuncMap template.FuncMap = map[string]interface{}{}
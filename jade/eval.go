@@ -0,0 +1,586 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package jade
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errorType is the reflect.Type for the error interface
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// scope holds the variables which are defined while rendering, e.g. the
+// variables in an each loop or the arguments for a mixin.
+type scope struct {
+	vars   map[string]interface{}
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: map[string]interface{}{}, parent: parent}
+}
+
+// lookup returns the value of the variable with the given name in s or any
+// of its parents, and whether or not it was found.
+func (s *scope) lookup(name string) (interface{}, bool) {
+	for ; s != nil; s = s.parent {
+		if value, found := s.vars[name]; found {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// eval evaluates e in the current scope and returns the result.
+func (s *state) eval(e expr) (interface{}, error) {
+	switch e := e.(type) {
+	case literalExpr:
+		return e.value, nil
+	case identExpr:
+		if value, found := s.scope.lookup(e.name); found {
+			return value, nil
+		}
+		if f, found := s.funcs[e.name]; found {
+			return f, nil
+		}
+		return nil, nil
+	case memberExpr:
+		object, err := s.eval(e.object)
+		if err != nil {
+			return nil, err
+		}
+		return member(object, e.name)
+	case indexExpr:
+		object, err := s.eval(e.object)
+		if err != nil {
+			return nil, err
+		}
+		index, err := s.eval(e.index)
+		if err != nil {
+			return nil, err
+		}
+		return indexOf(object, index)
+	case callExpr:
+		return s.evalCall(e)
+	case arrayExpr:
+		values := make([]interface{}, len(e.elems))
+		for i, elem := range e.elems {
+			value, err := s.eval(elem)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case unaryExpr:
+		operand, err := s.eval(e.operand)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "!":
+			return !truthy(operand), nil
+		case "-":
+			return -toNumber(operand), nil
+		}
+		return toNumber(operand), nil
+	case binaryExpr:
+		return s.evalBinary(e)
+	case condExpr:
+		cond, err := s.eval(e.cond)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return s.eval(e.then)
+		}
+		return s.eval(e.els)
+	case interpExpr:
+		// The attribute as a whole is escaped when it is rendered, so the
+		// interpolated values are not escaped here
+		str := ""
+		for _, part := range e.parts {
+			if part.value == nil {
+				str += part.literal
+				continue
+			}
+			value, err := s.eval(part.value)
+			if err != nil {
+				return nil, err
+			}
+			str += toString(value)
+		}
+		return str, nil
+	}
+	return nil, fmt.Errorf("unknown expression %v", e)
+}
+
+// evalCall evaluates a function or method call. Functions in the FuncMap take
+// precedence over other values with the same name, so that e.g. Posts(5)
+// calls the Posts function even if Posts is also a key in the data.
+func (s *state) evalCall(e callExpr) (interface{}, error) {
+	var fn reflect.Value
+	switch callee := e.callee.(type) {
+	case identExpr:
+		value, found := s.scope.lookup(callee.name)
+		if f, isFunc := s.funcs[callee.name]; isFunc && (!found || s.scope.isRoot(callee.name)) {
+			value = f
+		}
+		fn = reflect.ValueOf(value)
+	case memberExpr:
+		object, err := s.eval(callee.object)
+		if err != nil {
+			return nil, err
+		}
+		fn = method(object, callee.name)
+		if !fn.IsValid() {
+			value, err := member(object, callee.name)
+			if err != nil {
+				return nil, err
+			}
+			fn = reflect.ValueOf(value)
+		}
+	default:
+		value, err := s.eval(e.callee)
+		if err != nil {
+			return nil, err
+		}
+		fn = reflect.ValueOf(value)
+	}
+	if !fn.IsValid() || fn.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not a function", exprString(e.callee))
+	}
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		value, err := s.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	result, err := call(fn, args)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %s", exprString(e.callee), err)
+	}
+	return result, nil
+}
+
+// isRoot returns true iff the variable with the given name is only defined
+// in the outermost scope, i.e. in the data passed to the template.
+func (s *scope) isRoot(name string) bool {
+	for ; s.parent != nil; s = s.parent {
+		if _, found := s.vars[name]; found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *state) evalBinary(e binaryExpr) (interface{}, error) {
+	left, err := s.eval(e.left)
+	if err != nil {
+		return nil, err
+	}
+	// && and || short-circuit and return one of their operands, like they
+	// do in javascript
+	switch e.op {
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return s.eval(e.right)
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return s.eval(e.right)
+	}
+	right, err := s.eval(e.right)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "+":
+		if isString(left) || isString(right) {
+			return toString(left) + toString(right), nil
+		}
+		return toNumber(left) + toNumber(right), nil
+	case "-":
+		return toNumber(left) - toNumber(right), nil
+	case "*":
+		return toNumber(left) * toNumber(right), nil
+	case "/":
+		return toNumber(left) / toNumber(right), nil
+	case "%":
+		return math.Mod(toNumber(left), toNumber(right)), nil
+	case "==", "===":
+		return equal(left, right), nil
+	case "!=", "!==":
+		return !equal(left, right), nil
+	}
+	// The remaining operators are comparisons, which compare strings
+	// alphabetically and everything else as numbers
+	var cmp int
+	if isString(left) && isString(right) {
+		cmp = strings.Compare(toString(left), toString(right))
+	} else if l, r := toNumber(left), toNumber(right); l < r {
+		cmp = -1
+	} else if l > r {
+		cmp = 1
+	} else if l != r {
+		// at least one of them is NaN, so every comparison is false
+		return false, nil
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0, nil
+	case ">":
+		return cmp > 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// indirect follows pointers and interfaces until it reaches a value which is
+// neither, or a nil pointer or interface.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// member returns the field, map value, or result of calling the niladic method
+// with the given name on object. As a special case, length is the length of a
+// slice, array, map, or string. If there is no such member, it returns nil.
+func member(object interface{}, name string) (interface{}, error) {
+	if object == nil {
+		return nil, nil
+	}
+	v := indirect(reflect.ValueOf(object))
+	switch v.Kind() {
+	case reflect.Struct:
+		if field := v.FieldByName(name); field.IsValid() && field.CanInterface() {
+			return field.Interface(), nil
+		}
+	case reflect.Map:
+		if key, ok := convert(reflect.ValueOf(name), v.Type().Key()); ok {
+			if value := v.MapIndex(key); value.IsValid() {
+				return value.Interface(), nil
+			}
+			return nil, nil
+		}
+	}
+	if m := method(object, name); m.IsValid() {
+		if m.Type().NumIn() == 0 {
+			// niladic methods are called automatically, just like they are
+			// in go templates
+			return call(m, nil)
+		}
+		return m.Interface(), nil
+	}
+	if name == "length" {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+			return v.Len(), nil
+		}
+	}
+	return nil, nil
+}
+
+// method returns the method with the given name on object, or the zero
+// Value if there is no such method.
+func method(object interface{}, name string) reflect.Value {
+	if object == nil {
+		return reflect.Value{}
+	}
+	v := reflect.ValueOf(object)
+	if m := v.MethodByName(name); m.IsValid() {
+		return m
+	}
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem().MethodByName(name)
+	}
+	return reflect.Value{}
+}
+
+// indexOf returns the element of a slice, array, or string, the value in a
+// map, or the field of a struct which is identified by index. If there is no
+// such element, it returns nil.
+func indexOf(object interface{}, index interface{}) (interface{}, error) {
+	if object == nil {
+		return nil, nil
+	}
+	v := indirect(reflect.ValueOf(object))
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+		i := toNumber(index)
+		if i != math.Trunc(i) || i < 0 || int(i) >= v.Len() {
+			return nil, nil
+		}
+		if v.Kind() == reflect.String {
+			return string(v.String()[int(i)]), nil
+		}
+		return v.Index(int(i)).Interface(), nil
+	case reflect.Map:
+		if index == nil {
+			return nil, nil
+		}
+		key, ok := convert(reflect.ValueOf(index), v.Type().Key())
+		if !ok {
+			key, ok = convert(reflect.ValueOf(toString(index)), v.Type().Key())
+		}
+		if ok {
+			if value := v.MapIndex(key); value.IsValid() {
+				return value.Interface(), nil
+			}
+		}
+		return nil, nil
+	}
+	return member(object, toString(index))
+}
+
+// convert converts v to type t if it is assignable, or if both of them are
+// numbers or both of them are strings. The second return value is false if
+// v could not be converted.
+func convert(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.Type().AssignableTo(t) {
+		return v, true
+	}
+	if (isNumberKind(v.Kind()) && isNumberKind(t.Kind())) || (v.Kind() == reflect.String && t.Kind() == reflect.String) {
+		return v.Convert(t), true
+	}
+	return reflect.Value{}, false
+}
+
+// call calls fn with args, converting each argument to the type of the
+// corresponding parameter. If fn returns a non-nil error as its last result,
+// call returns that error. If fn panics, e.g. because it is a value method
+// on a nil pointer, the panic is returned as an error.
+func call(fn reflect.Value, args []interface{}) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("panic while calling function: %v", r)
+		}
+	}()
+	t := fn.Type()
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("expected at least %d arguments but got %d", numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("expected %d arguments but got %d", numIn, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			paramType = t.In(numIn - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+		if arg == nil {
+			in[i] = reflect.Zero(paramType)
+			continue
+		}
+		value, ok := convert(reflect.ValueOf(arg), paramType)
+		if !ok {
+			return nil, fmt.Errorf("argument %d should be %s but was %T", i+1, paramType, arg)
+		}
+		in[i] = value
+	}
+	out := fn.Call(in)
+	if len(out) > 0 && t.Out(len(out)-1) == errorType {
+		if err := out[len(out)-1]; !err.IsNil() {
+			return nil, err.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out[0].Interface(), nil
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isString(value interface{}) bool {
+	return value != nil && reflect.ValueOf(value).Kind() == reflect.String
+}
+
+// truthy returns true iff value would be truthy in javascript. nil, false,
+// 0, NaN, the empty string, and nil pointers, slices, and maps are falsy.
+func truthy(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Kind() == reflect.Bool:
+		return v.Bool()
+	case isNumberKind(v.Kind()):
+		f := toNumber(value)
+		return f != 0 && !math.IsNaN(f)
+	case v.Kind() == reflect.String:
+		return v.Len() > 0
+	case v.Kind() == reflect.Ptr, v.Kind() == reflect.Interface, v.Kind() == reflect.Slice, v.Kind() == reflect.Map, v.Kind() == reflect.Func:
+		return !v.IsNil()
+	}
+	return true
+}
+
+// toNumber converts value to a number the way that javascript would.
+func toNumber(value interface{}) float64 {
+	if value == nil {
+		return 0
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.String:
+		s := strings.TrimSpace(v.String())
+		if s == "" {
+			return 0
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+// toString converts value to a string for output. nil is converted to the
+// empty string and times are formatted the same way they are in json.
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	switch value := value.(type) {
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Slice, reflect.Array:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = toString(v.Index(i).Interface())
+		}
+		return strings.Join(elems, ",")
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// equal returns true iff left and right are equal. Numbers are compared as
+// numbers and strings are compared as strings, regardless of their types.
+func equal(left interface{}, right interface{}) bool {
+	if isNil(left) || isNil(right) {
+		return isNil(left) && isNil(right)
+	}
+	l, r := reflect.ValueOf(left), reflect.ValueOf(right)
+	if isNumberKind(l.Kind()) && isNumberKind(r.Kind()) {
+		return toNumber(left) == toNumber(right)
+	}
+	if l.Kind() == reflect.String && r.Kind() == reflect.String {
+		return l.String() == r.String()
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// isNil returns true iff value is nil or a nil pointer, slice, or map.
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// iterate calls f for each element of a slice or array (with its index) or
+// each value in a map (with its key, in sorted order). It returns an error if
+// collection is not a slice, array, or map. nil collections are empty.
+func iterate(collection interface{}, f func(value interface{}, key interface{}) error) error {
+	if collection == nil {
+		return nil
+	}
+	v := indirect(reflect.ValueOf(collection))
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := f(v.Index(i).Interface(), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return toString(keys[i].Interface()) < toString(keys[j].Interface())
+		})
+		for _, key := range keys {
+			if err := f(v.MapIndex(key).Interface(), key.Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Ptr:
+		// a nil pointer
+		return nil
+	}
+	return fmt.Errorf("can not iterate over %T", collection)
+}
+
+// exprString returns a short description of e for error messages.
+func exprString(e expr) string {
+	switch e := e.(type) {
+	case identExpr:
+		return e.name
+	case memberExpr:
+		return exprString(e.object) + "." + e.name
+	}
+	return "expression"
+}
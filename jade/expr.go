@@ -0,0 +1,385 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package jade
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed javascript-like expression, e.g. the contents of #{...}
+// or the value of an attribute.
+type expr interface{}
+
+type (
+	// literalExpr is a string, number, boolean, or null literal
+	literalExpr struct {
+		value interface{}
+	}
+	// identExpr is a reference to a variable or function, e.g. Posts
+	identExpr struct {
+		name string
+	}
+	// memberExpr is a field, key, or method access, e.g. post.Title
+	memberExpr struct {
+		object expr
+		name   string
+	}
+	// indexExpr is an index into a slice, array, map, or string, e.g. tags[0]
+	indexExpr struct {
+		object expr
+		index  expr
+	}
+	// callExpr is a function or method call, e.g. Posts(5)
+	callExpr struct {
+		callee expr
+		args   []expr
+	}
+	// arrayExpr is an array literal, e.g. ["a", "b"]
+	arrayExpr struct {
+		elems []expr
+	}
+	// unaryExpr is a unary operation, e.g. !post.Draft or -1
+	unaryExpr struct {
+		op      string
+		operand expr
+	}
+	// binaryExpr is a binary operation, e.g. a + b or a && b
+	binaryExpr struct {
+		op          string
+		left, right expr
+	}
+	// condExpr is a conditional (ternary) expression, e.g. a ? b : c
+	condExpr struct {
+		cond, then, els expr
+	}
+	// interpExpr is a quoted attribute value with interpolation, e.g.
+	// "#{post.Url}". It evaluates to a string.
+	interpExpr struct {
+		parts []textPart
+	}
+)
+
+// token is a single token in an expression
+type token struct {
+	// kind is one of "ident", "number", "string", "punct", or "eof"
+	kind  string
+	value string
+}
+
+// punctuators is a list of all the punctuators that may appear in an
+// expression, with the longest ones first so that they take precedence.
+var punctuators = []string{
+	"===", "!==", "==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "?", ":", ".", ",", "(", ")", "[", "]",
+}
+
+// tokenize splits src into tokens.
+func tokenize(src string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || r == '$' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || runes[i] == '$' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: "ident", value: string(runes[start:i])})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: "number", value: string(runes[start:i])})
+		case r == '"' || r == '\'':
+			value, end, err := unquote(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: "string", value: value})
+			i = end
+		default:
+			found := false
+			for _, p := range punctuators {
+				if strings.HasPrefix(string(runes[i:]), p) {
+					tokens = append(tokens, token{kind: "punct", value: p})
+					i += len([]rune(p))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q in expression %q", r, src)
+			}
+		}
+	}
+	return append(tokens, token{kind: "eof"}), nil
+}
+
+// unquote reads the quoted string which starts at runes[start] and returns
+// its value and the index just after the closing quote.
+func unquote(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	value := []rune{}
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case quote:
+			return string(value), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(runes) {
+				break
+			}
+			switch runes[i] {
+			case 'n':
+				value = append(value, '\n')
+			case 't':
+				value = append(value, '\t')
+			default:
+				value = append(value, runes[i])
+			}
+		default:
+			value = append(value, runes[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string in expression %q", string(runes))
+}
+
+// exprParser is a recursive descent parser for expressions
+type exprParser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+// parseExpr parses src into an expr. It returns an error if src is not a
+// single valid expression.
+func parseExpr(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	e, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.peek().value, src)
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token and returns true iff it is the punctuator op
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == "punct" && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q but got %q in expression %q", op, p.peek().value, p.src)
+	}
+	return nil
+}
+
+func (p *exprParser) parseCond() (expr, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return condExpr{cond: cond, then: then, els: els}, nil
+}
+
+// binaryPrecedence holds the binary operators for each level of precedence,
+// from lowest to highest.
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (expr, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		matched := false
+		if t.kind == "punct" {
+			for _, op := range binaryPrecedence[level] {
+				if t.value == op {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: t.value, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	for _, op := range []string{"!", "-", "+"} {
+		if p.accept(op) {
+			operand, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return unaryExpr{op: op, operand: operand}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != "ident" {
+				return nil, fmt.Errorf("expected a name after \".\" but got %q in expression %q", t.value, p.src)
+			}
+			e = memberExpr{object: e, name: t.value}
+		case p.accept("["):
+			index, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = indexExpr{object: e, index: index}
+		case p.accept("("):
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			e = callExpr{callee: e, args: args}
+		default:
+			return e, nil
+		}
+	}
+}
+
+// parseList parses a comma separated list of expressions which ends with
+// the punctuator end.
+func (p *exprParser) parseList(end string) ([]expr, error) {
+	list := []expr{}
+	if p.accept(end) {
+		return list, nil
+	}
+	for {
+		e, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if p.accept(end) {
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in expression %q", t.value, p.src)
+		}
+		return literalExpr{value: f}, nil
+	case "string":
+		return literalExpr{value: t.value}, nil
+	case "ident":
+		switch t.value {
+		case "true":
+			return literalExpr{value: true}, nil
+		case "false":
+			return literalExpr{value: false}, nil
+		case "null", "undefined":
+			return literalExpr{value: nil}, nil
+		}
+		return identExpr{name: t.value}, nil
+	case "punct":
+		switch t.value {
+		case "(":
+			e, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		case "[":
+			elems, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return arrayExpr{elems: elems}, nil
+		}
+	case "eof":
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	}
+	return nil, fmt.Errorf("unexpected %q in expression %q", t.value, p.src)
+}
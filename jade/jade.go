@@ -0,0 +1,170 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package jade is a pure go implementation of the subset of jade (now called
+// pug) that scribble sites typically use: tags with ids, classes, and
+// attributes, text and interpolation, layouts with extends and block,
+// includes, mixins, conditionals, and iteration. Expressions are evaluated
+// against go values directly, so templates have access to structs like
+// Post, maps, slices, and functions such as the ones in context.FuncMap.
+package jade
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Template is a parsed jade file, along with any files that it extends or
+// includes.
+type Template struct {
+	nodes  []node
+	mixins map[string]*mixin
+	// files is every file that was parsed in order to create the template
+	files []string
+	// includes is the stack of files which are currently being parsed, used
+	// to detect files which include themselves
+	includes []string
+	funcs    map[string]interface{}
+}
+
+// mixin is a reusable block of jade, e.g. mixin postLink(post)
+type mixin struct {
+	params []string
+	body   []node
+}
+
+// ParseFile parses the jade file at path, along with any files that it
+// extends or includes, and returns the resulting Template.
+func ParseFile(path string) (*Template, error) {
+	t := &Template{
+		mixins: map[string]*mixin{},
+		funcs:  map[string]interface{}{},
+	}
+	nodes, err := t.resolve(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.nodes = nodes
+	return t, nil
+}
+
+// resolve parses the file at path. If it extends another file, resolve
+// parses that file instead and replaces its blocks with the ones defined in
+// the file at path. extending is the chain of files which extend the file at
+// path, used to detect cycles.
+func (t *Template) resolve(path string, extending []string) ([]node, error) {
+	for _, other := range extending {
+		if other == path {
+			return nil, fmt.Errorf("%s extends itself: %s", path, strings.Join(append(extending, path), " -> "))
+		}
+	}
+	nodes, parentPath, err := t.parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if parentPath == "" {
+		return nodes, nil
+	}
+	blocks := []*blockNode{}
+	for _, n := range nodes {
+		if block, ok := n.(*blockNode); ok {
+			blocks = append(blocks, block)
+		}
+	}
+	parentNodes, err := t.resolve(parentPath, append(extending, path))
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		applyBlock(parentNodes, block)
+	}
+	return parentNodes, nil
+}
+
+// applyBlock replaces, appends to, or prepends to the children of each block
+// in nodes which has the same name as block, depending on its mode.
+func applyBlock(nodes []node, block *blockNode) {
+	for _, n := range nodes {
+		for _, children := range childLists(n) {
+			applyBlock(*children, block)
+		}
+		if original, ok := n.(*blockNode); ok && original.name == block.name {
+			switch block.mode {
+			case "append":
+				original.children = append(original.children, block.children...)
+			case "prepend":
+				original.children = append(append([]node{}, block.children...), original.children...)
+			default:
+				original.children = block.children
+			}
+		}
+	}
+}
+
+// Funcs adds the functions in funcs to the functions which may be called in
+// the template, e.g. context.FuncMap. It returns the template so that calls
+// can be chained.
+func (t *Template) Funcs(funcs map[string]interface{}) *Template {
+	for name, f := range funcs {
+		t.funcs[name] = f
+	}
+	return t
+}
+
+// Files returns the paths of all the files which were parsed to create the
+// template, i.e. the file itself and any files that it extends or includes.
+func (t *Template) Files() []string {
+	return append([]string{}, t.files...)
+}
+
+// addFile adds path to the files for the template if it is not already there
+func (t *Template) addFile(path string) {
+	for _, file := range t.files {
+		if file == path {
+			return
+		}
+	}
+	t.files = append(t.files, path)
+}
+
+// including returns true iff the file at path is currently being parsed
+func (t *Template) including(path string) bool {
+	for _, file := range t.includes {
+		if file == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute renders the template with the given data and writes the output to
+// w. The keys in data are available as variables in the template. Nothing
+// is written if there is an error, including a panic in one of the
+// functions called by the template.
+func (t *Template) Execute(w io.Writer, data map[string]interface{}) (err error) {
+	defer func() {
+		// expressions recover from panics themselves, with their position.
+		// This is only a last resort for anything else.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: panic while rendering: %v", t.files[0], r)
+		}
+	}()
+	root := newScope(nil)
+	for name, value := range data {
+		root.vars[name] = value
+	}
+	s := &state{
+		t:     t,
+		w:     &bytes.Buffer{},
+		scope: root,
+		funcs: t.funcs,
+	}
+	if err := s.render(t.nodes); err != nil {
+		return err
+	}
+	_, err = s.w.WriteTo(w)
+	return err
+}
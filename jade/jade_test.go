@@ -0,0 +1,242 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package jade
+
+import (
+	"bytes"
+	"github.com/albrow/scribble/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testPost struct {
+	Title string
+	Url   string
+	Tags  []string
+}
+
+func (p testPost) Slug() string {
+	return strings.ToLower(p.Title)
+}
+
+func TestExecute(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_jade_execute")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Files which may be extended or included by the test cases
+	files := map[string]string{
+		"_layouts/base.jade":   "doctype html\nhtml\n\thead\n\t\tblock scripts\n\t\t\tscript(src=\"/main.js\")\n\tbody\n\t\tblock content",
+		"_layouts/nested.jade": "extends base\nblock content\n\tmain\n\t\tblock main",
+		"_includes/nav.jade":   "nav\n\teach post in Posts\n\t\ta(href=post.Url)= post.Title",
+		"_includes/style.css":  "p { color: red; }",
+	}
+	for path, content := range files {
+		writeFile(t, filepath.Join(root, path), content)
+	}
+
+	data := map[string]interface{}{
+		"Title": "<Blog>",
+		"Posts": []testPost{
+			{Title: "One", Url: "/one/", Tags: []string{"a", "b"}},
+			{Title: "Two", Url: "/two/"},
+		},
+		"Count": 2,
+		"Draft": false,
+	}
+	funcs := map[string]interface{}{
+		"upper": strings.ToUpper,
+		"Posts": func(limit int) []testPost {
+			return data["Posts"].([]testPost)[:limit]
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "tags",
+			src:      "div\n\tp.intro#first(title=\"hi\" data-count=Count) Hello\n\tbr\n\t.box.wide(class=\"red\")",
+			expected: `<div><p class="intro" id="first" title="hi" data-count="2">Hello</p><br/><div class="box wide red"></div></div>`,
+		},
+		{
+			name:     "attributes",
+			src:      "doctype html\ninput(type=\"checkbox\", checked, disabled=Draft, class=[\"a\", \"b\"])",
+			expected: `<!DOCTYPE html><input type="checkbox" checked class="a b">`,
+		},
+		{
+			name:     "interpolation",
+			src:      "h1 #{Title} and !{Title}\np\n\t| Count: #{Count + 1}\n\t| \\#{Title}\nspan= upper(\"a\" + Title)",
+			expected: "<h1>&lt;Blog&gt; and <Blog></h1><p>Count: 3\n#{Title}</p><span>A&lt;BLOG&gt;</span>",
+		},
+		{
+			name:     "attribute interpolation",
+			src:      "each post in Posts\n\ta(href=\"#{post.Url}\", title='#{post.Title} by #{Title}', data-raw=\"\\\\#{Title}\", class=\"post-#{post.Slug}\")",
+			expected: `<a href="/one/" title="One by &lt;Blog&gt;" data-raw="#{Title}" class="post-one"></a><a href="/two/" title="Two by &lt;Blog&gt;" data-raw="#{Title}" class="post-two"></a>`,
+		},
+		{
+			name:     "text block",
+			src:      "script.\n\tif (a < b) {\n\t\tgo()\n\t}",
+			expected: "<script>if (a < b) {\n\tgo()\n}</script>",
+		},
+		{
+			name:     "each",
+			src:      "ul\n\teach post, i in Posts\n\t\tli(class=i == 0 ? \"first\" : null)= post.Slug\n\t\t\teach tag in post.Tags\n\t\t\t\tspan= tag\n\t\t\telse\n\t\t\t\tspan none",
+			expected: `<ul><li class="first">one<span>a</span><span>b</span></li><li>two<span>none</span></li></ul>`,
+		},
+		{
+			name:     "conditionals",
+			src:      "if Draft\n\tp draft\nelse if Count > 1 && Posts.length == 2\n\tp many\nelse\n\tp one\nunless Draft\n\tp published",
+			expected: "<p>many</p><p>published</p>",
+		},
+		{
+			name:     "code",
+			src:      "- var latest = Posts(1)\neach post in latest\n\tp= post.Title\n// a comment\n//- a silent comment",
+			expected: "<p>One</p><!-- a comment-->",
+		},
+		{
+			name:     "mixins",
+			src:      "mixin link(post, cls)\n\ta(href=post.Url, class=cls)\n\t\tblock\nul\n\teach post in Posts\n\t\tli: +link(post, \"post\")\n\t\t\tstrong= post.Title",
+			expected: `<ul><li><a href="/one/" class="post"><strong>One</strong></a></li><li><a href="/two/" class="post"><strong>Two</strong></a></li></ul>`,
+		},
+		{
+			name:     "extends",
+			src:      "extends _layouts/nested\nappend scripts\n\tscript(src=\"/extra.js\")\nblock main\n\tinclude _includes/nav\n\tstyle\n\t\tinclude _includes/style.css",
+			expected: `<!DOCTYPE html><html><head><script src="/main.js"></script><script src="/extra.js"></script></head><body><main><nav><a href="/one/">One</a><a href="/two/">Two</a></nav><style>p { color: red; }</style></main></body></html>`,
+		},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(root, strings.Replace(tc.name, " ", "_", -1)+".jade")
+		writeFile(t, path, tc.src)
+		tmpl, err := ParseFile(path)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", tc.name, err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := tmpl.Funcs(funcs).Execute(buf, data); err != nil {
+			t.Errorf("Unexpected error executing %s: %s", tc.name, err)
+			continue
+		}
+		if got := buf.String(); got != tc.expected {
+			t.Errorf("Output for %s was incorrect.\nExpected: %s\nGot:      %s", tc.name, tc.expected, got)
+		}
+	}
+
+	// Files should include everything that was extended or included
+	tmpl, err := ParseFile(filepath.Join(root, "extends.jade"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{"extends.jade", "_includes/nav.jade", "_includes/style.css", "_layouts/nested.jade", "_layouts/base.jade"}
+	if got := tmpl.Files(); len(got) != len(expectedFiles) {
+		t.Errorf("Expected %d files but got %d: %v", len(expectedFiles), len(got), got)
+	} else {
+		for i, file := range expectedFiles {
+			if expected := filepath.Join(root, file); got[i] != expected {
+				t.Errorf("Expected file %d to be %s but got %s", i, expected, got[i])
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_jade_errors")
+	defer func() {
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "syntax",
+			src:      "div\n\tp= (a",
+			expected: "syntax.jade:2:",
+		},
+		{
+			name:     "undefined mixin",
+			src:      "div\n\t+missing",
+			expected: "undefined mixin missing",
+		},
+		{
+			name:     "not a function",
+			src:      "p= Title()",
+			expected: "Title is not a function",
+		},
+		{
+			name:     "self include",
+			src:      "include self_include",
+			expected: "includes itself",
+		},
+		{
+			name:     "self extends",
+			src:      "extends self_extends",
+			expected: "extends itself",
+		},
+		{
+			name:     "case",
+			src:      "div\n\tcase Title\n\t\twhen \"a\"\n\t\t\tp a",
+			expected: "case.jade:2: case is not supported",
+		},
+		{
+			name:     "while",
+			src:      "while true\n\tp loop",
+			expected: "while.jade:1: while is not supported",
+		},
+		{
+			name:     "panic in function",
+			src:      "div\n\tp= Posts(-1)",
+			expected: "panic_in_function.jade:2:",
+		},
+		{
+			name:     "value method on nil pointer",
+			src:      "p= NilPost.Slug",
+			expected: "value_method_on_nil_pointer.jade:1:",
+		},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(root, strings.Replace(tc.name, " ", "_", -1)+".jade")
+		writeFile(t, path, tc.src)
+		tmpl, err := ParseFile(path)
+		if err == nil {
+			tmpl.Funcs(map[string]interface{}{
+				"Posts": func(limit int) []testPost {
+					return []testPost{}[:limit]
+				},
+			})
+			err = tmpl.Execute(ioutil.Discard, map[string]interface{}{"Title": "Title", "NilPost": (*testPost)(nil)})
+		}
+		if err == nil {
+			t.Errorf("Expected an error for %s but got none", tc.name)
+		} else if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Expected the error for %s to contain %q but got: %s", tc.name, tc.expected, err)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := util.CreateEmptyFiles([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,827 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package jade
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// line is a single non-blank line of a jade file, along with all the lines
+// which are indented below it.
+type line struct {
+	// text is the content of the line without any indentation
+	text string
+	// raw is the full line, including indentation
+	raw      string
+	num      int
+	indent   int
+	children []*line
+}
+
+// splitLines splits src into lines and returns the lines which are not
+// indented below any other line.
+func splitLines(src string) []*line {
+	root := &line{indent: -1}
+	stack := []*line{root}
+	for i, raw := range strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n") {
+		text := strings.TrimLeftFunc(raw, unicode.IsSpace)
+		if text == "" {
+			continue
+		}
+		l := &line{
+			text:   strings.TrimRightFunc(text, unicode.IsSpace),
+			raw:    strings.TrimRightFunc(raw, unicode.IsSpace),
+			num:    i + 1,
+			indent: len(raw) - len(text),
+		}
+		for stack[len(stack)-1].indent >= l.indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, l)
+		stack = append(stack, l)
+	}
+	return root.children
+}
+
+// rawText returns all the lines indented below l as text, with the
+// indentation of the first child removed from each of them.
+func (l *line) rawText() string {
+	lines := []string{}
+	var collect func(children []*line)
+	collect = func(children []*line) {
+		for _, child := range children {
+			lines = append(lines, child.raw)
+			collect(child.children)
+		}
+	}
+	collect(l.children)
+	if len(lines) == 0 {
+		return ""
+	}
+	indent := l.children[0].indent
+	for i, raw := range lines {
+		if len(raw) >= indent && strings.TrimSpace(raw[:indent]) == "" {
+			lines[i] = raw[indent:]
+		} else {
+			lines[i] = strings.TrimLeftFunc(raw, unicode.IsSpace)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parser parses a single jade file into nodes
+type parser struct {
+	t    *Template
+	path string
+}
+
+// errorf returns an error which includes the path and line number
+func (p *parser) errorf(l *line, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.path, l.num, fmt.Sprintf(format, args...))
+}
+
+// parseFile parses the jade file at path. If the file extends another file,
+// it returns the path of that file as well.
+func (t *Template) parseFile(path string) ([]node, string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	t.addFile(path)
+	t.includes = append(t.includes, path)
+	defer func() {
+		t.includes = t.includes[:len(t.includes)-1]
+	}()
+	p := &parser{t: t, path: path}
+	lines := splitLines(string(content))
+	parent := ""
+	if len(lines) > 0 {
+		if rest, ok := keyword(lines[0].text, "extends", "extend"); ok {
+			if rest == "" {
+				return nil, "", p.errorf(lines[0], "missing path for extends")
+			}
+			parent = p.resolvePath(rest)
+			lines = lines[1:]
+		}
+	}
+	nodes, err := p.parseLines(lines)
+	if err != nil {
+		return nil, "", err
+	}
+	return nodes, parent, nil
+}
+
+// resolvePath returns the path of a file which is extended or included by
+// the file being parsed. Relative paths are relative to the directory which
+// contains the file being parsed, and ".jade" is added if there is no
+// extension.
+func (p *parser) resolvePath(path string) string {
+	if filepath.Ext(path) == "" {
+		path += ".jade"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.path), path)
+	}
+	return path
+}
+
+// keyword returns the rest of text after the first of the given keywords and
+// true if text starts with it, or false if it doesn't start with any of them.
+func keyword(text string, keywords ...string) (string, bool) {
+	for _, kw := range keywords {
+		if text == kw {
+			return "", true
+		}
+		if strings.HasPrefix(text, kw+" ") {
+			return strings.TrimSpace(text[len(kw):]), true
+		}
+	}
+	return "", false
+}
+
+// parseLines parses each line and returns the resulting nodes
+func (p *parser) parseLines(lines []*line) ([]node, error) {
+	nodes := []node{}
+	for _, l := range lines {
+		if rest, ok := keyword(l.text, "else"); ok {
+			// else belongs to the preceding if or each
+			if err := p.parseElse(l, rest, nodes); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		n, err := p.parseLine(l.text, l)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// parseElse attaches an else or else if to the last node in nodes.
+func (p *parser) parseElse(l *line, rest string, nodes []node) error {
+	if len(nodes) == 0 {
+		return p.errorf(l, "else without if or each")
+	}
+	// find the last node in a chain of else ifs
+	var els *[]node
+	switch last := nodes[len(nodes)-1].(type) {
+	case *ifNode:
+		for len(last.els) == 1 && last.elseIf {
+			last = last.els[0].(*ifNode)
+		}
+		if last.els != nil {
+			return p.errorf(l, "unexpected else after else")
+		}
+		els = &last.els
+		if cond, ok := keyword(rest, "if"); ok {
+			n, err := p.parseIf(l, cond, false)
+			if err != nil {
+				return err
+			}
+			last.elseIf = true
+			*els = []node{n}
+			return nil
+		}
+	case *eachNode:
+		if last.els != nil {
+			return p.errorf(l, "unexpected else after else")
+		}
+		els = &last.els
+	default:
+		return p.errorf(l, "else without if or each")
+	}
+	if rest != "" {
+		return p.errorf(l, "unexpected %q after else", rest)
+	}
+	children, err := p.parseLines(l.children)
+	if err != nil {
+		return err
+	}
+	*els = children
+	return nil
+}
+
+// parseLine parses a single line of text. The children of l are the lines
+// indented below it. text is usually the same as l.text, except when a tag
+// is nested on the same line as another tag, e.g. li: a(href="/") Home.
+func (p *parser) parseLine(text string, l *line) (node, error) {
+	if strings.HasPrefix(text, "//-") {
+		// silent comment
+		return nil, nil
+	}
+	if strings.HasPrefix(text, "//") {
+		comment := text[2:]
+		if len(l.children) > 0 {
+			comment += "\n" + l.rawText() + "\n"
+		}
+		return &commentNode{text: comment}, nil
+	}
+	if strings.HasPrefix(text, "|") {
+		return p.parseTextNode(l, strings.TrimPrefix(text[1:], " "))
+	}
+	if strings.HasPrefix(text, "#{") || strings.HasPrefix(text, "!{") {
+		// a line which starts with interpolation is text, e.g. !{Post.Content}
+		return p.parseTextNode(l, text)
+	}
+	if strings.HasPrefix(text, "<") {
+		// literal html, which may contain nested lines
+		n, err := p.parseTextNode(l, text)
+		if err != nil {
+			return nil, err
+		}
+		children, err := p.parseLines(l.children)
+		if err != nil {
+			return nil, err
+		}
+		return &groupNode{children: append([]node{n}, children...)}, nil
+	}
+	if rest, ok := keyword(text, "doctype", "!!!"); ok {
+		return &doctypeNode{value: rest}, nil
+	}
+	if _, ok := keyword(text, "extends", "extend"); ok {
+		return nil, p.errorf(l, "extends must be the first line in the file")
+	}
+	if rest, ok := keyword(text, "block"); ok {
+		if rest == "" {
+			// the block passed to a mixin
+			return &mixinBlockNode{}, nil
+		}
+		mode := "replace"
+		if name, ok := keyword(rest, "append", "prepend"); ok {
+			mode = strings.Fields(rest)[0]
+			rest = name
+		}
+		return p.parseBlock(l, rest, mode)
+	}
+	if rest, ok := keyword(text, "append", "prepend"); ok {
+		return p.parseBlock(l, rest, strings.Fields(text)[0])
+	}
+	if rest, ok := keyword(text, "include"); ok {
+		return p.parseInclude(l, rest)
+	}
+	if rest, ok := keyword(text, "mixin"); ok {
+		return nil, p.parseMixin(l, rest)
+	}
+	if strings.HasPrefix(text, "+") {
+		return p.parseMixinCall(l, text[1:])
+	}
+	if rest, ok := keyword(text, "each", "for"); ok {
+		return p.parseEach(l, rest)
+	}
+	if rest, ok := keyword(text, "if"); ok {
+		return p.parseIf(l, rest, false)
+	}
+	if rest, ok := keyword(text, "unless"); ok {
+		return p.parseIf(l, rest, true)
+	}
+	if strings.HasPrefix(text, "-") {
+		return p.parseCode(l, strings.TrimSpace(text[1:]))
+	}
+	if strings.HasPrefix(text, "!=") {
+		return p.parseOutput(l, text[2:], false)
+	}
+	if strings.HasPrefix(text, "=") {
+		return p.parseOutput(l, text[1:], true)
+	}
+	return p.parseTag(text, l)
+}
+
+func (p *parser) parseTextNode(l *line, text string) (node, error) {
+	parts, err := parseText(text)
+	if err != nil {
+		return nil, p.errorf(l, "%s", err)
+	}
+	return &textNode{pos: p.pos(l), parts: parts}, nil
+}
+
+func (p *parser) parseBlock(l *line, name string, mode string) (node, error) {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return nil, p.errorf(l, "invalid block name %q", name)
+	}
+	children, err := p.parseLines(l.children)
+	if err != nil {
+		return nil, err
+	}
+	return &blockNode{name: name, mode: mode, children: children}, nil
+}
+
+// parseInclude parses an included file. Jade files are parsed and inserted
+// in place of the include, while any other file is inserted as is.
+func (p *parser) parseInclude(l *line, path string) (node, error) {
+	if path == "" {
+		return nil, p.errorf(l, "missing path for include")
+	}
+	path = p.resolvePath(path)
+	if p.t.including(path) {
+		return nil, p.errorf(l, "%s includes itself", path)
+	}
+	if filepath.Ext(path) != ".jade" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, p.errorf(l, "%s", err)
+		}
+		p.t.addFile(path)
+		return &rawNode{text: string(content)}, nil
+	}
+	nodes, parent, err := p.t.parseFile(path)
+	if err != nil {
+		return nil, p.errorf(l, "%s", err)
+	}
+	if parent != "" {
+		return nil, p.errorf(l, "included file %s can not use extends", path)
+	}
+	return &groupNode{children: nodes}, nil
+}
+
+// parseMixin parses a mixin definition and adds it to the template. Mixins
+// which were already defined (e.g. by a file which extends this one) are not
+// replaced.
+func (p *parser) parseMixin(l *line, signature string) error {
+	name, params := signature, ""
+	if i := strings.Index(signature, "("); i != -1 {
+		if !strings.HasSuffix(signature, ")") {
+			return p.errorf(l, "invalid mixin %q", signature)
+		}
+		name, params = signature[:i], signature[i+1:len(signature)-1]
+	}
+	name = strings.TrimSpace(name)
+	if !isName(name) {
+		return p.errorf(l, "invalid mixin name %q", name)
+	}
+	m := &mixin{}
+	for _, param := range strings.Split(params, ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			if !isName(param) {
+				return p.errorf(l, "invalid parameter %q for mixin %s", param, name)
+			}
+			m.params = append(m.params, param)
+		}
+	}
+	body, err := p.parseLines(l.children)
+	if err != nil {
+		return err
+	}
+	m.body = body
+	if _, found := p.t.mixins[name]; !found {
+		p.t.mixins[name] = m
+	}
+	return nil
+}
+
+func (p *parser) parseMixinCall(l *line, text string) (node, error) {
+	name, args := text, []expr{}
+	if i := strings.Index(text, "("); i != -1 {
+		end := matchingParen(text, i)
+		if end != len(text)-1 {
+			return nil, p.errorf(l, "invalid mixin call %q", text)
+		}
+		name = text[:i]
+		for _, src := range splitArgs(text[i+1 : end]) {
+			arg, err := parseExpr(src)
+			if err != nil {
+				return nil, p.errorf(l, "%s", err)
+			}
+			args = append(args, arg)
+		}
+	}
+	if !isName(name) {
+		return nil, p.errorf(l, "invalid mixin name %q", name)
+	}
+	block, err := p.parseLines(l.children)
+	if err != nil {
+		return nil, err
+	}
+	return &mixinCallNode{pos: p.pos(l), name: name, args: args, block: block}, nil
+}
+
+// parseEach parses an each or for loop, e.g. each post, i in Posts
+func (p *parser) parseEach(l *line, text string) (node, error) {
+	i := strings.Index(text, " in ")
+	if i == -1 {
+		return nil, p.errorf(l, "expected each <value>[, <key>] in <collection>")
+	}
+	vars := strings.Split(text[:i], ",")
+	if len(vars) > 2 {
+		return nil, p.errorf(l, "expected each <value>[, <key>] in <collection>")
+	}
+	n := &eachNode{pos: p.pos(l), valueName: strings.TrimSpace(vars[0])}
+	if len(vars) == 2 {
+		n.keyName = strings.TrimSpace(vars[1])
+	}
+	if !isName(n.valueName) || (len(vars) == 2 && !isName(n.keyName)) {
+		return nil, p.errorf(l, "invalid variable name in %q", text)
+	}
+	collection, err := parseExpr(text[i+4:])
+	if err != nil {
+		return nil, p.errorf(l, "%s", err)
+	}
+	n.collection = collection
+	if n.children, err = p.parseLines(l.children); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *parser) parseIf(l *line, text string, negate bool) (*ifNode, error) {
+	cond, err := parseExpr(text)
+	if err != nil {
+		return nil, p.errorf(l, "%s", err)
+	}
+	children, err := p.parseLines(l.children)
+	if err != nil {
+		return nil, err
+	}
+	return &ifNode{pos: p.pos(l), cond: cond, negate: negate, children: children}, nil
+}
+
+// parseCode parses unbuffered code. Only variable assignments are supported,
+// e.g. - var posts = Posts(5)
+func (p *parser) parseCode(l *line, code string) (node, error) {
+	code = strings.TrimSuffix(strings.TrimPrefix(code, "var "), ";")
+	i := strings.Index(code, "=")
+	if i == -1 || strings.HasPrefix(code[i:], "==") {
+		return nil, p.errorf(l, "unsupported code %q. Only variable assignments are supported", code)
+	}
+	name := strings.TrimSpace(code[:i])
+	if !isName(name) {
+		return nil, p.errorf(l, "unsupported code %q. Only variable assignments are supported", code)
+	}
+	value, err := parseExpr(code[i+1:])
+	if err != nil {
+		return nil, p.errorf(l, "%s", err)
+	}
+	return &assignNode{pos: p.pos(l), name: name, value: value}, nil
+}
+
+// parseOutput parses buffered code, e.g. = post.Title
+func (p *parser) parseOutput(l *line, code string, escape bool) (node, error) {
+	value, err := parseExpr(code)
+	if err != nil {
+		return nil, p.errorf(l, "%s", err)
+	}
+	return &outputNode{pos: p.pos(l), value: value, escape: escape}, nil
+}
+
+// unsupportedKeywords are the jade keywords which are not supported. A line
+// which starts with one of them is an error instead of a tag.
+var unsupportedKeywords = []string{"case", "when", "default", "while"}
+
+// parseTag parses a tag along with its id, classes, attributes, and content,
+// e.g. a.link(href=post.Url) #{post.Title}
+func (p *parser) parseTag(text string, l *line) (node, error) {
+	i := 0
+	for i < len(text) && isNameChar(rune(text[i]), i == 0) {
+		i++
+		if i+1 < len(text) && text[i] == ':' && unicode.IsLetter(rune(text[i+1])) {
+			// a namespaced tag, e.g. svg:rect
+			i++
+		}
+	}
+	tag := &tagNode{pos: p.pos(l), name: text[:i]}
+	if _, ok := keyword(text, unsupportedKeywords...); ok {
+		// these would otherwise be rendered as tags, e.g. <case>
+		return nil, p.errorf(l, "%s is not supported", tag.name)
+	}
+	if tag.name == "" {
+		if text[0] != '#' && text[0] != '.' {
+			return nil, p.errorf(l, "unexpected %q", text)
+		}
+		tag.name = "div"
+	}
+
+	// Parse the id, classes, and attributes
+	textBlock := false
+	for i < len(text) && !textBlock {
+		switch text[i] {
+		case '#', '.':
+			start := i + 1
+			end := start
+			for end < len(text) && isNameChar(rune(text[end]), false) {
+				end++
+			}
+			if end == start {
+				if text[i] == '.' && end == len(text) {
+					// a block of text, e.g. script.
+					textBlock = true
+					i = end
+					continue
+				}
+				return nil, p.errorf(l, "expected a name after %q", text[i])
+			}
+			name := "class"
+			if text[i] == '#' {
+				name = "id"
+			}
+			tag.attrs = append(tag.attrs, attr{name: name, value: literalExpr{value: text[start:end]}, escape: true})
+			i = end
+		case '(':
+			end := matchingParen(text, i)
+			if end == -1 {
+				return nil, p.errorf(l, "missing ) in %q", text)
+			}
+			attrs, err := parseAttrs(text[i+1 : end])
+			if err != nil {
+				return nil, p.errorf(l, "%s", err)
+			}
+			tag.attrs = append(tag.attrs, attrs...)
+			i = end + 1
+		default:
+			goto content
+		}
+	}
+
+content:
+	// Parse the content on the same line and the nested lines
+	rest := text[i:]
+	switch {
+	case textBlock:
+		parts, err := parseText(l.rawText())
+		if err != nil {
+			return nil, p.errorf(l, "%s", err)
+		}
+		tag.children = []node{&textNode{pos: p.pos(l), parts: parts}}
+		return tag, nil
+	case strings.HasPrefix(rest, ":"):
+		// block expansion, e.g. li: a(href="/") Home
+		child, err := p.parseLine(strings.TrimSpace(rest[1:]), l)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			tag.children = []node{child}
+		}
+		return tag, nil
+	case strings.HasPrefix(rest, "!="), strings.HasPrefix(rest, "="):
+		escape := rest[0] == '='
+		n, err := p.parseOutput(l, strings.TrimLeft(rest, "!="), escape)
+		if err != nil {
+			return nil, err
+		}
+		tag.children = append(tag.children, n)
+	case rest == "/":
+		tag.selfClose = true
+	case strings.HasPrefix(rest, " "):
+		n, err := p.parseTextNode(l, rest[1:])
+		if err != nil {
+			return nil, err
+		}
+		tag.children = append(tag.children, n)
+	case rest != "":
+		return nil, p.errorf(l, "unexpected %q", rest)
+	}
+	children, err := p.parseLines(l.children)
+	if err != nil {
+		return nil, err
+	}
+	tag.children = append(tag.children, children...)
+	return tag, nil
+}
+
+func (p *parser) pos(l *line) pos {
+	return pos{path: p.path, line: l.num}
+}
+
+// isNameChar returns true iff r may appear in the name of a tag, id, or class
+func isNameChar(r rune, first bool) bool {
+	if first {
+		return unicode.IsLetter(r)
+	}
+	return r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isName returns true iff name is a valid variable or mixin name
+func isName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r == '$' || r == '-' && i > 0 || unicode.IsLetter(r) || unicode.IsDigit(r) && i > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchingParen returns the index of the parenthesis which closes the one at
+// text[start], skipping over quoted strings. It returns -1 if there is none.
+func matchingParen(text string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitArgs splits a comma separated list of expressions, ignoring commas
+// inside of quotes and brackets.
+func splitArgs(src string) []string {
+	args := []string{}
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, src[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(src[start:]) != "" {
+		args = append(args, src[start:])
+	}
+	return args
+}
+
+// operatorChars are the characters which may come before or after an operator
+// in an attribute value. Attributes may be separated by whitespace instead of
+// commas, as long as the whitespace is not next to one of these characters.
+const operatorChars = "+-*/%<>=!&|?:,.([{"
+
+// parseAttrs parses the attributes inside the parentheses after a tag, e.g.
+// href=post.Url, class="link"
+func parseAttrs(src string) ([]attr, error) {
+	attrs := []attr{}
+	i := 0
+	for {
+		for i < len(src) && (src[i] == ',' || unicode.IsSpace(rune(src[i]))) {
+			i++
+		}
+		if i >= len(src) {
+			return attrs, nil
+		}
+		start := i
+		for i < len(src) && !strings.ContainsRune("=!, \t\n", rune(src[i])) {
+			i++
+		}
+		a := attr{name: src[start:i], escape: true}
+		if a.name == "" {
+			return nil, fmt.Errorf("unexpected %q in attributes", src[i:])
+		}
+		for i < len(src) && unicode.IsSpace(rune(src[i])) {
+			i++
+		}
+		switch {
+		case strings.HasPrefix(src[i:], "!="):
+			a.escape = false
+			i += 2
+		case strings.HasPrefix(src[i:], "="):
+			i++
+		default:
+			// a boolean attribute, e.g. input(checked)
+			a.value = literalExpr{value: true}
+			attrs = append(attrs, a)
+			continue
+		}
+		for i < len(src) && unicode.IsSpace(rune(src[i])) {
+			i++
+		}
+		end := attrValueEnd(src, i)
+		value, err := parseAttrValue(src[i:end])
+		if err != nil {
+			return nil, err
+		}
+		a.value = value
+		attrs = append(attrs, a)
+		i = end
+	}
+}
+
+// parseAttrValue parses the value of an attribute. If the value is a quoted
+// string with interpolation, e.g. href="/tags/#{tag}", the interpolated
+// values are evaluated whenever the attribute is rendered.
+func parseAttrValue(src string) (expr, error) {
+	value, err := parseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	literal, ok := value.(literalExpr)
+	if !ok {
+		return value, nil
+	}
+	str, ok := literal.value.(string)
+	if !ok || !strings.Contains(str, "{") {
+		return value, nil
+	}
+	parts, err := parseText(str)
+	if err != nil {
+		return nil, err
+	}
+	// Only escaped interpolation, e.g. "\\#{tag}", leaves a literal string
+	str = ""
+	for _, part := range parts {
+		if part.value != nil {
+			return interpExpr{parts: parts}, nil
+		}
+		str += part.literal
+	}
+	return literalExpr{value: str}, nil
+}
+
+// attrValueEnd returns the index just after the attribute value which starts
+// at src[start].
+func attrValueEnd(src string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && c == ',':
+			return i
+		case depth == 0 && unicode.IsSpace(rune(c)):
+			prev := strings.TrimRightFunc(src[start:i], unicode.IsSpace)
+			next := strings.TrimLeftFunc(src[i:], unicode.IsSpace)
+			if prev != "" && next != "" && !strings.ContainsRune(operatorChars, rune(prev[len(prev)-1])) && !strings.ContainsRune(operatorChars+")]}", rune(next[0])) {
+				return i
+			}
+		}
+	}
+	return len(src)
+}
+
+// parseText parses text which may contain interpolation, e.g.
+// Posted on #{post.Date}. Interpolation may be escaped with a backslash.
+func parseText(text string) ([]textPart, error) {
+	parts := []textPart{}
+	literal := ""
+	for i := 0; i < len(text); i++ {
+		if (text[i] == '#' || text[i] == '!') && strings.HasPrefix(text[i+1:], "{") {
+			if strings.HasSuffix(literal, "\\") {
+				literal = literal[:len(literal)-1] + text[i:i+1]
+				continue
+			}
+			end := matchingParen(text, i+1)
+			if end == -1 {
+				return nil, fmt.Errorf("missing } in %q", text)
+			}
+			value, err := parseExpr(text[i+2 : end])
+			if err != nil {
+				return nil, err
+			}
+			if literal != "" {
+				parts = append(parts, textPart{literal: literal})
+				literal = ""
+			}
+			parts = append(parts, textPart{value: value, escape: text[i] == '#'})
+			i = end
+			continue
+		}
+		literal += text[i : i+1]
+	}
+	if literal != "" {
+		parts = append(parts, textPart{literal: literal})
+	}
+	return parts, nil
+}
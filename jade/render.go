@@ -0,0 +1,470 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package jade
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// node is a single parsed element of a jade file, e.g. a tag or some text
+type node interface{}
+
+// pos is the position of a node, used in error messages
+type pos struct {
+	path string
+	line int
+}
+
+// attr is a single attribute of a tag, e.g. href=post.Url
+type attr struct {
+	name   string
+	value  expr
+	escape bool
+}
+
+// textPart is either literal text or an interpolated value, e.g. #{post.Title}
+type textPart struct {
+	literal string
+	value   expr
+	escape  bool
+}
+
+type (
+	// commentNode is a comment which is included in the output, e.g. // note
+	commentNode struct {
+		text string
+	}
+	// textNode is text which may contain interpolation, e.g. | Hello #{name}
+	textNode struct {
+		pos
+		parts []textPart
+	}
+	// groupNode is a list of nodes, e.g. the contents of an included file
+	groupNode struct {
+		children []node
+	}
+	// rawNode is text which is output as is, e.g. an included css file
+	rawNode struct {
+		text string
+	}
+	// doctypeNode is a doctype declaration, e.g. doctype html
+	doctypeNode struct {
+		value string
+	}
+	// blockNode is a named block which may be replaced, appended to, or
+	// prepended to by a file which extends this one
+	blockNode struct {
+		name     string
+		mode     string
+		children []node
+	}
+	// mixinBlockNode is the block passed to a mixin, i.e. a line with only
+	// the word block inside of a mixin
+	mixinBlockNode struct{}
+	// mixinCallNode is a call to a mixin, e.g. +postLink(post)
+	mixinCallNode struct {
+		pos
+		name  string
+		args  []expr
+		block []node
+	}
+	// eachNode is a loop over a collection, e.g. each post in Posts
+	eachNode struct {
+		pos
+		valueName  string
+		keyName    string
+		collection expr
+		children   []node
+		// els is rendered if the collection is empty
+		els []node
+	}
+	// ifNode is a conditional, e.g. if post.Draft or unless post.Draft
+	ifNode struct {
+		pos
+		cond     expr
+		negate   bool
+		children []node
+		els      []node
+		// elseIf is true iff els consists of a single else if
+		elseIf bool
+	}
+	// assignNode assigns a value to a variable, e.g. - var posts = Posts(5)
+	assignNode struct {
+		pos
+		name  string
+		value expr
+	}
+	// outputNode outputs the value of an expression, e.g. = post.Title
+	outputNode struct {
+		pos
+		value  expr
+		escape bool
+	}
+	// tagNode is an html tag, e.g. a(href=post.Url)
+	tagNode struct {
+		pos
+		name      string
+		attrs     []attr
+		selfClose bool
+		children  []node
+	}
+)
+
+// childLists returns pointers to each list of nodes which are nested inside
+// of n, so that they can be walked or modified.
+func childLists(n node) []*[]node {
+	switch n := n.(type) {
+	case *groupNode:
+		return []*[]node{&n.children}
+	case *blockNode:
+		return []*[]node{&n.children}
+	case *mixinCallNode:
+		return []*[]node{&n.block}
+	case *eachNode:
+		return []*[]node{&n.children, &n.els}
+	case *ifNode:
+		return []*[]node{&n.children, &n.els}
+	case *tagNode:
+		return []*[]node{&n.children}
+	}
+	return nil
+}
+
+// doctypes maps the shorthand for common doctypes to the full declaration
+var doctypes = map[string]string{
+	"":             "<!DOCTYPE html>",
+	"html":         "<!DOCTYPE html>",
+	"5":            "<!DOCTYPE html>",
+	"xml":          `<?xml version="1.0" encoding="utf-8" ?>`,
+	"transitional": `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">`,
+	"strict":       `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`,
+	"frameset":     `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Frameset//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd">`,
+	"1.1":          `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`,
+	"basic":        `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML Basic 1.1//EN" "http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd">`,
+	"mobile":       `<!DOCTYPE html PUBLIC "-//WAPFORUM//DTD XHTML Mobile 1.2//EN" "http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd">`,
+}
+
+// voidElements are the tags which never have any content or closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "keygen": true, "link": true,
+	"menuitem": true, "meta": true, "param": true, "source": true,
+	"track": true, "wbr": true,
+}
+
+var htmlEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+)
+
+// mixinBlock is the block passed to a mixin, along with the scope of the
+// caller, which is used when the block is rendered.
+type mixinBlock struct {
+	nodes []node
+	scope *scope
+}
+
+// state is the state of the template while it is being rendered
+type state struct {
+	t     *Template
+	w     *bytes.Buffer
+	scope *scope
+	funcs map[string]interface{}
+	// blocks is the stack of blocks passed to the mixins which are being
+	// rendered
+	blocks []mixinBlock
+	// terse is true iff the doctype is html, in which case void elements and
+	// boolean attributes are written in their short form
+	terse bool
+}
+
+// errorf returns an error which includes the path and line number
+func (s *state) errorf(p pos, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.path, p.line, fmt.Sprintf(format, args...))
+}
+
+// evalAt evaluates e and adds the position p to any error. A panic while
+// evaluating e, e.g. from the reflect package, is returned as an error too.
+func (s *state) evalAt(p pos, e expr) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, s.errorf(p, "panic while evaluating expression: %v", r)
+		}
+	}()
+	value, err = s.eval(e)
+	if err != nil {
+		return nil, s.errorf(p, "%s", err)
+	}
+	return value, nil
+}
+
+// render renders each node in nodes. Consecutive lines of text are separated
+// by a newline.
+func (s *state) render(nodes []node) error {
+	var prev node
+	for _, n := range nodes {
+		if _, isText := n.(*textNode); isText {
+			if _, prevIsText := prev.(*textNode); prevIsText {
+				s.w.WriteString("\n")
+			}
+		}
+		if err := s.renderNode(n); err != nil {
+			return err
+		}
+		prev = n
+	}
+	return nil
+}
+
+func (s *state) renderNode(n node) error {
+	switch n := n.(type) {
+	case *commentNode:
+		s.w.WriteString("<!--" + n.text + "-->")
+	case *textNode:
+		return s.renderText(n)
+	case *groupNode:
+		return s.render(n.children)
+	case *rawNode:
+		s.w.WriteString(n.text)
+	case *doctypeNode:
+		doctype, found := doctypes[strings.ToLower(n.value)]
+		if !found {
+			doctype = "<!DOCTYPE " + n.value + ">"
+		}
+		s.terse = doctype == "<!DOCTYPE html>"
+		s.w.WriteString(doctype)
+	case *blockNode:
+		return s.render(n.children)
+	case *mixinBlockNode:
+		return s.renderMixinBlock()
+	case *mixinCallNode:
+		return s.renderMixinCall(n)
+	case *eachNode:
+		return s.renderEach(n)
+	case *ifNode:
+		cond, err := s.evalAt(n.pos, n.cond)
+		if err != nil {
+			return err
+		}
+		if truthy(cond) != n.negate {
+			return s.render(n.children)
+		}
+		return s.render(n.els)
+	case *assignNode:
+		value, err := s.evalAt(n.pos, n.value)
+		if err != nil {
+			return err
+		}
+		s.scope.vars[n.name] = value
+	case *outputNode:
+		value, err := s.evalAt(n.pos, n.value)
+		if err != nil {
+			return err
+		}
+		s.write(value, n.escape)
+	case *tagNode:
+		return s.renderTag(n)
+	default:
+		return fmt.Errorf("unknown node %T", n)
+	}
+	return nil
+}
+
+// write writes value as a string, escaping it iff escape is true
+func (s *state) write(value interface{}, escape bool) {
+	if escape {
+		s.w.WriteString(htmlEscaper.Replace(toString(value)))
+	} else {
+		s.w.WriteString(toString(value))
+	}
+}
+
+func (s *state) renderText(n *textNode) error {
+	for _, part := range n.parts {
+		if part.value == nil {
+			s.w.WriteString(part.literal)
+			continue
+		}
+		value, err := s.evalAt(n.pos, part.value)
+		if err != nil {
+			return err
+		}
+		s.write(value, part.escape)
+	}
+	return nil
+}
+
+// renderMixinBlock renders the block which was passed to the mixin that is
+// currently being rendered, in the scope of the caller.
+func (s *state) renderMixinBlock() error {
+	if len(s.blocks) == 0 {
+		return nil
+	}
+	block := s.blocks[len(s.blocks)-1]
+	oldScope, oldBlocks := s.scope, s.blocks
+	s.scope, s.blocks = newScope(block.scope), s.blocks[:len(s.blocks)-1]
+	defer func() {
+		s.scope, s.blocks = oldScope, oldBlocks
+	}()
+	return s.render(block.nodes)
+}
+
+// renderMixinCall renders a mixin. The mixin can only access its arguments
+// and the variables in the outermost scope, not the variables of the caller.
+func (s *state) renderMixinCall(n *mixinCallNode) error {
+	m, found := s.t.mixins[n.name]
+	if !found {
+		return s.errorf(n.pos, "undefined mixin %s", n.name)
+	}
+	root := s.scope
+	for root.parent != nil {
+		root = root.parent
+	}
+	mixinScope := newScope(root)
+	for i, param := range m.params {
+		var value interface{}
+		if i < len(n.args) {
+			arg, err := s.evalAt(n.pos, n.args[i])
+			if err != nil {
+				return err
+			}
+			value = arg
+		}
+		mixinScope.vars[param] = value
+	}
+	oldScope, oldBlocks := s.scope, s.blocks
+	s.blocks = append(s.blocks, mixinBlock{nodes: n.block, scope: s.scope})
+	s.scope = mixinScope
+	defer func() {
+		s.scope, s.blocks = oldScope, oldBlocks
+	}()
+	return s.render(m.body)
+}
+
+func (s *state) renderEach(n *eachNode) error {
+	collection, err := s.evalAt(n.pos, n.collection)
+	if err != nil {
+		return err
+	}
+	oldScope := s.scope
+	defer func() {
+		s.scope = oldScope
+	}()
+	count := 0
+	if err := iterate(collection, func(value interface{}, key interface{}) error {
+		count++
+		s.scope = newScope(oldScope)
+		s.scope.vars[n.valueName] = value
+		if n.keyName != "" {
+			s.scope.vars[n.keyName] = key
+		}
+		return s.render(n.children)
+	}); err != nil {
+		if count == 0 {
+			return s.errorf(n.pos, "%s", err)
+		}
+		return err
+	}
+	s.scope = oldScope
+	if count == 0 {
+		return s.render(n.els)
+	}
+	return nil
+}
+
+func (s *state) renderTag(n *tagNode) error {
+	s.w.WriteString("<" + n.name)
+	if err := s.renderAttrs(n); err != nil {
+		return err
+	}
+	if n.selfClose || (voidElements[n.name] && len(n.children) == 0) {
+		if s.terse {
+			s.w.WriteString(">")
+		} else {
+			s.w.WriteString("/>")
+		}
+		return nil
+	}
+	s.w.WriteString(">")
+	if err := s.render(n.children); err != nil {
+		return err
+	}
+	s.w.WriteString("</" + n.name + ">")
+	return nil
+}
+
+// renderAttrs writes the attributes of a tag in order. All of the classes
+// are combined into a single class attribute at the position of the first
+// one. Attributes which are false or null are omitted.
+func (s *state) renderAttrs(n *tagNode) error {
+	classes := []string{}
+	classPos := -1
+	attrs := []string{}
+	for _, a := range n.attrs {
+		value, err := s.evalAt(n.pos, a.value)
+		if err != nil {
+			return err
+		}
+		if a.name == "class" {
+			if classPos == -1 {
+				classPos = len(attrs)
+				attrs = append(attrs, "")
+			}
+			classes = append(classes, classNames(value, a.escape)...)
+			continue
+		}
+		if isNil(value) || value == false {
+			continue
+		}
+		if value == true {
+			if s.terse {
+				attrs = append(attrs, " "+a.name)
+			} else {
+				attrs = append(attrs, fmt.Sprintf(` %s="%s"`, a.name, a.name))
+			}
+			continue
+		}
+		str := toString(value)
+		if a.escape {
+			str = htmlEscaper.Replace(str)
+		}
+		attrs = append(attrs, fmt.Sprintf(` %s="%s"`, a.name, str))
+	}
+	if len(classes) > 0 {
+		attrs[classPos] = fmt.Sprintf(` class="%s"`, strings.Join(classes, " "))
+	}
+	for _, a := range attrs {
+		s.w.WriteString(a)
+	}
+	return nil
+}
+
+// classNames returns the class names in value, which may be a string or a
+// slice of strings. Empty, false, and null values are omitted.
+func classNames(value interface{}, escape bool) []string {
+	names := []string{}
+	if isNil(value) || value == false {
+		return names
+	}
+	if v := indirect(reflect.ValueOf(value)); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			names = append(names, classNames(v.Index(i).Interface(), escape)...)
+		}
+		return names
+	}
+	name := toString(value)
+	if escape {
+		name = htmlEscaper.Replace(name)
+	}
+	if name != "" {
+		names = append(names, name)
+	}
+	return names
+}
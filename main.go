@@ -16,13 +16,24 @@ var (
 
 	versionCmd = app.Command("version", "Display version information and then quit.")
 
-	serveCmd   = app.Command("serve", "Compile and serve the site.")
-	servePort  = serveCmd.Flag("port", "The port on which to serve the site.").Short('p').Default("4000").Int()
-	serveTrace = serveCmd.Flag("trace", "Whether or not to print a full stack trace when there is an error.").Short('t').Default("false").Bool()
+	serveCmd      = app.Command("serve", "Compile and serve the site.")
+	servePort     = serveCmd.Flag("port", "The port on which to serve the site.").Short('p').Default("4000").Int()
+	serveTrace    = serveCmd.Flag("trace", "Whether or not to print a full stack trace when there is an error.").Short('t').Default("false").Bool()
+	serveDrafts   = serveCmd.Flag("drafts", "Whether or not to include draft posts.").Default("false").Bool()
+	serveFuture   = serveCmd.Flag("future", "Whether or not to include posts with a date in the future.").Default("false").Bool()
+	serveJobs     = serveCmd.Flag("jobs", "The maximum number of files to compile at once. Defaults to the number of CPUs.").Short('j').Default("0").Int()
+	serveMemory   = serveCmd.Flag("memory", "Whether or not to keep the compiled site in memory instead of writing it to destDir.").Short('m').Default("false").Bool()
+	serveHost     = serveCmd.Flag("host", "The address of the interface to serve the site on, e.g. 127.0.0.1. Defaults to all interfaces.").Default("").String()
+	serveBasePath = serveCmd.Flag("base-path", "The url path to serve the site under, e.g. /blog for a site which is deployed to a subdirectory.").Short('b').Default("").String()
+	serveGzip     = serveCmd.Flag("gzip", "Whether or not to compress responses with gzip.").Short('z').Default("false").Bool()
+	serveLog      = serveCmd.Flag("log", "Whether or not to log each request.").Short('l').Default("false").Bool()
 
-	compileCmd   = app.Command("compile", "Compile the site.")
-	compileWatch = compileCmd.Flag("watch", "Whether or not to watch for changes and automatically recompile.").Short('w').Default("").Bool()
-	compileTrace = compileCmd.Flag("trace", "Whether or not to print a full stack trace when there is an error.").Short('t').Default("false").Bool()
+	compileCmd    = app.Command("compile", "Compile the site.")
+	compileWatch  = compileCmd.Flag("watch", "Whether or not to watch for changes and automatically recompile.").Short('w').Default("").Bool()
+	compileTrace  = compileCmd.Flag("trace", "Whether or not to print a full stack trace when there is an error.").Short('t').Default("false").Bool()
+	compileDrafts = compileCmd.Flag("drafts", "Whether or not to include draft posts.").Default("false").Bool()
+	compileFuture = compileCmd.Flag("future", "Whether or not to include posts with a date in the future.").Default("false").Bool()
+	compileJobs   = compileCmd.Flag("jobs", "The maximum number of files to compile at once. Defaults to the number of CPUs.").Short('j').Default("0").Int()
 )
 
 const (
@@ -52,8 +63,7 @@ func main() {
 			<-done
 		}
 	case serveCmd.FullCommand():
-		compile(true)
-		serve(*servePort)
+		serve(compile(true), *servePort)
 	default:
 		app.Usage(os.Stdout)
 		os.Exit(0)
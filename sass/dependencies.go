@@ -0,0 +1,149 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// importRule matches an @import, @use, or @forward rule, along with
+// everything up to the end of the rule
+var importRule = regexp.MustCompile(`@(import|use|forward)\s+([^;{}]*)`)
+
+// Dependencies returns the path of the scss file at path and of every file it
+// imports, directly or indirectly, with @import, @use, or @forward. Imports
+// are resolved the same way as Compile does, using loadPaths. If an import
+// can't be found, the paths where it was looked for are included instead, so
+// that creating the missing file can be detected. Unlike Compile, it does
+// not evaluate anything, so it works for any file that sassc can compile,
+// and it returns an error only if a file can't be read.
+func Dependencies(path string, loadPaths []string) ([]string, error) {
+	deps := []string{}
+	seen := map[string]bool{}
+	var visit func(path string) error
+	visit = func(path string) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		deps = append(deps, path)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, name := range importedNames(string(content)) {
+			candidates := importCandidates(path, name, loadPaths)
+			found := false
+			for _, candidate := range candidates {
+				if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+					if err := visit(candidate); err != nil {
+						return err
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				for _, candidate := range candidates {
+					if !seen[candidate] {
+						seen[candidate] = true
+						deps = append(deps, candidate)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := visit(path); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// importedNames returns the names of the files imported by the scss in src,
+// excluding imports of plain css files and built-in modules, e.g. sass:math
+func importedNames(src string) []string {
+	names := []string{}
+	for _, match := range importRule.FindAllStringSubmatch(stripComments(src), -1) {
+		items := splitTopLevel(match[2], ',')
+		if match[1] != "import" {
+			// @use and @forward only take one url, followed by options, e.g.
+			// @use "colors" as c;
+			items = items[:1]
+		}
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			if item == "" || (item[0] != '"' && item[0] != '\'') {
+				continue
+			}
+			end := stringEnd(item, 0)
+			if end == -1 {
+				continue
+			}
+			name := item[1:end]
+			if isCSSImport(name) || strings.HasPrefix(name, "sass:") || strings.Contains(name, "#{") {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// stripComments removes the comments from src, leaving strings and unquoted
+// urls (which may contain //) intact
+func stripComments(src string) string {
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '"' || src[i] == '\'':
+			end := stringEnd(src, i)
+			if end == -1 {
+				end = len(src) - 1
+			}
+			b.WriteString(src[i : end+1])
+			i = end
+		case isUnquotedUrl(src, i):
+			end := strings.IndexByte(src[i:], ')')
+			if end == -1 {
+				end = len(src) - i - 1
+			}
+			b.WriteString(src[i : i+end+1])
+			i += end
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				return b.String()
+			}
+			i += end - 1
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return b.String()
+			}
+			i += end + 3
+		default:
+			b.WriteByte(src[i])
+		}
+	}
+	return b.String()
+}
+
+// isUnquotedUrl returns true iff an unquoted url, e.g. url(http://a.com/b.png),
+// starts at offset i in src
+func isUnquotedUrl(src string, i int) bool {
+	if !strings.HasPrefix(src[i:], "url(") {
+		return false
+	}
+	if i > 0 && isNameChar(src[i-1]) {
+		// e.g. a function called my-url(...)
+		return false
+	}
+	arg := strings.TrimLeft(src[i+len("url("):], " \t\n\r\f")
+	return arg != "" && arg[0] != '"' && arg[0] != '\''
+}
@@ -0,0 +1,925 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/log"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// env holds the variables, mixins, and functions which are defined in a
+// scope. Each rule, mixin, and function has its own scope.
+type env struct {
+	vars      map[string]value
+	mixins    map[string]*callable
+	functions map[string]*callable
+	parent    *env
+	// flow is true for the scopes of control directives, e.g. @each, which
+	// may assign to the variables in the scope which contains them
+	flow bool
+}
+
+func newEnv(parent *env) *env {
+	return &env{
+		vars:      map[string]value{},
+		mixins:    map[string]*callable{},
+		functions: map[string]*callable{},
+		parent:    parent,
+	}
+}
+
+// callable is a mixin or function, along with the scope where it was
+// defined
+type callable struct {
+	name   string
+	params []param
+	body   []stmt
+	env    *env
+}
+
+func (e *env) lookup(name string) (value, bool) {
+	for ; e != nil; e = e.parent {
+		if v, found := e.vars[name]; found {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (e *env) mixin(name string) *callable {
+	for ; e != nil; e = e.parent {
+		if m, found := e.mixins[name]; found {
+			return m
+		}
+	}
+	return nil
+}
+
+func (e *env) function(name string) *callable {
+	for ; e != nil; e = e.parent {
+		if f, found := e.functions[name]; found {
+			return f
+		}
+	}
+	return nil
+}
+
+// set assigns v to the variable with the given name. If global is false, it
+// assigns to an existing variable in a local scope, or an existing global
+// variable if there are only control directives in between. Otherwise it
+// defines a new variable in the current scope.
+func (e *env) set(name string, v value, global bool) {
+	if global {
+		for e.parent != nil {
+			e = e.parent
+		}
+		e.vars[name] = v
+		return
+	}
+	flow := true
+	for s := e; s != nil; s = s.parent {
+		if _, found := s.vars[name]; found && (s.parent != nil || flow) {
+			s.vars[name] = v
+			return
+		}
+		if !s.flow {
+			flow = false
+		}
+	}
+	e.vars[name] = v
+}
+
+// frame is the context in which statements are evaluated
+type frame struct {
+	env *env
+	// selectors are the selectors of the enclosing rule, or nil if there is
+	// none
+	selectors []string
+	// decls is where declarations are added, or nil if they are not allowed
+	decls *[]cssDecl
+	// out is where rules are added
+	out *[]cssNode
+	// mediaOut is where @media rules are added, i.e. outside of any other
+	// @media rule
+	mediaOut *[]cssNode
+	// media is the query of the enclosing @media rule, if any
+	media string
+	// mediaDepth is the depth of the enclosing @media rule
+	mediaDepth int
+	// depth is the nesting depth of the rules which are added, used to
+	// indent the output
+	depth int
+	// propPrefix is the prefix for nested properties, e.g. "font-"
+	propPrefix string
+	// content is the block passed to the enclosing mixin, if any
+	content *contentBlock
+	// inFunction is true while evaluating the body of a function
+	inFunction bool
+}
+
+// contentBlock is the block passed to a mixin, along with the frame it
+// should be evaluated in
+type contentBlock struct {
+	stmts []stmt
+	env   *env
+	outer *contentBlock
+}
+
+// extension is a selector which extends another selector via @extend
+type extension struct {
+	pos
+	target    string
+	extenders []string
+	optional  bool
+	matched   bool
+}
+
+// compile evaluates stmts and stores the resulting css in c.root
+func (c *compiler) compile(stmts []stmt) error {
+	f := frame{env: c.global, out: &c.root, mediaOut: &c.root}
+	if _, err := c.evalStmts(f, stmts); err != nil {
+		return err
+	}
+	return c.applyExtensions()
+}
+
+// evalStmts evaluates each statement in stmts. If one of them is a @return,
+// it stops and returns the value.
+func (c *compiler) evalStmts(f frame, stmts []stmt) (value, error) {
+	for _, s := range stmts {
+		result, err := c.evalStmt(f, s)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+	}
+	return nil, nil
+}
+
+// scoped returns a copy of f with a new scope
+func (f frame) scoped(flow bool) frame {
+	f.env = newEnv(f.env)
+	f.env.flow = flow
+	return f
+}
+
+func (c *compiler) evalStmt(f frame, s stmt) (value, error) {
+	if f.inFunction {
+		switch s := s.(type) {
+		case varStmt, returnStmt, ifStmt, eachStmt, forStmt, whileStmt, messageStmt:
+		default:
+			return nil, c.errorf(stmtPos(s), "Functions can only contain variable declarations and control directives.")
+		}
+	}
+	switch s := s.(type) {
+	case varStmt:
+		if s.isDefault {
+			if v, found := f.env.lookup(s.name); found && !isNull(v) {
+				return nil, nil
+			}
+		}
+		v, err := c.eval(f, s.value)
+		if err != nil {
+			return nil, c.wrap(s.pos, err)
+		}
+		f.env.set(s.name, v, s.global)
+	case returnStmt:
+		v, err := c.eval(f, s.value)
+		if err != nil {
+			return nil, c.wrap(s.pos, err)
+		}
+		return v, nil
+	case ruleStmt:
+		return nil, c.evalRule(f, s)
+	case declStmt:
+		return nil, c.evalDecl(f, s)
+	case commentStmt:
+		if f.decls != nil && f.selectors != nil {
+			*f.decls = append(*f.decls, cssDecl{pos: s.pos, value: s.text})
+		} else {
+			*f.out = append(*f.out, &cssComment{pos: s.pos, text: s.text, depth: f.depth})
+		}
+	case importStmt:
+		for _, path := range s.paths {
+			if err := c.evalImport(f, s.pos, path); err != nil {
+				return nil, err
+			}
+		}
+	case mixinStmt:
+		f.env.mixins[s.name] = &callable{name: s.name, params: s.params, body: s.children, env: f.env}
+	case functionStmt:
+		f.env.functions[s.name] = &callable{name: s.name, params: s.params, body: s.children, env: f.env}
+	case includeStmt:
+		return nil, c.evalInclude(f, s)
+	case contentStmt:
+		if f.content == nil {
+			return nil, nil
+		}
+		contentFrame := f
+		contentFrame.env = newEnv(f.content.env)
+		contentFrame.content = f.content.outer
+		_, err := c.evalStmts(contentFrame, f.content.stmts)
+		return nil, err
+	case ifStmt:
+		cond, err := c.eval(f, s.cond)
+		if err != nil {
+			return nil, c.wrap(s.pos, err)
+		}
+		if truthy(cond) {
+			return c.evalStmts(f, s.children)
+		}
+		return c.evalStmts(f, s.els)
+	case eachStmt:
+		return c.evalEach(f, s)
+	case forStmt:
+		return c.evalFor(f, s)
+	case whileStmt:
+		for i := 0; ; i++ {
+			if i > 100000 {
+				return nil, c.errorf(s.pos, "@while loop did not finish after %d iterations", i)
+			}
+			cond, err := c.eval(f, s.cond)
+			if err != nil {
+				return nil, c.wrap(s.pos, err)
+			}
+			if !truthy(cond) {
+				return nil, nil
+			}
+			if result, err := c.evalStmts(f.scoped(true), s.children); err != nil || result != nil {
+				return result, err
+			}
+		}
+	case mediaStmt:
+		return nil, c.evalMedia(f, s)
+	case extendStmt:
+		if f.selectors == nil {
+			return nil, c.errorf(s.pos, "Extend directives may only be used within rules.")
+		}
+		target, err := c.evalInterpolation(f, s.selector)
+		if err != nil {
+			return nil, c.wrap(s.pos, err)
+		}
+		for _, t := range splitTopLevel(target, ',') {
+			c.extensions = append(c.extensions, &extension{
+				pos:       s.pos,
+				target:    normalizeSelector(t),
+				extenders: f.selectors,
+				optional:  s.optional,
+			})
+		}
+	case messageStmt:
+		v, err := c.eval(f, s.value)
+		if err != nil {
+			return nil, c.wrap(s.pos, err)
+		}
+		msg, _ := toString(v, c.precision)
+		switch s.kind {
+		case "error":
+			return nil, c.errorf(s.pos, "%s", msg)
+		case "warn":
+			log.Warn.Printf("WARNING: %s\n        on line %d of %s", msg, s.line, s.path)
+		default:
+			log.Default.Printf("%s:%d DEBUG: %s", s.path, s.line, msg)
+		}
+	case atRuleStmt:
+		return nil, c.evalAtRule(f, s)
+	default:
+		return nil, fmt.Errorf("unknown statement %T", s)
+	}
+	return nil, nil
+}
+
+// stmtPos returns the position of s
+func stmtPos(s stmt) pos {
+	switch s := s.(type) {
+	case ruleStmt:
+		return s.pos
+	case declStmt:
+		return s.pos
+	case commentStmt:
+		return s.pos
+	case importStmt:
+		return s.pos
+	case mixinStmt:
+		return s.pos
+	case includeStmt:
+		return s.pos
+	case contentStmt:
+		return s.pos
+	case functionStmt:
+		return s.pos
+	case mediaStmt:
+		return s.pos
+	case extendStmt:
+		return s.pos
+	case atRuleStmt:
+		return s.pos
+	}
+	return pos{}
+}
+
+func (c *compiler) evalRule(f frame, s ruleStmt) error {
+	text, err := c.evalInterpolation(f, s.selector)
+	if err != nil {
+		return c.wrap(s.pos, err)
+	}
+	selectors, err := resolveSelectors(f.selectors, text)
+	if err != nil {
+		return c.errorf(s.pos, "%s", err)
+	}
+	rule := &cssRule{pos: s.pos, selectors: selectors, depth: f.depth}
+	*f.out = append(*f.out, rule)
+	child := f.scoped(false)
+	child.selectors = selectors
+	child.decls = &rule.decls
+	child.depth = f.depth + 1
+	child.propPrefix = ""
+	_, err = c.evalStmts(child, s.children)
+	return err
+}
+
+func (c *compiler) evalDecl(f frame, s declStmt) error {
+	if f.decls == nil {
+		return c.errorf(s.pos, "Properties are only allowed within rules, directives, mixin includes, or other properties.")
+	}
+	name, err := c.evalInterpolation(f, s.name)
+	if err != nil {
+		return c.wrap(s.pos, err)
+	}
+	name = f.propPrefix + name
+	if s.value != nil {
+		var text string
+		if s.custom {
+			text, err = c.evalInterpolation(f, s.value)
+		} else {
+			var v value
+			if v, err = c.evalValue(f, s.value); err == nil {
+				text, err = c.format(v)
+			}
+		}
+		if err != nil {
+			return c.wrap(s.pos, err)
+		}
+		if text != "" {
+			*f.decls = append(*f.decls, cssDecl{pos: s.pos, prop: name, value: text})
+		}
+	}
+	if len(s.children) > 0 {
+		child := f
+		child.propPrefix = name + "-"
+		if _, err := c.evalStmts(child, s.children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) evalImport(f frame, p pos, name string) error {
+	path, err := c.resolveImport(p.path, name)
+	if err != nil {
+		return c.errorf(p, "%s", err)
+	}
+	for _, other := range c.importing {
+		if other == path {
+			return c.errorf(p, "An @import loop has been found: %s imports itself", path)
+		}
+	}
+	stmts, err := c.parseFile(path)
+	if err != nil {
+		return c.wrap(p, err)
+	}
+	c.importing = append(c.importing, p.path)
+	defer func() {
+		c.importing = c.importing[:len(c.importing)-1]
+	}()
+	_, err = c.evalStmts(f, stmts)
+	return err
+}
+
+func (c *compiler) evalInclude(f frame, s includeStmt) error {
+	m := f.env.mixin(s.name)
+	if m == nil {
+		return c.errorf(s.pos, "no mixin named %s", s.name)
+	}
+	mixinEnv := newEnv(m.env)
+	if err := c.bindArgs(f, mixinEnv, m, s.args); err != nil {
+		return c.wrap(s.pos, err)
+	}
+	child := f
+	child.env = mixinEnv
+	if s.content != nil {
+		child.content = &contentBlock{stmts: s.content, env: f.env, outer: f.content}
+	} else {
+		child.content = nil
+	}
+	_, err := c.evalStmts(child, m.body)
+	return err
+}
+
+func (c *compiler) evalEach(f frame, s eachStmt) (value, error) {
+	collection, err := c.eval(f, s.collection)
+	if err != nil {
+		return nil, c.wrap(s.pos, err)
+	}
+	for _, item := range listItems(collection) {
+		child := f.scoped(true)
+		if len(s.names) == 1 {
+			child.env.vars[s.names[0]] = item
+		} else {
+			// destructure the item, e.g. @each $key, $value in $map
+			values := listItems(item)
+			for i, name := range s.names {
+				if i < len(values) {
+					child.env.vars[name] = values[i]
+				} else {
+					child.env.vars[name] = null{}
+				}
+			}
+		}
+		if result, err := c.evalStmts(child, s.children); err != nil || result != nil {
+			return result, err
+		}
+	}
+	return nil, nil
+}
+
+func (c *compiler) evalFor(f frame, s forStmt) (value, error) {
+	fromValue, err := c.eval(f, s.from)
+	if err != nil {
+		return nil, c.wrap(s.pos, err)
+	}
+	toValue, err := c.eval(f, s.to)
+	if err != nil {
+		return nil, c.wrap(s.pos, err)
+	}
+	from, ok := fromValue.(number)
+	to, ok2 := toValue.(number)
+	if !ok || !ok2 {
+		return nil, c.errorf(s.pos, "@for requires numbers for its start and end")
+	}
+	start, end := int(math.Round(from.v)), int(math.Round(to.v))
+	step := 1
+	if start > end {
+		step = -1
+	}
+	if !s.inclusive {
+		end -= step
+	}
+	for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+		child := f.scoped(true)
+		child.env.vars[s.name] = number{v: float64(i), unit: from.unit}
+		if result, err := c.evalStmts(child, s.children); err != nil || result != nil {
+			return result, err
+		}
+	}
+	return nil, nil
+}
+
+// mediaFeature matches an expression in a media query, e.g. (min-width: $md)
+var mediaFeature = regexp.MustCompile(`\(\s*([a-zA-Z-]+)\s*:\s*([^()]*(?:\([^()]*\)[^()]*)*)\)`)
+
+func (c *compiler) evalMedia(f frame, s mediaStmt) error {
+	query, err := c.evalInterpolation(f, s.query)
+	if err != nil {
+		return c.wrap(s.pos, err)
+	}
+	// evaluate any sass expressions in the features, e.g. (min-width: $md)
+	var featureErr error
+	query = mediaFeature.ReplaceAllStringFunc(query, func(feature string) string {
+		match := mediaFeature.FindStringSubmatch(feature)
+		e, err := parseExpr(match[2])
+		if err != nil {
+			featureErr = err
+			return feature
+		}
+		v, err := c.eval(f, e)
+		if err == nil {
+			var text string
+			if text, err = c.format(v); err == nil {
+				return "(" + match[1] + ": " + text + ")"
+			}
+		}
+		featureErr = err
+		return feature
+	})
+	if featureErr != nil {
+		return c.wrap(s.pos, featureErr)
+	}
+	query = strings.Join(strings.Fields(query), " ")
+	if f.media != "" {
+		query = f.media + " and " + query
+	}
+	return c.evalNestedAtRule(f, s.pos, &cssAtRule{name: "media", params: query, hasBlock: true}, s.children, true)
+}
+
+// evalNestedAtRule evaluates the children of an at-rule which has a block.
+// If bubble is true, any declarations inside of it are wrapped in a rule
+// with the selectors of the enclosing rule, e.g. for @media or @supports.
+func (c *compiler) evalNestedAtRule(f frame, p pos, at *cssAtRule, children []stmt, bubble bool) error {
+	at.pos, at.depth = p, f.depth
+	child := f.scoped(false)
+	child.out = &at.children
+	child.depth = f.depth + 1
+	if at.name == "media" {
+		if f.media != "" {
+			// nested @media rules are merged with the outer one
+			at.depth = f.mediaDepth
+			child.depth = at.depth + 1
+		}
+		*f.mediaOut = append(*f.mediaOut, at)
+		child.media = at.params
+		child.mediaDepth = at.depth
+	} else {
+		*f.out = append(*f.out, at)
+		child.mediaOut = child.out
+	}
+	if bubble && f.selectors != nil {
+		rule := &cssRule{pos: p, selectors: f.selectors, depth: child.depth}
+		at.children = append(at.children, rule)
+		child.decls = &rule.decls
+		child.depth++
+	} else if bubble {
+		child.decls = nil
+	} else {
+		child.selectors = nil
+		child.decls = &at.decls
+	}
+	_, err := c.evalStmts(child, children)
+	return err
+}
+
+func (c *compiler) evalAtRule(f frame, s atRuleStmt) error {
+	params, err := c.evalInterpolation(f, s.params)
+	if err != nil {
+		return c.wrap(s.pos, err)
+	}
+	params = strings.Join(strings.Fields(params), " ")
+	if s.name == "at-root" {
+		// evaluate the block without the enclosing selectors
+		child := f
+		child.selectors = nil
+		child.decls = nil
+		child.depth = 0
+		child.out, child.mediaOut = &c.root, &c.root
+		if params != "" {
+			rule := ruleStmt{pos: s.pos, selector: literal{v: str{s: params}}, children: s.children}
+			_, err := c.evalStmt(child, rule)
+			return err
+		}
+		_, err := c.evalStmts(child, s.children)
+		return err
+	}
+	at := &cssAtRule{name: s.name, params: params, hasBlock: s.hasBlock}
+	if !s.hasBlock {
+		at.pos, at.depth = s.pos, f.depth
+		if s.name == "import" {
+			// css imports must come before everything else
+			c.root = append([]cssNode{at}, c.root...)
+		} else {
+			*f.out = append(*f.out, at)
+		}
+		return nil
+	}
+	return c.evalNestedAtRule(f, s.pos, at, s.children, s.name == "supports" || s.name == "document")
+}
+
+// bindArgs evaluates the arguments for a mixin or function and assigns them
+// to the parameters in e
+func (c *compiler) bindArgs(f frame, e *env, fn *callable, args []argument) error {
+	positional, keywords, err := c.evalArgs(f, args)
+	if err != nil {
+		return err
+	}
+	for i, p := range fn.params {
+		switch {
+		case p.rest:
+			rest := []value{}
+			if i < len(positional) {
+				rest = positional[i:]
+			}
+			e.vars[p.name] = list{items: rest, sep: ","}
+			positional = positional[:i]
+		case i < len(positional):
+			e.vars[p.name] = positional[i]
+		default:
+			if v, found := keywords[p.name]; found {
+				e.vars[p.name] = v
+				delete(keywords, p.name)
+				continue
+			}
+			if p.def == nil {
+				return fmt.Errorf("%s() is missing argument $%s.", fn.name, p.name)
+			}
+			v, err := c.eval(frame{env: e}, p.def)
+			if err != nil {
+				return err
+			}
+			e.vars[p.name] = v
+		}
+	}
+	if len(fn.params) == 0 || !fn.params[len(fn.params)-1].rest {
+		if len(positional) > len(fn.params) {
+			return fmt.Errorf("%s() takes %d arguments but %d were passed.", fn.name, len(fn.params), len(positional))
+		}
+	}
+	for name := range keywords {
+		return fmt.Errorf("%s() has no argument named $%s.", fn.name, name)
+	}
+	return nil
+}
+
+// evalArgs evaluates args and returns the positional and keyword arguments.
+// Variable arguments, e.g. $list..., are expanded.
+func (c *compiler) evalArgs(f frame, args []argument) ([]value, map[string]value, error) {
+	positional := []value{}
+	keywords := map[string]value{}
+	for _, arg := range args {
+		v, err := c.eval(f, arg.value)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case arg.name != "":
+			keywords[arg.name] = v
+		case arg.rest:
+			if m, ok := v.(sassMap); ok {
+				for i, key := range m.keys {
+					name, _ := toString(key, c.precision)
+					keywords[strings.TrimPrefix(name, "$")] = m.values[i]
+				}
+			} else {
+				positional = append(positional, listItems(v)...)
+			}
+		default:
+			positional = append(positional, v)
+		}
+	}
+	return positional, keywords, nil
+}
+
+// callFunction calls the function with the given name. User defined
+// functions take precedence over built-in functions, and any other function
+// is output as a plain css function, e.g. translate(10px, 20px).
+func (c *compiler) callFunction(f frame, e call) (value, error) {
+	if fn := f.env.function(e.name); fn != nil {
+		fnEnv := newEnv(fn.env)
+		if err := c.bindArgs(f, fnEnv, fn, e.args); err != nil {
+			return nil, err
+		}
+		result, err := c.evalStmts(frame{env: fnEnv, inFunction: true}, fn.body)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			return nil, fmt.Errorf("Function %s finished without @return", e.name)
+		}
+		return result, nil
+	}
+	if e.name == "if" && len(e.args) == 3 {
+		// if() only evaluates one of its branches
+		cond, err := c.eval(f, e.args[0].value)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return c.eval(f, e.args[1].value)
+		}
+		return c.eval(f, e.args[2].value)
+	}
+	positional, keywords, err := c.evalArgs(f, e.args)
+	if err != nil {
+		return nil, err
+	}
+	if b, found := builtins[e.name]; found {
+		args, err := b.bind(e.name, positional, keywords)
+		if err != nil {
+			return nil, err
+		}
+		return b.fn(c, args)
+	}
+	if len(keywords) > 0 {
+		return nil, fmt.Errorf("Plain CSS function %s() doesn't support keyword arguments", e.name)
+	}
+	formatted := make([]string, len(positional))
+	for i, arg := range positional {
+		if formatted[i], err = c.format(arg); err != nil {
+			return nil, err
+		}
+	}
+	return str{s: e.name + "(" + strings.Join(formatted, ", ") + ")"}, nil
+}
+
+// eval evaluates e in the frame f. A / between two numbers is always a
+// division, except in property values (see evalValue).
+func (c *compiler) eval(f frame, e expr) (value, error) {
+	switch e := e.(type) {
+	case literal:
+		return e.v, nil
+	case variable:
+		if e.name == "&" {
+			if f.selectors == nil {
+				return null{}, nil
+			}
+			items := make([]value, len(f.selectors))
+			for i, s := range f.selectors {
+				items[i] = str{s: s}
+			}
+			return list{items: items, sep: ","}, nil
+		}
+		v, found := f.env.lookup(e.name)
+		if !found {
+			return nil, fmt.Errorf("Undefined variable: \"$%s\".", e.name)
+		}
+		return v, nil
+	case interpolation:
+		s, err := c.evalInterpolation(f, e)
+		if err != nil {
+			return nil, err
+		}
+		return str{s: s, quoted: e.quoted}, nil
+	case concatenation:
+		s := ""
+		for _, part := range e.parts {
+			v, err := c.eval(f, part)
+			if err != nil {
+				return nil, err
+			}
+			text, err := toString(v, c.precision)
+			if err != nil {
+				return nil, err
+			}
+			s += text
+		}
+		return str{s: s}, nil
+	case call:
+		return c.callFunction(f, e)
+	case binary:
+		return c.evalBinary(f, e)
+	case unary:
+		operand, err := c.eval(f, e.operand)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "not":
+			return boolean(!truthy(operand)), nil
+		case "-":
+			if n, ok := operand.(number); ok {
+				return number{v: -n.v, unit: n.unit}, nil
+			}
+		case "+":
+			if n, ok := operand.(number); ok {
+				return n, nil
+			}
+		}
+		s, err := toString(operand, c.precision)
+		return str{s: e.op + s}, err
+	case listExpr:
+		items := make([]value, len(e.items))
+		for i, item := range e.items {
+			v, err := c.eval(f, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return list{items: items, sep: e.sep}, nil
+	case mapExpr:
+		m := sassMap{}
+		for i := range e.keys {
+			key, err := c.eval(f, e.keys[i])
+			if err != nil {
+				return nil, err
+			}
+			v, err := c.eval(f, e.values[i])
+			if err != nil {
+				return nil, err
+			}
+			m = m.set(key, v)
+		}
+		return m, nil
+	case parens:
+		return c.eval(f, e.inner)
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func (c *compiler) evalBinary(f frame, e binary) (value, error) {
+	left, err := c.eval(f, e.left)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "and":
+		if !truthy(left) {
+			return left, nil
+		}
+		return c.eval(f, e.right)
+	case "or":
+		if truthy(left) {
+			return left, nil
+		}
+		return c.eval(f, e.right)
+	}
+	right, err := c.eval(f, e.right)
+	if err != nil {
+		return nil, err
+	}
+	return operate(e.op, left, right)
+}
+
+// evalValue evaluates the value of a property. Unlike eval, a / between two
+// literal numbers is output as is, e.g. font: 12px/1.5.
+func (c *compiler) evalValue(f frame, e expr) (value, error) {
+	switch e := e.(type) {
+	case listExpr:
+		items := make([]value, len(e.items))
+		for i, item := range e.items {
+			v, err := c.evalValue(f, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return list{items: items, sep: e.sep}, nil
+	case binary:
+		if isLiteralSlash(e) {
+			return c.evalSlash(f, e)
+		}
+	}
+	return c.eval(f, e)
+}
+
+// isLiteralSlash returns true iff e is a / between literal numbers or
+// strings, e.g. 12px/1.5 or 1/2/3, which is not a division
+func isLiteralSlash(e expr) bool {
+	switch e := e.(type) {
+	case literal:
+		switch e.v.(type) {
+		case number, str:
+			return true
+		}
+	case binary:
+		return e.op == "/" && isLiteralSlash(e.left) && isLiteralSlash(e.right)
+	}
+	return false
+}
+
+func (c *compiler) evalSlash(f frame, e binary) (value, error) {
+	parts := []string{}
+	for _, side := range []expr{e.left, e.right} {
+		var v value
+		var err error
+		if b, ok := side.(binary); ok {
+			v, err = c.evalSlash(f, b)
+		} else {
+			v, err = c.eval(f, side)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := c.format(v)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, s)
+	}
+	return str{s: strings.Join(parts, "/")}, nil
+}
+
+// evalInterpolation evaluates e, which is usually an interpolation, and
+// returns the result as a string without quotes
+func (c *compiler) evalInterpolation(f frame, e expr) (string, error) {
+	interp, ok := e.(interpolation)
+	if !ok {
+		v, err := c.eval(f, e)
+		if err != nil {
+			return "", err
+		}
+		return toString(v, c.precision)
+	}
+	result := ""
+	for _, part := range interp.parts {
+		if text, ok := part.(string); ok {
+			result += text
+			continue
+		}
+		v, err := c.eval(f, part)
+		if err != nil {
+			return "", err
+		}
+		text, err := toString(v, c.precision)
+		if err != nil {
+			return "", err
+		}
+		result += text
+	}
+	return result, nil
+}
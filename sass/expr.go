@@ -0,0 +1,760 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expr is a parsed sass expression, e.g. the value of a property or the
+// arguments to a mixin.
+type expr interface{}
+
+type (
+	// literal is a value which was written literally, e.g. 10px or red
+	literal struct {
+		v value
+	}
+	// variable is a reference to a variable, e.g. $red
+	variable struct {
+		name string
+	}
+	// interpolation is a string made of literal text and interpolated
+	// expressions, e.g. "#{$font}, sans-serif" or url(#{$path}). Each part is
+	// either a string or an expr.
+	interpolation struct {
+		parts  []interface{}
+		quoted bool
+	}
+	// concatenation is several expressions next to each other without any
+	// whitespace, e.g. #{$size}px, which results in an unquoted string
+	concatenation struct {
+		parts []expr
+	}
+	// call is a call to a function, e.g. darken($red, 10%)
+	call struct {
+		name string
+		args []argument
+	}
+	// argument is an argument to a function or mixin. name is set for
+	// keyword arguments, and rest is true for variable arguments, e.g. $args...
+	argument struct {
+		name  string
+		value expr
+		rest  bool
+	}
+	binary struct {
+		op          string
+		left, right expr
+	}
+	unary struct {
+		op      string
+		operand expr
+	}
+	listExpr struct {
+		items []expr
+		sep   string
+	}
+	mapExpr struct {
+		keys, values []expr
+	}
+	// parens is an expression in parentheses. It is only used to tell
+	// whether a / is a division or a literal slash, e.g. in font: 12px/1.5
+	parens struct {
+		inner expr
+	}
+)
+
+type token struct {
+	// kind is one of "number", "color", "ident", "variable", "string",
+	// "interpolation", "raw", "flag", "op", or "eof"
+	kind  string
+	value string
+	// unit is the unit of a number
+	unit string
+	// space is true iff there was whitespace before the token
+	space bool
+}
+
+// ops is a list of all the operators, with the longest ones first so that
+// they take precedence
+var ops = []string{"...", "==", "!=", "<=", ">=", "+", "-", "*", "/", "%", "<", ">", "(", ")", ",", ":", "[", "]", "&", "="}
+
+// rawFunctions are the css functions whose arguments are not parsed as sass,
+// except for interpolation, e.g. calc(100% - 10px)
+var rawFunctions = map[string]bool{
+	"url": true, "calc": true, "-webkit-calc": true, "-moz-calc": true,
+	"expression": true, "element": true, "env": true, "var": true,
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 || c == '\\'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || c == '-' || c >= '0' && c <= '9'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// tokenize splits src into tokens
+func tokenize(src string) ([]token, error) {
+	tokens := []token{}
+	space := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		var next byte
+		if i+1 < len(src) {
+			next = src[i+1]
+		}
+		var t token
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			i++
+			continue
+		case c == '/' && next == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment in %q", src)
+			}
+			i += end + 4
+			space = true
+			continue
+		case isDigit(c) || c == '.' && isDigit(next) || c == '-' && isNegativeNumber(src, i, tokens, space):
+			i++
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.' && i+1 < len(src) && isDigit(src[i+1])) {
+				i++
+			}
+			numEnd := i
+			if i < len(src) && src[i] == '%' {
+				i++
+			} else {
+				for i < len(src) && (isNameStart(src[i]) || src[i] == '-' && i+1 < len(src) && isNameStart(src[i+1])) {
+					i++
+				}
+			}
+			t = token{kind: "number", value: src[start:numEnd], unit: src[numEnd:i]}
+		case c == '$':
+			i++
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			t = token{kind: "variable", value: src[start+1 : i]}
+		case c == '#' && next == '{':
+			end := matchingBrace(src, i+1)
+			if end == -1 {
+				return nil, fmt.Errorf("missing } in %q", src)
+			}
+			t = token{kind: "interpolation", value: src[i+2 : end]}
+			i = end + 1
+		case c == '#':
+			i++
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			t = token{kind: "color", value: src[start:i]}
+		case c == '"' || c == '\'':
+			end := stringEnd(src, i)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated string in %q", src)
+			}
+			t = token{kind: "string", value: src[i+1 : end]}
+			i = end + 1
+		case c == '!' && next != '=':
+			i++
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			t = token{kind: "flag", value: strings.ToLower(src[start+1 : i])}
+		case isNameStart(c) || c == '-' && (isNameStart(next) || next == '-' || next == '#'):
+			i++
+			for i < len(src) && isNameChar(src[i]) {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				i++
+			}
+			name := src[start:i]
+			if i < len(src) && src[i] == '(' && rawFunctions[strings.ToLower(name)] {
+				end := matchingParen(src, i)
+				if end == -1 {
+					return nil, fmt.Errorf("missing ) in %q", src)
+				}
+				args := src[i+1 : end]
+				if !strings.Contains(args, "$") || strings.Contains(args, "#{") {
+					t = token{kind: "raw", value: src[start : end+1]}
+					i = end + 1
+					break
+				}
+			}
+			if name == "-" {
+				t = token{kind: "op", value: "-"}
+				break
+			}
+			t = token{kind: "ident", value: name}
+		default:
+			found := false
+			for _, op := range ops {
+				if strings.HasPrefix(src[i:], op) {
+					t = token{kind: "op", value: op}
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("Invalid CSS after %q: expected expression, was %q", src[:i], src[i:])
+			}
+		}
+		t.space = space
+		space = false
+		tokens = append(tokens, t)
+	}
+	return append(tokens, token{kind: "eof", space: space}), nil
+}
+
+// isNegativeNumber returns true iff the - at src[i] is the sign of a number
+// rather than an operator. It is a sign if it is followed by a digit and
+// comes after an operator or at the start, or if there is whitespace before
+// it but not after it, e.g. the second item in 1px -2px.
+func isNegativeNumber(src string, i int, tokens []token, space bool) bool {
+	if i+1 >= len(src) || !(isDigit(src[i+1]) || src[i+1] == '.' && i+2 < len(src) && isDigit(src[i+2])) {
+		return false
+	}
+	if len(tokens) == 0 {
+		return true
+	}
+	if prev := tokens[len(tokens)-1]; prev.kind == "op" && prev.value != ")" && prev.value != "]" {
+		return true
+	}
+	return space
+}
+
+// matchingBrace returns the index of the } which closes the { at src[start],
+// skipping over quoted strings. It returns -1 if there is none.
+func matchingBrace(src string, start int) int {
+	return matching(src, start, '{', '}')
+}
+
+// matchingParen returns the index of the ) which closes the ( at src[start],
+// skipping over quoted strings. It returns -1 if there is none.
+func matchingParen(src string, start int) int {
+	return matching(src, start, '(', ')')
+}
+
+func matching(src string, start int, open, close byte) int {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '"', '\'':
+			end := stringEnd(src, i)
+			if end == -1 {
+				return -1
+			}
+			i = end
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// stringEnd returns the index of the quote which ends the string starting at
+// src[start], or -1 if there is none.
+func stringEnd(src string, start int) int {
+	quote := src[start]
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		case '#':
+			if i+1 < len(src) && src[i+1] == '{' {
+				end := matchingBrace(src, i+1)
+				if end == -1 {
+					return -1
+				}
+				i = end
+			}
+		}
+	}
+	return -1
+}
+
+// exprParser is a recursive descent parser for sass expressions
+type exprParser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+// parseExpr parses src, which may be a comma separated list
+func parseExpr(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	e, err := p.parseCommaList()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.unexpected()
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == "op" && t.value == op
+}
+
+func (p *exprParser) accept(op string) bool {
+	if p.isOp(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("Invalid CSS in %q: expected %q, was %q", p.src, op, p.peek().value)
+	}
+	return nil
+}
+
+func (p *exprParser) unexpected() error {
+	t := p.peek()
+	if t.kind == "eof" {
+		return fmt.Errorf("Invalid CSS in %q: unexpected end of expression", p.src)
+	}
+	return fmt.Errorf("Invalid CSS in %q: unexpected %q", p.src, t.value)
+}
+
+// atListEnd returns true iff the next token ends a space separated list
+func (p *exprParser) atListEnd() bool {
+	t := p.peek()
+	if t.kind == "eof" {
+		return true
+	}
+	if t.kind == "op" {
+		switch t.value {
+		case ",", ")", "]", ":", "...":
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) parseCommaList() (expr, error) {
+	first, err := p.parseSpaceList()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp(",") {
+		return first, nil
+	}
+	items := []expr{first}
+	for p.accept(",") {
+		if p.atListEnd() {
+			// a trailing comma
+			break
+		}
+		item, err := p.parseSpaceList()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return listExpr{items: items, sep: ","}, nil
+}
+
+func (p *exprParser) parseSpaceList() (expr, error) {
+	first, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	items := []expr{first}
+	for !p.atListEnd() {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if len(items) == 1 {
+		return first, nil
+	}
+	return listExpr{items: items, sep: " "}, nil
+}
+
+// isKeyword returns true iff the next token is the identifier kw, e.g. and
+func (p *exprParser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == "ident" && t.value == kw
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+// binaryPrecedence holds the binary operators for each level of precedence,
+// from lowest to highest
+var binaryPrecedence = [][]string{
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (expr, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		matched := false
+		if t.kind == "op" {
+			for _, op := range binaryPrecedence[level] {
+				if t.value == op {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: t.value, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: "not", operand: operand}, nil
+	}
+	for _, op := range []string{"-", "+", "/"} {
+		if p.accept(op) {
+			operand, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return unary{op: op, operand: operand}, nil
+		}
+	}
+	return p.parseConcatenation()
+}
+
+// parseConcatenation parses a primary expression, along with any expressions
+// that are right next to it if either of them is an interpolation, e.g.
+// #{$size}px or icon-#{$name}.
+func (p *exprParser) parseConcatenation() (expr, error) {
+	first, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	parts := []expr{first}
+	prevKind := p.tokens[p.pos-1].kind
+	for {
+		t := p.peek()
+		if t.space || (prevKind != "interpolation" && t.kind != "interpolation") {
+			break
+		}
+		if t.kind != "interpolation" && t.kind != "ident" && t.kind != "number" && t.kind != "string" && !(t.kind == "op" && t.value == "-") {
+			break
+		}
+		if t.kind == "op" {
+			// a hyphen, e.g. #{$prefix}-#{$name}
+			p.next()
+			parts = append(parts, literal{v: str{s: "-"}})
+		} else {
+			part, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		prevKind = t.kind
+	}
+	if len(parts) == 1 {
+		return first, nil
+	}
+	return concatenation{parts: parts}, nil
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		v, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %q", t.value)
+		}
+		return literal{v: number{v: v, unit: t.unit}}, nil
+	case "color":
+		c, ok := parseHexColor(t.value)
+		if !ok {
+			return literal{v: str{s: t.value}}, nil
+		}
+		return literal{v: c}, nil
+	case "variable":
+		return variable{name: t.value}, nil
+	case "string":
+		return parseInterpolation(t.value, true)
+	case "interpolation":
+		inner, err := parseExpr(t.value)
+		if err != nil {
+			return nil, err
+		}
+		return interpolation{parts: []interface{}{inner}}, nil
+	case "raw":
+		return parseInterpolation(t.value, false)
+	case "flag":
+		return literal{v: str{s: "!" + t.value}}, nil
+	case "ident":
+		if p.isOp("(") && !p.peek().space {
+			p.next()
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return call{name: t.value, args: args}, nil
+		}
+		switch t.value {
+		case "true":
+			return literal{v: boolean(true)}, nil
+		case "false":
+			return literal{v: boolean(false)}, nil
+		case "null":
+			return literal{v: null{}}, nil
+		}
+		if c, ok := namedColors[strings.ToLower(t.value)]; ok {
+			c.repr = t.value
+			return literal{v: c}, nil
+		}
+		return literal{v: str{s: t.value}}, nil
+	case "op":
+		switch t.value {
+		case "(":
+			return p.parseParens()
+		case "&":
+			return variable{name: "&"}, nil
+		}
+	}
+	p.pos--
+	if t.kind == "eof" {
+		p.pos = len(p.tokens) - 1
+	}
+	return nil, p.unexpected()
+}
+
+// parseParens parses the contents of parentheses, which may be a map
+func (p *exprParser) parseParens() (expr, error) {
+	if p.accept(")") {
+		return listExpr{sep: " "}, nil
+	}
+	first, err := p.parseSpaceList()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept(":") {
+		m := mapExpr{}
+		key := first
+		for {
+			value, err := p.parseSpaceList()
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, key)
+			m.values = append(m.values, value)
+			if !p.accept(",") || p.isOp(")") {
+				break
+			}
+			if key, err = p.parseSpaceList(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	inner := first
+	if p.isOp(",") {
+		items := []expr{first}
+		for p.accept(",") {
+			if p.isOp(")") {
+				break
+			}
+			item, err := p.parseSpaceList()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		inner = listExpr{items: items, sep: ","}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return parens{inner: inner}, nil
+}
+
+// parseArgs parses the arguments to a function or mixin, up to and including
+// the closing parenthesis
+func (p *exprParser) parseArgs() ([]argument, error) {
+	args := []argument{}
+	for !p.accept(")") {
+		arg := argument{}
+		if t := p.peek(); t.kind == "variable" && p.tokens[p.pos+1].kind == "op" && p.tokens[p.pos+1].value == ":" {
+			arg.name = t.value
+			p.pos += 2
+		}
+		value, err := p.parseSpaceList()
+		if err != nil {
+			return nil, err
+		}
+		arg.value = value
+		if p.accept("...") {
+			arg.rest = true
+		}
+		args = append(args, arg)
+		if !p.accept(",") {
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return args, nil
+}
+
+// parseInterpolation parses text which may contain #{...}
+func parseInterpolation(text string, quoted bool) (expr, error) {
+	parts := []interface{}{}
+	literalText := ""
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && quoted && i+1 < len(text) {
+			// keep escapes other than escaped quotes as is
+			if text[i+1] != '"' && text[i+1] != '\'' {
+				literalText += text[i : i+1]
+			}
+			i++
+			literalText += text[i : i+1]
+			continue
+		}
+		if text[i] == '#' && i+1 < len(text) && text[i+1] == '{' {
+			end := matchingBrace(text, i+1)
+			if end == -1 {
+				return nil, fmt.Errorf("missing } in %q", text)
+			}
+			inner, err := parseExpr(text[i+2 : end])
+			if err != nil {
+				return nil, err
+			}
+			if literalText != "" {
+				parts = append(parts, literalText)
+				literalText = ""
+			}
+			parts = append(parts, inner)
+			i = end
+			continue
+		}
+		literalText += text[i : i+1]
+	}
+	if len(parts) == 0 {
+		return literal{v: str{s: literalText, quoted: quoted}}, nil
+	}
+	if literalText != "" {
+		parts = append(parts, literalText)
+	}
+	return interpolation{parts: parts, quoted: quoted}, nil
+}
+
+// parseHexColor parses a color like #f00 or #ff0000
+func parseHexColor(s string) (color, bool) {
+	hex := s[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color{}, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color{}, false
+	}
+	return color{r: float64(v >> 16), g: float64(v >> 8 & 0xff), b: float64(v & 0xff), a: 1, repr: s}, true
+}
@@ -0,0 +1,783 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// builtin is a function which is built in to sass, e.g. darken
+type builtin struct {
+	// params are the names of the parameters, which may be used for keyword
+	// arguments. A name ending in "?" is optional and defaults to null, and a
+	// name ending in "..." takes any remaining arguments as a list.
+	params []string
+	fn     func(c *compiler, args []value) (value, error)
+}
+
+// bind matches the positional and keyword arguments for a call to the
+// builtin with the given name to its parameters
+func (b builtin) bind(name string, positional []value, keywords map[string]value) ([]value, error) {
+	args := make([]value, len(b.params))
+	for i, p := range b.params {
+		switch {
+		case strings.HasSuffix(p, "..."):
+			rest := []value{}
+			if i < len(positional) {
+				rest = positional[i:]
+			}
+			args[i] = list{items: rest, sep: ","}
+			positional = positional[:len(positional)-len(rest)]
+			continue
+		case i < len(positional):
+			args[i] = positional[i]
+			continue
+		}
+		p = strings.TrimSuffix(p, "?")
+		if v, found := keywords[p]; found {
+			args[i] = v
+			delete(keywords, p)
+		} else if strings.HasSuffix(b.params[i], "?") {
+			args[i] = null{}
+		} else {
+			return nil, fmt.Errorf("%s() is missing argument $%s.", name, p)
+		}
+	}
+	if len(positional) > len(b.params) {
+		return nil, fmt.Errorf("%s() takes %d arguments but %d were passed.", name, len(b.params), len(positional))
+	}
+	for p := range keywords {
+		return nil, fmt.Errorf("%s() has no argument named $%s.", name, p)
+	}
+	return args, nil
+}
+
+// builtins holds the built-in functions, keyed by name
+var builtins = map[string]builtin{
+	"rgb":  {[]string{"red", "green", "blue"}, rgbFunc},
+	"rgba": {[]string{"red", "green?", "blue?", "alpha?"}, rgbaFunc},
+	"hsl":  {[]string{"hue", "saturation", "lightness"}, hslFunc},
+	"hsla": {[]string{"hue", "saturation", "lightness", "alpha"}, hslFunc},
+	"red": {[]string{"color"}, colorGetter(func(c color) value {
+		return number{v: float64(clampChannel(c.r))}
+	})},
+	"green": {[]string{"color"}, colorGetter(func(c color) value {
+		return number{v: float64(clampChannel(c.g))}
+	})},
+	"blue": {[]string{"color"}, colorGetter(func(c color) value {
+		return number{v: float64(clampChannel(c.b))}
+	})},
+	"alpha": {[]string{"color"}, colorGetter(func(c color) value {
+		return number{v: c.a}
+	})},
+	"opacity": {[]string{"color"}, colorGetter(func(c color) value {
+		return number{v: c.a}
+	})},
+	"hue": {[]string{"color"}, colorGetter(func(c color) value {
+		h, _, _ := toHSL(c)
+		return number{v: h, unit: "deg"}
+	})},
+	"saturation": {[]string{"color"}, colorGetter(func(c color) value {
+		_, s, _ := toHSL(c)
+		return number{v: s, unit: "%"}
+	})},
+	"lightness": {[]string{"color"}, colorGetter(func(c color) value {
+		_, _, l := toHSL(c)
+		return number{v: l, unit: "%"}
+	})},
+	"lighten":        {[]string{"color", "amount"}, adjustHSL(0, 0, 1)},
+	"darken":         {[]string{"color", "amount"}, adjustHSL(0, 0, -1)},
+	"saturate":       {[]string{"color", "amount?"}, saturateFunc},
+	"desaturate":     {[]string{"color", "amount"}, adjustHSL(0, -1, 0)},
+	"adjust-hue":     {[]string{"color", "degrees"}, adjustHSL(1, 0, 0)},
+	"opacify":        {[]string{"color", "amount"}, adjustAlpha(1)},
+	"fade-in":        {[]string{"color", "amount"}, adjustAlpha(1)},
+	"transparentize": {[]string{"color", "amount"}, adjustAlpha(-1)},
+	"fade-out":       {[]string{"color", "amount"}, adjustAlpha(-1)},
+	"mix":            {[]string{"color1", "color2", "weight?"}, mixFunc},
+	"grayscale":      {[]string{"color"}, grayscaleFunc},
+	"complement":     {[]string{"color"}, complementFunc},
+	"invert":         {[]string{"color", "weight?"}, invertFunc},
+	"percentage":     {[]string{"number"}, percentageFunc},
+	"round":          {[]string{"number"}, mathFunc("round", math.Round)},
+	"ceil":           {[]string{"number"}, mathFunc("ceil", math.Ceil)},
+	"floor":          {[]string{"number"}, mathFunc("floor", math.Floor)},
+	"abs":            {[]string{"number"}, mathFunc("abs", math.Abs)},
+	"min":            {[]string{"numbers..."}, extremumFunc("min", "<")},
+	"max":            {[]string{"numbers..."}, extremumFunc("max", ">")},
+	"if":             {[]string{"condition", "if-true", "if-false"}, ifFunc},
+	"unquote":        {[]string{"string"}, unquoteFunc},
+	"quote":          {[]string{"string"}, quoteFunc},
+	"str-length":     {[]string{"string"}, strLengthFunc},
+	"to-upper-case":  {[]string{"string"}, caseFunc(strings.ToUpper)},
+	"to-lower-case":  {[]string{"string"}, caseFunc(strings.ToLower)},
+	"type-of":        {[]string{"value"}, typeOfFunc},
+	"unit":           {[]string{"number"}, unitFunc},
+	"unitless":       {[]string{"number"}, unitlessFunc},
+	"length":         {[]string{"list"}, lengthFunc},
+	"nth":            {[]string{"list", "n"}, nthFunc},
+	"join":           {[]string{"list1", "list2", "separator?"}, joinFunc},
+	"append":         {[]string{"list", "val", "separator?"}, appendFunc},
+	"index":          {[]string{"list", "value"}, indexFunc},
+	"map-get":        {[]string{"map", "key"}, mapGetFunc},
+	"map-merge":      {[]string{"map1", "map2"}, mapMergeFunc},
+	"map-keys":       {[]string{"map"}, mapKeysFunc},
+	"map-values":     {[]string{"map"}, mapValuesFunc},
+	"map-has-key":    {[]string{"map", "key"}, mapHasKeyFunc},
+	"map-remove":     {[]string{"map", "keys..."}, mapRemoveFunc},
+}
+
+// argument helpers
+
+func colorArg(fn string, name string, v value) (color, error) {
+	c, ok := v.(color)
+	if !ok {
+		s, _ := inspect(v)
+		return color{}, fmt.Errorf("$%s: %s is not a color for `%s'", name, s, fn)
+	}
+	return c, nil
+}
+
+func numberArg(fn string, name string, v value) (number, error) {
+	n, ok := v.(number)
+	if !ok {
+		s, _ := inspect(v)
+		return number{}, fmt.Errorf("$%s: %s is not a number for `%s'", name, s, fn)
+	}
+	return n, nil
+}
+
+func mapArg(fn string, name string, v value) (sassMap, error) {
+	switch v := v.(type) {
+	case sassMap:
+		return v, nil
+	case list:
+		if len(v.items) == 0 {
+			// an empty list is also an empty map
+			return sassMap{}, nil
+		}
+	}
+	s, _ := inspect(v)
+	return sassMap{}, fmt.Errorf("$%s: %s is not a map for `%s'", name, s, fn)
+}
+
+// channel returns n as a value between 0 and 255 for a color channel. A
+// percentage is relative to 255.
+func channel(n number) float64 {
+	if n.unit == "%" {
+		return clampFloat(n.v*255/100, 0, 255)
+	}
+	return clampFloat(n.v, 0, 255)
+}
+
+// fraction returns n as a value between 0 and 1, e.g. for an alpha channel
+// or an amount. A percentage is relative to 100.
+func fraction(n number) float64 {
+	if n.unit == "%" {
+		return clampFloat(n.v/100, 0, 1)
+	}
+	return clampFloat(n.v, 0, 1)
+}
+
+// plainCall returns a call to a plain css function with the given arguments,
+// for when a sass function has the same name as a css function, e.g.
+// grayscale(50%) for filters
+func plainCall(c *compiler, name string, args ...value) (value, error) {
+	formatted := []string{}
+	for _, arg := range args {
+		if isNull(arg) {
+			continue
+		}
+		s, err := c.format(arg)
+		if err != nil {
+			return nil, err
+		}
+		formatted = append(formatted, s)
+	}
+	return str{s: name + "(" + strings.Join(formatted, ", ") + ")"}, nil
+}
+
+// color functions
+
+func rgbFunc(c *compiler, args []value) (value, error) {
+	result := color{a: 1}
+	channels := []*float64{&result.r, &result.g, &result.b}
+	for i, arg := range args {
+		n, err := numberArg("rgb", []string{"red", "green", "blue"}[i], arg)
+		if err != nil {
+			return nil, err
+		}
+		*channels[i] = channel(n)
+	}
+	return result, nil
+}
+
+func rgbaFunc(c *compiler, args []value) (value, error) {
+	if base, ok := args[0].(color); ok && isNull(args[2]) {
+		// rgba($color, $alpha)
+		alpha, err := numberArg("rgba", "alpha", args[1])
+		if err != nil {
+			return nil, err
+		}
+		base.a = fraction(alpha)
+		base.repr = ""
+		return base, nil
+	}
+	for i, name := range []string{"red", "green", "blue", "alpha"} {
+		if isNull(args[i]) {
+			return nil, fmt.Errorf("rgba() is missing argument $%s.", name)
+		}
+	}
+	result, err := rgbFunc(c, args[:3])
+	if err != nil {
+		return nil, err
+	}
+	alpha, err := numberArg("rgba", "alpha", args[3])
+	if err != nil {
+		return nil, err
+	}
+	rgb := result.(color)
+	rgb.a = fraction(alpha)
+	return rgb, nil
+}
+
+func hslFunc(c *compiler, args []value) (value, error) {
+	nums := make([]number, len(args))
+	for i, arg := range args {
+		n, err := numberArg("hsl", []string{"hue", "saturation", "lightness", "alpha"}[i], arg)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = n
+	}
+	alpha := 1.0
+	if len(nums) == 4 {
+		alpha = fraction(nums[3])
+	}
+	return fromHSL(nums[0].v, nums[1].v, nums[2].v, alpha), nil
+}
+
+func colorGetter(get func(c color) value) func(*compiler, []value) (value, error) {
+	return func(c *compiler, args []value) (value, error) {
+		col, err := colorArg("color", "color", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return get(col), nil
+	}
+}
+
+// adjustHSL returns a function which adds its amount to the hue,
+// saturation, or lightness of a color, depending on which of the
+// multipliers are non-zero
+func adjustHSL(hue, saturation, lightness float64) func(*compiler, []value) (value, error) {
+	return func(c *compiler, args []value) (value, error) {
+		col, err := colorArg("adjust", "color", args[0])
+		if err != nil {
+			return nil, err
+		}
+		amount, err := numberArg("adjust", "amount", args[1])
+		if err != nil {
+			return nil, err
+		}
+		if hue == 0 && (amount.v < 0 || amount.v > 100) {
+			return nil, fmt.Errorf("Amount %s must be between 0%% and 100%%", formatNumber(amount, c.precision, false))
+		}
+		h, s, l := toHSL(col)
+		h += hue * amount.v
+		s = clampFloat(s+saturation*amount.v, 0, 100)
+		l = clampFloat(l+lightness*amount.v, 0, 100)
+		return fromHSL(h, s, l, col.a), nil
+	}
+}
+
+func saturateFunc(c *compiler, args []value) (value, error) {
+	if n, ok := args[0].(number); ok && isNull(args[1]) {
+		// the css filter function, e.g. saturate(50%)
+		return plainCall(c, "saturate", n)
+	}
+	if isNull(args[1]) {
+		return nil, fmt.Errorf("saturate() is missing argument $amount.")
+	}
+	return adjustHSL(0, 1, 0)(c, args)
+}
+
+// adjustAlpha returns a function which adds its amount, times sign, to the
+// alpha channel of a color
+func adjustAlpha(sign float64) func(*compiler, []value) (value, error) {
+	return func(c *compiler, args []value) (value, error) {
+		col, err := colorArg("opacify", "color", args[0])
+		if err != nil {
+			return nil, err
+		}
+		amount, err := numberArg("opacify", "amount", args[1])
+		if err != nil {
+			return nil, err
+		}
+		col.a = clampFloat(col.a+sign*fraction(amount), 0, 1)
+		col.repr = ""
+		return col, nil
+	}
+}
+
+func mixFunc(c *compiler, args []value) (value, error) {
+	c1, err := colorArg("mix", "color1", args[0])
+	if err != nil {
+		return nil, err
+	}
+	c2, err := colorArg("mix", "color2", args[1])
+	if err != nil {
+		return nil, err
+	}
+	weight := 0.5
+	if !isNull(args[2]) {
+		n, err := numberArg("mix", "weight", args[2])
+		if err != nil {
+			return nil, err
+		}
+		weight = fraction(n)
+	}
+	// the same algorithm as ruby sass, which takes the alpha channels into
+	// account
+	w := weight*2 - 1
+	a := c1.a - c2.a
+	var w1 float64
+	if w*a == -1 {
+		w1 = (w + 1) / 2
+	} else {
+		w1 = ((w+a)/(1+w*a) + 1) / 2
+	}
+	w2 := 1 - w1
+	return color{
+		r: c1.r*w1 + c2.r*w2,
+		g: c1.g*w1 + c2.g*w2,
+		b: c1.b*w1 + c2.b*w2,
+		a: c1.a*weight + c2.a*(1-weight),
+	}, nil
+}
+
+func grayscaleFunc(c *compiler, args []value) (value, error) {
+	if n, ok := args[0].(number); ok {
+		return plainCall(c, "grayscale", n)
+	}
+	col, err := colorArg("grayscale", "color", args[0])
+	if err != nil {
+		return nil, err
+	}
+	h, _, l := toHSL(col)
+	return fromHSL(h, 0, l, col.a), nil
+}
+
+func complementFunc(c *compiler, args []value) (value, error) {
+	col, err := colorArg("complement", "color", args[0])
+	if err != nil {
+		return nil, err
+	}
+	h, s, l := toHSL(col)
+	return fromHSL(h+180, s, l, col.a), nil
+}
+
+func invertFunc(c *compiler, args []value) (value, error) {
+	if n, ok := args[0].(number); ok {
+		return plainCall(c, "invert", n)
+	}
+	col, err := colorArg("invert", "color", args[0])
+	if err != nil {
+		return nil, err
+	}
+	inverted := color{r: 255 - col.r, g: 255 - col.g, b: 255 - col.b, a: col.a}
+	if isNull(args[1]) {
+		return inverted, nil
+	}
+	return mixFunc(c, []value{inverted, col, args[1]})
+}
+
+// toHSL returns the hue in degrees and the saturation and lightness as
+// percentages for c
+func toHSL(c color) (float64, float64, float64) {
+	r, g, b := c.r/255, c.g/255, c.b/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	d := max - min
+	var h, s float64
+	l := (max + min) / 2
+	switch {
+	case d == 0:
+		h = 0
+	case max == r:
+		h = 60 * (g - b) / d
+	case max == g:
+		h = 60*(b-r)/d + 120
+	default:
+		h = 60*(r-g)/d + 240
+	}
+	if d != 0 {
+		if l < 0.5 {
+			s = d / (max + min)
+		} else {
+			s = d / (2 - max - min)
+		}
+	}
+	return math.Mod(h+360, 360), s * 100, l * 100
+}
+
+// fromHSL returns the color with the given hue in degrees, saturation and
+// lightness as percentages, and alpha
+func fromHSL(h, s, l, a float64) color {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+	s = clampFloat(s, 0, 100) / 100
+	l = clampFloat(l, 0, 100) / 100
+	var m2 float64
+	if l <= 0.5 {
+		m2 = l * (s + 1)
+	} else {
+		m2 = l + s - l*s
+	}
+	m1 := l*2 - m2
+	hueToRGB := func(h float64) float64 {
+		if h < 0 {
+			h++
+		}
+		if h > 1 {
+			h--
+		}
+		switch {
+		case h*6 < 1:
+			return m1 + (m2-m1)*h*6
+		case h*2 < 1:
+			return m2
+		case h*3 < 2:
+			return m1 + (m2-m1)*(2.0/3-h)*6
+		}
+		return m1
+	}
+	return color{
+		r: hueToRGB(h+1.0/3) * 255,
+		g: hueToRGB(h) * 255,
+		b: hueToRGB(h-1.0/3) * 255,
+		a: a,
+	}
+}
+
+// number functions
+
+func percentageFunc(c *compiler, args []value) (value, error) {
+	n, err := numberArg("percentage", "number", args[0])
+	if err != nil {
+		return nil, err
+	}
+	if n.unit != "" {
+		return nil, fmt.Errorf("$number: %s is not a unitless number for `percentage'", formatNumber(n, c.precision, false))
+	}
+	return number{v: n.v * 100, unit: "%"}, nil
+}
+
+func mathFunc(name string, fn func(float64) float64) func(*compiler, []value) (value, error) {
+	return func(c *compiler, args []value) (value, error) {
+		n, err := numberArg(name, "number", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return number{v: fn(n.v), unit: n.unit}, nil
+	}
+}
+
+// extremumFunc returns min or max, depending on op. If any of the arguments
+// are not numbers, e.g. min(10px, 5vw), it is output as a css function.
+func extremumFunc(name string, op string) func(*compiler, []value) (value, error) {
+	return func(c *compiler, args []value) (value, error) {
+		items := args[0].(list).items
+		if len(items) == 0 {
+			return nil, fmt.Errorf("At least one argument must be passed to %s()", name)
+		}
+		var result number
+		for i, item := range items {
+			n, ok := item.(number)
+			if !ok {
+				return plainCall(c, name, items...)
+			}
+			if i == 0 {
+				result = n
+				continue
+			}
+			better, err := operateNumbers(op, n, result)
+			if err != nil {
+				// incompatible units, e.g. min(100%, 500px)
+				return plainCall(c, name, items...)
+			}
+			if better.(boolean) {
+				result = n
+			}
+		}
+		return result, nil
+	}
+}
+
+func ifFunc(c *compiler, args []value) (value, error) {
+	if truthy(args[0]) {
+		return args[1], nil
+	}
+	return args[2], nil
+}
+
+// string functions
+
+func unquoteFunc(c *compiler, args []value) (value, error) {
+	if s, ok := args[0].(str); ok {
+		return str{s: s.s}, nil
+	}
+	return args[0], nil
+}
+
+func quoteFunc(c *compiler, args []value) (value, error) {
+	s, err := toString(args[0], c.precision)
+	return str{s: s, quoted: true}, err
+}
+
+func strLengthFunc(c *compiler, args []value) (value, error) {
+	s, ok := args[0].(str)
+	if !ok {
+		v, _ := inspect(args[0])
+		return nil, fmt.Errorf("$string: %s is not a string for `str-length'", v)
+	}
+	return number{v: float64(len([]rune(s.s)))}, nil
+}
+
+func caseFunc(fn func(string) string) func(*compiler, []value) (value, error) {
+	return func(c *compiler, args []value) (value, error) {
+		s, ok := args[0].(str)
+		if !ok {
+			v, _ := inspect(args[0])
+			return nil, fmt.Errorf("$string: %s is not a string", v)
+		}
+		return str{s: fn(s.s), quoted: s.quoted}, nil
+	}
+}
+
+// introspection functions
+
+func typeOfFunc(c *compiler, args []value) (value, error) {
+	return str{s: typeOf(args[0])}, nil
+}
+
+func unitFunc(c *compiler, args []value) (value, error) {
+	n, err := numberArg("unit", "number", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return str{s: n.unit, quoted: true}, nil
+}
+
+func unitlessFunc(c *compiler, args []value) (value, error) {
+	n, err := numberArg("unitless", "number", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return boolean(n.unit == ""), nil
+}
+
+// list functions
+
+// separator returns the separator of v if it is a list with more than one
+// item, or def otherwise
+func separator(v value, def string) string {
+	if l, ok := v.(list); ok && len(l.items) > 1 {
+		return l.sep
+	}
+	if _, ok := v.(sassMap); ok {
+		return ","
+	}
+	return def
+}
+
+// separatorArg returns the separator for a list function given its
+// $separator argument, which is one of comma, space, or auto
+func separatorArg(v value, auto string) (string, error) {
+	if isNull(v) {
+		return auto, nil
+	}
+	s, _ := toString(v, defaultPrecision)
+	switch s {
+	case "comma":
+		return ",", nil
+	case "space":
+		return " ", nil
+	case "auto":
+		return auto, nil
+	}
+	return "", fmt.Errorf("Separator name must be space, comma, or auto")
+}
+
+func lengthFunc(c *compiler, args []value) (value, error) {
+	return number{v: float64(len(listItems(args[0])))}, nil
+}
+
+func nthFunc(c *compiler, args []value) (value, error) {
+	items := listItems(args[0])
+	n, err := numberArg("nth", "n", args[1])
+	if err != nil {
+		return nil, err
+	}
+	i := int(n.v)
+	if i < 0 {
+		i += len(items) + 1
+	}
+	if i < 1 || i > len(items) {
+		return nil, fmt.Errorf("index %d out of bounds for `nth($list, $n)'", int(n.v))
+	}
+	return items[i-1], nil
+}
+
+func joinFunc(c *compiler, args []value) (value, error) {
+	sep, err := separatorArg(args[2], separator(args[0], separator(args[1], " ")))
+	if err != nil {
+		return nil, err
+	}
+	items := append(append([]value{}, listItems(args[0])...), listItems(args[1])...)
+	return list{items: items, sep: sep}, nil
+}
+
+func appendFunc(c *compiler, args []value) (value, error) {
+	sep, err := separatorArg(args[2], separator(args[0], " "))
+	if err != nil {
+		return nil, err
+	}
+	items := append(append([]value{}, listItems(args[0])...), args[1])
+	return list{items: items, sep: sep}, nil
+}
+
+func indexFunc(c *compiler, args []value) (value, error) {
+	for i, item := range listItems(args[0]) {
+		if equal(item, args[1]) {
+			return number{v: float64(i + 1)}, nil
+		}
+	}
+	return null{}, nil
+}
+
+// map functions
+
+func mapGetFunc(c *compiler, args []value) (value, error) {
+	m, err := mapArg("map-get", "map", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return m.get(args[1]), nil
+}
+
+func mapMergeFunc(c *compiler, args []value) (value, error) {
+	m1, err := mapArg("map-merge", "map1", args[0])
+	if err != nil {
+		return nil, err
+	}
+	m2, err := mapArg("map-merge", "map2", args[1])
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range m2.keys {
+		m1 = m1.set(key, m2.values[i])
+	}
+	return m1, nil
+}
+
+func mapKeysFunc(c *compiler, args []value) (value, error) {
+	m, err := mapArg("map-keys", "map", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return list{items: append([]value{}, m.keys...), sep: ","}, nil
+}
+
+func mapValuesFunc(c *compiler, args []value) (value, error) {
+	m, err := mapArg("map-values", "map", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return list{items: append([]value{}, m.values...), sep: ","}, nil
+}
+
+func mapHasKeyFunc(c *compiler, args []value) (value, error) {
+	m, err := mapArg("map-has-key", "map", args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range m.keys {
+		if equal(key, args[1]) {
+			return boolean(true), nil
+		}
+	}
+	return boolean(false), nil
+}
+
+func mapRemoveFunc(c *compiler, args []value) (value, error) {
+	m, err := mapArg("map-remove", "map", args[0])
+	if err != nil {
+		return nil, err
+	}
+	result := sassMap{}
+	for i, key := range m.keys {
+		removed := false
+		for _, k := range args[1].(list).items {
+			if equal(key, k) {
+				removed = true
+			}
+		}
+		if !removed {
+			result = result.set(key, m.values[i])
+		}
+	}
+	return result, nil
+}
+
+// namedColors holds the css color keywords
+var namedColors = map[string]color{}
+
+func init() {
+	hexes := map[string]uint32{
+		"aliceblue": 0xf0f8ff, "antiquewhite": 0xfaebd7, "aqua": 0x00ffff, "aquamarine": 0x7fffd4,
+		"azure": 0xf0ffff, "beige": 0xf5f5dc, "bisque": 0xffe4c4, "black": 0x000000,
+		"blanchedalmond": 0xffebcd, "blue": 0x0000ff, "blueviolet": 0x8a2be2, "brown": 0xa52a2a,
+		"burlywood": 0xdeb887, "cadetblue": 0x5f9ea0, "chartreuse": 0x7fff00, "chocolate": 0xd2691e,
+		"coral": 0xff7f50, "cornflowerblue": 0x6495ed, "cornsilk": 0xfff8dc, "crimson": 0xdc143c,
+		"cyan": 0x00ffff, "darkblue": 0x00008b, "darkcyan": 0x008b8b, "darkgoldenrod": 0xb8860b,
+		"darkgray": 0xa9a9a9, "darkgreen": 0x006400, "darkgrey": 0xa9a9a9, "darkkhaki": 0xbdb76b,
+		"darkmagenta": 0x8b008b, "darkolivegreen": 0x556b2f, "darkorange": 0xff8c00, "darkorchid": 0x9932cc,
+		"darkred": 0x8b0000, "darksalmon": 0xe9967a, "darkseagreen": 0x8fbc8f, "darkslateblue": 0x483d8b,
+		"darkslategray": 0x2f4f4f, "darkslategrey": 0x2f4f4f, "darkturquoise": 0x00ced1, "darkviolet": 0x9400d3,
+		"deeppink": 0xff1493, "deepskyblue": 0x00bfff, "dimgray": 0x696969, "dimgrey": 0x696969,
+		"dodgerblue": 0x1e90ff, "firebrick": 0xb22222, "floralwhite": 0xfffaf0, "forestgreen": 0x228b22,
+		"fuchsia": 0xff00ff, "gainsboro": 0xdcdcdc, "ghostwhite": 0xf8f8ff, "gold": 0xffd700,
+		"goldenrod": 0xdaa520, "gray": 0x808080, "green": 0x008000, "greenyellow": 0xadff2f,
+		"grey": 0x808080, "honeydew": 0xf0fff0, "hotpink": 0xff69b4, "indianred": 0xcd5c5c,
+		"indigo": 0x4b0082, "ivory": 0xfffff0, "khaki": 0xf0e68c, "lavender": 0xe6e6fa,
+		"lavenderblush": 0xfff0f5, "lawngreen": 0x7cfc00, "lemonchiffon": 0xfffacd, "lightblue": 0xadd8e6,
+		"lightcoral": 0xf08080, "lightcyan": 0xe0ffff, "lightgoldenrodyellow": 0xfafad2, "lightgray": 0xd3d3d3,
+		"lightgreen": 0x90ee90, "lightgrey": 0xd3d3d3, "lightpink": 0xffb6c1, "lightsalmon": 0xffa07a,
+		"lightseagreen": 0x20b2aa, "lightskyblue": 0x87cefa, "lightslategray": 0x778899, "lightslategrey": 0x778899,
+		"lightsteelblue": 0xb0c4de, "lightyellow": 0xffffe0, "lime": 0x00ff00, "limegreen": 0x32cd32,
+		"linen": 0xfaf0e6, "magenta": 0xff00ff, "maroon": 0x800000, "mediumaquamarine": 0x66cdaa,
+		"mediumblue": 0x0000cd, "mediumorchid": 0xba55d3, "mediumpurple": 0x9370db, "mediumseagreen": 0x3cb371,
+		"mediumslateblue": 0x7b68ee, "mediumspringgreen": 0x00fa9a, "mediumturquoise": 0x48d1cc, "mediumvioletred": 0xc71585,
+		"midnightblue": 0x191970, "mintcream": 0xf5fffa, "mistyrose": 0xffe4e1, "moccasin": 0xffe4b5,
+		"navajowhite": 0xffdead, "navy": 0x000080, "oldlace": 0xfdf5e6, "olive": 0x808000,
+		"olivedrab": 0x6b8e23, "orange": 0xffa500, "orangered": 0xff4500, "orchid": 0xda70d6,
+		"palegoldenrod": 0xeee8aa, "palegreen": 0x98fb98, "paleturquoise": 0xafeeee, "palevioletred": 0xdb7093,
+		"papayawhip": 0xffefd5, "peachpuff": 0xffdab9, "peru": 0xcd853f, "pink": 0xffc0cb,
+		"plum": 0xdda0dd, "powderblue": 0xb0e0e6, "purple": 0x800080, "rebeccapurple": 0x663399,
+		"red": 0xff0000, "rosybrown": 0xbc8f8f, "royalblue": 0x4169e1, "saddlebrown": 0x8b4513,
+		"salmon": 0xfa8072, "sandybrown": 0xf4a460, "seagreen": 0x2e8b57, "seashell": 0xfff5ee,
+		"sienna": 0xa0522d, "silver": 0xc0c0c0, "skyblue": 0x87ceeb, "slateblue": 0x6a5acd,
+		"slategray": 0x708090, "slategrey": 0x708090, "snow": 0xfffafa, "springgreen": 0x00ff7f,
+		"steelblue": 0x4682b4, "tan": 0xd2b48c, "teal": 0x008080, "thistle": 0xd8bfd8,
+		"tomato": 0xff6347, "turquoise": 0x40e0d0, "violet": 0xee82ee, "wheat": 0xf5deb3,
+		"white": 0xffffff, "whitesmoke": 0xf5f5f5, "yellow": 0xffff00, "yellowgreen": 0x9acd32,
+	}
+	for name, hex := range hexes {
+		namedColors[name] = color{r: float64(hex >> 16), g: float64(hex >> 8 & 0xff), b: float64(hex & 0xff), a: 1}
+	}
+	namedColors["transparent"] = color{a: 0}
+}
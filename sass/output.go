@@ -0,0 +1,464 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// cssNode is a node in the resulting css, i.e. a *cssRule, *cssAtRule, or
+// *cssComment
+type cssNode interface{}
+
+type (
+	// cssRule is a style rule with its declarations. depth is the nesting
+	// depth of the sass rule it came from, which is used to indent the
+	// output the same way sassc does.
+	cssRule struct {
+		pos
+		selectors []string
+		decls     []cssDecl
+		depth     int
+	}
+	// cssAtRule is an at-rule, e.g. @media or @font-face. If it has a block,
+	// it contains either rules or declarations.
+	cssAtRule struct {
+		pos
+		name     string
+		params   string
+		hasBlock bool
+		children []cssNode
+		decls    []cssDecl
+		depth    int
+	}
+	cssComment struct {
+		pos
+		text  string
+		depth int
+	}
+	// cssDecl is a property declaration. If prop is empty, value is a
+	// comment.
+	cssDecl struct {
+		pos
+		prop  string
+		value string
+	}
+)
+
+// normalizeSelector removes extra whitespace from a single selector, and
+// puts exactly one space around each combinator
+func normalizeSelector(s string) string {
+	return formatSelector(s, false)
+}
+
+// formatSelector removes extra whitespace from a single selector. If
+// compressed is true, it also removes the whitespace around combinators.
+// Attribute selectors, pseudo-class arguments, and strings are left as is,
+// e.g. [href~="a b"] or :nth-child(2n + 1).
+func formatSelector(s string, compressed bool) string {
+	var b strings.Builder
+	depth := 0
+	space := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := stringEnd(s, i)
+			if end == -1 {
+				end = len(s) - 1
+			}
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteString(s[i : end+1])
+			i = end
+			continue
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case depth == 0 && strings.IndexByte(" \t\n\r\f", c) != -1:
+			space = true
+			continue
+		case depth == 0 && (c == '>' || c == '+' || c == '~'):
+			if compressed {
+				b.WriteByte(c)
+			} else if b.Len() > 0 {
+				b.WriteString(" " + string(c) + " ")
+			} else {
+				b.WriteString(string(c) + " ")
+			}
+			space = false
+			for i+1 < len(s) && strings.IndexByte(" \t\n\r\f", s[i+1]) != -1 {
+				i++
+			}
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// resolveSelectors combines the selectors of a nested rule with the
+// selectors of its parent, replacing & with the parent selector
+func resolveSelectors(parents []string, text string) ([]string, error) {
+	result := []string{}
+	for _, s := range splitTopLevel(text, ',') {
+		s = normalizeSelector(s)
+		if s == "" {
+			continue
+		}
+		if parents == nil {
+			if strings.Contains(s, "&") {
+				return nil, fmt.Errorf("Base-level rules cannot contain the parent-selector-referencing character '&'.")
+			}
+			result = append(result, s)
+			continue
+		}
+		for _, parent := range parents {
+			if strings.Contains(s, "&") {
+				result = append(result, strings.Replace(s, "&", parent, -1))
+			} else {
+				result = append(result, parent+" "+s)
+			}
+		}
+	}
+	return result, nil
+}
+
+// applyExtensions adds the extending selectors to every rule with a
+// selector that contains the target of an @extend, and then removes any
+// selectors which contain placeholders, e.g. %button.
+func (c *compiler) applyExtensions() error {
+	c.extendNodes(c.root)
+	for _, e := range c.extensions {
+		if !e.matched && !e.optional {
+			return c.errorf(e.pos, "\"%s\" failed to @extend \"%s\".\nThe selector \"%s\" was not found.\nUse \"@extend %s !optional\" if the extend should be able to fail.",
+				strings.Join(e.extenders, ", "), e.target, e.target, e.target)
+		}
+	}
+	return nil
+}
+
+func (c *compiler) extendNodes(nodes []cssNode) {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *cssRule:
+			node.selectors = c.extendSelectors(node.selectors)
+		case *cssAtRule:
+			c.extendNodes(node.children)
+		}
+	}
+}
+
+// extendSelectors returns selectors with the selectors which extend them
+// added, repeating until there are no new ones so that extensions can be
+// chained
+func (c *compiler) extendSelectors(selectors []string) []string {
+	seen := map[string]bool{}
+	for _, s := range selectors {
+		seen[s] = true
+	}
+	for i := 0; i < len(selectors); i++ {
+		for _, e := range c.extensions {
+			if !containsSimpleSelector(selectors[i], e.target) {
+				continue
+			}
+			e.matched = true
+			for _, extender := range e.extenders {
+				extended := replaceSimpleSelector(selectors[i], e.target, extender)
+				if !seen[extended] {
+					seen[extended] = true
+					selectors = append(selectors, extended)
+				}
+			}
+		}
+	}
+	result := []string{}
+	for _, s := range selectors {
+		if !strings.Contains(s, "%") {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// selectorBoundary returns true iff the selector ends at s[i], i.e. the
+// target of an @extend is a whole simple selector rather than a prefix of a
+// longer one, e.g. .button in .button-primary
+func selectorBoundary(s string, i int) bool {
+	return i >= len(s) || !isNameChar(s[i])
+}
+
+func containsSimpleSelector(selector string, target string) bool {
+	for i := 0; ; {
+		j := strings.Index(selector[i:], target)
+		if j == -1 {
+			return false
+		}
+		if selectorBoundary(selector, i+j+len(target)) {
+			return true
+		}
+		i += j + 1
+	}
+}
+
+func replaceSimpleSelector(selector string, target string, replacement string) string {
+	result := ""
+	for {
+		j := strings.Index(selector, target)
+		if j == -1 {
+			return result + selector
+		}
+		if selectorBoundary(selector, j+len(target)) {
+			result += selector[:j] + replacement
+		} else {
+			result += selector[:j+len(target)]
+		}
+		selector = selector[j+len(target):]
+	}
+}
+
+// printer writes the resulting css in one of the output styles. It keeps
+// track of the current line and column, which are used for source maps.
+type printer struct {
+	style    string
+	buf      strings.Builder
+	line     int
+	col      int
+	mappings []mapping
+}
+
+// output returns the resulting css in the output style for c. If sourceMap
+// is not empty, the result includes a source map which will be written to
+// that path.
+func (c *compiler) output(sourceMap string) (*Result, error) {
+	p := &printer{style: c.style}
+	var prev cssNode
+	for _, node := range c.root {
+		if p.isEmpty(node) {
+			continue
+		}
+		if prev != nil {
+			p.write(p.separator(prev, node))
+		}
+		p.printNode(node, 0)
+		prev = node
+	}
+	if prev != nil {
+		p.write("\n")
+	}
+	result := &Result{}
+	if sourceMap != "" {
+		var err error
+		if result.SourceMap, err = p.sourceMap(sourceMap, c.sources); err != nil {
+			return nil, err
+		}
+		p.write("\n/*# sourceMappingURL=" + filepath.ToSlash(filepath.Base(sourceMap)) + " */\n")
+	}
+	result.CSS = []byte(p.buf.String())
+	return result, nil
+}
+
+// write writes s to the output
+func (p *printer) write(s string) {
+	p.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i != -1 {
+		p.line += strings.Count(s, "\n")
+		p.col = len(s) - i - 1
+	} else {
+		p.col += len(s)
+	}
+}
+
+// mark records that the output at the current position came from the sass
+// at pos
+func (p *printer) mark(at pos) {
+	if at.path != "" {
+		p.mappings = append(p.mappings, mapping{line: p.line, col: p.col, source: at})
+	}
+}
+
+// keepComment returns true iff the comment text should be included in the
+// output. Only comments which start with /*! are kept in compressed output.
+func (p *printer) keepComment(text string) bool {
+	return p.style != "compressed" || strings.HasPrefix(text, "/*!")
+}
+
+// isEmpty returns true iff nothing would be output for node
+func (p *printer) isEmpty(node cssNode) bool {
+	switch node := node.(type) {
+	case *cssRule:
+		return len(node.decls) == 0 || len(node.selectors) == 0
+	case *cssComment:
+		return !p.keepComment(node.text)
+	case *cssAtRule:
+		if !node.hasBlock || len(node.decls) > 0 {
+			return false
+		}
+		for _, child := range node.children {
+			if !p.isEmpty(child) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// separator returns the text between prev and node, which are both at the
+// top level of the output
+func (p *printer) separator(prev cssNode, node cssNode) string {
+	if p.style == "compressed" {
+		return ""
+	}
+	if _, ok := prev.(*cssComment); ok {
+		return "\n"
+	}
+	if p.style != "expanded" && nodeDepth(node) > 0 {
+		// nested rules come right after their parent
+		return "\n"
+	}
+	return "\n\n"
+}
+
+func nodeDepth(node cssNode) int {
+	switch node := node.(type) {
+	case *cssRule:
+		return node.depth
+	case *cssAtRule:
+		return node.depth
+	case *cssComment:
+		return node.depth
+	}
+	return 0
+}
+
+// indent returns the indentation for node, which is inside of level
+// at-rules
+func (p *printer) indent(node cssNode, level int) string {
+	switch p.style {
+	case "nested":
+		return strings.Repeat("  ", nodeDepth(node))
+	case "expanded":
+		return strings.Repeat("  ", level)
+	}
+	return ""
+}
+
+func (p *printer) printNode(node cssNode, level int) {
+	switch node := node.(type) {
+	case *cssRule:
+		p.printRule(node, level)
+	case *cssComment:
+		p.write(p.indent(node, level))
+		p.mark(node.pos)
+		p.write(node.text)
+	case *cssAtRule:
+		p.printAtRule(node, level)
+	}
+}
+
+func (p *printer) printRule(rule *cssRule, level int) {
+	indent := p.indent(rule, level)
+	p.write(indent)
+	p.mark(rule.pos)
+	if p.style == "compressed" {
+		selectors := make([]string, len(rule.selectors))
+		for i, s := range rule.selectors {
+			selectors[i] = formatSelector(s, true)
+		}
+		p.write(strings.Join(selectors, ",") + "{")
+	} else {
+		p.write(strings.Join(rule.selectors, ", ") + " {")
+	}
+	p.printDecls(rule.decls, indent)
+	switch p.style {
+	case "nested", "compact":
+		p.write(" }")
+	case "expanded":
+		p.write("\n" + indent + "}")
+	default:
+		p.write("}")
+	}
+}
+
+// printDecls writes decls inside of a block whose opening line has the given
+// indentation
+func (p *printer) printDecls(decls []cssDecl, indent string) {
+	first := true
+	for _, d := range decls {
+		if d.prop == "" && !p.keepComment(d.value) {
+			continue
+		}
+		switch p.style {
+		case "nested", "expanded":
+			p.write("\n" + indent + "  ")
+		case "compact":
+			p.write(" ")
+		default:
+			if !first && d.prop != "" {
+				p.write(";")
+			}
+		}
+		first = false
+		p.mark(d.pos)
+		switch {
+		case d.prop == "":
+			p.write(d.value)
+		case p.style == "compressed":
+			p.write(d.prop + ":" + d.value)
+		default:
+			p.write(d.prop + ": " + d.value + ";")
+		}
+	}
+}
+
+func (p *printer) printAtRule(at *cssAtRule, level int) {
+	indent := p.indent(at, level)
+	p.write(indent)
+	p.mark(at.pos)
+	p.write("@" + at.name)
+	if at.params != "" {
+		p.write(" " + at.params)
+	}
+	if !at.hasBlock {
+		p.write(";")
+		return
+	}
+	if p.style == "compressed" {
+		p.write("{")
+	} else {
+		p.write(" {")
+	}
+	p.printDecls(at.decls, indent)
+	for _, child := range at.children {
+		if p.isEmpty(child) {
+			continue
+		}
+		switch p.style {
+		case "nested", "expanded":
+			p.write("\n")
+		case "compact":
+			p.write(" ")
+		}
+		p.printNode(child, level+1)
+	}
+	switch p.style {
+	case "nested", "compact":
+		p.write(" }")
+	case "expanded":
+		p.write("\n" + indent + "}")
+	default:
+		p.write("}")
+	}
+}
@@ -0,0 +1,713 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// stmt is a single parsed statement in a sass file, e.g. a rule or a
+// variable declaration
+type stmt interface{}
+
+// pos is the position of a statement, used in error messages
+type pos struct {
+	path string
+	line int
+}
+
+// param is a parameter of a mixin or function, with an optional default
+// value. rest is true for variable arguments, e.g. $args...
+type param struct {
+	name string
+	def  expr
+	rest bool
+}
+
+type (
+	// ruleStmt is a style rule, e.g. a:hover { ... }
+	ruleStmt struct {
+		pos
+		selector expr
+		children []stmt
+	}
+	// declStmt is a property declaration, e.g. color: $red. If the property
+	// has nested properties, e.g. font: { family: $font; }, they are the
+	// children.
+	declStmt struct {
+		pos
+		name     expr
+		value    expr
+		children []stmt
+		// custom is true for custom properties, e.g. --main-color, whose
+		// values are not evaluated except for interpolation
+		custom bool
+	}
+	// varStmt is a variable declaration, e.g. $red: #f00 !default
+	varStmt struct {
+		pos
+		name      string
+		value     expr
+		isDefault bool
+		global    bool
+	}
+	// importStmt is an import of other sass files, e.g. @import "colors"
+	importStmt struct {
+		pos
+		paths []string
+	}
+	// commentStmt is a comment which is included in the output, e.g. /* a */
+	commentStmt struct {
+		pos
+		text string
+	}
+	mixinStmt struct {
+		pos
+		name     string
+		params   []param
+		children []stmt
+	}
+	includeStmt struct {
+		pos
+		name string
+		args []argument
+		// content is the block passed to the mixin, or nil if there is none
+		content []stmt
+	}
+	contentStmt struct {
+		pos
+	}
+	functionStmt struct {
+		pos
+		name     string
+		params   []param
+		children []stmt
+	}
+	returnStmt struct {
+		pos
+		value expr
+	}
+	// ifStmt is an @if with an optional @else. For an @else if, els holds a
+	// single ifStmt.
+	ifStmt struct {
+		pos
+		cond     expr
+		children []stmt
+		els      []stmt
+	}
+	eachStmt struct {
+		pos
+		names      []string
+		collection expr
+		children   []stmt
+	}
+	forStmt struct {
+		pos
+		name      string
+		from, to  expr
+		inclusive bool
+		children  []stmt
+	}
+	whileStmt struct {
+		pos
+		cond     expr
+		children []stmt
+	}
+	mediaStmt struct {
+		pos
+		query    expr
+		children []stmt
+	}
+	extendStmt struct {
+		pos
+		selector expr
+		optional bool
+	}
+	// messageStmt is a @warn, @debug, or @error
+	messageStmt struct {
+		pos
+		kind  string
+		value expr
+	}
+	// atRuleStmt is any other at-rule, which is output as is, e.g.
+	// @font-face or @keyframes
+	atRuleStmt struct {
+		pos
+		name     string
+		params   expr
+		hasBlock bool
+		children []stmt
+	}
+)
+
+// parser parses a single sass file into statements
+type parser struct {
+	path string
+	src  string
+	i    int
+}
+
+// parse parses the contents of the sass file at path
+func parse(path string, src string) ([]stmt, error) {
+	p := &parser{path: path, src: strings.Replace(src, "\r\n", "\n", -1)}
+	stmts, err := p.parseBlock(true)
+	if err != nil {
+		return nil, err
+	}
+	return stmts, nil
+}
+
+// pos returns the position of the character at offset i
+func (p *parser) pos(i int) pos {
+	return pos{path: p.path, line: strings.Count(p.src[:i], "\n") + 1}
+}
+
+func (p *parser) errorf(i int, format string, args ...interface{}) error {
+	return newError(p.pos(i), p.src, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips whitespace and silent comments. It stops at loud comments,
+// i.e. /* ... */, which are returned as statements.
+func (p *parser) skipSpace() {
+	for p.i < len(p.src) {
+		switch {
+		case strings.ContainsRune(" \t\n\r\f", rune(p.src[p.i])):
+			p.i++
+		case strings.HasPrefix(p.src[p.i:], "//"):
+			end := strings.IndexByte(p.src[p.i:], '\n')
+			if end == -1 {
+				p.i = len(p.src)
+			} else {
+				p.i += end
+			}
+		default:
+			return
+		}
+	}
+}
+
+// readUntil reads text until one of the characters in stops, skipping over
+// strings, interpolation, parentheses, and comments. It returns the text and
+// the character it stopped at, which is not consumed, or 0 at the end of the
+// file.
+func (p *parser) readUntil(stops string) (string, byte, error) {
+	start := p.i
+	text := []byte{}
+	depth := 0
+	for p.i < len(p.src) {
+		c := p.src[p.i]
+		switch {
+		case depth == 0 && strings.IndexByte(stops, c) != -1:
+			return string(text), c, nil
+		case c == '"' || c == '\'':
+			end := stringEnd(p.src, p.i)
+			if end == -1 {
+				return "", 0, p.errorf(p.i, "unterminated string")
+			}
+			text = append(text, p.src[p.i:end+1]...)
+			p.i = end + 1
+			continue
+		case c == '#' && p.i+1 < len(p.src) && p.src[p.i+1] == '{':
+			end := matchingBrace(p.src, p.i+1)
+			if end == -1 {
+				return "", 0, p.errorf(p.i, "missing } for interpolation")
+			}
+			text = append(text, p.src[p.i:end+1]...)
+			p.i = end + 1
+			continue
+		case c == '/' && p.i+1 < len(p.src) && p.src[p.i+1] == '*':
+			end := strings.Index(p.src[p.i+2:], "*/")
+			if end == -1 {
+				return "", 0, p.errorf(p.i, "unterminated comment")
+			}
+			p.i += end + 4
+			continue
+		case depth == 0 && c == '/' && p.i+1 < len(p.src) && p.src[p.i+1] == '/':
+			end := strings.IndexByte(p.src[p.i:], '\n')
+			if end == -1 {
+				end = len(p.src) - p.i
+			}
+			p.i += end
+			continue
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		}
+		text = append(text, c)
+		p.i++
+	}
+	if depth != 0 {
+		return "", 0, p.errorf(start, "missing closing parenthesis")
+	}
+	return string(text), 0, nil
+}
+
+// parseBlock parses statements until the closing brace of the block, which
+// is consumed, or until the end of the file if top is true.
+func (p *parser) parseBlock(top bool) ([]stmt, error) {
+	stmts := []stmt{}
+	for {
+		p.skipSpace()
+		if p.i >= len(p.src) {
+			if !top {
+				return nil, p.errorf(p.i, "expected \"}\" but reached the end of the file")
+			}
+			return stmts, nil
+		}
+		start := p.i
+		switch {
+		case p.src[p.i] == '}':
+			if top {
+				return nil, p.errorf(p.i, "unexpected \"}\"")
+			}
+			p.i++
+			return stmts, nil
+		case p.src[p.i] == ';':
+			p.i++
+			continue
+		case strings.HasPrefix(p.src[p.i:], "/*"):
+			end := strings.Index(p.src[p.i+2:], "*/")
+			if end == -1 {
+				return nil, p.errorf(p.i, "unterminated comment")
+			}
+			p.i += end + 4
+			stmts = append(stmts, commentStmt{pos: p.pos(start), text: p.src[start:p.i]})
+			continue
+		}
+		s, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			stmts = append(stmts, s)
+		}
+	}
+}
+
+// endStmt consumes the semicolon after a statement, if there is one
+func (p *parser) endStmt(stop byte) {
+	if stop == ';' {
+		p.i++
+	}
+}
+
+// propertyName matches the name of a property, which may contain
+// interpolation
+var propertyName = regexp.MustCompile(`^(\*|_)?(-|[a-zA-Z_]|#\{)([a-zA-Z0-9_-]|#\{[^}]*\})*$`)
+
+func (p *parser) parseStmt() (stmt, error) {
+	start := p.i
+	switch p.src[p.i] {
+	case '@':
+		return p.parseAtRule()
+	case '$':
+		return p.parseVariable()
+	}
+	text, stop, err := p.readUntil("{;}")
+	if err != nil {
+		return nil, err
+	}
+	text = strings.TrimSpace(text)
+	if stop == '{' {
+		p.i++
+		children, err := p.parseBlock(false)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(text, ":") && propertyName.MatchString(strings.TrimSpace(text[:len(text)-1])) {
+			// nested properties, e.g. font: { family: $font; }
+			name, err := parseInterpolation(strings.TrimSpace(text[:len(text)-1]), false)
+			if err != nil {
+				return nil, p.errorf(start, "%s", err)
+			}
+			return declStmt{pos: p.pos(start), name: name, children: children}, nil
+		}
+		selector, err := parseInterpolation(text, false)
+		if err != nil {
+			return nil, p.errorf(start, "%s", err)
+		}
+		return ruleStmt{pos: p.pos(start), selector: selector, children: children}, nil
+	}
+	p.endStmt(stop)
+	colon := strings.Index(text, ":")
+	if colon == -1 || !propertyName.MatchString(strings.TrimSpace(text[:colon])) {
+		return nil, p.errorf(start, "Invalid CSS after %q: expected \"{\", was %q", text, string(stop))
+	}
+	rawName := strings.TrimSpace(text[:colon])
+	rawValue := strings.TrimSpace(text[colon+1:])
+	name, err := parseInterpolation(rawName, false)
+	if err != nil {
+		return nil, p.errorf(start, "%s", err)
+	}
+	decl := declStmt{pos: p.pos(start), name: name, custom: strings.HasPrefix(rawName, "--")}
+	if decl.custom {
+		decl.value, err = parseInterpolation(rawValue, false)
+	} else {
+		decl.value, err = parseExpr(rawValue)
+	}
+	if err != nil {
+		return nil, p.errorf(start, "%s", err)
+	}
+	return decl, nil
+}
+
+// varFlags matches the flags at the end of a variable declaration
+var varFlags = regexp.MustCompile(`\s*!(default|global)\s*$`)
+
+func (p *parser) parseVariable() (stmt, error) {
+	start := p.i
+	text, stop, err := p.readUntil(";}")
+	if err != nil {
+		return nil, err
+	}
+	p.endStmt(stop)
+	colon := strings.Index(text, ":")
+	if colon == -1 {
+		return nil, p.errorf(start, "Invalid CSS after %q: expected \":\"", strings.TrimSpace(text))
+	}
+	s := varStmt{pos: p.pos(start), name: strings.TrimSpace(text[1:colon])}
+	rawValue := text[colon+1:]
+	for {
+		match := varFlags.FindStringSubmatchIndex(rawValue)
+		if match == nil {
+			break
+		}
+		if rawValue[match[2]:match[3]] == "default" {
+			s.isDefault = true
+		} else {
+			s.global = true
+		}
+		rawValue = rawValue[:match[0]]
+	}
+	if strings.TrimSpace(rawValue) == "" {
+		return nil, p.errorf(start, "Invalid CSS after %q: expected expression", strings.TrimSpace(text))
+	}
+	if s.value, err = parseExpr(rawValue); err != nil {
+		return nil, p.errorf(start, "%s", err)
+	}
+	return s, nil
+}
+
+// signature matches the name and parameters of a mixin or function, or the
+// name and arguments for an @include
+var signature = regexp.MustCompile(`(?s)^([a-zA-Z_-][a-zA-Z0-9_-]*)\s*(?:\((.*)\))?$`)
+
+func (p *parser) parseAtRule() (stmt, error) {
+	start := p.i
+	p.i++
+	nameStart := p.i
+	for p.i < len(p.src) && isNameChar(p.src[p.i]) {
+		p.i++
+	}
+	name := p.src[nameStart:p.i]
+	text, stop, err := p.readUntil("{;}")
+	if err != nil {
+		return nil, err
+	}
+	text = strings.TrimSpace(text)
+	at := p.pos(start)
+	errorf := func(format string, args ...interface{}) error {
+		return p.errorf(start, format, args...)
+	}
+	// block parses the block after the at-rule, which is required
+	block := func() ([]stmt, error) {
+		if stop != '{' {
+			return nil, errorf("expected \"{\" after @%s", name)
+		}
+		p.i++
+		return p.parseBlock(false)
+	}
+	switch name {
+	case "import":
+		p.endStmt(stop)
+		return p.parseImport(at, text)
+	case "mixin", "function":
+		match := signature.FindStringSubmatch(text)
+		if match == nil {
+			return nil, errorf("invalid @%s %q", name, text)
+		}
+		params, err := parseParams(match[2])
+		if err != nil {
+			return nil, errorf("%s", err)
+		}
+		children, err := block()
+		if err != nil {
+			return nil, err
+		}
+		if name == "mixin" {
+			return mixinStmt{pos: at, name: match[1], params: params, children: children}, nil
+		}
+		return functionStmt{pos: at, name: match[1], params: params, children: children}, nil
+	case "include":
+		match := signature.FindStringSubmatch(text)
+		if match == nil {
+			return nil, errorf("invalid @include %q", text)
+		}
+		s := includeStmt{pos: at, name: match[1], args: []argument{}}
+		if match[2] != "" {
+			if s.args, err = parseArgList(match[2]); err != nil {
+				return nil, errorf("%s", err)
+			}
+		}
+		if stop == '{' {
+			if s.content, err = block(); err != nil {
+				return nil, err
+			}
+		} else {
+			p.endStmt(stop)
+		}
+		return s, nil
+	case "content":
+		p.endStmt(stop)
+		return contentStmt{pos: at}, nil
+	case "return", "warn", "debug", "error":
+		p.endStmt(stop)
+		value, err := parseExpr(text)
+		if err != nil {
+			return nil, errorf("%s", err)
+		}
+		if name == "return" {
+			return returnStmt{pos: at, value: value}, nil
+		}
+		return messageStmt{pos: at, kind: name, value: value}, nil
+	case "if":
+		return p.parseIf(at, text, block)
+	case "else":
+		return nil, errorf("Invalid CSS: @else must come after @if")
+	case "each":
+		in := strings.Index(text, " in ")
+		if in == -1 {
+			return nil, errorf("expected @each $var in <list>")
+		}
+		s := eachStmt{pos: at}
+		for _, name := range strings.Split(text[:in], ",") {
+			name = strings.TrimSpace(name)
+			if !strings.HasPrefix(name, "$") {
+				return nil, errorf("expected a variable name in @each but got %q", name)
+			}
+			s.names = append(s.names, name[1:])
+		}
+		if s.collection, err = parseExpr(text[in+4:]); err != nil {
+			return nil, errorf("%s", err)
+		}
+		if s.children, err = block(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "for":
+		match := forLoop.FindStringSubmatch(text)
+		if match == nil {
+			return nil, errorf("expected @for $var from <start> through <end>")
+		}
+		s := forStmt{pos: at, name: match[1], inclusive: match[3] == "through"}
+		if s.from, err = parseExpr(match[2]); err != nil {
+			return nil, errorf("%s", err)
+		}
+		if s.to, err = parseExpr(match[4]); err != nil {
+			return nil, errorf("%s", err)
+		}
+		if s.children, err = block(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "while":
+		s := whileStmt{pos: at}
+		if s.cond, err = parseExpr(text); err != nil {
+			return nil, errorf("%s", err)
+		}
+		if s.children, err = block(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "media":
+		s := mediaStmt{pos: at}
+		if s.query, err = parseInterpolation(text, false); err != nil {
+			return nil, errorf("%s", err)
+		}
+		if s.children, err = block(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "extend":
+		p.endStmt(stop)
+		s := extendStmt{pos: at}
+		if strings.HasSuffix(text, "!optional") {
+			s.optional = true
+			text = strings.TrimSpace(strings.TrimSuffix(text, "!optional"))
+		}
+		if s.selector, err = parseInterpolation(text, false); err != nil {
+			return nil, errorf("%s", err)
+		}
+		return s, nil
+	case "charset":
+		p.endStmt(stop)
+		return nil, nil
+	case "use", "forward":
+		return nil, errorf("@%s is not supported by the built-in sass compiler. Use @import instead, or use sassc", name)
+	}
+	s := atRuleStmt{pos: at, name: name}
+	if s.params, err = parseInterpolation(text, false); err != nil {
+		return nil, errorf("%s", err)
+	}
+	if stop == '{' {
+		s.hasBlock = true
+		if s.children, err = block(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.endStmt(stop)
+	}
+	return s, nil
+}
+
+// forLoop matches the contents of a @for rule, e.g. $i from 1 through 3
+var forLoop = regexp.MustCompile(`(?s)^\$([a-zA-Z0-9_-]+)\s+from\s+(.+?)\s+(through|to)\s+(.+)$`)
+
+// parseIf parses an @if rule along with any @else rules which follow it
+func (p *parser) parseIf(at pos, cond string, block func() ([]stmt, error)) (stmt, error) {
+	s := ifStmt{pos: at}
+	var err error
+	if s.cond, err = parseExpr(cond); err != nil {
+		return nil, p.errorf(p.i, "%s", err)
+	}
+	if s.children, err = block(); err != nil {
+		return nil, err
+	}
+	// check for an @else
+	saved := p.i
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.i:], "@else") {
+		p.i = saved
+		return s, nil
+	}
+	start := p.i
+	p.i += len("@else")
+	text, stop, err := p.readUntil("{;}")
+	if err != nil {
+		return nil, err
+	}
+	if stop != '{' {
+		return nil, p.errorf(start, "expected \"{\" after @else")
+	}
+	text = strings.TrimSpace(text)
+	elseBlock := func() ([]stmt, error) {
+		p.i++
+		return p.parseBlock(false)
+	}
+	if rest := strings.TrimPrefix(text, "if"); rest != text && (rest == "" || rest[0] == ' ' || rest[0] == '(') {
+		elseIf, err := p.parseIf(p.pos(start), rest, elseBlock)
+		if err != nil {
+			return nil, err
+		}
+		s.els = []stmt{elseIf}
+		return s, nil
+	}
+	if text != "" {
+		return nil, p.errorf(start, "Invalid CSS after \"@else\": expected \"{\", was %q", text)
+	}
+	if s.els, err = elseBlock(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseImport parses the paths in an @import rule. Imports of css files or
+// urls, and imports with media queries, are output as is.
+func (p *parser) parseImport(at pos, text string) (stmt, error) {
+	s := importStmt{pos: at}
+	for _, item := range splitTopLevel(text, ',') {
+		item = strings.TrimSpace(item)
+		end := -1
+		if item != "" && (item[0] == '"' || item[0] == '\'') {
+			end = stringEnd(item, 0)
+		}
+		if end != len(item)-1 || isCSSImport(item[1:end]) {
+			// a plain css import
+			params, err := parseInterpolation(text, false)
+			if err != nil {
+				return nil, newError(at, p.src, err.Error())
+			}
+			return atRuleStmt{pos: at, name: "import", params: params}, nil
+		}
+		s.paths = append(s.paths, item[1:end])
+	}
+	return s, nil
+}
+
+// isCSSImport returns true iff path refers to a plain css file, which is
+// imported by the browser rather than by sass
+func isCSSImport(path string) bool {
+	return strings.HasSuffix(path, ".css") || strings.HasPrefix(path, "http://") ||
+		strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "//")
+}
+
+// splitTopLevel splits text by sep, ignoring any separators inside of
+// strings, parentheses, or interpolation
+func splitTopLevel(text string, sep byte) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '"' || c == '\'':
+			if end := stringEnd(text, i); end != -1 {
+				i = end
+			}
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// parseParams parses the parameters of a mixin or function, e.g.
+// $color, $size: 10px
+func parseParams(src string) ([]param, error) {
+	params := []param{}
+	if strings.TrimSpace(src) == "" {
+		return params, nil
+	}
+	args, err := parseArgList(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if arg.name != "" {
+			params = append(params, param{name: arg.name, def: arg.value})
+			continue
+		}
+		v, ok := arg.value.(variable)
+		if !ok {
+			return nil, fmt.Errorf("Invalid parameters %q: expected a variable name", src)
+		}
+		params = append(params, param{name: v.name, rest: arg.rest})
+	}
+	return params, nil
+}
+
+// parseArgList parses a comma separated list of arguments, e.g.
+// $red, $size: 10px
+func parseArgList(src string) ([]argument, error) {
+	tokens, err := tokenize(src + ")")
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "eof" {
+		return nil, p.unexpected()
+	}
+	return args, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package sass is a pure go compiler for the subset of scss that scribble
+// sites typically use: variables, nesting (including the parent selector &
+// and nested properties), partial imports, mixins with arguments and
+// @content, functions, control directives (@if, @each, @for, and @while),
+// @media, @extend, arithmetic, and the common built-in functions, e.g.
+// darken, rgba, percentage, and map-get. It does not support the indented
+// sass syntax or the module system (@use and @forward).
+package sass
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultPrecision is the number of digits after the decimal point which
+// are included in the output for numbers
+const defaultPrecision = 5
+
+// Error is an error in a sass file. Its message includes the path and line
+// number of the statement which caused the error, in the same format that
+// sassc uses.
+type Error struct {
+	Path    string
+	Line    int
+	Message string
+	// Source is the line of the file which caused the error
+	Source string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("Error: %s\n        on line %d of %s", e.Message, e.Line, e.Path)
+	if e.Source != "" {
+		msg += "\n>> " + e.Source
+	}
+	return msg
+}
+
+// newError returns an Error for the statement at p in a file with the given
+// contents
+func newError(p pos, src string, message string) *Error {
+	err := &Error{Path: p.path, Line: p.line, Message: message}
+	if lines := strings.Split(src, "\n"); p.line > 0 && p.line <= len(lines) {
+		err.Source = strings.TrimSpace(lines[p.line-1])
+	}
+	return err
+}
+
+// Options are the options for compiling a sass file. The zero value is the
+// same as the defaults for sassc.
+type Options struct {
+	// Style is the output style, which is one of "nested" (the default),
+	// "expanded", "compact", or "compressed".
+	Style string
+	// LoadPaths are additional directories which are searched for imports
+	// after the directory which contains the importing file.
+	LoadPaths []string
+	// Precision is the number of digits after the decimal point which are
+	// included in the output for numbers. If it is 0, defaultPrecision is
+	// used.
+	Precision int
+	// SourceMap is the path where the source map for the css will be
+	// written, or an empty string for no source map. The source map refers
+	// to the sources relative to it, and the css links to it with a
+	// sourceMappingURL comment.
+	SourceMap string
+}
+
+// Styles are the supported output styles
+var Styles = []string{"nested", "expanded", "compact", "compressed"}
+
+// Result is the result of compiling a sass file
+type Result struct {
+	CSS []byte
+	// SourceMap is the contents of the source map, or nil if
+	// Options.SourceMap was empty. The caller is responsible for writing it
+	// to Options.SourceMap.
+	SourceMap []byte
+}
+
+// CompileFile compiles the scss file at path with the default options and
+// returns the resulting css.
+func CompileFile(path string) ([]byte, error) {
+	result, err := Compile(path, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return result.CSS, nil
+}
+
+// Compile compiles the scss file at path with the given options. Imports are
+// resolved relative to the file which contains them, and then relative to
+// each of the load paths.
+func Compile(path string, opts Options) (*Result, error) {
+	c, err := newCompiler(opts)
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := c.parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.compile(stmts); err != nil {
+		return nil, err
+	}
+	return c.output(opts.SourceMap)
+}
+
+// compiler holds the state for compiling a single scss file
+type compiler struct {
+	precision int
+	style     string
+	loadPaths []string
+	// sources holds the contents of each file which was parsed, used to
+	// show the line which caused an error
+	sources map[string]string
+	// importing is the stack of files which are currently being imported,
+	// used to detect import cycles
+	importing []string
+	// root holds the resulting css
+	root []cssNode
+	// extensions holds the selectors which extend other selectors
+	extensions []*extension
+	global     *env
+}
+
+func newCompiler(opts Options) (*compiler, error) {
+	c := &compiler{
+		precision: opts.Precision,
+		style:     opts.Style,
+		loadPaths: opts.LoadPaths,
+		sources:   map[string]string{},
+		global:    newEnv(nil),
+	}
+	if c.precision == 0 {
+		c.precision = defaultPrecision
+	} else if c.precision < 0 {
+		return nil, fmt.Errorf("sass: precision must be positive but was %d", c.precision)
+	}
+	if c.style == "" {
+		c.style = "nested"
+	}
+	for _, style := range Styles {
+		if c.style == style {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("sass: unknown output style %q. It should be one of: %s", c.style, strings.Join(Styles, ", "))
+}
+
+// compressed returns true iff the output style is compressed
+func (c *compiler) compressed() bool {
+	return c.style == "compressed"
+}
+
+// format formats v as css according to the options for c
+func (c *compiler) format(v value) (string, error) {
+	return format(v, c.precision, c.compressed())
+}
+
+// parseFile reads and parses the file at path
+func (c *compiler) parseFile(path string) ([]stmt, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.sources[path] = string(content)
+	return parse(path, string(content))
+}
+
+// errorf returns an Error for the statement at p
+func (c *compiler) errorf(p pos, format string, args ...interface{}) error {
+	return newError(p, c.sources[p.path], fmt.Sprintf(format, args...))
+}
+
+// wrap adds the position p to err, unless it already has a position
+func (c *compiler) wrap(p pos, err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return c.errorf(p, "%s", err)
+}
+
+// resolveImport returns the path of the file for an @import of name in the
+// file at from.
+func (c *compiler) resolveImport(from string, name string) (string, error) {
+	for _, path := range importCandidates(from, name, c.loadPaths) {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("File to import not found or unreadable: %s.", name)
+}
+
+// importCandidates returns the paths where the file for an import of name in
+// the file at from may be, in order of precedence. It looks for the file
+// relative to the directory that contains from and then in each of the load
+// paths, with or without an underscore and the .scss extension, or as the
+// index file of a directory.
+func importCandidates(from string, name string, loadPaths []string) []string {
+	dir, base := filepath.Split(name)
+	names := []string{name}
+	if filepath.Ext(base) != ".scss" {
+		names = []string{
+			filepath.Join(dir, base+".scss"),
+			filepath.Join(dir, "_"+base+".scss"),
+			filepath.Join(name, "index.scss"),
+			filepath.Join(name, "_index.scss"),
+		}
+	} else if !strings.HasPrefix(base, "_") {
+		names = append(names, filepath.Join(dir, "_"+base))
+	}
+	if filepath.IsAbs(name) {
+		return names
+	}
+	candidates := []string{}
+	for _, searchDir := range append([]string{filepath.Dir(from)}, loadPaths...) {
+		for _, n := range names {
+			candidates = append(candidates, filepath.Join(searchDir, n))
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,320 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"github.com/albrow/scribble/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileFile(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_compile_file")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Partials which may be imported by the test cases
+	writeFile(t, filepath.Join(root, "_colors.scss"), "$red: #ff0000;\n$blue: blue !default;")
+	writeFile(t, filepath.Join(root, "mixins", "_buttons.scss"), "@mixin button($color, $pad: 5px) {\n\tcolor: $color;\n\tpadding: $pad $pad * 2;\n\t@content;\n}")
+
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "variables",
+			src:      "$size: 10px;\n.a { width: $size; height: $size * 2 + 1px; }",
+			expected: ".a {\n  width: 10px;\n  height: 21px; }\n",
+		},
+		{
+			name:     "nesting",
+			src:      ".a {\n\tcolor: red;\n\t.b, > .c { color: blue; }\n\t&:hover, &-d { color: green; }\n}\n.e { margin: 0; }",
+			expected: ".a {\n  color: red; }\n  .a .b, .a > .c {\n    color: blue; }\n  .a:hover, .a-d {\n    color: green; }\n\n.e {\n  margin: 0; }\n",
+		},
+		{
+			name:     "nested properties",
+			src:      ".a { font: { family: Georgia; size: 12px; } }",
+			expected: ".a {\n  font-family: Georgia;\n  font-size: 12px; }\n",
+		},
+		{
+			name:     "imports",
+			src:      "@import \"colors\", \"mixins/buttons\";\n$blue: #00f;\n.a { @include button($red) { border: 0; } }\n.b { color: $blue; }",
+			expected: ".a {\n  color: #ff0000;\n  padding: 5px 10px;\n  border: 0; }\n\n.b {\n  color: #00f; }\n",
+		},
+		{
+			name:     "css imports",
+			src:      ".a { color: red; }\n@import \"reset.css\";",
+			expected: "@import \"reset.css\";\n\n.a {\n  color: red; }\n",
+		},
+		{
+			name:     "mixin keyword arguments",
+			src:      "@mixin m($a: 1, $b: 2) { order: $a $b; }\n.a { @include m($b: 3); }",
+			expected: ".a {\n  order: 1 3; }\n",
+		},
+		{
+			name:     "functions",
+			src:      "@function double($n) { @return $n * 2; }\n.a { width: double(5px); height: percentage(1/4); color: darken(#fff, 20%); background: rgba(#000, .5); }",
+			expected: ".a {\n  width: 10px;\n  height: 25%;\n  color: #cccccc;\n  background: rgba(0, 0, 0, 0.5); }\n",
+		},
+		{
+			name:     "plain css functions",
+			src:      ".a { transform: translate(10px, 20px); width: calc(100% - 10px); background: url(\"a.png\"); }",
+			expected: ".a {\n  transform: translate(10px, 20px);\n  width: calc(100% - 10px);\n  background: url(\"a.png\"); }\n",
+		},
+		{
+			name:     "slashes",
+			src:      "$h: 1.5;\n.a { font: 12px/1.5 serif; width: (10px / 2); height: 10px / $h * 3; }",
+			expected: ".a {\n  font: 12px/1.5 serif;\n  width: 5px;\n  height: 20px; }\n",
+		},
+		{
+			name:     "interpolation",
+			src:      "$name: icon;\n$side: left;\n.#{$name}-x { margin-#{$side}: #{1 + 2}px; content: \"#{$name}\"; }",
+			expected: ".icon-x {\n  margin-left: 3px;\n  content: \"icon\"; }\n",
+		},
+		{
+			name:     "control directives",
+			src:      "$theme: dark;\n$sizes: (s: 1px, l: 2px);\n.a { @if $theme == light { color: white; } @else if $theme == dark { color: black; } }\n@each $name, $size in $sizes { .b-#{$name} { width: $size; } }\n@for $i from 1 to 3 { .c-#{$i} { order: $i; } }",
+			expected: ".a {\n  color: black; }\n\n.b-s {\n  width: 1px; }\n\n.b-l {\n  width: 2px; }\n\n.c-1 {\n  order: 1; }\n\n.c-2 {\n  order: 2; }\n",
+		},
+		{
+			name:     "media",
+			src:      "$md: 768px;\n.a { color: red; @media screen and (min-width: $md) { color: blue; } }",
+			expected: ".a {\n  color: red; }\n  @media screen and (min-width: 768px) {\n    .a {\n      color: blue; } }\n",
+		},
+		{
+			name:     "extend",
+			src:      "%base { margin: 0; }\n.error { color: red; }\n.a { @extend %base; @extend .error; padding: 0; }",
+			expected: ".a {\n  margin: 0; }\n\n.error, .a {\n  color: red; }\n\n.a {\n  padding: 0; }\n",
+		},
+		{
+			name:     "comments",
+			src:      "// silent\n/* loud */\n.a { color: red; // silent\n}",
+			expected: "/* loud */\n.a {\n  color: red; }\n",
+		},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(root, strings.Replace(tc.name, " ", "_", -1)+".scss")
+		writeFile(t, path, tc.src)
+		got, err := CompileFile(path)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", tc.name, err)
+			continue
+		}
+		if string(got) != tc.expected {
+			t.Errorf("Output for %s was incorrect.\nExpected:\n%s\nBut got:\n%s", tc.name, tc.expected, string(got))
+		}
+	}
+}
+
+func TestStyles(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_styles")
+	defer func() {
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	path := filepath.Join(root, "main.scss")
+	writeFile(t, path, "/* a */\n.a {\n\tcolor: #ffffff;\n\tmargin: 0.5px 1px;\n\t> .b, .c ~ .d { top: 0; }\n\t@media print { width: 1px; }\n}\n.e { left: 0; }")
+	testCases := []struct {
+		style    string
+		expected string
+	}{
+		{
+			style:    "nested",
+			expected: "/* a */\n.a {\n  color: #ffffff;\n  margin: 0.5px 1px; }\n  .a > .b, .a .c ~ .d {\n    top: 0; }\n  @media print {\n    .a {\n      width: 1px; } }\n\n.e {\n  left: 0; }\n",
+		},
+		{
+			style:    "expanded",
+			expected: "/* a */\n.a {\n  color: #ffffff;\n  margin: 0.5px 1px;\n}\n\n.a > .b, .a .c ~ .d {\n  top: 0;\n}\n\n@media print {\n  .a {\n    width: 1px;\n  }\n}\n\n.e {\n  left: 0;\n}\n",
+		},
+		{
+			style:    "compact",
+			expected: "/* a */\n.a { color: #ffffff; margin: 0.5px 1px; }\n.a > .b, .a .c ~ .d { top: 0; }\n@media print { .a { width: 1px; } }\n\n.e { left: 0; }\n",
+		},
+		{
+			style:    "compressed",
+			expected: ".a{color:#fff;margin:.5px 1px}.a>.b,.a .c~.d{top:0}@media print{.a{width:1px}}.e{left:0}\n",
+		},
+	}
+	for _, tc := range testCases {
+		result, err := Compile(path, Options{Style: tc.style})
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", tc.style, err)
+			continue
+		}
+		if got := string(result.CSS); got != tc.expected {
+			t.Errorf("Output for %s was incorrect.\nExpected:\n%s\nBut got:\n%s", tc.style, tc.expected, got)
+		}
+	}
+	if _, err := Compile(path, Options{Style: "fancy"}); err == nil {
+		t.Error("Expected an error for an unknown style but got none")
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_source_map")
+	defer func() {
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	writeFile(t, filepath.Join(root, "source", "_colors.scss"), "$red: #f00;\n.red {\n\tcolor: $red;\n}")
+	path := filepath.Join(root, "source", "main.scss")
+	writeFile(t, path, "@import \"colors\";\n\n.a {\n\tcolor: $red;\n}")
+	result, err := Compile(path, Options{Style: "expanded", SourceMap: filepath.Join(root, "public", "main.css.map")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCSS := ".red {\n  color: #f00;\n}\n\n.a {\n  color: #f00;\n}\n\n/*# sourceMappingURL=main.css.map */\n"
+	if got := string(result.CSS); got != expectedCSS {
+		t.Errorf("Output was incorrect.\nExpected:\n%s\nBut got:\n%s", expectedCSS, got)
+	}
+	// .red is on line 2 of _colors.scss, and .a is on line 3 of main.scss,
+	// each with a declaration on the next line
+	expectedMap := `{"version":3,"file":"main.css","sources":["../source/_colors.scss","../source/main.scss"],"sourcesContent":["$red: #f00;\n.red {\n\tcolor: $red;\n}","@import \"colors\";\n\n.a {\n\tcolor: $red;\n}"],"names":[],"mappings":"AACA;EACA;;;ACAA;EACA"}`
+	if got := string(result.SourceMap); got != expectedMap {
+		t.Errorf("Source map was incorrect.\nExpected: %s\nBut got:  %s", expectedMap, got)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_dependencies")
+	defer func() {
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	files := map[string]string{
+		"main.scss":                  "// @import \"commented\";\n@import \"reset.css\", \"base\";\n@use \"sass:math\";\n@use 'vendor' as v;\n.a { background: url(http://example.com/a.png); } @import \"extra\";",
+		"_base.scss":                 "/* @import \"commented\"; */\n@import \"colors\", \"missing\";",
+		"_colors.scss":               "@import \"base\";",
+		"_vendor/vendor/_index.scss": "$x: 1;",
+		"_extra.scss":                "",
+	}
+	for path, content := range files {
+		writeFile(t, filepath.Join(root, path), content)
+	}
+	got, err := Dependencies(filepath.Join(root, "main.scss"), []string{filepath.Join(root, "_vendor")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"main.scss",
+		"_base.scss",
+		"_colors.scss",
+		// the places where the missing import was looked for
+		"missing.scss",
+		"_missing.scss",
+		"missing/index.scss",
+		"missing/_index.scss",
+		"_vendor/missing.scss",
+		"_vendor/_missing.scss",
+		"_vendor/missing/index.scss",
+		"_vendor/missing/_index.scss",
+		"_vendor/vendor/_index.scss",
+		"_extra.scss",
+	}
+	for i := range expected {
+		expected[i] = filepath.Join(root, expected[i])
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Dependencies were incorrect.\nExpected: %v\nBut got:  %v", expected, got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sass_errors")
+	defer func() {
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	writeFile(t, filepath.Join(root, "_broken.scss"), "\n.a {\n\tcolor: $missing;\n}")
+
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "undefined variable",
+			src:      ".a {\n\tcolor: $red;\n}",
+			expected: "Error: Undefined variable: \"$red\".\n        on line 2 of " + filepath.Join(root, "undefined_variable.scss") + "\n>> color: $red;",
+		},
+		{
+			name:     "error in import",
+			src:      "@import \"broken\";",
+			expected: "on line 3 of " + filepath.Join(root, "_broken.scss"),
+		},
+		{
+			name:     "missing import",
+			src:      "@import \"missing\";",
+			expected: "File to import not found or unreadable: missing.",
+		},
+		{
+			name:     "unclosed block",
+			src:      ".a {\n\tcolor: red;",
+			expected: "expected \"}\"",
+		},
+		{
+			name:     "undefined mixin",
+			src:      ".a { @include missing; }",
+			expected: "no mixin named missing",
+		},
+		{
+			name:     "incompatible units",
+			src:      ".a { width: 1px + 1em; }",
+			expected: "Incompatible units",
+		},
+		{
+			name:     "error directive",
+			src:      "@error \"oops\";",
+			expected: "Error: oops",
+		},
+		{
+			name:     "failed extend",
+			src:      ".a { @extend .missing; }",
+			expected: "failed to @extend \".missing\"",
+		},
+		{
+			name:     "module system",
+			src:      "@use \"colors\";",
+			expected: "@use is not supported",
+		},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(root, strings.Replace(tc.name, " ", "_", -1)+".scss")
+		writeFile(t, path, tc.src)
+		_, err := CompileFile(path)
+		if err == nil {
+			t.Errorf("Expected an error for %s but got none", tc.name)
+		} else if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Expected the error for %s to contain %q but got: %s", tc.name, tc.expected, err)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := util.CreateEmptyFiles([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// mapping maps a position in the output to the position in a sass file
+// where it came from. Lines and columns start at 0.
+type mapping struct {
+	line   int
+	col    int
+	source pos
+}
+
+// sourceMapV3 is the format of a source map, as described in
+// https://sourcemaps.info/spec.html. SourcesContent holds the contents of
+// each of the sources, so that browsers can show them even though they are
+// not in the destDir.
+type sourceMapV3 struct {
+	Version        int      `json:"version"`
+	File           string   `json:"file"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+}
+
+// sourceMap returns the source map for the output of p, which will be
+// written to path. The css file is assumed to be path without the .map
+// extension, and the sources are relative to the directory which contains
+// path. sources holds the contents of each file that was parsed, which is
+// included in the source map.
+func (p *printer) sourceMap(path string, sources map[string]string) ([]byte, error) {
+	dir := filepath.Dir(path)
+	m := sourceMapV3{
+		Version:        3,
+		File:           filepath.Base(strings.TrimSuffix(path, ".map")),
+		Sources:        []string{},
+		SourcesContent: []string{},
+		Names:          []string{},
+	}
+	sourceIndexes := map[string]int{}
+	var mappings strings.Builder
+	// each field is relative to the previous mapping, except for the column
+	// in the output, which is relative to the previous mapping on the same
+	// line
+	line, col, source, sourceLine := 0, 0, 0, 0
+	for i, mp := range p.mappings {
+		index, found := sourceIndexes[mp.source.path]
+		if !found {
+			rel, err := filepath.Rel(dir, mp.source.path)
+			if err != nil {
+				rel = mp.source.path
+			}
+			index = len(m.Sources)
+			sourceIndexes[mp.source.path] = index
+			m.Sources = append(m.Sources, filepath.ToSlash(rel))
+			m.SourcesContent = append(m.SourcesContent, sources[mp.source.path])
+		}
+		if mp.line > line {
+			mappings.WriteString(strings.Repeat(";", mp.line-line))
+			line, col = mp.line, 0
+		} else if i > 0 {
+			mappings.WriteString(",")
+		}
+		mappings.WriteString(encodeVLQ(mp.col - col))
+		mappings.WriteString(encodeVLQ(index - source))
+		mappings.WriteString(encodeVLQ(mp.source.line - 1 - sourceLine))
+		// the column in the source is always 0, since only lines are known
+		mappings.WriteString(encodeVLQ(0))
+		col, source, sourceLine = mp.col, index, mp.source.line-1
+	}
+	m.Mappings = mappings.String()
+	return json.Marshal(m)
+}
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// encodeVLQ encodes n as a base64 variable-length quantity, as used in the
+// mappings of a source map
+func encodeVLQ(n int) string {
+	v := n << 1
+	if n < 0 {
+		v = (-n << 1) | 1
+	}
+	result := ""
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		result += string(base64Digits[digit])
+		if v == 0 {
+			return result
+		}
+	}
+}
@@ -0,0 +1,516 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package sass
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// value is the result of evaluating a sass expression. It is one of number,
+// color, str, list, sassMap, boolean, or null.
+type value interface{}
+
+type (
+	// number is a number with an optional unit, e.g. 10px or 1.5
+	number struct {
+		v    float64
+		unit string
+	}
+	// color is an rgb color with an alpha channel. If the color was written
+	// literally and has not been changed, repr is the way it was written,
+	// e.g. #f00 or red, so that it is output the same way.
+	color struct {
+		r, g, b, a float64
+		repr       string
+	}
+	// str is a quoted or unquoted string, e.g. "Helvetica" or sans-serif
+	str struct {
+		s      string
+		quoted bool
+	}
+	// list is a space or comma separated list, e.g. 1px solid red
+	list struct {
+		items []value
+		// sep is either " " or ","
+		sep string
+	}
+	// sassMap is a map, e.g. (small: 10px, large: 20px). The keys are kept
+	// in the order they were defined.
+	sassMap struct {
+		keys   []value
+		values []value
+	}
+	boolean bool
+	null    struct{}
+)
+
+// isNull returns true iff v is null
+func isNull(v value) bool {
+	_, ok := v.(null)
+	return ok
+}
+
+// truthy returns true iff v is anything but false or null
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case boolean:
+		return bool(v)
+	case null:
+		return false
+	}
+	return true
+}
+
+// typeOf returns the name of the type of v, as returned by type-of()
+func typeOf(v value) string {
+	switch v.(type) {
+	case number:
+		return "number"
+	case color:
+		return "color"
+	case str:
+		return "string"
+	case list:
+		return "list"
+	case sassMap:
+		return "map"
+	case boolean:
+		return "bool"
+	}
+	return "null"
+}
+
+// listItems returns the items in v if it is a list or map, or a list with v
+// as its only item otherwise.
+func listItems(v value) []value {
+	switch v := v.(type) {
+	case list:
+		return v.items
+	case sassMap:
+		items := make([]value, len(v.keys))
+		for i := range v.keys {
+			items[i] = list{items: []value{v.keys[i], v.values[i]}, sep: " "}
+		}
+		return items
+	case null:
+		return nil
+	}
+	return []value{v}
+}
+
+// get returns the value for key in m, or null if there is none
+func (m sassMap) get(key value) value {
+	for i, k := range m.keys {
+		if equal(k, key) {
+			return m.values[i]
+		}
+	}
+	return null{}
+}
+
+// set returns a copy of m with key set to v
+func (m sassMap) set(key value, v value) sassMap {
+	result := sassMap{keys: append([]value{}, m.keys...), values: append([]value{}, m.values...)}
+	for i, k := range result.keys {
+		if equal(k, key) {
+			result.values[i] = v
+			return result
+		}
+	}
+	result.keys = append(result.keys, key)
+	result.values = append(result.values, v)
+	return result
+}
+
+// format returns v formatted as css. precision is the number of digits
+// after the decimal point for numbers, and compressed is true iff
+// unnecessary characters should be removed.
+func format(v value, precision int, compressed bool) (string, error) {
+	switch v := v.(type) {
+	case number:
+		return formatNumber(v, precision, compressed), nil
+	case color:
+		return formatColor(v, precision, compressed), nil
+	case str:
+		if v.quoted {
+			return quote(v.s), nil
+		}
+		return v.s, nil
+	case list:
+		items := []string{}
+		for _, item := range v.items {
+			if isNull(item) {
+				continue
+			}
+			s, err := format(item, precision, compressed)
+			if err != nil {
+				return "", err
+			}
+			if l, ok := item.(list); ok && l.sep == "," && v.sep == "," {
+				s = "(" + s + ")"
+			}
+			items = append(items, s)
+		}
+		sep := v.sep
+		if sep == "," && !compressed {
+			sep = ", "
+		}
+		return strings.Join(items, sep), nil
+	case sassMap:
+		s, _ := inspect(v)
+		return "", fmt.Errorf("%s isn't a valid CSS value.", s)
+	case boolean:
+		return strconv.FormatBool(bool(v)), nil
+	case null:
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown value %v", v)
+}
+
+// inspect returns a representation of v for use in error messages and
+// interpolation. Unlike format, it never fails.
+func inspect(v value) (string, error) {
+	switch v := v.(type) {
+	case sassMap:
+		pairs := make([]string, len(v.keys))
+		for i := range v.keys {
+			key, _ := inspect(v.keys[i])
+			val, _ := inspect(v.values[i])
+			pairs[i] = key + ": " + val
+		}
+		return "(" + strings.Join(pairs, ", ") + ")", nil
+	case null:
+		return "null", nil
+	}
+	return format(v, defaultPrecision, false)
+}
+
+// toString returns the contents of v as a string without quotes, as is done
+// for interpolation.
+func toString(v value, precision int) (string, error) {
+	switch v := v.(type) {
+	case str:
+		return v.s, nil
+	case null:
+		return "", nil
+	case list:
+		items := []string{}
+		for _, item := range v.items {
+			s, err := toString(item, precision)
+			if err != nil {
+				return "", err
+			}
+			if s != "" {
+				items = append(items, s)
+			}
+		}
+		sep := v.sep
+		if sep == "," {
+			sep = ", "
+		}
+		return strings.Join(items, sep), nil
+	case sassMap:
+		return inspect(v)
+	}
+	return format(v, precision, false)
+}
+
+func quote(s string) string {
+	if strings.Contains(s, `"`) && !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func formatNumber(n number, precision int, compressed bool) string {
+	v := n.v
+	scale := math.Pow(10, float64(precision))
+	v = math.Round(v*scale) / scale
+	if v == 0 {
+		// avoid -0
+		v = 0
+	}
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if strings.Contains(s, ".") {
+		s = strconv.FormatFloat(v, 'f', precision, 64)
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if compressed {
+		if strings.HasPrefix(s, "0.") {
+			s = s[1:]
+		} else if strings.HasPrefix(s, "-0.") {
+			s = "-" + s[2:]
+		}
+	}
+	return s + n.unit
+}
+
+func formatColor(c color, precision int, compressed bool) string {
+	if c.repr != "" && !compressed {
+		return c.repr
+	}
+	r, g, b := clampChannel(c.r), clampChannel(c.g), clampChannel(c.b)
+	if c.a < 1 {
+		alpha := formatNumber(number{v: math.Max(c.a, 0)}, precision, compressed)
+		sep := ", "
+		if compressed {
+			sep = ","
+		}
+		return fmt.Sprintf("rgba(%d%s%d%s%d%s%s)", r, sep, g, sep, b, sep, alpha)
+	}
+	hex := fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	if compressed {
+		if hex[1] == hex[2] && hex[3] == hex[4] && hex[5] == hex[6] {
+			hex = "#" + hex[1:2] + hex[3:4] + hex[5:6]
+		}
+		if c.repr != "" && len(c.repr) < len(hex) {
+			return c.repr
+		}
+	}
+	return hex
+}
+
+// clampChannel rounds a color channel and clamps it between 0 and 255
+func clampChannel(v float64) int {
+	return int(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// unitConversions holds the size of each unit which can be converted to
+// another unit, relative to the other units of the same kind.
+var unitConversions = map[string]struct {
+	kind string
+	size float64
+}{
+	"px":   {"length", 1},
+	"in":   {"length", 96},
+	"cm":   {"length", 96 / 2.54},
+	"mm":   {"length", 96 / 25.4},
+	"pt":   {"length", 96.0 / 72},
+	"pc":   {"length", 16},
+	"s":    {"time", 1000},
+	"ms":   {"time", 1},
+	"deg":  {"angle", 1},
+	"rad":  {"angle", 180 / math.Pi},
+	"grad": {"angle", 0.9},
+	"turn": {"angle", 360},
+}
+
+// convertUnit converts n to the given unit. It returns false if the units
+// are incompatible, e.g. px and em.
+func convertUnit(n number, unit string) (number, bool) {
+	if n.unit == unit || n.unit == "" || unit == "" {
+		return number{v: n.v, unit: unit}, true
+	}
+	from, fromFound := unitConversions[n.unit]
+	to, toFound := unitConversions[unit]
+	if !fromFound || !toFound || from.kind != to.kind {
+		return n, false
+	}
+	return number{v: n.v * from.size / to.size, unit: unit}, true
+}
+
+// equal returns true iff a and b are equal, as tested by == in sass
+func equal(a, b value) bool {
+	switch a := a.(type) {
+	case number:
+		b, ok := b.(number)
+		if !ok {
+			return false
+		}
+		if a.unit == "" || b.unit == "" {
+			return a.unit == b.unit && a.v == b.v
+		}
+		converted, ok := convertUnit(b, a.unit)
+		return ok && math.Abs(converted.v-a.v) < 1e-10
+	case color:
+		b, ok := b.(color)
+		return ok && clampChannel(a.r) == clampChannel(b.r) && clampChannel(a.g) == clampChannel(b.g) &&
+			clampChannel(a.b) == clampChannel(b.b) && a.a == b.a
+	case str:
+		b, ok := b.(str)
+		return ok && a.s == b.s
+	case list:
+		b, ok := b.(list)
+		if !ok || len(a.items) != len(b.items) || (a.sep != b.sep && len(a.items) > 1) {
+			return false
+		}
+		for i := range a.items {
+			if !equal(a.items[i], b.items[i]) {
+				return false
+			}
+		}
+		return true
+	case sassMap:
+		b, ok := b.(sassMap)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for i, key := range a.keys {
+			if !equal(a.values[i], b.get(key)) {
+				return false
+			}
+		}
+		return true
+	case boolean:
+		b, ok := b.(boolean)
+		return ok && a == b
+	case null:
+		return isNull(b)
+	}
+	return false
+}
+
+// operate applies the binary operator op to a and b
+func operate(op string, a, b value) (value, error) {
+	switch op {
+	case "==":
+		return boolean(equal(a, b)), nil
+	case "!=":
+		return boolean(!equal(a, b)), nil
+	}
+	switch a := a.(type) {
+	case number:
+		switch b := b.(type) {
+		case number:
+			return operateNumbers(op, a, b)
+		case color:
+			if op == "+" || op == "*" {
+				return operateColor(op, b, a)
+			}
+		case str:
+			if op == "+" {
+				s, err := format(a, defaultPrecision, false)
+				return str{s: s + b.s, quoted: b.quoted}, err
+			}
+		}
+	case color:
+		return operateColor(op, a, b)
+	case str:
+		if op == "+" || op == "-" || op == "/" {
+			s, err := toString(b, defaultPrecision)
+			if err != nil {
+				return nil, err
+			}
+			if op != "+" {
+				s = op + s
+			}
+			return str{s: a.s + s, quoted: a.quoted}, nil
+		}
+	}
+	if op == "+" || op == "-" || op == "/" {
+		// anything else is concatenated as an unquoted string, e.g. a list
+		// plus a string
+		left, err := toString(a, defaultPrecision)
+		if err != nil {
+			return nil, err
+		}
+		right, err := toString(b, defaultPrecision)
+		if err != nil {
+			return nil, err
+		}
+		if op != "+" {
+			right = op + right
+		}
+		return str{s: left + right}, nil
+	}
+	left, _ := inspect(a)
+	right, _ := inspect(b)
+	return nil, fmt.Errorf("Undefined operation: \"%s %s %s\".", left, op, right)
+}
+
+func operateNumbers(op string, a, b number) (value, error) {
+	// convert b to the unit of a for addition, subtraction, and comparison
+	unit := a.unit
+	if unit == "" {
+		unit = b.unit
+	}
+	switch op {
+	case "+", "-", "%", "<", ">", "<=", ">=":
+		converted, ok := convertUnit(b, unit)
+		if !ok {
+			return nil, fmt.Errorf("Incompatible units: '%s' and '%s'.", b.unit, a.unit)
+		}
+		left, right := a.v, converted.v
+		switch op {
+		case "+":
+			return number{v: left + right, unit: unit}, nil
+		case "-":
+			return number{v: left - right, unit: unit}, nil
+		case "%":
+			return number{v: math.Mod(left, right), unit: unit}, nil
+		case "<":
+			return boolean(left < right), nil
+		case ">":
+			return boolean(left > right), nil
+		case "<=":
+			return boolean(left <= right), nil
+		}
+		return boolean(left >= right), nil
+	case "*":
+		if a.unit != "" && b.unit != "" {
+			return nil, fmt.Errorf("%s*%s isn't a valid CSS value.", formatNumber(a, defaultPrecision, false), formatNumber(b, defaultPrecision, false))
+		}
+		return number{v: a.v * b.v, unit: unit}, nil
+	case "/":
+		if b.unit == "" {
+			return number{v: a.v / b.v, unit: a.unit}, nil
+		}
+		converted, ok := convertUnit(b, a.unit)
+		if !ok || a.unit == "" {
+			return nil, fmt.Errorf("%s/%s isn't a valid CSS value.", formatNumber(a, defaultPrecision, false), formatNumber(b, defaultPrecision, false))
+		}
+		return number{v: a.v / converted.v}, nil
+	}
+	return nil, fmt.Errorf("Undefined operation: \"%s %s %s\".", formatNumber(a, defaultPrecision, false), op, formatNumber(b, defaultPrecision, false))
+}
+
+// operateColor applies op to each channel of c, e.g. #010203 + #010101
+func operateColor(op string, c color, other value) (value, error) {
+	if !strings.Contains("+-*/%", op) {
+		right, _ := inspect(other)
+		return nil, fmt.Errorf("Undefined operation: \"%s %s %s\".", formatColor(c, defaultPrecision, false), op, right)
+	}
+	var r, g, b float64
+	switch other := other.(type) {
+	case number:
+		r, g, b = other.v, other.v, other.v
+	case color:
+		r, g, b = other.r, other.g, other.b
+	default:
+		if op == "+" {
+			left := formatColor(c, defaultPrecision, false)
+			right, err := toString(other, defaultPrecision)
+			return str{s: left + right}, err
+		}
+		right, _ := inspect(other)
+		return nil, fmt.Errorf("Undefined operation: \"%s %s %s\".", formatColor(c, defaultPrecision, false), op, right)
+	}
+	apply := func(x, y float64) float64 {
+		switch op {
+		case "+":
+			return x + y
+		case "-":
+			return x - y
+		case "*":
+			return x * y
+		case "/":
+			return x / y
+		}
+		return math.Mod(x, y)
+	}
+	return color{
+		r: clampFloat(apply(c.r, r), 0, 255),
+		g: clampFloat(apply(c.g, g), 0, 255),
+		b: clampFloat(apply(c.b, b), 0, 255),
+		a: c.a,
+	}, nil
+}
+
+func clampFloat(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
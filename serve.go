@@ -5,37 +5,26 @@
 package main
 
 import (
-	"fmt"
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/log"
-	"github.com/codegangsta/negroni"
+	"github.com/albrow/scribble/site"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 )
 
-// serve serves all the static content in config.DestDir via a lightweight
-// negroni server on the given port.
-func serve(port int) {
-	log.Default.Printf("Serving on port %d", port)
-	// use negroni to serve destDir on port
-	destFileSystem := http.Dir(config.DestDir)
-	n := negroni.New(negroni.NewRecovery(), negroni.NewStatic(destFileSystem), negroni.HandlerFunc(NotFound))
-	portStr := fmt.Sprintf(":%d", port)
-	log.Error.Fatal(http.ListenAndServe(portStr, n))
-}
-
-func NotFound(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	rw.WriteHeader(http.StatusNotFound)
-	rw.Header().Add("Content-Type", "text/html")
-	urlPath := strings.Replace(r.URL.String(), "/", string(os.PathSeparator), -1)
-	lookedPath := filepath.Join(config.DestDir, urlPath)
-	content := fmt.Sprintf("<h3>404 Not Found</h3><p>Scribble could not find <em>%s</em>. Looked in <em>%s</em>.</p>", r.URL, lookedPath)
-	fmt.Fprint(rw, wrapHtml("Not Found", content))
-}
-
-// wrapHtml returns a string of boilerplate-wrapped html with the given title and content.
-func wrapHtml(title string, content string) string {
-	return fmt.Sprintf(`<!doctype html><html><head><title>%s</title></head><body>%s</body></html>`, title, content)
+// serve serves all the static content in the destination directory for s
+// on the given port, with the options in the serve section of config.toml
+// and the command line flags. Pages which are open in a browser are reloaded
+// whenever something is recompiled, and are covered by an error overlay if it
+// failed.
+func serve(s *site.Site, port int) {
+	s.LiveReload = true
+	s.ErrorOverlay = true
+	host := s.Config.Serve.Host
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if host == "" {
+		host = "localhost"
+	}
+	log.Default.Printf("Serving on http://%s%s/", net.JoinHostPort(host, strconv.Itoa(port)), s.Config.Serve.BasePath)
+	log.Error.Fatal(http.ListenAndServe(addr, s.Handler()))
 }
@@ -0,0 +1,87 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package site
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressibleTypes are the prefixes of the content types which are worth
+// compressing. Most other files, e.g. images, are compressed already.
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"image/svg+xml",
+}
+
+// compress compresses the response with gzip if the browser supports it and
+// the content type is worth compressing. The live reload endpoint and
+// requests for a range of a file are never compressed.
+func compress(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.URL.Path == LiveReloadPath || r.Header.Get("Range") != "" {
+		next(rw, r)
+		return
+	}
+	res := &gzipResponse{ResponseWriter: rw}
+	next(res, r)
+	if res.gz != nil {
+		res.gz.Close()
+	}
+}
+
+// gzipResponse is an http.ResponseWriter which decides whether or not to
+// compress the response once the headers are written
+type gzipResponse struct {
+	http.ResponseWriter
+	// gz is the writer for the compressed response, or nil if the response
+	// is not compressed
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponse) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	header := g.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponse) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// compressible returns true iff responses with the given content type are
+// worth compressing
+func compressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
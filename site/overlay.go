@@ -0,0 +1,113 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package site
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/compilers"
+	"html"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LastError returns the errors from the last time the site was built and
+// from recompiling each file that changed since then which has not been
+// recompiled successfully yet, or nil if there are none. More than one error
+// is returned as compilers.CompileErrors.
+func (s *Site) LastError() error {
+	s.servingMutex.Lock()
+	defer s.servingMutex.Unlock()
+	return s.combinedErrors()
+}
+
+// combinedErrors returns all of the errors in s.errs, sorted by path, as one
+// error. The caller must hold s.servingMutex.
+func (s *Site) combinedErrors() error {
+	paths := []string{}
+	for path := range s.errs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	errs := compilers.CompileErrors{}
+	for _, path := range paths {
+		if compileErrs, ok := s.errs[path].(compilers.CompileErrors); ok {
+			errs = append(errs, compileErrs...)
+		} else {
+			errs = append(errs, s.errs[path])
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// setError sets the error from recompiling after the file at path changed,
+// which is nil if it succeeded. It returns true iff there were any errors
+// before.
+func (s *Site) setError(path string, err error) bool {
+	s.servingMutex.Lock()
+	defer s.servingMutex.Unlock()
+	hadErrors := len(s.errs) > 0
+	if err != nil {
+		s.errs[path] = err
+	} else {
+		delete(s.errs, path)
+	}
+	return hadErrors
+}
+
+// resetErrors forgets about all the errors from before the site was built and
+// sets the error from the build, which is nil if it succeeded.
+func (s *Site) resetErrors(err error) {
+	s.servingMutex.Lock()
+	defer s.servingMutex.Unlock()
+	s.errs = map[string]error{}
+	if err != nil {
+		s.errs[""] = err
+	}
+}
+
+// errorOverlay returns html for an overlay which covers the page and
+// describes each error in err, i.e. which compiler failed, the source file
+// and line, and the error message.
+func (s *Site) errorOverlay(err error) string {
+	errs := []error{err}
+	if compileErrs, ok := err.(compilers.CompileErrors); ok {
+		errs = compileErrs
+	}
+	items := []string{}
+	for _, err := range errs {
+		heading := "Error"
+		msg := err.Error()
+		if fileErr, ok := err.(*compilers.FileError); ok {
+			if fileErr.Compiler != "" {
+				heading = fmt.Sprintf("Error in %s compiler", fileErr.Compiler)
+			}
+			location := fileErr.Path
+			// Show the path relative to the directory which contains the
+			// source directory, e.g. source/styles/main.scss
+			if rel, err := filepath.Rel(filepath.Dir(s.Config.SourceDir), fileErr.Path); err == nil {
+				location = rel
+			}
+			if fileErr.Line > 0 {
+				location += fmt.Sprintf(":%d", fileErr.Line)
+			}
+			heading += ": " + location
+		}
+		items = append(items, fmt.Sprintf(`<h3 style="margin:1.5em 0 0.5em;font-size:16px;color:#ff8080">%s</h3><pre style="margin:0;white-space:pre-wrap;font-size:14px">%s</pre>`,
+			html.EscapeString(heading), html.EscapeString(msg)))
+	}
+	return `<div id="scribble-error-overlay" style="position:fixed;top:0;right:0;bottom:0;left:0;z-index:2147483647;overflow:auto;padding:2em;background:rgba(20,20,20,0.95);color:#eee;font-family:Menlo,Consolas,monospace;text-align:left">` +
+		`<button onclick="this.parentNode.style.display='none'" style="float:right;font-size:14px">Dismiss</button>` +
+		`<h2 style="margin:0;font-size:20px">Scribble could not compile the site</h2>` +
+		strings.Join(items, "") +
+		`<p style="margin-top:2em;color:#aaa">The page below is out of date. This message will go away once the errors are fixed.</p>` +
+		`</div>`
+}
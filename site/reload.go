@@ -0,0 +1,140 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package site
+
+import (
+	"fmt"
+	"github.com/albrow/scribble/compilers"
+	"net/http"
+	"path/filepath"
+	"sync"
+)
+
+// LiveReloadPath is the path of the endpoint which browsers connect to in
+// order to be notified when the site changes. The notifications are sent as
+// server-sent events.
+const LiveReloadPath = "/_scribble/livereload"
+
+// Kinds of live reload events
+const (
+	// reloadEvent tells browsers to reload the page
+	reloadEvent = "reload"
+	// cssEvent tells browsers to reload their stylesheets without reloading
+	// the page
+	cssEvent = "css"
+)
+
+// liveReloadScript is injected into every html page when live reload is
+// enabled. It listens for events from LiveReloadPath and either reloads the
+// page or swaps each stylesheet for a fresh copy.
+const liveReloadScript = `<script>
+(function() {
+	if (!window.EventSource) return;
+	var source = new EventSource("` + LiveReloadPath + `");
+	source.addEventListener("` + reloadEvent + `", function() {
+		window.location.reload();
+	});
+	source.addEventListener("` + cssEvent + `", function() {
+		var links = document.querySelectorAll("link[rel=stylesheet]");
+		for (var i = 0; i < links.length; i++) {
+			var link = links[i];
+			if (link.host !== window.location.host) continue;
+			var href = link.href.replace(/([?&])_scribble=\d+&?/, "$1").replace(/[?&]$/, "");
+			link.href = href + (href.indexOf("?") === -1 ? "?" : "&") + "_scribble=" + Date.now();
+		}
+	});
+})();
+</script>`
+
+// reloader keeps track of the browsers which are connected to the live
+// reload endpoint and notifies them when the site changes
+type reloader struct {
+	sync.Mutex
+	clients map[chan string]struct{}
+}
+
+func newReloader() *reloader {
+	return &reloader{
+		clients: map[chan string]struct{}{},
+	}
+}
+
+// notify sends the event to every connected browser. Browsers which are not
+// keeping up are skipped rather than blocking the watcher.
+func (r *reloader) notify(event string) {
+	r.Lock()
+	defer r.Unlock()
+	for client := range r.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+}
+
+func (r *reloader) subscribe() chan string {
+	r.Lock()
+	defer r.Unlock()
+	client := make(chan string, 8)
+	r.clients[client] = struct{}{}
+	return client
+}
+
+func (r *reloader) unsubscribe(client chan string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.clients, client)
+}
+
+// serveEvents streams live reload events to a single browser until it
+// disconnects
+func (r *reloader) serveEvents(rw http.ResponseWriter, req *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	client := r.subscribe()
+	defer r.unsubscribe(client)
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprint(rw, ": connected\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case event := <-client:
+			fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event, event)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// reloadEventFor returns the kind of live reload event for a change to the
+// file at path. Changes which can only affect stylesheets, i.e. sass files
+// and plain css files, don't need a full page reload. It must be called while
+// s is in use.
+func (s *Site) reloadEventFor(path string) (string, error) {
+	sass := false
+	for _, c := range s.state.Compilers() {
+		match, err := c.WatchMatchFunc()(path)
+		if err != nil {
+			return "", err
+		}
+		if !match {
+			continue
+		}
+		if _, isSass := c.(*compilers.SassCompilerType); !isSass {
+			return reloadEvent, nil
+		}
+		sass = true
+	}
+	if sass || filepath.Ext(path) == ".css" {
+		return cssEvent, nil
+	}
+	return reloadEvent, nil
+}
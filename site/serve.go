@@ -0,0 +1,257 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/log"
+	"github.com/albrow/scribble/util"
+	"github.com/codegangsta/negroni"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler returns an http.Handler which serves all the static content in
+// the destination directory for the site, or in memory if s.InMemory is
+// true. Requests are redirected according to the rules in the _redirects
+// file, and the site's own 404.html page is used for files that don't
+// exist. If s.LiveReload is true, it also serves the live reload endpoint at
+// LiveReloadPath. The options in s.Config.Serve determine the path the site
+// is served under, the headers which are added to responses, and whether or
+// not responses are compressed and requests are logged.
+func (s *Site) Handler() http.Handler {
+	serve := s.Config.Serve
+	n := negroni.New(negroni.NewRecovery())
+	if serve.Log {
+		n.Use(negroni.HandlerFunc(logRequest))
+	}
+	if serve.Gzip {
+		n.Use(negroni.HandlerFunc(compress))
+	}
+	if s.LiveReload || s.ErrorOverlay {
+		n.Use(negroni.HandlerFunc(s.inject))
+	}
+	if basePath := config.CleanBasePath(serve.BasePath); basePath != "" {
+		n.Use(negroni.HandlerFunc(s.stripBasePath(basePath)))
+	}
+	if len(serve.Headers) > 0 {
+		n.Use(negroni.HandlerFunc(addHeaders(serve.Headers)))
+	}
+	n.Use(negroni.HandlerFunc(s.redirect))
+	n.Use(negroni.NewStatic(s.destFileSystem()))
+	n.Use(negroni.HandlerFunc(s.notFound))
+	return n
+}
+
+// destFileSystem returns the http.FileSystem for the destination directory
+func (s *Site) destFileSystem() http.FileSystem {
+	if s.InMemory {
+		return memoryDir{files: s.state.Files(), dir: s.Config.DestDir}
+	}
+	return http.Dir(s.Config.DestDir)
+}
+
+// logRequest logs the method, url, and status of each request along with
+// how long it took to respond.
+func logRequest(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	next(rw, r)
+	status := http.StatusOK
+	if res, ok := rw.(negroni.ResponseWriter); ok && res.Status() != 0 {
+		status = res.Status()
+	}
+	log.Default.Printf("%s %s %d %s", r.Method, r.URL.RequestURI(), status, time.Since(start))
+}
+
+// stripBasePath returns a middleware which serves the site under basePath,
+// e.g. /blog, as if it was deployed to a subdirectory. Requests for the root
+// are redirected to basePath, and any other requests outside of basePath are
+// not found. Redirects to absolute paths within the site, e.g. the ones in
+// the _redirects file, are sent to the same path under basePath.
+func (s *Site) stripBasePath(basePath string) negroni.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		switch {
+		case r.URL.Path == "/" || r.URL.Path == basePath:
+			http.Redirect(rw, r, basePath+"/", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			stripped := *r
+			stripped.URL = new(url.URL)
+			*stripped.URL = *r.URL
+			stripped.URL.Path = strings.TrimPrefix(r.URL.Path, basePath)
+			next(&prefixedRedirects{ResponseWriter: rw, basePath: basePath}, &stripped)
+		default:
+			s.notFound(rw, r, next)
+		}
+	}
+}
+
+// prefixedRedirects is an http.ResponseWriter which adds a base path to the
+// Location header of redirects to absolute paths
+type prefixedRedirects struct {
+	http.ResponseWriter
+	basePath string
+}
+
+func (p *prefixedRedirects) WriteHeader(status int) {
+	location := p.Header().Get("Location")
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+		p.Header().Set("Location", p.basePath+location)
+	}
+	p.ResponseWriter.WriteHeader(status)
+}
+
+// addHeaders returns a middleware which adds the headers from each of the
+// rules whose pattern matches the url path. Rules later in the slice take
+// precedence over earlier ones.
+func addHeaders(rules []config.HeaderRule) negroni.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		for _, rule := range rules {
+			if rule.Matches(r.URL.Path) {
+				for name, value := range rule.Headers {
+					rw.Header().Set(name, value)
+				}
+			}
+		}
+		next(rw, r)
+	}
+}
+
+// redirect redirects the request if its path matches one of the rules in
+// the _redirects file.
+func (s *Site) redirect(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	s.servingMutex.Lock()
+	redirects := s.redirects
+	s.servingMutex.Unlock()
+	for _, redirect := range redirects {
+		if redirect.Matches(r.URL.Path) {
+			http.Redirect(rw, r, redirect.To, redirect.Status)
+			return
+		}
+	}
+	next(rw, r)
+}
+
+// inject serves the live reload endpoint if s.LiveReload is true, and
+// injects html into every html page: the error overlay if s.ErrorOverlay is
+// true and the last build failed, and the live reload script if s.LiveReload
+// is true.
+func (s *Site) inject(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if s.LiveReload && r.URL.Path == LiveReloadPath {
+		s.reloader.serveEvents(rw, r)
+		return
+	}
+	injected := ""
+	if s.ErrorOverlay {
+		if err := s.LastError(); err != nil {
+			injected += s.errorOverlay(err)
+		}
+	}
+	if s.LiveReload {
+		injected += liveReloadScript
+	}
+	if injected == "" {
+		next(rw, r)
+		return
+	}
+	// Ranges of a page would no longer line up once the html is injected
+	r.Header.Del("Range")
+	buf := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	next(buf, r)
+	body := buf.body.Bytes()
+	if buf.status != http.StatusNotModified && strings.HasPrefix(buf.header.Get("Content-Type"), "text/html") {
+		body = injectHtml(body, injected)
+		buf.header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	for key, values := range buf.header {
+		rw.Header()[key] = values
+	}
+	rw.WriteHeader(buf.status)
+	rw.Write(body)
+}
+
+// memoryDir is an http.FileSystem for a destination directory which is kept
+// in memory. The files are looked up on every request, because each build
+// mounts a new util.MemoryFileSystem at the directory.
+type memoryDir struct {
+	files *util.Mounts
+	dir   string
+}
+
+func (m memoryDir) Open(name string) (http.File, error) {
+	fs, ok := m.files.MountedAt(m.dir).(http.FileSystem)
+	if !ok {
+		// The site has not been built yet
+		return nil, os.ErrNotExist
+	}
+	return fs.Open(name)
+}
+
+// injectHtml inserts html right before the closing body tag in page, or at
+// the end if there is no closing body tag
+func injectHtml(page []byte, html string) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i == -1 {
+		return append(page, html...)
+	}
+	result := make([]byte, 0, len(page)+len(html))
+	result = append(result, page[:i]...)
+	result = append(result, html...)
+	return append(result, page[i:]...)
+}
+
+// bufferedResponse is an http.ResponseWriter which holds on to the response
+// so that it can be changed before it is sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// notFound responds with the site's own 404.html page if there is one.
+// Otherwise it responds with a 404 page which says where scribble looked for
+// the requested file.
+func (s *Site) notFound(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if page, err := s.destFileSystem().Open("/404.html"); err == nil {
+		defer page.Close()
+		rw.WriteHeader(http.StatusNotFound)
+		io.Copy(rw, page)
+		return
+	}
+	rw.WriteHeader(http.StatusNotFound)
+	urlPath := strings.Replace(r.URL.Path, "/", string(os.PathSeparator), -1)
+	lookedPath := filepath.Join(s.Config.DestDir, urlPath)
+	if s.InMemory {
+		lookedPath += " (in memory)"
+	}
+	content := fmt.Sprintf("<h3>404 Not Found</h3><p>Scribble could not find <em>%s</em>. Looked in <em>%s</em>.</p>", html.EscapeString(r.URL.String()), html.EscapeString(lookedPath))
+	fmt.Fprint(rw, wrapHtml("Not Found", content))
+}
+
+// wrapHtml returns a string of boilerplate-wrapped html with the given title and content.
+func wrapHtml(title string, content string) string {
+	return fmt.Sprintf(`<!doctype html><html><head><title>%s</title></head><body>%s</body></html>`, title, content)
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package site makes it possible to embed scribble in other programs. A Site
+// holds its own config and compiled state, so several sites can be built,
+// watched, and served by the same program.
+package site
+
+import (
+	"context"
+	"github.com/albrow/scribble/compilers"
+	"github.com/albrow/scribble/config"
+	"github.com/albrow/scribble/log"
+	"github.com/albrow/scribble/util"
+	"sync"
+)
+
+// Site is a scribble site, i.e. a source directory with posts, templates,
+// and other files which are compiled into a destination directory.
+type Site struct {
+	// Config is the config for the site. It may be changed before the site
+	// is built, e.g. to override values from config.toml.
+	Config config.Config
+	// OnError is called with any errors that occur while watching for
+	// changes. If it is nil, the errors are logged.
+	OnError func(error)
+	// LiveReload determines whether or not Handler injects a script into
+	// every html page which reloads the page whenever Watch recompiles
+	// something. Changes to stylesheets are swapped in without reloading
+	// the page.
+	LiveReload bool
+	// ErrorOverlay determines whether or not Handler covers every html page
+	// with a description of the errors whenever the last build or recompile
+	// failed, so that it is obvious that the page is out of date.
+	ErrorOverlay bool
+	// InMemory determines whether or not the compiled site is kept in memory
+	// instead of being written to the destination directory, which is left
+	// untouched. Handler serves the site from memory. It should be set before
+	// the site is built.
+	InMemory bool
+	// state holds everything the compilers know about the site
+	state *compilers.State
+	// lock is held while the site is being compiled. It is a channel so that
+	// waiting for it can be cancelled.
+	lock chan struct{}
+	// fileHashes holds the last known hash of each watched file
+	fileHashes map[string][]byte
+	// reloader notifies browsers when something is recompiled
+	reloader *reloader
+	// errs holds the error from the last build under the empty string, and
+	// the error from the last recompile after a file changed under the path
+	// of that file. The entry for a file is only removed when it recompiles
+	// successfully or the whole site is built again.
+	errs map[string]error
+	// redirects holds the redirect rules from the last build or recompile
+	redirects []compilers.Redirect
+	// servingMutex guards the fields which are read while serving
+	servingMutex sync.Mutex
+}
+
+// New returns a Site with the given config.
+func New(c config.Config) *Site {
+	return &Site{
+		Config:     c,
+		state:      compilers.NewState(c),
+		lock:       make(chan struct{}, 1),
+		fileHashes: map[string][]byte{},
+		reloader:   newReloader(),
+		errs:       map[string]error{},
+	}
+}
+
+// NewFromFile returns a Site with the config in the config.toml file at path.
+// Relative directories in the file are relative to the directory which
+// contains it.
+func NewFromFile(path string) (*Site, error) {
+	c, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(c), nil
+}
+
+// Build compiles everything in the source directory for the site and puts
+// the compiled result in the destination directory. If ctx is done before
+// the build starts, Build returns ctx.Err() without building anything.
+// Browsers which are connected for live reload are reloaded afterwards.
+func (s *Site) Build(ctx context.Context) error {
+	return s.use(ctx, func() error {
+		log.Default.Println("Compiling...")
+		err := s.state.CompileAll()
+		s.resetErrors(err)
+		s.reloader.notify(reloadEvent)
+		return err
+	})
+}
+
+// Posts returns all the published posts for the site, sorted by date. It
+// returns an empty slice if the site has not been built yet.
+func (s *Site) Posts() []*compilers.Post {
+	var posts []*compilers.Post
+	s.use(context.Background(), func() error {
+		posts = s.state.Posts()
+		return nil
+	})
+	return posts
+}
+
+// use waits until s is not being compiled by another goroutine, gives the
+// compilers the current config for s, and then calls f. Other sites are not
+// affected, so they can be compiled at the same time.
+func (s *Site) use(ctx context.Context, f func() error) error {
+	select {
+	case s.lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		<-s.lock
+	}()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.state.SetConfig(s.Config)
+	files := s.state.Files()
+	if s.InMemory && files.MountedAt(s.Config.DestDir) == nil {
+		files.Mount(s.Config.DestDir, util.NewMemoryFileSystem())
+	}
+	defer func() {
+		s.servingMutex.Lock()
+		s.redirects = s.state.Redirects()
+		s.servingMutex.Unlock()
+	}()
+	return f()
+}
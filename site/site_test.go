@@ -0,0 +1,612 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package site
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"github.com/albrow/scribble/util"
+	"github.com/howeyc/fsnotify"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testConfig = `sourceDir = "source"
+destDir = "public"
+postsDir = "source/_posts"
+layoutsDir = "source/_layouts"
+postLayoutsDir = "source/_post_layouts"
+`
+
+func TestSitesAreIndependent(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_sites_are_independent")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	// Create two sites from the same test files, and remove one of the
+	// posts from the second site, which is kept in memory
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	sites := []*Site{}
+	for _, name := range []string{"one", "two"} {
+		siteDir := filepath.Join(root, name)
+		if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), filepath.Join(siteDir, "source")); err != nil {
+			t.Fatal(err)
+		}
+		configPath := filepath.Join(siteDir, "config.toml")
+		if err := ioutil.WriteFile(configPath, []byte(testConfig), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewFromFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Config.DestDir != filepath.Join(siteDir, "public") {
+			t.Errorf("Expected destDir to be relative to config.toml. Got %s", s.Config.DestDir)
+		}
+		sites = append(sites, s)
+	}
+	if err := os.Remove(filepath.Join(root, "two", "source", "_posts", "two.md")); err != nil {
+		t.Fatal(err)
+	}
+	sites[1].InMemory = true
+
+	// Build both sites at once
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(sites))
+	for i, s := range sites {
+		wg.Add(1)
+		go func(i int, s *Site) {
+			defer wg.Done()
+			errs[i] = s.Build(context.Background())
+		}(i, s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each site should only know about its own posts
+	for i, expected := range []int{2, 1} {
+		if got := len(sites[i].Posts()); got != expected {
+			t.Errorf("Expected site %d to have %d posts but got %d", i, expected, got)
+		}
+	}
+
+	// Each site should serve its own compiled files
+	for i, expected := range []bool{true, false} {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/index.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sites[i].Handler().ServeHTTP(rec, req)
+		if got := strings.Contains(rec.Body.String(), "Two"); got != expected {
+			t.Errorf("Expected the index page for site %d to list the second post: %v. Got: %s", i, expected, rec.Body.String())
+		}
+	}
+
+	// Only the second site should be kept in memory
+	if _, err := os.Stat(filepath.Join(root, "one", "public", "index.html")); err != nil {
+		t.Errorf("Expected the first site to be written to disk: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "two", "public")); !os.IsNotExist(err) {
+		t.Errorf("Expected the second site to be kept in memory but found it on disk")
+	}
+
+	// Posts which were returned before a rebuild should not change, even if
+	// the post was changed
+	posts := sites[0].Posts()
+	onePath := filepath.Join(root, "one", "source", "_posts", "one.md")
+	if err := ioutil.WriteFile(onePath, []byte("+++\ntitle = \"Changed\"\ndate = \"2014-11-16T13:50:53-05:00\"\nlayout = \"post.tmpl\"\n+++\n\nThe first post."), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := sites[0].Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if posts[0].Title != "One" {
+		t.Errorf("Expected the post returned before the rebuild to keep its title. Got %s", posts[0].Title)
+	}
+	if got := sites[0].Posts()[0].Title; got != "Changed" {
+		t.Errorf("Expected the post to be changed after the rebuild. Got %s", got)
+	}
+
+	// A cancelled context should prevent a build
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sites[0].Build(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+}
+
+func TestLiveReload(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_live_reload")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), filepath.Join(root, "source")); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(root, "config.toml")
+	if err := ioutil.WriteFile(configPath, []byte(testConfig), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.LiveReload = true
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	// The script should be injected into html pages, but not anything else
+	for path, expected := range map[string]bool{
+		"/index.html": true,
+		"/js/main.js": false,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(body), LiveReloadPath); got != expected {
+			t.Errorf("Expected %s to include the live reload script: %v. Got: %s", path, expected, body)
+		}
+	}
+
+	// Connect to the live reload endpoint
+	resp, err := http.Get(server.URL + LiveReloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected Content-Type to be text/event-stream but got %s", contentType)
+	}
+	events := bufio.NewReader(resp.Body)
+	nextEvent := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "event: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+			}
+		}
+	}
+
+	// Changing a stylesheet should only reload the stylesheets, and changing
+	// anything else should reload the page
+	for _, change := range []struct {
+		path     string
+		expected string
+	}{
+		{path: filepath.Join("styles", "_colors.scss"), expected: cssEvent},
+		{path: filepath.Join("styles", "plain.css"), expected: cssEvent},
+		{path: filepath.Join("js", "main.js"), expected: reloadEvent},
+	} {
+		path := filepath.Join(root, "source", change.path)
+		file, err := util.CreateFileWithPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString("/* changed */\n"); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if err := s.fileChanged(context.Background(), &fsnotify.FileEvent{Name: path}); err != nil {
+			t.Fatal(err)
+		}
+		if got := nextEvent(); got != change.expected {
+			t.Errorf("Expected a %s event after %s changed but got %s", change.expected, change.path, got)
+		}
+	}
+}
+
+func TestErrorOverlay(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_error_overlay")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), filepath.Join(root, "source")); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(root, "config.toml")
+	if err := ioutil.WriteFile(configPath, []byte(testConfig), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ErrorOverlay = true
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	getIndex := func() string {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/index.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	changeFile := func(path string, content string) error {
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		return s.fileChanged(context.Background(), &fsnotify.FileEvent{Name: path})
+	}
+	if body := getIndex(); strings.Contains(body, "scribble-error-overlay") {
+		t.Errorf("Expected no error overlay after a successful build. Got: %s", body)
+	}
+
+	// Break a sass file. The overlay should describe the error.
+	sassPath := filepath.Join(root, "source", "main.scss")
+	if err := changeFile(sassPath, "body {\n  color: $undefined;\n}\n"); err == nil {
+		t.Fatal("Expected an error for an undefined variable but got none")
+	}
+	body := getIndex()
+	for _, expected := range []string{
+		"scribble-error-overlay",
+		"Error in sass compiler: " + filepath.Join("source", "main.scss") + ":2",
+		"Undefined variable: &#34;$undefined&#34;.",
+		// the stale page is still underneath
+		"</body>",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the page to contain %q. Got: %s", expected, body)
+		}
+	}
+
+	// Successfully recompiling some other file should not hide the error
+	jsPath := filepath.Join(root, "source", "js", "main.js")
+	if err := changeFile(jsPath, "console.log('changed');\n"); err != nil {
+		t.Fatal(err)
+	}
+	if s.LastError() == nil {
+		t.Error("Expected LastError to still be the sass error but got nil")
+	}
+	if body := getIndex(); !strings.Contains(body, "scribble-error-overlay") {
+		t.Errorf("Expected the error overlay after an unrelated file changed. Got: %s", body)
+	}
+
+	// Fix the file. The overlay should go away.
+	if err := changeFile(sassPath, "body {\n  color: red;\n}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if s.LastError() != nil {
+		t.Errorf("Expected LastError to be nil but got %v", s.LastError())
+	}
+	if body := getIndex(); strings.Contains(body, "scribble-error-overlay") {
+		t.Errorf("Expected no error overlay after the error was fixed. Got: %s", body)
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_in_memory")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), filepath.Join(root, "source")); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(root, "config.toml")
+	if err := ioutil.WriteFile(configPath, []byte(testConfig), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.InMemory = true
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+	checkNothingOnDisk := func() {
+		infos, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range infos {
+			if info.Name() != "source" && info.Name() != "config.toml" {
+				t.Errorf("Expected nothing to be written to disk but found %s", info.Name())
+			}
+		}
+	}
+
+	// The site should be served from memory, and nothing should be written
+	// to disk
+	if code, body := get("/index.html"); code != http.StatusOK || !strings.Contains(body, "Two") {
+		t.Errorf("Expected the index page to be served from memory. Got %d: %s", code, body)
+	}
+	checkNothingOnDisk()
+
+	// Changes should be recompiled in memory too
+	jsPath := filepath.Join(root, "source", "js", "main.js")
+	if err := ioutil.WriteFile(jsPath, []byte("changed();"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.fileChanged(context.Background(), &fsnotify.FileEvent{Name: jsPath}); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get("/js/main.js"); code != http.StatusOK || body != "changed();" {
+		t.Errorf("Expected the changed file to be served from memory. Got %d: %s", code, body)
+	}
+
+	// Rebuilding should replace everything that was in memory
+	if err := os.Remove(filepath.Join(root, "source", "_posts", "two.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := get("/two/"); code != http.StatusNotFound {
+		t.Errorf("Expected the removed post to not be found but got %d", code)
+	}
+	if code, _ := get("/one/"); code != http.StatusOK {
+		t.Errorf("Expected the first post to still be served but got %d", code)
+	}
+	checkNothingOnDisk()
+}
+
+func TestRedirectsAndNotFound(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_redirects_and_not_found")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(root, "config.toml"):  testConfig,
+		filepath.Join(srcDir, "_redirects"): "/old-one/ /one/ 302\n/old-feed.xml /feed.xml\n",
+		filepath.Join(srcDir, "404.tmpl"):   `{{ define "content" }}Custom missing page{{ end }}{{ template "base.tmpl" . }}`,
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewFromFile(filepath.Join(root, "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The rules in _redirects should be followed, including the ones which
+	// don't have a page
+	for path, expected := range map[string]string{
+		"/old-one":      "/one/",
+		"/old-one/":     "/one/",
+		"/old-feed.xml": "/feed.xml",
+	} {
+		rec := get(path)
+		if rec.Code < 300 || rec.Code >= 400 || rec.Header().Get("Location") != expected {
+			t.Errorf("Expected %s to redirect to %s but got %d to %q", path, expected, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	if rec := get("/old-one/"); rec.Code != http.StatusFound {
+		t.Errorf("Expected status %d but got %d", http.StatusFound, rec.Code)
+	}
+
+	// The site's own 404 page should be used
+	rec := get("/missing/")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "Custom missing page") {
+		t.Errorf("Expected the site's 404 page but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Without a 404 page, the url should be escaped in the default one
+	if err := os.Remove(filepath.Join(srcDir, "404.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec = get("/<script>alert(1)</script>")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d but got %d", http.StatusNotFound, rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;") {
+		t.Errorf("Expected the url to be escaped but got: %s", body)
+	}
+}
+
+func TestServeOptions(t *testing.T) {
+	// Create a root path where all of our test files for this
+	// test will live
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_serve_options")
+	defer func() {
+		// Remove everything after we're done
+		if err := util.RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+
+	testFilesDir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "albrow", "scribble", "test_files", "dependencies")
+	srcDir := filepath.Join(root, "source")
+	if err := util.RecursiveCopy(filepath.Join(testFilesDir, "source"), srcDir); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(root, "config.toml"): testConfig + `
+[serve]
+basePath = "/blog/"
+gzip = true
+log = true
+
+[serve.headers."/*"]
+Cache-Control = "no-cache"
+X-Frame-Options = "DENY"
+
+[serve.headers."/js/*"]
+Cache-Control = "max-age=60"
+`,
+		filepath.Join(srcDir, "_redirects"): "/old-one/ /one/\n",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewFromFile(filepath.Join(root, "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Serve.BasePath != "/blog" {
+		t.Errorf("Expected the base path to be /blog but got %q", s.Config.Serve.BasePath)
+	}
+	if err := s.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string, gzipped bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gzipped {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Paths outside of the base path should be redirected to it or not found,
+	// and redirects to paths within the site should stay inside of it
+	for path, expected := range map[string]string{
+		"/":              "/blog/",
+		"/blog":          "/blog/",
+		"/blog/js":       "/blog/js/",
+		"/blog/old-one/": "/blog/one/",
+	} {
+		rec := get(path, false)
+		if rec.Code < 300 || rec.Code >= 400 || rec.Header().Get("Location") != expected {
+			t.Errorf("Expected %s to redirect to %s but got %d to %q", path, expected, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	if rec := get("/js/main.js", false); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d outside of the base path but got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// The headers should be added, with the most specific pattern winning
+	rec := get("/blog/index.html", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but got %d", http.StatusOK, rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Expected Cache-Control to be no-cache but got %q", got)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected the response not to be compressed but got Content-Encoding %q", got)
+	}
+	rec = get("/blog/js/main.js", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but got %d", http.StatusOK, rec.Code)
+	}
+	for name, expected := range map[string]string{
+		"Cache-Control":    "max-age=60",
+		"X-Frame-Options":  "DENY",
+		"Content-Encoding": "gzip",
+		"Vary":             "Accept-Encoding",
+	} {
+		if got := rec.Header().Get(name); got != expected {
+			t.Errorf("Expected %s to be %q but got %q", name, expected, got)
+		}
+	}
+
+	// The compressed response should hold the file
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join(srcDir, "js", "main.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("Expected the decompressed response to be %q but got %q", string(expected), string(got))
+	}
+}
@@ -2,54 +2,40 @@
 // Use of this source code is governed by the MIT
 // license, which can be found in the LICENSE file.
 
-package main
+package site
 
 import (
+	"context"
+	"fmt"
 	"github.com/OneOfOne/xxhash/native"
-	"github.com/albrow/scribble/compilers"
-	"github.com/albrow/scribble/config"
 	"github.com/albrow/scribble/log"
-	"github.com/albrow/scribble/util"
 	"github.com/howeyc/fsnotify"
 	"io"
 	"os"
 	"path/filepath"
-	"sync"
 )
 
-var watchedPaths = []string{}
-var watcher *fsnotify.Watcher
-var watchMutex = sync.Mutex{}
-
-// a map of known file hashes. This is required to
-// determine whether a file actually changed. It is a workaround
-// to fix the bug that occurs when a text editor uses atomic saves,
-// which triggers multiple watch events even though the file was
-// only saved once.
-var fileHashes = map[string][]byte{}
-
-// watchAll begins watching all the files in config.SourceDir and reacts
-// to any changes.
-func watchAll() {
+// Watch watches all the files in the source directory for the site and
+// recompiles whatever is affected by each change. It blocks until ctx is
+// done and then returns ctx.Err(), or returns an error right away if the
+// files could not be watched. Any errors that occur while recompiling are
+// passed to OnError. Watch should only be called once for each Site at a
+// time.
+func (s *Site) Watch(ctx context.Context) error {
 	log.Default.Println("Watching for changes...")
-	if watcher == nil {
-		var err error
-		watcher, err = createWatcher()
-		if err != nil {
-			panic(err)
-		}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
 	}
+	defer watcher.Close()
 
 	// walk through source dir and watch all subdirectories
 	// we have to do this because fsnotify is currently not recursive
-	if err := watcher.Watch(config.SourceDir); err != nil {
-		panic(err)
-	}
-	if err := filepath.Walk(config.SourceDir, func(path string, info os.FileInfo, err error) error {
+	if err := filepath.Walk(s.Config.SourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.Name()[0] == '.' {
+		if info.Name()[0] == '.' && path != s.Config.SourceDir {
 			// ignore hidden system files
 			if info.IsDir() {
 				return filepath.SkipDir
@@ -57,66 +43,83 @@ func watchAll() {
 			return nil
 		}
 		if info.IsDir() {
-			watchMutex.Lock()
-			watchedPaths = append(watchedPaths, path)
-			if err := watcher.Watch(path); err != nil {
-				panic(err)
-			}
-			watchMutex.Unlock()
+			return watcher.Watch(path)
 		}
 		return nil
 	}); err != nil {
-		panic(err)
-	}
-}
-
-// createWatcher creates and returns an fsnotify.Watcher.
-func createWatcher() (*fsnotify.Watcher, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Process events
-	go func() {
-		defer func() {
-			// Recover from panics and then restart the watchLoop if needed
-			util.Recovery(*compileTrace || *serveTrace)
-			watchLoop(watcher)
-		}()
-		watchLoop(watcher)
-	}()
-	return watcher, nil
-}
-
-func watchLoop(watcher *fsnotify.Watcher) {
 	for {
 		select {
 		case ev := <-watcher.Event:
-			if changed, err := fileDidChange(ev.Name); err != nil {
-				util.ChimeError(err)
-			} else if changed {
-				if err := compilers.FileChanged(ev.Name, ev); err != nil {
-					util.ChimeError(err)
-				}
+			if err := s.fileChanged(ctx, ev); err != nil {
+				s.handleError(err)
 			}
 		case err := <-watcher.Error:
-			util.ChimeError(err)
+			s.handleError(err)
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
 
+// fileChanged recompiles whatever is affected by the change described by ev,
+// if the file actually changed, and then notifies any browsers which are
+// connected for live reload. Any panics are recovered and returned as errors
+// so that one bad file doesn't stop the site from being watched. The error
+// is kept for the error overlay until the file recompiles successfully.
+func (s *Site) fileChanged(ctx context.Context, ev *fsnotify.FileEvent) error {
+	changed, err := s.fileDidChange(ev.Name)
+	if err != nil || !changed {
+		return err
+	}
+	event := reloadEvent
+	err = s.use(ctx, func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		if err := s.state.FileChanged(ev.Name, ev); err != nil {
+			return err
+		}
+		event, err = s.reloadEventFor(ev.Name)
+		return err
+	})
+	if err != nil && err == ctx.Err() {
+		// Nothing was recompiled
+		return err
+	}
+	if hadErrors := s.setError(ev.Name, err); err != nil || hadErrors {
+		// The whole page needs to be reloaded to show or hide the error
+		// overlay
+		event = reloadEvent
+	}
+	s.reloader.notify(event)
+	return err
+}
+
+// handleError passes err to s.OnError, or logs it if s.OnError is nil.
+func (s *Site) handleError(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	} else {
+		log.Error.Printf("ERROR: %s", err)
+	}
+}
+
 // fileDidChange uses the last known hash to determine whether or
 // not the file actually changed. It solves the problem of false positives
 // coming from fsnotify when used with a text editor that uses atomic saves.
-func fileDidChange(path string) (bool, error) {
-	if hash, found := fileHashes[path]; !found {
+func (s *Site) fileDidChange(path string) (bool, error) {
+	if hash, found := s.fileHashes[path]; !found {
 		// we have not hashed the file before.
 		// hash it now and store the value
 		if newHash, exists, err := calculateHashForPath(path); err != nil {
 			return false, err
 		} else if exists {
-			fileHashes[path] = newHash
+			s.fileHashes[path] = newHash
 		}
 		return true, nil
 	} else {
@@ -125,12 +128,12 @@ func fileDidChange(path string) (bool, error) {
 		} else if !exists {
 			// if the file no longer exists, it has been deleted
 			// we should consider that a change and recompile
-			delete(fileHashes, path)
+			delete(s.fileHashes, path)
 			return true, nil
 		} else if string(newHash) != string(hash) {
 			// if the file does exist and has a different hash, there
 			// was an actual change and we should recompile
-			fileHashes[path] = newHash
+			s.fileHashes[path] = newHash
 			return true, nil
 		}
 		return false, nil
@@ -162,6 +165,7 @@ func calculateHashForPath(path string) ([]byte, bool, error) {
 			return nil, false, err
 		}
 	}
+	defer f.Close()
 	if _, err := io.Copy(h, f); err != nil {
 		return nil, false, err
 	}
@@ -0,0 +1 @@
+let message: string = "hello";
@@ -12,45 +12,45 @@ import (
 )
 
 // CreateFileWithPath creates a file by first creating the directory
-// the file will be placed in with os.MkdirAll (analogous to mkdir -p),
-// and then creating the file itself. If the file already exists, it will
-// overwrite the existing file. If there were any other problems creating
-// the file, it will return an error.
-func CreateFileWithPath(path string) (*os.File, error) {
-	dir := filepath.Dir(path)
-	// First create the directory if needed
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		if !os.IsExist(err) {
-			// If the dir already existed, that's fine.
-			// For any other error, we should return it.
-			return nil, err
-		}
-	}
-	// Then create the file itself
-	file, err := os.Create(path)
-	if err != nil && !os.IsExist(err) {
-		// If the file already existed, that's fine.
-		// For any other error, we should return it.
-		return nil, err
-	}
-	return file, nil
+// the file will be placed in (analogous to mkdir -p), and then creating
+// the file itself. If the file already exists, it will overwrite the
+// existing file. If there were any other problems creating the file, it
+// will return an error.
+func CreateFileWithPath(path string) (File, error) {
+	return noMounts.CreateFileWithPath(path)
+}
+
+// CreateFileWithPath works the same way as the CreateFileWithPath function,
+// except that if path is inside a directory where a FileSystem is mounted,
+// the file is created there instead of on disk.
+func (m *Mounts) CreateFileWithPath(path string) (File, error) {
+	fs, name := m.resolve(path)
+	return fs.Create(name)
 }
 
 // CopyFile copies the file at srcePath to destPath. It creates any
 // directories needed for destPath.
 func CopyFile(srcPath string, destPath string) error {
-	destFile, err := CreateFileWithPath(destPath)
+	return noMounts.CopyFile(srcPath, destPath)
+}
+
+// CopyFile works the same way as the CopyFile function. srcPath is always
+// read from disk, but destPath may be inside a mounted FileSystem.
+func (m *Mounts) CopyFile(srcPath string, destPath string) error {
+	srcFile, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
-	srcFile, err := os.Open(srcPath)
+	defer srcFile.Close()
+	destFile, err := m.CreateFileWithPath(destPath)
 	if err != nil {
 		return err
 	}
 	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
 		return err
 	}
-	return nil
+	return destFile.Close()
 }
 
 // RecursiveCopy copies everything from srcDir to destDir recursively.
@@ -74,8 +74,14 @@ func RecursiveCopy(srcDir string, destDir string) error {
 // does not write to them, and any old content that may have been there is
 // erased.
 func CreateEmptyFiles(paths []string) error {
+	return noMounts.CreateEmptyFiles(paths)
+}
+
+// CreateEmptyFiles works the same way as the CreateEmptyFiles function, except
+// that it also creates files in mounted FileSystems.
+func (m *Mounts) CreateEmptyFiles(paths []string) error {
 	for _, path := range paths {
-		if f, err := CreateFileWithPath(path); err != nil {
+		if f, err := m.CreateFileWithPath(path); err != nil {
 			return err
 		} else {
 			if err := f.Close(); err != nil {
@@ -91,7 +97,14 @@ func CreateEmptyFiles(paths []string) error {
 // the default behavior in the os package, RemoveAllIfExists will not return
 // an error if path does not exist.
 func RemoveAllIfExists(path string) error {
-	if err := os.RemoveAll(path); err != nil {
+	return noMounts.RemoveAllIfExists(path)
+}
+
+// RemoveAllIfExists works the same way as the RemoveAllIfExists function,
+// except that it also removes directories in mounted FileSystems.
+func (m *Mounts) RemoveAllIfExists(path string) error {
+	fs, name := m.resolve(path)
+	if err := fs.RemoveAll(name); err != nil {
 		if !os.IsNotExist(err) {
 			return err
 		}
@@ -104,7 +117,14 @@ func RemoveAllIfExists(path string) error {
 // default behavior in the os package, RemoveIfExists will not return an
 // error if path does not exist.
 func RemoveIfExists(path string) error {
-	if err := os.Remove(path); err != nil {
+	return noMounts.RemoveIfExists(path)
+}
+
+// RemoveIfExists works the same way as the RemoveIfExists function, except
+// that it also removes files in mounted FileSystems.
+func (m *Mounts) RemoveIfExists(path string) error {
+	fs, name := m.resolve(path)
+	if err := fs.Remove(name); err != nil {
 		if !os.IsNotExist(err) {
 			return err
 		}
@@ -115,34 +135,31 @@ func RemoveIfExists(path string) error {
 // RemoveEmptyDirs recursively iterates through path and removes any empty
 // directories within it.
 func RemoveEmptyDirs(path string) error {
-	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	return noMounts.RemoveEmptyDirs(path)
+}
+
+// RemoveEmptyDirs works the same way as the RemoveEmptyDirs function, except
+// that it also removes directories in mounted FileSystems.
+func (m *Mounts) RemoveEmptyDirs(path string) error {
+	return m.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
-			// Open the directory to see if it's empty
-			file, err := os.Open(path)
+			// Read the directory to see if it's empty
+			infos, err := m.ReadDir(path)
 			if err != nil {
-				switch err {
-				case os.ErrNotExist:
+				if os.IsNotExist(err) {
 					// If the directory we were going to maybe delete doesn't exist
 					// anymore, that's fine
 					return nil
-				default:
-					// If there was some other error, return it
-					return err
 				}
+				// If there was some other error, return it
+				return err
 			}
-
-			if _, err := file.Readdirnames(1); err != nil {
-				switch err {
-				case io.EOF:
-					// This means the directory has no files, we need to delete it
-					if err := RemoveAllIfExists(path); err != nil {
-						return err
-					}
-				default:
-					// If there was some other error, return it
+			if len(infos) == 0 {
+				// This means the directory has no files, we need to delete it
+				if err := m.RemoveAllIfExists(path); err != nil {
 					return err
 				}
 			}
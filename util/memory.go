@@ -0,0 +1,303 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryFileSystem is a FileSystem which keeps everything in memory. It also
+// satisfies http.FileSystem, so the files in it can be served directly. It is
+// safe to use from several goroutines at once.
+type MemoryFileSystem struct {
+	mutex sync.RWMutex
+	// files holds the contents of each file by name
+	files map[string]*memoryFile
+	// dirs holds the time each directory was created by name. The root
+	// directory, "", always exists.
+	dirs map[string]time.Time
+}
+
+// memoryFile is a file in a MemoryFileSystem. Writes only ever append to
+// data or replace it, so a reader can safely hold on to data while the file
+// is written.
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryFileSystem returns an empty MemoryFileSystem.
+func NewMemoryFileSystem() *MemoryFileSystem {
+	return &MemoryFileSystem{
+		files: map[string]*memoryFile{},
+		dirs:  map[string]time.Time{"": time.Now()},
+	}
+}
+
+// cleanName returns name without any leading or trailing slashes, or "" for
+// the root directory
+func cleanName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// parent returns the name of the directory which contains name
+func parent(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+func (m *MemoryFileSystem) Create(name string) (File, error) {
+	name = cleanName(name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, found := m.dirs[name]; found {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	}
+	if err := m.mkdirAll(parent(name)); err != nil {
+		return nil, err
+	}
+	file := &memoryFile{modTime: time.Now()}
+	m.files[name] = file
+	return &memoryWriter{fs: m, file: file}, nil
+}
+
+func (m *MemoryFileSystem) MkdirAll(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.mkdirAll(cleanName(name))
+}
+
+// mkdirAll creates the directory with the given name and any directories it
+// will be placed in. The caller must hold the lock.
+func (m *MemoryFileSystem) mkdirAll(name string) error {
+	for dir := name; ; dir = parent(dir) {
+		if _, found := m.dirs[dir]; found {
+			break
+		}
+		if _, found := m.files[dir]; found {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		m.dirs[dir] = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryFileSystem) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.stat(name)
+}
+
+// stat returns the os.FileInfo for name. The caller must hold the lock.
+func (m *MemoryFileSystem) stat(name string) (os.FileInfo, error) {
+	if file, found := m.files[name]; found {
+		return memoryFileInfo{name: path.Base(name), size: int64(len(file.data)), modTime: file.modTime}, nil
+	}
+	if modTime, found := m.dirs[name]; found {
+		return memoryFileInfo{name: path.Base("/" + name), modTime: modTime, dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *MemoryFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	name = cleanName(name)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readDir(name)
+}
+
+// readDir returns the contents of the directory with the given name, sorted
+// by name. The caller must hold the lock.
+func (m *MemoryFileSystem) readDir(name string) ([]os.FileInfo, error) {
+	if _, found := m.dirs[name]; !found {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	infos := []os.FileInfo{}
+	for child := range m.files {
+		if parent(child) == name {
+			info, _ := m.stat(child)
+			infos = append(infos, info)
+		}
+	}
+	for child := range m.dirs {
+		if child != "" && parent(child) == name {
+			info, _ := m.stat(child)
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (m *MemoryFileSystem) Remove(name string) error {
+	name = cleanName(name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, found := m.files[name]; found {
+		delete(m.files, name)
+		return nil
+	}
+	if _, found := m.dirs[name]; !found || name == "" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if infos, _ := m.readDir(name); len(infos) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+	}
+	delete(m.dirs, name)
+	return nil
+}
+
+// RemoveAll removes the file or directory with the given name along with
+// everything it contains. Removing the root directory only removes its
+// contents.
+func (m *MemoryFileSystem) RemoveAll(name string) error {
+	name = cleanName(name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	inside := func(other string) bool {
+		return name == "" || other == name || strings.HasPrefix(other, name+"/")
+	}
+	for other := range m.files {
+		if inside(other) {
+			delete(m.files, other)
+		}
+	}
+	for other := range m.dirs {
+		if other != "" && inside(other) {
+			delete(m.dirs, other)
+		}
+	}
+	return nil
+}
+
+// Open opens the file or directory with the given name for reading. It
+// satisfies http.FileSystem.
+func (m *MemoryFileSystem) Open(name string) (http.File, error) {
+	name = cleanName(name)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	info, err := m.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		infos, err := m.readDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &memoryReader{info: info, dirInfos: infos}, nil
+	}
+	return &memoryReader{info: info, Reader: bytes.NewReader(m.files[name].data)}, nil
+}
+
+// memoryWriter writes to a memoryFile
+type memoryWriter struct {
+	fs   *MemoryFileSystem
+	file *memoryFile
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	w.fs.mutex.Lock()
+	defer w.fs.mutex.Unlock()
+	w.file.data = append(w.file.data, p...)
+	w.file.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *memoryWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *memoryWriter) Sync() error {
+	return nil
+}
+
+func (w *memoryWriter) Close() error {
+	return nil
+}
+
+// memoryReader is an http.File for reading a file or directory in a
+// MemoryFileSystem
+type memoryReader struct {
+	*bytes.Reader
+	info     os.FileInfo
+	dirInfos []os.FileInfo
+}
+
+func (r *memoryReader) Read(p []byte) (int, error) {
+	if r.Reader == nil {
+		return 0, io.EOF
+	}
+	return r.Reader.Read(p)
+}
+
+func (r *memoryReader) Seek(offset int64, whence int) (int64, error) {
+	if r.Reader == nil {
+		return 0, nil
+	}
+	return r.Reader.Seek(offset, whence)
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
+
+func (r *memoryReader) Readdir(count int) ([]os.FileInfo, error) {
+	if !r.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: r.info.Name(), Err: os.ErrInvalid}
+	}
+	if count <= 0 {
+		infos := r.dirInfos
+		r.dirInfos = nil
+		return infos, nil
+	}
+	if len(r.dirInfos) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(r.dirInfos) {
+		count = len(r.dirInfos)
+	}
+	infos := r.dirInfos[:count]
+	r.dirInfos = r.dirInfos[count:]
+	return infos, nil
+}
+
+func (r *memoryReader) Stat() (os.FileInfo, error) {
+	return r.info, nil
+}
+
+// memoryFileInfo is the os.FileInfo for a file or directory in a
+// MemoryFileSystem
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memoryFileInfo) Name() string       { return i.name }
+func (i memoryFileInfo) Size() int64        { return i.size }
+func (i memoryFileInfo) ModTime() time.Time { return i.modTime }
+func (i memoryFileInfo) IsDir() bool        { return i.dir }
+func (i memoryFileInfo) Sys() interface{}   { return nil }
+
+func (i memoryFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package util
+
+import (
+	"github.com/albrow/scribble/test_util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryFileSystem(t *testing.T) {
+	root := string(os.PathSeparator) + filepath.Join("tmp", "test_memory_file_system")
+	defer func() {
+		if err := RemoveAllIfExists(root); err != nil {
+			panic(err)
+		}
+	}()
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	srcPath := filepath.Join(root, "source.txt")
+	if err := ioutil.WriteFile(srcPath, []byte("copied"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mount a MemoryFileSystem and write some files to it
+	destDir := filepath.Join(root, "public")
+	fs := NewMemoryFileSystem()
+	mounts := NewMounts()
+	mounts.Mount(destDir, fs)
+	if mounts.OnDisk(filepath.Join(destDir, "a.txt")) || !mounts.OnDisk(srcPath) {
+		t.Error("Expected only paths inside destDir to be kept in memory")
+	}
+	file, err := mounts.CreateFileWithPath(filepath.Join(destDir, "a", "b", "c.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString("Hello, "); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString("memory!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mounts.CopyFile(srcPath, filepath.Join(destDir, "copied.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mounts.MkdirAll(filepath.Join(destDir, "empty", "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing should have been written to disk, and other Mounts should not
+	// be affected
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to not exist on disk but got %v", destDir, err)
+	}
+	if _, err := NewMounts().Stat(filepath.Join(destDir, "copied.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected copied.txt to not exist for other Mounts but got %v", err)
+	}
+
+	// The files should be readable through http.FileSystem
+	for name, expected := range map[string]string{
+		"/a/b/c.txt":  "Hello, memory!",
+		"/copied.txt": "copied",
+	} {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expected {
+			t.Errorf("Expected %s to contain %q but got %q", name, expected, got)
+		}
+	}
+	if info, err := mounts.Stat(filepath.Join(destDir, "a", "b", "c.txt")); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != int64(len("Hello, memory!")) || info.IsDir() {
+		t.Errorf("Stat was incorrect. Got size %d and IsDir %v", info.Size(), info.IsDir())
+	}
+
+	// Walk should find everything, and RemoveEmptyDirs should remove the
+	// empty directory
+	walked := func() []string {
+		paths := []string{}
+		if err := mounts.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return paths
+	}
+	test_util.CheckStringsMatch(t, []string{
+		destDir,
+		filepath.Join(destDir, "a"),
+		filepath.Join(destDir, "a", "b"),
+		filepath.Join(destDir, "a", "b", "c.txt"),
+		filepath.Join(destDir, "copied.txt"),
+		filepath.Join(destDir, "empty"),
+		filepath.Join(destDir, "empty", "dir"),
+	}, walked())
+	if err := mounts.RemoveEmptyDirs(destDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := mounts.RemoveIfExists(filepath.Join(destDir, "copied.txt")); err != nil {
+		t.Fatal(err)
+	}
+	test_util.CheckStringsMatch(t, []string{
+		destDir,
+		filepath.Join(destDir, "a"),
+		filepath.Join(destDir, "a", "b"),
+		filepath.Join(destDir, "a", "b", "c.txt"),
+		filepath.Join(destDir, "empty"),
+	}, walked())
+
+	// RemoveAllIfExists should remove a directory and everything in it
+	if err := mounts.RemoveAllIfExists(filepath.Join(destDir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mounts.Stat(filepath.Join(destDir, "a", "b", "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected c.txt to be removed but got %v", err)
+	}
+}
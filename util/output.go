@@ -0,0 +1,225 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package util
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// File is a file which was created for writing
+type File interface {
+	io.WriteCloser
+	WriteString(s string) (int, error)
+	Sync() error
+}
+
+// FileSystem is somewhere that files can be written, e.g. the disk or memory.
+// A FileSystem other than the disk is mounted at a directory in a Mounts, and
+// then the methods of the Mounts which create, inspect, or remove files use it
+// for every path inside of that directory. Names given to a mounted
+// FileSystem are relative to the directory it is mounted at and separated by
+// slashes, with "" for the directory itself. Names given to the disk are
+// ordinary paths.
+type FileSystem interface {
+	// Create creates or truncates the file with the given name, creating
+	// any directories it will be placed in.
+	Create(name string) (File, error)
+	// MkdirAll creates the directory with the given name along with any
+	// directories it will be placed in.
+	MkdirAll(name string) error
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the contents of the directory with the given name,
+	// sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Remove removes the file or empty directory with the given name.
+	Remove(name string) error
+	// RemoveAll removes the file or directory with the given name along with
+	// everything it contains. It does not return an error if there is
+	// nothing with that name.
+	RemoveAll(name string) error
+}
+
+// disk is the FileSystem for every path where nothing is mounted
+var disk FileSystem = diskFileSystem{}
+
+// Mounts is a table of the FileSystems which are mounted at some directories,
+// e.g. the destination directory for a site which is kept in memory. Its
+// methods create, inspect, and remove files in whichever FileSystem is
+// responsible for each path, which is the disk for every path where nothing
+// is mounted. Each site has its own Mounts, so mounting a FileSystem for one
+// site does not affect any other. A Mounts is safe to use from several
+// goroutines at once.
+type Mounts struct {
+	mutex sync.RWMutex
+	// fileSystems holds the FileSystem mounted at each directory
+	fileSystems map[string]FileSystem
+}
+
+// NewMounts returns a Mounts where nothing is mounted, i.e. every path refers
+// to the disk.
+func NewMounts() *Mounts {
+	return &Mounts{fileSystems: map[string]FileSystem{}}
+}
+
+// noMounts is used by the functions in this package which always use the disk
+var noMounts = NewMounts()
+
+// Mount makes fs responsible for the directory at dir and everything in it.
+// Any FileSystem previously mounted at dir is replaced.
+func (m *Mounts) Mount(dir string, fs FileSystem) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.fileSystems[filepath.Clean(dir)] = fs
+}
+
+// Unmount removes the FileSystem mounted at dir, if any, so that dir refers
+// to the disk again.
+func (m *Mounts) Unmount(dir string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.fileSystems, filepath.Clean(dir))
+}
+
+// MountedAt returns the FileSystem mounted at dir, or nil if there is none.
+func (m *Mounts) MountedAt(dir string) FileSystem {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.fileSystems[filepath.Clean(dir)]
+}
+
+// OnDisk returns true iff path refers to the disk, i.e. it is not inside a
+// directory where some other FileSystem is mounted.
+func (m *Mounts) OnDisk(path string) bool {
+	fs, _ := m.resolve(path)
+	return fs == disk
+}
+
+// resolve returns the FileSystem which is responsible for path and the name
+// of path within it
+func (m *Mounts) resolve(path string) (FileSystem, string) {
+	path = filepath.Clean(path)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var result FileSystem = disk
+	name, longest := path, -1
+	for dir, fs := range m.fileSystems {
+		if len(dir) <= longest {
+			continue
+		}
+		if path == dir {
+			result, name, longest = fs, "", len(dir)
+		} else if strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			result, name, longest = fs, filepath.ToSlash(path[len(dir)+1:]), len(dir)
+		}
+	}
+	return result, name
+}
+
+// MkdirAll creates the directory at path along with any directories it will be
+// placed in, analogous to mkdir -p. It does nothing if the directory already
+// exists.
+func (m *Mounts) MkdirAll(path string) error {
+	fs, name := m.resolve(path)
+	return fs.MkdirAll(name)
+}
+
+// Stat returns the os.FileInfo for the file or directory at path.
+func (m *Mounts) Stat(path string) (os.FileInfo, error) {
+	fs, name := m.resolve(path)
+	return fs.Stat(name)
+}
+
+// ReadDir returns the contents of the directory at path, sorted by name.
+func (m *Mounts) ReadDir(path string) ([]os.FileInfo, error) {
+	fs, name := m.resolve(path)
+	return fs.ReadDir(name)
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. It works the same way as
+// filepath.Walk, except that it also walks through mounted FileSystems.
+func (m *Mounts) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := m.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = m.walk(root, info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (m *Mounts) walk(path string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(path, info, nil)
+	}
+	infos, err := m.ReadDir(path)
+	if err := walkFn(path, info, err); err != nil || infos == nil {
+		return err
+	}
+	for _, child := range infos {
+		if err := m.walk(filepath.Join(path, child.Name()), child, walkFn); err != nil {
+			if child.IsDir() && err == filepath.SkipDir {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// MkdirAll creates the directory at path on disk along with any directories
+// it will be placed in, analogous to mkdir -p. It does nothing if the
+// directory already exists.
+func MkdirAll(path string) error {
+	return noMounts.MkdirAll(path)
+}
+
+// Stat returns the os.FileInfo for the file or directory at path on disk.
+func Stat(path string) (os.FileInfo, error) {
+	return noMounts.Stat(path)
+}
+
+// ReadDir returns the contents of the directory at path on disk, sorted by
+// name.
+func ReadDir(path string) ([]os.FileInfo, error) {
+	return noMounts.ReadDir(path)
+}
+
+// diskFileSystem is a FileSystem which uses the os package
+type diskFileSystem struct{}
+
+func (diskFileSystem) Create(path string) (File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (diskFileSystem) MkdirAll(path string) error {
+	return os.MkdirAll(path, os.ModePerm)
+}
+
+func (diskFileSystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (diskFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+func (diskFileSystem) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (diskFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,29 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify converts s into a lowercase string which is suitable for use
+// in a url. Any run of characters which are not letters or numbers is
+// replaced by a single hyphen, and leading or trailing hyphens are removed.
+// So e.g. "Go & Concurrency" becomes "go-concurrency".
+func Slugify(s string) string {
+	slug := make([]rune, 0, len(s))
+	lastWasHyphen := true
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			slug = append(slug, r)
+			lastWasHyphen = false
+		} else if !lastWasHyphen {
+			slug = append(slug, '-')
+			lastWasHyphen = true
+		}
+	}
+	return strings.TrimSuffix(string(slug), "-")
+}
@@ -0,0 +1,28 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	testCases := map[string]string{
+		"go":                "go",
+		"Go":                "go",
+		"Go & Concurrency":  "go-concurrency",
+		"  leading spaces":  "leading-spaces",
+		"trailing!!":        "trailing",
+		"web-development":   "web-development",
+		"C++ and C#":        "c-and-c",
+		"über café":         "über-café",
+		"version 1.0 notes": "version-1-0-notes",
+	}
+	for input, expected := range testCases {
+		if got := Slugify(input); got != expected {
+			t.Errorf("Slugify(%q) was incorrect. Expected %q but got %q.", input, expected, got)
+		}
+	}
+}